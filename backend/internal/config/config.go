@@ -7,38 +7,104 @@ import (
 )
 
 type Config struct {
-	DBHost        string
-	DBPort        string
-	DBUser        string
-	DBPassword    string
-	DBName        string
-	JWTSecret     string
-	Port          string
-	OllamaBaseURL string
-	OllamaModel   string
-	RedisHost     string
-	RedisPort     string
-	RedisPassword string
-	RedisDB       string
+	DBHost             string
+	DBPort             string
+	DBUser             string
+	DBPassword         string
+	DBName             string
+	JWTSecret          string
+	Port               string
+	OllamaBaseURL      string
+	OllamaModel        string
+	RedisHost          string
+	RedisPort          string
+	RedisPassword      string
+	RedisDB            string
+	AppBaseURL         string
+	MailDriver         string
+	MailFrom           string
+	MailLogDir         string
+	SMTPHost           string
+	SMTPPort           string
+	SMTPUsername       string
+	SMTPPassword       string
+	TOTPIssuer         string
+	OIDCIssuer         string
+	OIDCClientID       string
+	OIDCClientSecret   string
+	OIDCRedirectURL    string
+	OIDCScopes         string
+	DeleteGraceDays    string
+	TrashRetentionDays string
+	TranscriberDriver  string
+	TranscriberBaseURL string
+	TranscriberAPIKey  string
+	TranscriberModel   string
+	StorageDriver      string
+	StorageLocalDir    string
+	S3Endpoint         string
+	S3Region           string
+	S3Bucket           string
+	S3AccessKey        string
+	S3SecretKey        string
+	S3PathStyle        string
+	MediaURLTTLMinutes string
+	MediaGCGraceHours  string
+	SynthesizerDriver  string
+	SynthesizerBaseURL string
+	SynthesizerVoice   string
 }
 
 func Load() *Config {
 	// 尝试加载.env文件（如果存在）
 	godotenv.Load("config.env")
 	return &Config{
-		DBHost:        getEnv("DB_HOST", "localhost"),
-		DBPort:        getEnv("DB_PORT", "3306"),
-		DBUser:        getEnv("DB_USER", "root"),
-		DBPassword:    getEnv("DB_PASSWORD", "password"),
-		DBName:        getEnv("DB_NAME", "role_play_ai"),
-		JWTSecret:     getEnv("JWT_SECRET", "your-secret-key-here"),
-		Port:          getEnv("PORT", "8080"),
-		OllamaBaseURL: getEnv("OLLAMA_BASE_URL", "http://localhost:11434"),
-		OllamaModel:   getEnv("OLLAMA_MODEL", "llama2"),
-		RedisHost:     getEnv("REDIS_HOST", "localhost"),
-		RedisPort:     getEnv("REDIS_PORT", "6379"),
-		RedisPassword: getEnv("REDIS_PASSWORD", ""),
-		RedisDB:       getEnv("REDIS_DB", "0"),
+		DBHost:             getEnv("DB_HOST", "localhost"),
+		DBPort:             getEnv("DB_PORT", "3306"),
+		DBUser:             getEnv("DB_USER", "root"),
+		DBPassword:         getEnv("DB_PASSWORD", "password"),
+		DBName:             getEnv("DB_NAME", "role_play_ai"),
+		JWTSecret:          getEnv("JWT_SECRET", "your-secret-key-here"),
+		Port:               getEnv("PORT", "8080"),
+		OllamaBaseURL:      getEnv("OLLAMA_BASE_URL", "http://localhost:11434"),
+		OllamaModel:        getEnv("OLLAMA_MODEL", "llama2"),
+		RedisHost:          getEnv("REDIS_HOST", "localhost"),
+		RedisPort:          getEnv("REDIS_PORT", "6379"),
+		RedisPassword:      getEnv("REDIS_PASSWORD", ""),
+		RedisDB:            getEnv("REDIS_DB", "0"),
+		AppBaseURL:         getEnv("APP_BASE_URL", "http://localhost:3000"),
+		MailDriver:         getEnv("MAIL_DRIVER", "log"),
+		MailFrom:           getEnv("MAIL_FROM", "noreply@localhost"),
+		MailLogDir:         getEnv("MAIL_LOG_DIR", ""),
+		SMTPHost:           getEnv("SMTP_HOST", "localhost"),
+		SMTPPort:           getEnv("SMTP_PORT", "587"),
+		SMTPUsername:       getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:       getEnv("SMTP_PASSWORD", ""),
+		TOTPIssuer:         getEnv("TOTP_ISSUER", "RolePlayAI"),
+		OIDCIssuer:         getEnv("OIDC_ISSUER", ""),
+		OIDCClientID:       getEnv("OIDC_CLIENT_ID", ""),
+		OIDCClientSecret:   getEnv("OIDC_CLIENT_SECRET", ""),
+		OIDCRedirectURL:    getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/api/v1/auth/oidc/callback"),
+		OIDCScopes:         getEnv("OIDC_SCOPES", "openid email profile"),
+		DeleteGraceDays:    getEnv("ACCOUNT_DELETION_GRACE_DAYS", "7"),
+		TrashRetentionDays: getEnv("CONVERSATION_TRASH_RETENTION_DAYS", "30"),
+		TranscriberDriver:  getEnv("TRANSCRIBER_DRIVER", ""),
+		TranscriberBaseURL: getEnv("TRANSCRIBER_BASE_URL", "http://localhost:8178"),
+		TranscriberAPIKey:  getEnv("TRANSCRIBER_API_KEY", ""),
+		TranscriberModel:   getEnv("TRANSCRIBER_MODEL", "whisper-1"),
+		StorageDriver:      getEnv("STORAGE_DRIVER", "local"),
+		StorageLocalDir:    getEnv("STORAGE_LOCAL_DIR", "uploads"),
+		S3Endpoint:         getEnv("S3_ENDPOINT", "http://localhost:9000"),
+		S3Region:           getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:           getEnv("S3_BUCKET", "role-play-ai"),
+		S3AccessKey:        getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:        getEnv("S3_SECRET_KEY", ""),
+		S3PathStyle:        getEnv("S3_PATH_STYLE", "true"),
+		MediaURLTTLMinutes: getEnv("MEDIA_URL_TTL_MINUTES", "60"),
+		MediaGCGraceHours:  getEnv("MEDIA_GC_GRACE_HOURS", "24"),
+		SynthesizerDriver:  getEnv("SYNTHESIZER_DRIVER", ""),
+		SynthesizerBaseURL: getEnv("SYNTHESIZER_BASE_URL", "http://localhost:5000"),
+		SynthesizerVoice:   getEnv("SYNTHESIZER_VOICE", ""),
 	}
 }
 
@@ -71,18 +71,35 @@ const (
 	ConversationCachePrefix = "conversation"
 	UserCachePrefix         = "user"
 	SessionCachePrefix      = "session"
+	UserSessionsPrefix      = "user_sessions"
 	RateLimitPrefix         = "rate_limit"
+	RateLimitOverridePrefix = "rate_limit_override"
 	AICachePrefix           = "ai_response"
+	PasswordResetPrefix     = "password_reset"
+	EmailVerifyPrefix       = "email_verify"
+	LoginChallengePrefix    = "login_challenge"
+	TOTPUsedPrefix          = "totp_used"
+	OIDCStatePrefix         = "oidc_state"
+	LoginFailurePrefix      = "login_failures"
+	LoginLockPrefix         = "login_lock"
+	LoginAuditPrefix        = "login_audit"
 )
 
 // 缓存过期时间
 const (
-	CharacterCacheExpiry    = 24 * time.Hour     // 角色信息缓存24小时
-	ConversationCacheExpiry = 1 * time.Hour      // 对话列表缓存1小时
-	UserCacheExpiry         = 30 * time.Minute   // 用户信息缓存30分钟
-	SessionCacheExpiry      = 7 * 24 * time.Hour // 会话缓存7天
-	RateLimitExpiry         = 1 * time.Minute    // 限流缓存1分钟
-	AICacheExpiry           = 1 * time.Hour      // AI响应缓存1小时
+	CharacterCacheExpiry     = 24 * time.Hour     // 角色信息缓存24小时
+	CharacterListCacheExpiry = 5 * time.Minute    // 角色列表按热度排序，缓存5分钟
+	ConversationCacheExpiry  = 1 * time.Hour      // 对话统计缓存1小时
+	UserCacheExpiry          = 30 * time.Minute   // 用户信息缓存30分钟
+	SessionCacheExpiry       = 7 * 24 * time.Hour // 会话缓存7天
+	RateLimitExpiry          = 1 * time.Minute    // 限流缓存1分钟
+	AICacheExpiry            = 1 * time.Hour      // AI响应缓存1小时
+	PasswordResetExpiry      = 1 * time.Hour      // 密码重置链接1小时有效
+	EmailVerifyExpiry        = 24 * time.Hour     // 邮箱验证链接24小时有效
+	LoginChallengeExpiry     = 5 * time.Minute    // 两步验证登录挑战5分钟有效
+	OIDCStateExpiry          = 10 * time.Minute   // OIDC授权请求10分钟有效
+	LoginFailureExpiry       = 1 * time.Hour      // 登录失败计数1小时内无新失败则清零
+	LoginAuditExpiry         = 7 * 24 * time.Hour // 登录失败记录保留7天
 )
 
 // SetCache 设置缓存
@@ -95,6 +112,11 @@ func GetCache(key string) (string, error) {
 	return RedisClient.Get(Ctx, key).Result()
 }
 
+// GetCacheMulti 批量获取缓存，结果与keys一一对应，不存在的键对应nil
+func GetCacheMulti(keys ...string) ([]interface{}, error) {
+	return RedisClient.MGet(Ctx, keys...).Result()
+}
+
 // DeleteCache 删除缓存
 func DeleteCache(key string) error {
 	return RedisClient.Del(Ctx, key).Err()
@@ -112,12 +134,38 @@ func DeleteCachePattern(pattern string) error {
 	return nil
 }
 
+// GetDelCache 获取并删除缓存，用于一次性令牌
+func GetDelCache(key string) (string, error) {
+	return RedisClient.GetDel(Ctx, key).Result()
+}
+
 // ExistsCache 检查缓存是否存在
 func ExistsCache(key string) (bool, error) {
 	result, err := RedisClient.Exists(Ctx, key).Result()
 	return result > 0, err
 }
 
+// IncrementHash 在一个管道中递增哈希的多个字段
+func IncrementHash(key string, increments map[string]int64) error {
+	pipe := RedisClient.Pipeline()
+	for field, n := range increments {
+		pipe.HIncrBy(Ctx, key, field, n)
+	}
+	_, err := pipe.Exec(Ctx)
+	return err
+}
+
+// TakeHash 原子地读取并删除哈希的全部字段
+func TakeHash(key string) (map[string]string, error) {
+	pipe := RedisClient.TxPipeline()
+	fields := pipe.HGetAll(Ctx, key)
+	pipe.Del(Ctx, key)
+	if _, err := pipe.Exec(Ctx); err != nil {
+		return nil, err
+	}
+	return fields.Val(), nil
+}
+
 // IncrementCache 递增缓存值
 func IncrementCache(key string, expiration time.Duration) (int64, error) {
 	pipe := RedisClient.Pipeline()
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"role-play-ai/internal/middleware"
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccountHandler struct {
+	userService   *services.UserService
+	exportService *services.ExportService
+	auditService  *services.AuditService
+}
+
+func NewAccountHandler(userService *services.UserService, exportService *services.ExportService, auditService *services.AuditService) *AccountHandler {
+	return &AccountHandler{
+		userService:   userService,
+		exportService: exportService,
+		auditService:  auditService,
+	}
+}
+
+// ExportData 导出个人数据
+// @Summary 导出个人数据
+// @Description 以ZIP压缩包下载账户信息、全部对话和消息（JSON和Markdown两种格式）
+// @Tags 账户
+// @Produce application/zip
+// @Security BearerAuth
+// @Success 200 {file} file "ZIP压缩包"
+// @Failure 401 {object} map[string]string "未授权"
+// @Router /auth/me/export [get]
+func (h *AccountHandler) ExportData(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	filename := fmt.Sprintf("role-play-ai-export-%s.zip", time.Now().UTC().Format("20060102"))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	// 边生成边写入响应，开始写入后出错只能中断连接
+	if err := h.exportService.WriteUserArchive(userID, c.Writer); err != nil {
+		log.Printf("Failed to export data for user %d: %v", userID, err)
+		if !c.Writer.Written() {
+			c.Header("Content-Disposition", "")
+			c.Header("Content-Type", "application/json; charset=utf-8")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export data"})
+		}
+		c.Abort()
+		return
+	}
+
+	h.auditService.Record(auditActor(c), models.AuditAccountExport, "user", strconv.Itoa(userID), nil)
+}
+
+// RequestDeletion 申请删除账户
+// @Summary 申请删除账户
+// @Description 验证密码后申请删除账户并登出所有设备，宽限期内API密钥停止生效，宽限期结束后永久删除账户、对话和消息，宽限期内重新登录可以撤销
+// @Tags 账户
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.DeleteAccountRequest true "删除账户请求"
+// @Success 202 {object} map[string]interface{} "已申请删除"
+// @Failure 400 {object} map[string]string "请求参数错误或密码错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /auth/me/delete [post]
+func (h *AccountHandler) RequestDeletion(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req models.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userService.RequestDeletion(auditActor(c), userID, req.Password)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := middleware.RevokeAllUserSessions(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":               "Account scheduled for deletion",
+		"deletion_scheduled_at": user.DeletionScheduledAt,
+	})
+}
+
+// CancelDeletion 撤销删除账户申请
+// @Summary 撤销删除账户
+// @Description 在宽限期内撤销删除账户申请
+// @Tags 账户
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]string "撤销成功"
+// @Failure 400 {object} map[string]string "未申请删除"
+// @Failure 401 {object} map[string]string "未授权"
+// @Router /auth/me/delete [delete]
+func (h *AccountHandler) CancelDeletion(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	if err := h.userService.CancelDeletion(auditActor(c), userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deletion cancelled"})
+}
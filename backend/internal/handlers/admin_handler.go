@@ -0,0 +1,561 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"role-play-ai/internal/middleware"
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	userService      *services.UserService
+	characterService *services.CharacterService
+	aiService        *services.AIService
+	loginGuard       *services.LoginGuard
+	auditService     *services.AuditService
+}
+
+func NewAdminHandler(userService *services.UserService, characterService *services.CharacterService, aiService *services.AIService, loginGuard *services.LoginGuard, auditService *services.AuditService) *AdminHandler {
+	return &AdminHandler{
+		userService:      userService,
+		characterService: characterService,
+		aiService:        aiService,
+		loginGuard:       loginGuard,
+		auditService:     auditService,
+	}
+}
+
+// ListUsers 获取用户列表
+// @Summary 获取用户列表
+// @Description 分页获取用户列表，可按用户名或邮箱搜索（仅管理员）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param q query string false "搜索关键词"
+// @Param page query int false "页码" default(1)
+// @Param limit query int false "每页数量" default(20)
+// @Success 200 {object} map[string]interface{} "用户列表"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /admin/users [get]
+func (h *AdminHandler) ListUsers(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	users, total, err := h.userService.ListUsers(c.Query("q"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": users,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+// GetUser 获取用户详情
+// @Summary 获取用户详情
+// @Description 获取用户信息、活跃会话和限流覆盖（仅管理员）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "用户ID"
+// @Success 200 {object} map[string]interface{} "用户详情"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 404 {object} map[string]string "用户不存在"
+// @Router /admin/users/{id} [get]
+func (h *AdminHandler) GetUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	sessions, err := middleware.GetUserSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+
+	lockedFor, err := h.loginGuard.Check(user.Email, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login lockout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"user":                 user,
+		"sessions":             sessions,
+		"rate_limit_overrides": middleware.GetRateLimitOverrides(userID),
+		"login_locked_seconds": int(lockedFor.Seconds()),
+	})
+}
+
+// UpdateUserRole 修改用户角色
+// @Summary 修改用户角色
+// @Description 将用户设置为 user、moderator 或 admin（仅管理员，不能修改自己的角色）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "用户ID"
+// @Param request body models.UpdateUserRoleRequest true "角色"
+// @Success 200 {object} map[string]string "修改成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 404 {object} map[string]string "用户不存在"
+// @Router /admin/users/{id}/role [put]
+func (h *AdminHandler) UpdateUserRole(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req models.UpdateUserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 防止管理员误操作把自己降级，导致系统没有管理员
+	if userID == c.GetInt("user_id") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot change your own role"})
+		return
+	}
+
+	if err := h.userService.SetUserRole(auditActor(c), userID, req.Role); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User role updated successfully"})
+}
+
+// RevokeUserSessions 撤销用户的所有会话
+// @Summary 撤销用户会话
+// @Description 强制用户在所有设备上登出（仅管理员）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "用户ID"
+// @Success 200 {object} map[string]string "撤销成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /admin/users/{id}/sessions [delete]
+func (h *AdminHandler) RevokeUserSessions(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := middleware.RevokeAllUserSessions(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+	h.auditService.Record(auditActor(c), models.AuditSessionRevokeAll, "user", strconv.Itoa(userID), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "User sessions revoked successfully"})
+}
+
+// UnlockUser 解除用户的登录锁定
+// @Summary 解除登录锁定
+// @Description 清除用户邮箱的登录失败计数并解除锁定（仅管理员）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "用户ID"
+// @Success 200 {object} map[string]string "解除成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 404 {object} map[string]string "用户不存在"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /admin/users/{id}/lockout [delete]
+func (h *AdminHandler) UnlockUser(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	user, err := h.userService.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	if err := h.loginGuard.Unlock(user.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock user"})
+		return
+	}
+	h.auditService.Record(auditActor(c), models.AuditUserUnlock, "user", strconv.Itoa(userID), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
+
+// UnlockIP 解除IP的登录锁定
+// @Summary 解除IP登录锁定
+// @Description 清除IP的登录失败计数并解除锁定（仅管理员）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param ip path string true "IP地址"
+// @Success 200 {object} map[string]string "解除成功"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /admin/ip-lockouts/{ip} [delete]
+func (h *AdminHandler) UnlockIP(c *gin.Context) {
+	if err := h.loginGuard.UnlockIP(c.Param("ip")); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock IP"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "IP unlocked successfully"})
+}
+
+// GetLoginFailures 获取登录失败记录
+// @Summary 获取登录失败记录
+// @Description 获取最近的登录失败记录，可按邮箱过滤（仅管理员）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param email query string false "邮箱"
+// @Param limit query int false "数量" default(100)
+// @Success 200 {object} map[string]interface{} "登录失败记录"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /admin/login-failures [get]
+func (h *AdminHandler) GetLoginFailures(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	failures, err := h.loginGuard.GetFailures(c.Query("email"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"failures": failures})
+}
+
+// GetRateLimitOverrides 获取用户的限流覆盖
+// @Summary 获取用户限流覆盖
+// @Description 获取用户在各限流器上的自定义限额（仅管理员）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "用户ID"
+// @Success 200 {object} map[string]interface{} "限流覆盖"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Router /admin/users/{id}/rate-limits [get]
+func (h *AdminHandler) GetRateLimitOverrides(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rate_limit_overrides": middleware.GetRateLimitOverrides(userID)})
+}
+
+// SetRateLimitOverride 设置用户的限流覆盖
+// @Summary 设置用户限流覆盖
+// @Description 为用户在指定限流器上设置自定义限额，ttl_hours为空表示永久有效（仅管理员）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "用户ID"
+// @Param request body models.RateLimitOverrideRequest true "限流覆盖"
+// @Success 200 {object} map[string]string "设置成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 404 {object} map[string]string "用户不存在"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /admin/users/{id}/rate-limits [put]
+func (h *AdminHandler) SetRateLimitOverride(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req models.RateLimitOverrideRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := h.userService.GetUserByID(userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	ttl := time.Duration(req.TTLHours) * time.Hour
+	if err := middleware.SetRateLimitOverride(req.Limiter, userID, req.MaxRequests, ttl); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set rate limit override"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Rate limit override set successfully"})
+}
+
+// DeleteRateLimitOverride 删除用户的限流覆盖
+// @Summary 删除用户限流覆盖
+// @Description 恢复用户在指定限流器上的默认限额（仅管理员）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "用户ID"
+// @Param limiter path string true "限流器名称（api 或 ai_chat）"
+// @Success 200 {object} map[string]string "删除成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /admin/users/{id}/rate-limits/{limiter} [delete]
+func (h *AdminHandler) DeleteRateLimitOverride(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	if err := middleware.DeleteRateLimitOverride(c.Param("limiter"), userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete rate limit override"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Rate limit override deleted successfully"})
+}
+
+// ListCharacters 获取全部角色
+// @Summary 获取全部角色
+// @Description 获取包括未发布角色在内的全部角色（管理员和版主）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "角色列表"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /admin/characters [get]
+func (h *AdminHandler) ListCharacters(c *gin.Context) {
+	characters, err := h.characterService.ListAllCharacters()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"characters": characters})
+}
+
+// CreateCharacter 创建角色
+// @Summary 创建角色
+// @Description 创建新角色，默认直接发布（管理员和版主）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.CharacterRequest true "角色信息"
+// @Success 201 {object} models.Character "创建成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Router /admin/characters [post]
+func (h *AdminHandler) CreateCharacter(c *gin.Context) {
+	var req models.CharacterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	character, err := h.characterService.CreateCharacter(auditActor(c), &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, character)
+}
+
+// UpdateCharacter 更新角色
+// @Summary 更新角色
+// @Description 更新角色信息和系统提示词（管理员和版主）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "角色ID"
+// @Param request body models.CharacterRequest true "角色信息"
+// @Success 200 {object} models.Character "更新成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 404 {object} map[string]string "角色不存在"
+// @Router /admin/characters/{id} [put]
+func (h *AdminHandler) UpdateCharacter(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+		return
+	}
+
+	var req models.CharacterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	character, err := h.characterService.UpdateCharacter(auditActor(c), id, &req)
+	if err != nil {
+		if err.Error() == "character not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, character)
+}
+
+// PublishCharacter 发布或下架角色
+// @Summary 发布或下架角色
+// @Description 下架的角色不出现在角色列表中，也不能发起新对话，已有对话不受影响（管理员和版主）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "角色ID"
+// @Param request body models.PublishCharacterRequest true "发布状态"
+// @Success 200 {object} map[string]string "操作成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 404 {object} map[string]string "角色不存在"
+// @Router /admin/characters/{id}/publish [put]
+func (h *AdminHandler) PublishCharacter(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+		return
+	}
+
+	var req models.PublishCharacterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.characterService.SetCharacterPublished(auditActor(c), id, *req.Published); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Character updated successfully"})
+}
+
+// DeleteCharacter 删除角色
+// @Summary 删除角色
+// @Description 删除没有对话的角色，已有对话的角色请下架（仅管理员）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "角色ID"
+// @Success 200 {object} map[string]string "删除成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 404 {object} map[string]string "角色不存在"
+// @Router /admin/characters/{id} [delete]
+func (h *AdminHandler) DeleteCharacter(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+		return
+	}
+
+	if err := h.characterService.DeleteCharacter(auditActor(c), id); err != nil {
+		if err.Error() == "character not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Character deleted successfully"})
+}
+
+// GetCacheStats 获取缓存统计
+// @Summary 获取缓存统计
+// @Description 获取AI响应缓存和角色缓存的数量（管理员和版主）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "缓存统计"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /admin/cache/stats [get]
+func (h *AdminHandler) GetCacheStats(c *gin.Context) {
+	stats, err := h.aiService.GetCacheStats()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get cache stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// FlushAICache 清除AI响应缓存
+// @Summary 清除AI响应缓存
+// @Description 清除全部AI响应缓存，修改系统提示词后可用于让新回复立即生效（仅管理员）
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]string "清除成功"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /admin/cache/ai [delete]
+func (h *AdminHandler) FlushAICache(c *gin.Context) {
+	if err := h.aiService.ClearAICache(0); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to flush AI cache"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "AI cache flushed successfully"})
+}
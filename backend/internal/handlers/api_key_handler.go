@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyHandler struct {
+	apiKeyService *services.APIKeyService
+}
+
+func NewAPIKeyHandler(apiKeyService *services.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+	}
+}
+
+// GetAPIKeys 获取API密钥列表
+// @Summary 获取API密钥列表
+// @Description 获取当前用户未撤销的API密钥（不含密钥明文）
+// @Tags API密钥
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "API密钥列表"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /auth/api-keys [get]
+func (h *APIKeyHandler) GetAPIKeys(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	keys, err := h.apiKeyService.ListAPIKeys(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+// CreateAPIKey 创建API密钥
+// @Summary 创建API密钥
+// @Description 创建带权限范围和有效期的API密钥，密钥明文只在本次响应中返回
+// @Tags API密钥
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.CreateAPIKeyRequest true "创建API密钥请求"
+// @Success 201 {object} map[string]interface{} "创建成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Router /auth/api-keys [post]
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key, plaintext, err := h.apiKeyService.CreateAPIKey(userID, &req)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "API key created, store it now: it will not be shown again",
+		"api_key": key,
+		"key":     plaintext,
+	})
+}
+
+// RevokeAPIKey 撤销API密钥
+// @Summary 撤销API密钥
+// @Description 撤销指定的API密钥，撤销后立即失效
+// @Tags API密钥
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "API密钥ID"
+// @Success 200 {object} map[string]string "撤销成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "API密钥不存在"
+// @Router /auth/api-keys/{id} [delete]
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	if err := h.apiKeyService.RevokeAPIKey(id, userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditHandler struct {
+	auditService *services.AuditService
+}
+
+func NewAuditHandler(auditService *services.AuditService) *AuditHandler {
+	return &AuditHandler{
+		auditService: auditService,
+	}
+}
+
+// auditActor 根据请求上下文构造审计操作者
+func auditActor(c *gin.Context) *models.AuditActor {
+	return &models.AuditActor{
+		UserID:    c.GetInt("user_id"),
+		APIKeyID:  c.GetInt("api_key_id"),
+		IP:        c.ClientIP(),
+		UserAgent: c.GetHeader("User-Agent"),
+	}
+}
+
+// ListAuditEvents 查询审计日志
+// @Summary 查询审计日志
+// @Description 按操作者、事件类型、目标、IP和时间范围查询审计日志，format=csv时导出CSV文件（仅管理员）
+// @Tags 管理
+// @Accept json
+// @Produce json,text/csv
+// @Security BearerAuth
+// @Param actor_id query int false "操作者用户ID"
+// @Param action query string false "事件类型，以.结尾时按前缀匹配（如 auth.）"
+// @Param target_type query string false "目标类型"
+// @Param target_id query string false "目标ID"
+// @Param ip query string false "IP地址"
+// @Param from query string false "开始时间（RFC3339）"
+// @Param to query string false "结束时间（RFC3339）"
+// @Param page query int false "页码" default(1)
+// @Param limit query int false "每页数量" default(50)
+// @Param format query string false "导出格式" Enums(json, csv)
+// @Success 200 {object} map[string]interface{} "审计日志"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /admin/audit-events [get]
+func (h *AuditHandler) ListAuditEvents(c *gin.Context) {
+	filter, err := parseAuditEventFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		h.exportAuditEvents(c, filter)
+		return
+	}
+
+	events, total, err := h.auditService.ListEvents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"total":  total,
+		"page":   filter.Page,
+		"limit":  filter.Limit,
+	})
+}
+
+func (h *AuditHandler) exportAuditEvents(c *gin.Context, filter *models.AuditEventFilter) {
+	filename := fmt.Sprintf("audit-events-%s.csv", time.Now().UTC().Format("20060102-150405"))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"id", "created_at", "actor_user_id", "actor_api_key_id", "ip", "user_agent", "action", "target_type", "target_id", "metadata"})
+
+	err := h.auditService.ExportEvents(filter, func(event *models.AuditEvent) error {
+		return writer.Write([]string{
+			strconv.FormatInt(event.ID, 10),
+			event.CreatedAt.UTC().Format(time.RFC3339Nano),
+			optionalInt(event.ActorUserID),
+			optionalInt(event.ActorAPIKeyID),
+			csvCell(event.IP),
+			csvCell(event.UserAgent),
+			csvCell(event.Action),
+			csvCell(event.TargetType),
+			csvCell(event.TargetID),
+			csvCell(string(event.Metadata)),
+		})
+	})
+	writer.Flush()
+
+	// 响应头已经发送，只能中断输出
+	if err != nil {
+		c.Error(err)
+		c.Abort()
+	}
+}
+
+func parseAuditEventFilter(c *gin.Context) (*models.AuditEventFilter, error) {
+	filter := &models.AuditEventFilter{
+		Action:     c.Query("action"),
+		TargetType: c.Query("target_type"),
+		TargetID:   c.Query("target_id"),
+		IP:         c.Query("ip"),
+	}
+
+	if actorID := c.Query("actor_id"); actorID != "" {
+		id, err := strconv.Atoi(actorID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid actor_id")
+		}
+		filter.ActorUserID = id
+	}
+
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{{"from", &filter.From}, {"to", &filter.To}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s, expected RFC3339 time", param.name)
+		}
+		*param.dest = &t
+	}
+
+	filter.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	filter.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.Limit < 1 || filter.Limit > 200 {
+		filter.Limit = 50
+	}
+
+	return filter, nil
+}
+
+func optionalInt(value *int) string {
+	if value == nil {
+		return ""
+	}
+	return strconv.Itoa(*value)
+}
+
+// csvCell 在以公式字符开头的值前加单引号，避免管理员用电子表格打开导出文件时执行攻击者写入的公式（如登录时伪造的User-Agent）
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
@@ -1,9 +1,15 @@
 package handlers
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
+	"role-play-ai/internal/database"
 	"role-play-ai/internal/middleware"
 	"role-play-ai/internal/models"
 	"role-play-ai/internal/services"
@@ -13,25 +19,31 @@ import (
 )
 
 type AuthHandler struct {
-	userService *services.UserService
-	jwtSecret   string
+	userService      *services.UserService
+	twoFactorService *services.TwoFactorService
+	loginGuard       *services.LoginGuard
+	auditService     *services.AuditService
+	jwtSecret        string
 }
 
-func NewAuthHandler(userService *services.UserService, jwtSecret string) *AuthHandler {
+func NewAuthHandler(userService *services.UserService, twoFactorService *services.TwoFactorService, loginGuard *services.LoginGuard, auditService *services.AuditService, jwtSecret string) *AuthHandler {
 	return &AuthHandler{
-		userService: userService,
-		jwtSecret:   jwtSecret,
+		userService:      userService,
+		twoFactorService: twoFactorService,
+		loginGuard:       loginGuard,
+		auditService:     auditService,
+		jwtSecret:        jwtSecret,
 	}
 }
 
 // Register 用户注册
 // @Summary 用户注册
-// @Description 创建新用户账户
+// @Description 创建新用户账户。为避免泄露邮箱或用户名是否已注册，无论结果如何都返回相同响应，注册结果通过邮件通知；注册成功后使用登录接口获取token
 // @Tags 认证
 // @Accept json
 // @Produce json
 // @Param request body models.UserRegister true "用户注册信息"
-// @Success 201 {object} map[string]interface{} "注册成功"
+// @Success 202 {object} map[string]string "注册请求已受理"
 // @Failure 400 {object} map[string]string "请求参数错误"
 // @Failure 500 {object} map[string]string "服务器内部错误"
 // @Router /auth/register [post]
@@ -42,71 +54,146 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	user, err := h.userService.CreateUser(&req)
-	if err != nil {
+	if err := h.userService.Register(&req); err != nil {
+		log.Printf("Failed to register user: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "Registration received, please check your email",
+	})
+}
+
+// Login 用户登录
+// @Summary 用户登录
+// @Description 用户登录获取JWT token
+// @Tags 认证
+// @Accept json
+// @Produce json
+// @Param request body models.UserLogin true "用户登录信息"
+// @Success 200 {object} map[string]interface{} "登录成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "认证失败"
+// @Failure 429 {object} map[string]interface{} "登录失败次数过多，暂时锁定"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /auth/login [post]
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req models.UserLogin
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 生成JWT token
-	token, err := h.generateToken(user.ID)
+	retryAfter, err := h.loginGuard.Check(req.Email, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+	if retryAfter > 0 {
+		abortTooManyLoginAttempts(c, retryAfter)
+		return
+	}
+
+	user, err := h.userService.VerifyPassword(req.Email, req.Password)
+	if err == services.ErrInvalidCredentials {
+		lock, guardErr := h.loginGuard.RecordFailure(req.Email, c.ClientIP(), c.GetHeader("User-Agent"), "invalid_credentials")
+		if guardErr != nil {
+			log.Printf("Failed to record login failure: %v", guardErr)
+		}
+		h.auditService.Record(auditActor(c), models.AuditLoginFailed, "user", "", map[string]interface{}{
+			"email": req.Email,
+		})
+		if lock > 0 {
+			abortTooManyLoginAttempts(c, lock)
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify credentials"})
 		return
 	}
+	h.loginGuard.RecordSuccess(req.Email)
 
-	// 存储会话到Redis
-	err = middleware.StoreSession(user.ID, token)
+	// 开启两步验证的用户先签发挑战令牌，验证通过后才创建会话
+	enabled, err := h.twoFactorService.IsEnabled(user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store session"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor status"})
 		return
 	}
+	if enabled {
+		challengeToken, err := h.twoFactorService.CreateLoginChallenge(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create login challenge"})
+			return
+		}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "User created successfully",
+		c.JSON(http.StatusOK, gin.H{
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     challengeToken,
+			"expires_in":          int(database.LoginChallengeExpiry.Seconds()),
+		})
+		return
+	}
+
+	// 生成JWT token并存储会话到Redis
+	token, err := h.createSession(c, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+	h.recordLogin(c, user.ID, token, "password")
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Login successful",
 		"token":   token,
 		"user":    user,
 	})
 }
 
-// Login 用户登录
-// @Summary 用户登录
-// @Description 用户登录获取JWT token
+// LoginTwoFactor 两步验证登录
+// @Summary 两步验证登录
+// @Description 使用登录时返回的挑战令牌和TOTP验证码（或恢复码）完成登录
 // @Tags 认证
 // @Accept json
 // @Produce json
-// @Param request body models.UserLogin true "用户登录信息"
+// @Param request body models.LoginTwoFactorRequest true "两步验证登录信息"
 // @Success 200 {object} map[string]interface{} "登录成功"
 // @Failure 400 {object} map[string]string "请求参数错误"
-// @Failure 401 {object} map[string]string "认证失败"
+// @Failure 401 {object} map[string]string "验证失败"
 // @Failure 500 {object} map[string]string "服务器内部错误"
-// @Router /auth/login [post]
-func (h *AuthHandler) Login(c *gin.Context) {
-	var req models.UserLogin
+// @Router /auth/login/2fa [post]
+func (h *AuthHandler) LoginTwoFactor(c *gin.Context) {
+	var req models.LoginTwoFactorRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	user, err := h.userService.VerifyPassword(req.Email, req.Password)
+	userID, err := h.twoFactorService.CompleteLoginChallenge(req.ChallengeToken, req.Code)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
+		h.auditService.Record(auditActor(c), models.AuditLoginFailed, "user", "", map[string]interface{}{
+			"method": "two_factor",
+		})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 		return
 	}
 
-	// 生成JWT token
-	token, err := h.generateToken(user.ID)
+	user, err := h.userService.GetUserByID(userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
 
-	// 存储会话到Redis
-	err = middleware.StoreSession(user.ID, token)
+	token, err := h.createSession(c, user.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store session"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
 		return
 	}
+	h.recordLogin(c, user.ID, token, "password+two_factor")
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Login successful",
@@ -158,15 +245,462 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 		token := authHeader[7:]
 		// 从Redis中删除会话
 		middleware.RevokeSession(token)
+		h.auditService.Record(auditActor(c), models.AuditLogout, "session", middleware.SessionID(token), nil)
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Logout successful"})
 }
 
+// GetSessions 获取当前用户的活跃会话
+// @Summary 获取会话列表
+// @Description 获取当前用户所有已登录设备的会话信息
+// @Tags 认证
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "会话列表"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /auth/sessions [get]
+func (h *AuthHandler) GetSessions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	currentID := c.GetString("session_id")
+
+	sessions, err := middleware.GetUserSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get sessions"})
+		return
+	}
+
+	for _, session := range sessions {
+		session.Current = session.ID == currentID
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeSession 撤销指定会话
+// @Summary 撤销会话
+// @Description 撤销当前用户的指定会话（登出该设备）
+// @Tags 认证
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path string true "会话ID"
+// @Success 200 {object} map[string]string "撤销成功"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "会话不存在"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /auth/sessions/{id} [delete]
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	err := middleware.RevokeUserSession(userID, c.Param("id"))
+	if err == middleware.ErrSessionNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+	h.auditService.Record(auditActor(c), models.AuditSessionRevoke, "session", c.Param("id"), nil)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// RevokeOtherSessions 撤销除当前会话外的所有会话
+// @Summary 撤销其他会话
+// @Description 登出除当前设备外的所有设备
+// @Tags 认证
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "撤销成功"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /auth/sessions [delete]
+func (h *AuthHandler) RevokeOtherSessions(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	revoked, err := middleware.RevokeOtherUserSessions(userID, c.GetString("session_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+	h.auditService.Record(auditActor(c), models.AuditSessionRevokeOthers, "user", strconv.Itoa(userID), map[string]interface{}{
+		"revoked_count": revoked,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Other sessions revoked successfully",
+		"revoked_count": revoked,
+	})
+}
+
+// ChangePassword 修改密码
+// @Summary 修改密码
+// @Description 验证当前密码后设置新密码，成功后撤销所有会话并为当前设备签发新token
+// @Tags 认证
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.ChangePasswordRequest true "修改密码请求"
+// @Success 200 {object} map[string]interface{} "修改成功"
+// @Failure 400 {object} map[string]string "请求参数错误或当前密码错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /auth/password [put]
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.userService.ChangePassword(auditActor(c), userID, req.CurrentPassword, req.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 密码变更后撤销所有会话，包括当前会话
+	if err := middleware.RevokeAllUserSessions(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	token, err := h.createSession(c, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password changed successfully",
+		"token":   token,
+	})
+}
+
+// ForgotPassword 忘记密码
+// @Summary 忘记密码
+// @Description 向邮箱发送密码重置链接，无论邮箱是否注册都返回相同结果
+// @Tags 认证
+// @Accept json
+// @Produce json
+// @Param request body models.ForgotPasswordRequest true "忘记密码请求"
+// @Success 200 {object} map[string]string "请求已受理"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Router /auth/password/forgot [post]
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.userService.SendPasswordReset(req.Email); err != nil {
+		log.Printf("Failed to send password reset email: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "If the email is registered, a password reset link has been sent"})
+}
+
+// ResetPassword 重置密码
+// @Summary 重置密码
+// @Description 使用邮件中的一次性令牌设置新密码，成功后撤销该用户的所有会话
+// @Tags 认证
+// @Accept json
+// @Produce json
+// @Param request body models.ResetPasswordRequest true "重置密码请求"
+// @Success 200 {object} map[string]string "重置成功"
+// @Failure 400 {object} map[string]string "令牌无效或已过期"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /auth/password/reset [post]
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, err := h.userService.ResetPassword(auditActor(c), req.Token, req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := middleware.RevokeAllUserSessions(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	// 通过邮件重置密码证明了邮箱所有权，同时解除登录锁定
+	if user, err := h.userService.GetUserByID(userID); err == nil {
+		h.loginGuard.Unlock(user.Email)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+// VerifyEmail 验证邮箱
+// @Summary 验证邮箱
+// @Description 使用邮件中的令牌确认邮箱地址
+// @Tags 认证
+// @Accept json
+// @Produce json
+// @Param request body models.VerifyEmailRequest true "邮箱验证请求"
+// @Success 200 {object} map[string]interface{} "验证成功"
+// @Failure 400 {object} map[string]string "令牌无效或已过期"
+// @Router /auth/email/verify [post]
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userService.VerifyEmail(req.Token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Email verified successfully",
+		"user":    user,
+	})
+}
+
+// ResendVerification 重新发送验证邮件
+// @Summary 重新发送验证邮件
+// @Description 向当前用户的邮箱重新发送验证链接
+// @Tags 认证
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]string "发送成功"
+// @Failure 400 {object} map[string]string "邮箱已验证"
+// @Failure 401 {object} map[string]string "未授权"
+// @Router /auth/email/verify/resend [post]
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	if err := h.userService.SendEmailVerification(userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+}
+
+// GetTwoFactorStatus 获取两步验证状态
+// @Summary 获取两步验证状态
+// @Description 获取当前用户是否开启两步验证及剩余恢复码数量
+// @Tags 两步验证
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "两步验证状态"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /auth/2fa [get]
+func (h *AuthHandler) GetTwoFactorStatus(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	enabled, err := h.twoFactorService.IsEnabled(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	remaining := 0
+	if enabled {
+		remaining, err = h.twoFactorService.RemainingRecoveryCodes(userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  enabled,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// SetupTwoFactor 开始两步验证绑定
+// @Summary 开始绑定两步验证
+// @Description 生成TOTP密钥和otpauth URI（用于生成二维码），需调用确认接口后才会生效
+// @Tags 两步验证
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "密钥和URI"
+// @Failure 400 {object} map[string]string "已开启两步验证"
+// @Failure 401 {object} map[string]string "未授权"
+// @Router /auth/2fa/setup [post]
+func (h *AuthHandler) SetupTwoFactor(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	secret, uri, err := h.twoFactorService.BeginEnrollment(userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": uri,
+	})
+}
+
+// EnableTwoFactor 确认并开启两步验证
+// @Summary 开启两步验证
+// @Description 使用验证器应用生成的验证码确认绑定，返回只展示一次的恢复码
+// @Tags 两步验证
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.TwoFactorCodeRequest true "验证码"
+// @Success 200 {object} map[string]interface{} "开启成功，返回恢复码"
+// @Failure 400 {object} map[string]string "验证码错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Router /auth/2fa/enable [post]
+func (h *AuthHandler) EnableTwoFactor(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.twoFactorService.ConfirmEnrollment(userID, req.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor 关闭两步验证
+// @Summary 关闭两步验证
+// @Description 验证密码和验证码（或恢复码）后关闭两步验证，未设置密码的账户（仅单点登录）只需验证码
+// @Tags 两步验证
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.DisableTwoFactorRequest true "密码和验证码"
+// @Success 200 {object} map[string]string "关闭成功"
+// @Failure 400 {object} map[string]string "密码或验证码错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Router /auth/2fa/disable [post]
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req models.DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.userService.ConfirmPassword(userID, req.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.twoFactorService.Disable(userID, req.Code); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// RegenerateRecoveryCodes 重新生成恢复码
+// @Summary 重新生成恢复码
+// @Description 验证后重新生成恢复码，旧恢复码全部失效
+// @Tags 两步验证
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.TwoFactorCodeRequest true "验证码"
+// @Success 200 {object} map[string]interface{} "新的恢复码"
+// @Failure 400 {object} map[string]string "验证码错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Router /auth/2fa/recovery-codes [post]
+func (h *AuthHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	var req models.TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	codes, err := h.twoFactorService.RegenerateRecoveryCodes(userID, req.Code)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// recordLogin 记录登录成功的审计事件
+func (h *AuthHandler) recordLogin(c *gin.Context, userID int, token, method string) {
+	actor := auditActor(c)
+	actor.UserID = userID
+	h.auditService.Record(actor, models.AuditLogin, "session", middleware.SessionID(token), map[string]interface{}{
+		"method": method,
+	})
+}
+
+// abortTooManyLoginAttempts 返回登录锁定响应
+func abortTooManyLoginAttempts(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(retryAfter.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many failed login attempts, please try again later",
+		"retry_after": seconds,
+	})
+}
+
+// createSession 生成JWT token并将会话（含IP和User-Agent）存储到Redis
+func (h *AuthHandler) createSession(c *gin.Context, userID int) (string, error) {
+	token, err := h.generateToken(userID)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	if err := middleware.StoreSession(userID, token, c.ClientIP(), c.Request.UserAgent()); err != nil {
+		return "", fmt.Errorf("failed to store session: %w", err)
+	}
+
+	return token, nil
+}
+
 func (h *AuthHandler) generateToken(userID int) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
 	claims := jwt.MapClaims{
 		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24 * 7).Unix(), // 7天过期
+		"jti":     hex.EncodeToString(jti), // 保证同一秒内签发的token也互不相同
+		"iat":     now.Unix(),
+		"exp":     now.Add(time.Hour * 24 * 7).Unix(), // 7天过期
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
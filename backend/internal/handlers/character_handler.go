@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 
+	"role-play-ai/internal/models"
 	"role-play-ai/internal/services"
 
 	"github.com/gin-gonic/gin"
@@ -11,31 +15,90 @@ import (
 
 type CharacterHandler struct {
 	characterService *services.CharacterService
+	favoriteService  *services.FavoriteService
+	ratingService    *services.RatingService
 }
 
-func NewCharacterHandler(characterService *services.CharacterService) *CharacterHandler {
+func NewCharacterHandler(characterService *services.CharacterService, favoriteService *services.FavoriteService, ratingService *services.RatingService) *CharacterHandler {
 	return &CharacterHandler{
 		characterService: characterService,
+		favoriteService:  favoriteService,
+		ratingService:    ratingService,
 	}
 }
 
-// GetCharacters 获取所有角色
+// GetCharacters 获取角色列表
 // @Summary 获取角色列表
-// @Description 获取所有可用的AI角色列表
+// @Description 分页获取已发布的角色，支持关键词、分类和标签筛选，返回分类和标签的分面统计
 // @Tags 角色
 // @Accept json
 // @Produce json
+// @Param q query string false "搜索关键词，匹配名称、描述、分类和标签"
+// @Param category query string false "分类"
+// @Param tags query string false "标签，多个用逗号分隔，需全部匹配"
+// @Param sort query string false "排序：relevance（有关键词时默认）、popular（默认）、rating、favorites、recent、name"
+// @Param page query int false "页码" default(1)
+// @Param limit query int false "每页数量，最大100" default(50)
 // @Success 200 {object} map[string]interface{} "角色列表"
+// @Failure 400 {object} map[string]string "请求参数错误"
 // @Failure 500 {object} map[string]string "服务器内部错误"
 // @Router /characters [get]
 func (h *CharacterHandler) GetCharacters(c *gin.Context) {
-	characters, err := h.characterService.GetCharacters()
+	query, err := parseCharacterQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	list, err := h.characterService.DiscoverCharacters(query)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"characters": characters})
+	c.JSON(http.StatusOK, list)
+}
+
+// parseCharacterQuery 解析角色列表的查询参数
+func parseCharacterQuery(c *gin.Context) (*models.CharacterQuery, error) {
+	query := &models.CharacterQuery{
+		Query:    strings.TrimSpace(c.Query("q")),
+		Category: strings.TrimSpace(c.Query("category")),
+		Sort:     c.Query("sort"),
+	}
+	if utf8.RuneCountInString(query.Query) > 100 {
+		return nil, fmt.Errorf("search query is too long")
+	}
+
+	// 同时支持 tags=a,b 和 tags=a&tags=b
+	for _, value := range c.QueryArray("tags") {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				query.Tags = append(query.Tags, tag)
+			}
+		}
+	}
+	if len(query.Tags) > 10 {
+		return nil, fmt.Errorf("too many tags, maximum is 10")
+	}
+
+	switch query.Sort {
+	case "", services.CharacterSortRelevance, services.CharacterSortPopular, services.CharacterSortRating,
+		services.CharacterSortFavorites, services.CharacterSortRecent, services.CharacterSortName:
+	default:
+		return nil, fmt.Errorf("invalid sort, expected relevance, popular, rating, favorites, recent or name")
+	}
+
+	query.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	query.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.Limit < 1 || query.Limit > 100 {
+		query.Limit = 50
+	}
+
+	return query, nil
 }
 
 // GetCharacter 获取单个角色
@@ -69,23 +132,57 @@ func (h *CharacterHandler) GetCharacter(c *gin.Context) {
 
 // SearchCharacters 搜索角色
 // @Summary 搜索角色
-// @Description 根据关键词搜索角色
+// @Description 根据关键词搜索角色，按相关度排序（名称完全匹配、名称前缀匹配优先）。支持与角色列表相同的筛选和分页参数
 // @Tags 角色
 // @Accept json
 // @Produce json
-// @Param query query string true "搜索关键词"
+// @Param q query string true "搜索关键词"
+// @Param category query string false "分类"
+// @Param tags query string false "标签，多个用逗号分隔"
+// @Param page query int false "页码" default(1)
+// @Param limit query int false "每页数量，最大100" default(50)
 // @Success 200 {object} map[string]interface{} "搜索结果"
 // @Failure 400 {object} map[string]string "请求参数错误"
 // @Failure 500 {object} map[string]string "服务器内部错误"
 // @Router /characters/search [get]
 func (h *CharacterHandler) SearchCharacters(c *gin.Context) {
-	query := c.Query("q")
-	if query == "" {
+	query, err := parseCharacterQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if query.Query == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
 		return
 	}
+	if query.Sort == "" {
+		query.Sort = services.CharacterSortRelevance
+	}
 
-	characters, err := h.characterService.SearchCharacters(query)
+	list, err := h.characterService.DiscoverCharacters(query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// GetFavorites 获取收藏的角色
+// @Summary 获取收藏的角色
+// @Description 获取当前用户收藏的已发布角色，最近收藏的在前
+// @Tags 角色
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "角色列表"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /characters/favorites [get]
+func (h *CharacterHandler) GetFavorites(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	characters, err := h.favoriteService.GetFavorites(userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -93,3 +190,230 @@ func (h *CharacterHandler) SearchCharacters(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"characters": characters})
 }
+
+// AddFavorite 收藏角色
+// @Summary 收藏角色
+// @Description 收藏已发布的角色，重复收藏不报错
+// @Tags 角色
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "角色ID"
+// @Success 200 {object} map[string]string "收藏成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "角色不存在"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /characters/{id}/favorite [post]
+func (h *CharacterHandler) AddFavorite(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+		return
+	}
+
+	if err := h.favoriteService.AddFavorite(userID, id); err != nil {
+		if err.Error() == "character not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Character added to favorites"})
+}
+
+// RemoveFavorite 取消收藏角色
+// @Summary 取消收藏角色
+// @Description 从收藏中移除角色
+// @Tags 角色
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "角色ID"
+// @Success 200 {object} map[string]string "取消成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "未收藏该角色"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /characters/{id}/favorite [delete]
+func (h *CharacterHandler) RemoveFavorite(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+		return
+	}
+
+	if err := h.favoriteService.RemoveFavorite(userID, id); err != nil {
+		if err.Error() == "favorite not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Character removed from favorites"})
+}
+
+// GetRatings 获取角色的评分和评价
+// @Summary 获取角色评价
+// @Description 分页获取角色的评分和评价，最近更新的在前
+// @Tags 角色
+// @Accept json
+// @Produce json
+// @Param id path int true "角色ID"
+// @Param page query int false "页码" default(1)
+// @Param limit query int false "每页数量，最大50" default(20)
+// @Success 200 {object} map[string]interface{} "评价列表"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 404 {object} map[string]string "角色不存在"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /characters/{id}/ratings [get]
+func (h *CharacterHandler) GetRatings(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 50 {
+		limit = 20
+	}
+
+	ratings, total, err := h.ratingService.GetCharacterRatings(id, page, limit)
+	if err != nil {
+		if err.Error() == "character not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"ratings": ratings,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+// GetMyRating 获取自己对角色的评分
+// @Summary 获取我的评分
+// @Description 获取当前用户对角色的评分和评价
+// @Tags 角色
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "角色ID"
+// @Success 200 {object} map[string]interface{} "评分"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "尚未评分"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /characters/{id}/rating [get]
+func (h *CharacterHandler) GetMyRating(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+		return
+	}
+
+	rating, err := h.ratingService.GetUserRating(userID, id)
+	if err != nil {
+		if err.Error() == "rating not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rating": rating})
+}
+
+// RateCharacter 给角色评分
+// @Summary 给角色评分
+// @Description 给已发布的角色打1-5星并可附带评价，再次提交会覆盖之前的评分。角色的平均评分异步更新
+// @Tags 角色
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "角色ID"
+// @Param request body models.RateCharacterRequest true "评分"
+// @Success 200 {object} map[string]interface{} "评分"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "角色不存在"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /characters/{id}/rating [put]
+func (h *CharacterHandler) RateCharacter(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+		return
+	}
+
+	var req models.RateCharacterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rating, err := h.ratingService.RateCharacter(userID, id, &req)
+	if err != nil {
+		if err.Error() == "character not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rating": rating})
+}
+
+// DeleteRating 删除对角色的评分
+// @Summary 删除我的评分
+// @Description 删除当前用户对角色的评分和评价
+// @Tags 角色
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "角色ID"
+// @Success 200 {object} map[string]string "删除成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "尚未评分"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /characters/{id}/rating [delete]
+func (h *CharacterHandler) DeleteRating(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+		return
+	}
+
+	if err := h.ratingService.DeleteRating(userID, id); err != nil {
+		if err.Error() == "rating not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Rating deleted successfully"})
+}
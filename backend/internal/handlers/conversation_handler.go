@@ -2,9 +2,16 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"role-play-ai/internal/models"
 	"role-play-ai/internal/services"
@@ -16,41 +23,167 @@ type ConversationHandler struct {
 	conversationService *services.ConversationService
 	messageService      *services.MessageService
 	aiService           *services.AIService
+	exportService       *services.ExportService
+	importService       *services.ImportService
+	audioService        *services.AudioService
+	speechService       *services.SpeechService
 }
 
 func NewConversationHandler(
 	conversationService *services.ConversationService,
 	messageService *services.MessageService,
 	aiService *services.AIService,
+	exportService *services.ExportService,
+	importService *services.ImportService,
+	audioService *services.AudioService,
+	speechService *services.SpeechService,
 ) *ConversationHandler {
 	return &ConversationHandler{
 		conversationService: conversationService,
 		messageService:      messageService,
 		aiService:           aiService,
+		exportService:       exportService,
+		importService:       importService,
+		audioService:        audioService,
+		speechService:       speechService,
 	}
 }
 
 // GetConversations 获取用户对话列表
 // @Summary 获取对话列表
-// @Description 获取当前用户的所有对话会话
+// @Description 分页获取当前用户的对话，置顶的在前，包含消息数、最后一条消息预览和标签
 // @Tags 对话
 // @Accept json
 // @Produce json
 // @Security BearerAuth
+// @Param character_id query int false "按角色筛选"
+// @Param from query string false "最后活动时间起始（RFC3339或YYYY-MM-DD）"
+// @Param to query string false "最后活动时间截止（RFC3339或YYYY-MM-DD，日期包含当天）"
+// @Param archived query string false "归档状态" Enums(false, true, all) default(false)
+// @Param folder_id query string false "按文件夹筛选，none表示不在任何文件夹中"
+// @Param label_id query int false "按标签筛选"
+// @Param sort query string false "排序方式，置顶的对话始终在前" Enums(recent, created, title) default(recent)
+// @Param page query int false "页码" default(1)
+// @Param limit query int false "每页数量，最大100" default(20)
 // @Success 200 {object} map[string]interface{} "对话列表"
+// @Failure 400 {object} map[string]string "请求参数错误"
 // @Failure 401 {object} map[string]string "未授权"
 // @Failure 500 {object} map[string]string "服务器内部错误"
 // @Router /conversations [get]
 func (h *ConversationHandler) GetConversations(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
-	conversations, err := h.conversationService.GetConversations(userID)
+	filter, err := parseConversationFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversations, total, err := h.conversationService.ListConversations(userID, filter)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"conversations": conversations})
+	c.JSON(http.StatusOK, gin.H{
+		"conversations": conversations,
+		"total":         total,
+		"page":          filter.Page,
+		"limit":         filter.Limit,
+	})
+}
+
+func parseConversationFilter(c *gin.Context) (*models.ConversationFilter, error) {
+	filter := &models.ConversationFilter{}
+
+	if characterID := c.Query("character_id"); characterID != "" {
+		id, err := strconv.Atoi(characterID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid character_id")
+		}
+		filter.CharacterID = id
+	}
+
+	switch c.DefaultQuery("archived", "false") {
+	case "false":
+		archived := false
+		filter.Archived = &archived
+	case "true":
+		archived := true
+		filter.Archived = &archived
+	case "all":
+	default:
+		return nil, fmt.Errorf("invalid archived, expected true, false or all")
+	}
+
+	switch folderID := c.Query("folder_id"); folderID {
+	case "":
+	case "none":
+		none := 0
+		filter.FolderID = &none
+	default:
+		id, err := strconv.Atoi(folderID)
+		if err != nil || id < 1 {
+			return nil, fmt.Errorf("invalid folder_id")
+		}
+		filter.FolderID = &id
+	}
+
+	if labelID := c.Query("label_id"); labelID != "" {
+		id, err := strconv.Atoi(labelID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label_id")
+		}
+		filter.LabelID = id
+	}
+
+	filter.Sort = c.DefaultQuery("sort", models.ConversationSortRecent)
+	switch filter.Sort {
+	case models.ConversationSortRecent, models.ConversationSortCreated, models.ConversationSortTitle:
+	default:
+		return nil, fmt.Errorf("invalid sort, expected recent, created or title")
+	}
+
+	if err := parseTimeRange(c, &filter.From, &filter.To); err != nil {
+		return nil, err
+	}
+
+	filter.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	filter.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.Limit < 1 || filter.Limit > 100 {
+		filter.Limit = 20
+	}
+
+	return filter, nil
+}
+
+// parseTimeRange 解析from和to查询参数，支持RFC3339时间或YYYY-MM-DD日期
+func parseTimeRange(c *gin.Context, from, to **time.Time) error {
+	for _, param := range []struct {
+		name string
+		dest **time.Time
+	}{{"from", from}, {"to", to}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			// 只有日期时，截止日期包含当天
+			t, err = time.ParseInLocation("2006-01-02", value, time.Local)
+			if err != nil {
+				return fmt.Errorf("invalid %s, expected RFC3339 time or YYYY-MM-DD", param.name)
+			}
+			if param.name == "to" {
+				t = t.AddDate(0, 0, 1)
+			}
+		}
+		*param.dest = &t
+	}
+	return nil
 }
 
 // CreateConversation 创建新对话
@@ -84,6 +217,19 @@ func (h *ConversationHandler) CreateConversation(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"conversation": conversation})
 }
 
+// GetConversation 获取对话详情
+// @Summary 获取对话详情
+// @Description 获取对话信息和最新一页消息，更早的消息通过 /conversations/{id}/messages 分页加载
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Success 200 {object} map[string]interface{} "对话详情"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "对话不存在"
+// @Router /conversations/{id} [get]
 func (h *ConversationHandler) GetConversation(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
@@ -100,8 +246,8 @@ func (h *ConversationHandler) GetConversation(c *gin.Context) {
 		return
 	}
 
-	// 获取消息历史
-	messages, err := h.messageService.GetConversationMessages(id, userID)
+	// 只返回最新一页消息
+	page, err := h.messageService.GetMessagePage(id, userID, &models.MessagePageQuery{})
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -109,10 +255,75 @@ func (h *ConversationHandler) GetConversation(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"conversation": conversation,
-		"messages":     messages,
+		"messages":     page.Messages,
+		"has_more":     page.HasMore,
+		"next_cursor":  page.NextCursor,
 	})
 }
 
+// GetMessages 分页获取对话消息
+// @Summary 分页获取对话消息
+// @Description 按消息ID游标分页获取消息，结果按时间正序排列。before返回该消息之前的消息，after返回该消息之后的消息，都不传时返回最新一页
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Param before query int false "返回此消息ID之前的消息"
+// @Param after query int false "返回此消息ID之后的消息"
+// @Param limit query int false "每页数量，最大200" default(50)
+// @Success 200 {object} models.MessagePage "消息列表"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "对话不存在"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /conversations/{id}/messages [get]
+func (h *ConversationHandler) GetMessages(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	query := &models.MessagePageQuery{}
+	for _, param := range []struct {
+		name string
+		dest *int
+	}{{"before", &query.Before}, {"after", &query.After}, {"limit", &query.Limit}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s", param.name)})
+			return
+		}
+		*param.dest = n
+	}
+	if query.Before > 0 && query.After > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "before and after cannot be used together"})
+		return
+	}
+	if query.Limit > services.MaxMessagePageSize {
+		query.Limit = services.MaxMessagePageSize
+	}
+
+	page, err := h.messageService.GetMessagePage(id, userID, query)
+	if err != nil {
+		if err.Error() == "conversation not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
 // SendMessage 发送消息
 // @Summary 发送消息
 // @Description 向对话发送消息并获取AI回复
@@ -157,33 +368,107 @@ func (h *ConversationHandler) SendMessage(c *gin.Context) {
 		return
 	}
 
+	h.reply(c, conversation, userMessage, nil)
+}
+
+// SendAudioMessage 发送语音消息
+// @Summary 发送语音消息
+// @Description 上传录音，服务端转写为文字后保存为用户消息（同时包含转写文本和语音地址），并返回AI回复。用于不支持浏览器语音识别的环境
+// @Tags 对话
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Param audio formData file true "录音文件（webm、ogg、wav、mp3、m4a、flac），按内容识别格式"
+// @Param language formData string false "语音语言，如 zh、en，为空时自动识别"
+// @Success 200 {object} map[string]interface{} "消息发送成功，包含转写文本"
+// @Failure 400 {object} map[string]string "请求参数错误或没有识别到语音"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "对话不存在"
+// @Failure 413 {object} map[string]string "文件过大"
+// @Failure 502 {object} map[string]string "语音转写服务出错"
+// @Failure 503 {object} map[string]string "未启用语音转写"
+// @Router /conversations/{id}/messages/audio [post]
+func (h *ConversationHandler) SendAudioMessage(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	conversation, err := h.conversationService.GetConversation(conversationID, userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	data, ok := readUploadedFile(c, "audio", "audio", services.MaxAudioFileSize)
+	if !ok {
+		return
+	}
+
+	transcript, audioURL, err := h.audioService.TranscribeUpload(c.Request.Context(), userID, data, c.PostForm("language"))
+	if err != nil {
+		switch {
+		case err.Error() == "speech-to-text is not configured":
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		case strings.HasPrefix(err.Error(), "failed to transcribe"):
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		case strings.HasPrefix(err.Error(), "failed to"):
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	userMessage, err := h.messageService.CreateMessage(conversationID, "user", transcript, &audioURL)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.reply(c, conversation, userMessage, gin.H{"transcript": transcript})
+}
+
+// reply 根据对话历史生成AI回复并返回用户消息和AI消息，extra中的字段一并返回
+func (h *ConversationHandler) reply(c *gin.Context, conversation *models.Conversation, userMessage *models.Message, extra gin.H) {
 	// 获取对话历史
-	messages, err := h.messageService.GetMessages(conversationID)
+	messages, err := h.messageService.GetMessages(conversation.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	// 生成AI响应
+	var aiMessage *models.Message
 	aiResponse, err := h.aiService.GenerateResponse(conversation.Character, messages)
 	if err != nil {
-		aiResponse = "系统错误，请稍后再试"
+		aiMessage, err = h.messageService.CreateMessage(conversation.ID, "assistant", "系统错误，请稍后再试", nil)
+	} else {
+		aiMessage, err = h.messageService.CreateAssistantMessage(conversation.ID, aiResponse, h.aiService.Model())
+		if err == nil {
+			h.generateTitle(conversation, messages, aiMessage)
+		}
 	}
-
-	// 保存AI消息
-	aiMessage, err := h.messageService.CreateMessage(conversationID, "assistant", aiResponse, nil)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"user_message": userMessage,
 		"ai_message":   aiMessage,
-	})
+	}
+	for key, value := range extra {
+		response[key] = value
+	}
+	c.JSON(http.StatusOK, response)
 }
 
-// SendMessageStream 发送消息并返回流式AI响应
+// SendMessageStream 发送消息并返回流式AI响应。
+// 请求中tts为true且启用了语音合成时，回复过程中逐句合成语音，以 {"type":"audio"} 事件按顺序返回音频地址
 func (h *ConversationHandler) SendMessageStream(c *gin.Context) {
 	userID := c.GetInt("user_id")
 	conversationID, err := strconv.Atoi(c.Param("id"))
@@ -202,6 +487,7 @@ func (h *ConversationHandler) SendMessageStream(c *gin.Context) {
 	var request struct {
 		Content  string `json:"content" binding:"required"`
 		AudioURL string `json:"audio_url,omitempty"`
+		TTS      bool   `json:"tts,omitempty"`
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -236,7 +522,19 @@ func (h *ConversationHandler) SendMessageStream(c *gin.Context) {
 	c.Writer.Flush()
 
 	// 使用流式响应生成AI回复
-	fullResponse, err := h.aiService.GenerateStreamingResponse(conversation.Character, messages, c.Writer)
+	var writer io.Writer = c.Writer
+	var onContent func(string)
+	var speaker *sentenceSpeaker
+	if request.TTS && h.speechService.Enabled() {
+		speaker = newSentenceSpeaker(c.Request.Context(), c.Writer, h.speechService, userID, conversation.Character)
+		writer = speaker
+		onContent = speaker.Add
+	}
+	fullResponse, err := h.aiService.GenerateStreamingResponse(conversation.Character, messages, writer, onContent)
+	if speaker != nil {
+		// 语音事件全部写完后再直接写入c.Writer
+		speaker.Close()
+	}
 	if err != nil {
 		// 如果流式响应失败，创建错误消息
 		errorMsg := "系统错误，请稍后再试"
@@ -249,15 +547,140 @@ func (h *ConversationHandler) SendMessageStream(c *gin.Context) {
 
 	// 流式响应完成后，创建完整的AI消息并保存到数据库
 	if fullResponse != "" {
-		aiMessage, err := h.messageService.CreateMessage(conversationID, "assistant", fullResponse, nil)
+		aiMessage, err := h.messageService.CreateAssistantMessage(conversationID, fullResponse, h.aiService.Model())
 		if err != nil {
 			fmt.Printf("Failed to save AI message to database: %v\n", err)
 		} else {
 			fmt.Printf("AI message saved to database with ID: %d\n", aiMessage.ID)
+
+			// 流式输出时使用的是临时ID，保存后发送带真实ID的消息替换它，客户端才能对这条消息提交反馈
+			aiMessageJSON, _ := json.Marshal(aiMessage)
+			fmt.Fprintf(c.Writer, "data: %s\n\n", string(aiMessageJSON))
+			c.Writer.Flush()
+
+			h.generateTitle(conversation, messages, aiMessage)
+		}
+	}
+}
+
+// generateTitle 第一轮对话完成后在后台生成标题，用户手动改过标题的对话不再生成
+func (h *ConversationHandler) generateTitle(conversation *models.Conversation, history []*models.Message, aiMessage *models.Message) {
+	if !conversation.TitleAuto || len(history) != 1 {
+		return
+	}
+
+	go func() {
+		title, err := h.aiService.GenerateTitle(conversation.Character, []*models.Message{history[0], aiMessage})
+		if err != nil {
+			log.Printf("Failed to generate title for conversation %d: %v", conversation.ID, err)
+			return
+		}
+		if err := h.conversationService.UpdateConversationTitle(conversation.ID, conversation.UserID, title); err != nil {
+			log.Printf("Failed to update title for conversation %d: %v", conversation.ID, err)
+		}
+	}()
+}
+
+// RenameConversation 修改对话标题
+// @Summary 修改对话标题
+// @Description 手动修改对话标题，修改后不再自动生成标题
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Param request body models.RenameConversationRequest true "新标题"
+// @Success 200 {object} map[string]interface{} "修改成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "对话不存在"
+// @Router /conversations/{id}/title [put]
+func (h *ConversationHandler) RenameConversation(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req models.RenameConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversation, err := h.conversationService.RenameConversation(id, userID, req.Title)
+	if err != nil {
+		switch err.Error() {
+		case "conversation not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "title cannot be empty":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+// CloneConversation 复制对话
+// @Summary 复制对话
+// @Description 将对话的全部消息或截止到指定消息（含）的部分复制为新对话，可以换用其他角色继续聊天，原对话不受影响
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Param request body models.CloneConversationRequest false "复制范围、角色和标题"
+// @Success 201 {object} map[string]interface{} "复制成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "对话、消息或角色不存在"
+// @Router /conversations/{id}/clone [post]
+func (h *ConversationHandler) CloneConversation(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req models.CloneConversationRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
 	}
+
+	conversation, err := h.conversationService.CloneConversation(id, userID, &req)
+	if err != nil {
+		switch err.Error() {
+		case "conversation not found", "message not found", "character not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"conversation": conversation})
 }
 
+// DeleteConversation 删除对话
+// @Summary 删除对话
+// @Description 将对话移入回收站，保留期内可以恢复，之后永久删除
+// @Tags 对话
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Success 200 {object} map[string]string "已移入回收站"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "对话不存在"
+// @Router /conversations/{id} [delete]
 func (h *ConversationHandler) DeleteConversation(c *gin.Context) {
 	userID := c.GetInt("user_id")
 
@@ -268,13 +691,137 @@ func (h *ConversationHandler) DeleteConversation(c *gin.Context) {
 		return
 	}
 
-	err = h.conversationService.DeleteConversation(id, userID)
+	err = h.conversationService.DeleteConversation(auditActor(c), id, userID)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Conversation deleted successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Conversation moved to trash"})
+}
+
+// GetTrash 获取回收站中的对话
+// @Summary 获取回收站
+// @Description 分页获取回收站中的对话，按删除时间倒序，超过保留期的对话会被永久删除
+// @Tags 对话
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "页码" default(1)
+// @Param limit query int false "每页数量，最大100" default(20)
+// @Success 200 {object} map[string]interface{} "对话列表"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /conversations/trash [get]
+func (h *ConversationHandler) GetTrash(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	filter := &models.ConversationFilter{Deleted: true}
+	filter.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	filter.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if filter.Page < 1 {
+		filter.Page = 1
+	}
+	if filter.Limit < 1 || filter.Limit > 100 {
+		filter.Limit = 20
+	}
+
+	conversations, total, err := h.conversationService.ListConversations(userID, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"conversations": conversations,
+		"total":         total,
+		"page":          filter.Page,
+		"limit":         filter.Limit,
+	})
+}
+
+// RestoreConversation 从回收站恢复对话
+// @Summary 恢复对话
+// @Description 将回收站中的对话恢复到对话列表
+// @Tags 对话
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Success 200 {object} map[string]interface{} "恢复成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "回收站中没有该对话"
+// @Router /conversations/{id}/restore [post]
+func (h *ConversationHandler) RestoreConversation(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	conversation, err := h.conversationService.RestoreConversation(auditActor(c), id, userID)
+	if err != nil {
+		if err.Error() == "conversation not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+// PermanentlyDeleteConversation 永久删除回收站中的对话
+// @Summary 永久删除对话
+// @Description 永久删除回收站中的对话及其全部消息，无法恢复。只能删除已在回收站中的对话
+// @Tags 对话
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Success 200 {object} map[string]string "删除成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "回收站中没有该对话"
+// @Router /conversations/{id}/permanent [delete]
+func (h *ConversationHandler) PermanentlyDeleteConversation(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	if err := h.conversationService.PermanentlyDeleteConversation(auditActor(c), id, userID); err != nil {
+		if err.Error() == "conversation not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Conversation deleted permanently"})
+}
+
+// EmptyTrash 清空回收站
+// @Summary 清空回收站
+// @Description 永久删除回收站中的全部对话，无法恢复
+// @Tags 对话
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "删除成功"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /conversations/trash [delete]
+func (h *ConversationHandler) EmptyTrash(c *gin.Context) {
+	deletedCount, err := h.conversationService.EmptyTrash(auditActor(c), c.GetInt("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deleted_count": deletedCount})
 }
 
 func (h *ConversationHandler) BatchDeleteConversations(c *gin.Context) {
@@ -294,14 +841,346 @@ func (h *ConversationHandler) BatchDeleteConversations(c *gin.Context) {
 		return
 	}
 
-	deletedCount, err := h.conversationService.BatchDeleteConversations(request.IDs, userID)
+	deletedCount, err := h.conversationService.BatchDeleteConversations(auditActor(c), request.IDs, userID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":       "Conversations deleted successfully",
+		"message":       "Conversations moved to trash",
 		"deleted_count": deletedCount,
 	})
 }
+
+// UpdateConversation 修改对话状态
+// @Summary 修改对话状态
+// @Description 置顶、归档或移动到文件夹，folder_id为0时移出文件夹，未提供的字段保持不变。不改变最后活动时间
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Param request body models.UpdateConversationRequest true "对话状态"
+// @Success 200 {object} map[string]interface{} "修改成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "对话或文件夹不存在"
+// @Router /conversations/{id} [patch]
+func (h *ConversationHandler) UpdateConversation(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req models.UpdateConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversation, err := h.conversationService.UpdateConversation(id, userID, &req)
+	if err != nil {
+		folderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+// SetConversationLabels 设置对话标签
+// @Summary 设置对话标签
+// @Description 用给定的标签替换对话的全部标签，传空数组清除标签
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Param request body models.SetConversationLabelsRequest true "标签ID列表"
+// @Success 200 {object} map[string]interface{} "修改成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "对话或标签不存在"
+// @Router /conversations/{id}/labels [put]
+func (h *ConversationHandler) SetConversationLabels(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req models.SetConversationLabelsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	conversation, err := h.conversationService.SetConversationLabels(id, userID, req.LabelIDs)
+	if err != nil {
+		folderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"conversation": conversation})
+}
+
+// BatchArchiveConversations 批量归档对话
+// @Summary 批量归档对话
+// @Description 批量归档或取消归档，不属于当前用户的ID会被忽略
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.BatchArchiveRequest true "对话ID和归档状态"
+// @Success 200 {object} map[string]interface{} "修改成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /conversations/batch/archive [post]
+func (h *ConversationHandler) BatchArchiveConversations(c *gin.Context) {
+	var req models.BatchArchiveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updatedCount, err := h.conversationService.BatchArchiveConversations(req.IDs, c.GetInt("user_id"), req.Archived)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated_count": updatedCount})
+}
+
+// BatchMoveConversations 批量移动对话
+// @Summary 批量移动对话到文件夹
+// @Description 批量移动到文件夹，folder_id为0时移出文件夹，不属于当前用户的ID会被忽略
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.BatchMoveRequest true "对话ID和文件夹ID"
+// @Success 200 {object} map[string]interface{} "移动成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "文件夹不存在"
+// @Router /conversations/batch/move [post]
+func (h *ConversationHandler) BatchMoveConversations(c *gin.Context) {
+	var req models.BatchMoveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updatedCount, err := h.conversationService.BatchMoveConversations(req.IDs, c.GetInt("user_id"), req.FolderID)
+	if err != nil {
+		folderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated_count": updatedCount})
+}
+
+// BatchLabelConversations 批量修改对话标签
+// @Summary 批量添加或移除标签
+// @Description 为多个对话添加标签，remove为true时移除，不属于当前用户的ID会被忽略
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.BatchLabelRequest true "对话ID和标签ID"
+// @Success 200 {object} map[string]interface{} "修改成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "标签不存在"
+// @Router /conversations/batch/label [post]
+func (h *ConversationHandler) BatchLabelConversations(c *gin.Context) {
+	var req models.BatchLabelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updatedCount, err := h.conversationService.BatchLabelConversations(req.IDs, c.GetInt("user_id"), req.LabelID, req.Remove)
+	if err != nil {
+		folderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated_count": updatedCount})
+}
+
+// ExportConversation 导出对话
+// @Summary 导出对话
+// @Description 以Markdown、JSON、HTML、JSONL（微调数据集chat格式）或纯文本格式下载对话
+// @Tags 对话
+// @Produce json,text/markdown,text/html,text/plain
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Param format query string false "导出格式" Enums(markdown, json, html, jsonl, txt) default(markdown)
+// @Success 200 {file} file "导出文件"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "对话不存在"
+// @Router /conversations/{id}/export [get]
+func (h *ConversationHandler) ExportConversation(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	format := c.DefaultQuery("format", services.ExportFormatMarkdown)
+	switch format {
+	case services.ExportFormatMarkdown, services.ExportFormatJSON, services.ExportFormatHTML, services.ExportFormatJSONL, services.ExportFormatText:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported format, expected markdown, json, html, jsonl or txt"})
+		return
+	}
+
+	file, err := h.exportService.ExportConversation(id, userID, format)
+	if err != nil {
+		if err.Error() == "conversation not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", attachmentDisposition(file.Filename))
+	c.Data(http.StatusOK, file.ContentType, file.Data)
+}
+
+// ImportConversation 导入对话
+// @Summary 导入对话
+// @Description 导入本系统导出的JSON、JSONL聊天记录（{role,content}、{name,content}、ShareGPT或messages数组）或 "名字: 内容" 形式的纯文本。发言者映射到指定角色、同名的已发布角色或自己的私有角色，都没有时新建只有自己可以使用的私有角色，返回跳过的行
+// @Tags 对话
+// @Accept multipart/form-data,plain
+// @Produce json
+// @Security BearerAuth
+// @Param file formData file false "导入文件（也可以直接作为请求体发送）"
+// @Param format query string false "导入格式，为空时自动识别" Enums(json, jsonl, transcript)
+// @Param character_id query int false "导入到指定的已发布角色或自己的私有角色"
+// @Param character_name query string false "按名称匹配或新建私有角色"
+// @Param user_name query string false "视为用户本人的发言者名称，多个用逗号分隔"
+// @Param title query string false "对话标题"
+// @Success 201 {object} models.ImportConversationResult "导入成功"
+// @Failure 400 {object} map[string]string "请求参数错误或无法解析"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "角色不存在"
+// @Failure 413 {object} map[string]string "文件过大"
+// @Router /conversations/import [post]
+func (h *ConversationHandler) ImportConversation(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	opts := &services.ImportOptions{
+		Format:        c.Query("format"),
+		CharacterName: c.Query("character_name"),
+		Title:         strings.TrimSpace(c.Query("title")),
+	}
+	switch opts.Format {
+	case "", services.ImportFormatJSON, services.ImportFormatJSONL, services.ImportFormatTranscript:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported format, expected json, jsonl or transcript"})
+		return
+	}
+	if characterID := c.Query("character_id"); characterID != "" {
+		id, err := strconv.Atoi(characterID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid character ID"})
+			return
+		}
+		opts.CharacterID = id
+	}
+	for _, name := range strings.Split(c.Query("user_name"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			opts.UserNames = append(opts.UserNames, name)
+		}
+	}
+
+	data, err := readImportFile(c)
+	if err != nil {
+		status := http.StatusBadRequest
+		if _, ok := err.(*http.MaxBytesError); ok {
+			status = http.StatusRequestEntityTooLarge
+			err = fmt.Errorf("file too large, maximum is %d MB", services.MaxImportFileSize>>20)
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.importService.ImportConversation(auditActor(c), userID, data, opts)
+	if err != nil {
+		switch {
+		case err.Error() == "character not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case strings.HasPrefix(err.Error(), "failed to"):
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, result)
+}
+
+// readImportFile 读取multipart中的file字段，不是multipart请求时读取整个请求体
+func readImportFile(c *gin.Context) ([]byte, error) {
+	// 预留multipart边界和其他字段的空间
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, services.MaxImportFileSize+64<<10)
+
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return nil, maxBytesErr
+			}
+			return nil, fmt.Errorf("file is required")
+		}
+		if fileHeader.Size > services.MaxImportFileSize {
+			return nil, &http.MaxBytesError{Limit: services.MaxImportFileSize}
+		}
+		f, err := fileHeader.Open()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file")
+		}
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+
+	data, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, maxBytesErr
+		}
+		return nil, fmt.Errorf("failed to read request body")
+	}
+	if len(data) > services.MaxImportFileSize {
+		return nil, &http.MaxBytesError{Limit: services.MaxImportFileSize}
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("file is required")
+	}
+	return data, nil
+}
+
+// attachmentDisposition 生成下载文件的Content-Disposition，非ASCII文件名使用RFC 5987编码
+func attachmentDisposition(filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r > unicode.MaxASCII || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, filename)
+	return fmt.Sprintf("attachment; filename=\"%s\"; filename*=UTF-8''%s", fallback, url.PathEscape(filename))
+}
@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"role-play-ai/internal/config"
+	"role-play-ai/internal/database"
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/services"
+	"role-play-ai/internal/storage"
+	"role-play-ai/internal/transcriber"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// testWAV 最小的WAV文件头，足以按内容识别格式
+var testWAV = append([]byte("RIFF\x24\x00\x00\x00WAVEfmt "), make([]byte, 32)...)
+
+type errorTranscriber struct{}
+
+func (errorTranscriber) Transcribe(ctx context.Context, audio *transcriber.Audio) (string, error) {
+	return "", errors.New("upstream unavailable")
+}
+
+// captureArg 匹配任意参数并记下它的值
+type captureArg struct {
+	value driver.Value
+}
+
+func (a *captureArg) Match(v driver.Value) bool {
+	a.value = v
+	return true
+}
+
+type audioMessageTest struct {
+	router   *gin.Engine
+	mock     sqlmock.Sqlmock
+	mediaDir string
+}
+
+func newAudioMessageTest(t *testing.T, tr transcriber.Transcriber) *audioMessageTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	mr := miniredis.RunT(t)
+	database.RedisClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ollama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": map[string]string{"role": "assistant", "content": "你好，我听到了"},
+			"done":    true,
+		})
+	}))
+	t.Cleanup(ollama.Close)
+
+	cfg := &config.Config{OllamaBaseURL: ollama.URL, OllamaModel: "test-model"}
+	mediaDir := t.TempDir()
+	searchIndex := services.NewMySQLSearchIndex(db)
+	handler := NewConversationHandler(
+		services.NewConversationService(db, services.NewAuditService(db), searchIndex, cfg),
+		services.NewMessageService(db, searchIndex),
+		services.NewAIService(cfg),
+		nil,
+		nil,
+		services.NewAudioService(tr, services.NewMediaService(db, storage.NewLocalStore(mediaDir), cfg)),
+		nil,
+	)
+
+	router := gin.New()
+	router.POST("/conversations/:id/messages/audio", func(c *gin.Context) {
+		c.Set("user_id", 7)
+	}, handler.SendAudioMessage)
+
+	tt := &audioMessageTest{router: router, mock: mock, mediaDir: mediaDir}
+	tt.expectConversation()
+	return tt
+}
+
+func (tt *audioMessageTest) expectConversation() {
+	now := time.Now()
+	tt.mock.ExpectQuery(regexp.QuoteMeta("WHERE c.id = ? AND c.user_id = ? AND c.deleted_at IS NULL")).
+		WithArgs(1, 7).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "user_id", "character_id", "title", "title_auto", "is_archived", "pinned_at", "folder_id", "deleted_at", "created_at", "updated_at",
+			"ch_id", "name", "description", "avatar_url", "category", "is_published", "ch_created_at", "ch_updated_at",
+			"system_prompt", "voice", "voice_speed",
+		}).AddRow(
+			1, 7, 3, "对话", false, false, nil, nil, nil, now, now,
+			3, "苏格拉底", "", "", "哲学", true, now, now,
+			"你是苏格拉底", nil, 1.0,
+		))
+	tt.mock.ExpectQuery(regexp.QuoteMeta("SELECT s.conversation_id, s.message_count")).
+		WillReturnRows(sqlmock.NewRows([]string{"conversation_id", "message_count", "role", "content", "created_at"}))
+	tt.mock.ExpectQuery(regexp.QuoteMeta("FROM conversation_label_links")).
+		WillReturnRows(sqlmock.NewRows([]string{"conversation_id", "id", "name"}))
+}
+
+func (tt *audioMessageTest) send(t *testing.T, audio []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("audio", "recording.wav")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(audio)
+	form.WriteField("language", "zh")
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/conversations/1/messages/audio", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	tt.router.ServeHTTP(w, req)
+	return w
+}
+
+func messageRow(id int, role, content string, audioURL interface{}) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "conversation_id", "role", "content", "audio_url", "model", "created_at"}).
+		AddRow(id, 1, role, content, audioURL, nil, time.Now())
+}
+
+func TestSendAudioMessage(t *testing.T) {
+	tt := newAudioMessageTest(t, transcriber.NewFakeTranscriber("你好，苏格拉底"))
+
+	tt.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO media_blobs")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	savedAudioURL := &captureArg{}
+	tt.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO messages")).
+		WithArgs(1, "user", "你好，苏格拉底", savedAudioURL, nil).
+		WillReturnResult(sqlmock.NewResult(100, 1))
+	tt.mock.ExpectExec(regexp.QuoteMeta("UPDATE conversations SET updated_at")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tt.mock.ExpectQuery(regexp.QuoteMeta("FROM messages")).WithArgs(100).
+		WillReturnRows(messageRow(100, "user", "你好，苏格拉底", "/api/v1/media/audio/recording.wav"))
+	tt.mock.ExpectQuery(regexp.QuoteMeta("FROM messages")).WithArgs(1).
+		WillReturnRows(messageRow(100, "user", "你好，苏格拉底", "/api/v1/media/audio/recording.wav"))
+	tt.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO messages")).
+		WithArgs(1, "assistant", "你好，我听到了", nil, "test-model").
+		WillReturnResult(sqlmock.NewResult(101, 1))
+	tt.mock.ExpectExec(regexp.QuoteMeta("UPDATE conversations SET updated_at")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tt.mock.ExpectQuery(regexp.QuoteMeta("FROM messages")).WithArgs(101).
+		WillReturnRows(messageRow(101, "assistant", "你好，我听到了", nil))
+
+	w := tt.send(t, testWAV)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if err := tt.mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	var response struct {
+		Transcript  string          `json:"transcript"`
+		UserMessage *models.Message `json:"user_message"`
+		AIMessage   *models.Message `json:"ai_message"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Transcript != "你好，苏格拉底" || response.AIMessage == nil || response.AIMessage.Content != "你好，我听到了" {
+		t.Fatalf("unexpected response: %s", w.Body.String())
+	}
+
+	// 用户消息同时保存转写文本和指向已保存录音的语音地址
+	audioURL, _ := savedAudioURL.value.(string)
+	if !strings.HasPrefix(audioURL, "/api/v1/media/audio/") || !strings.HasSuffix(audioURL, ".wav") {
+		t.Fatalf("expected audio_url to be saved on the user message, got %v", savedAudioURL.value)
+	}
+	stored, err := os.ReadFile(filepath.Join(tt.mediaDir, strings.TrimPrefix(audioURL, "/api/v1/media/")))
+	if err != nil || !bytes.Equal(stored, testWAV) {
+		t.Fatalf("expected uploaded audio to be stored at %s: %v", audioURL, err)
+	}
+}
+
+func TestSendAudioMessageErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		transcriber transcriber.Transcriber
+		audio       []byte
+		wantStatus  int
+	}{
+		{"empty audio", transcriber.NewFakeTranscriber(""), []byte{}, http.StatusBadRequest},
+		{"transcriber error", errorTranscriber{}, testWAV, http.StatusBadGateway},
+		{"not configured", nil, testWAV, http.StatusServiceUnavailable},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tt := newAudioMessageTest(t, tc.transcriber)
+
+			w := tt.send(t, tc.audio)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tc.wantStatus, w.Code, w.Body.String())
+			}
+			// 没有保存消息和录音
+			if err := tt.mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+			if entries, _ := os.ReadDir(tt.mediaDir); len(entries) != 0 {
+				t.Fatalf("expected no stored audio, found %d entries", len(entries))
+			}
+		})
+	}
+}
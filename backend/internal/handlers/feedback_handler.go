@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FeedbackHandler struct {
+	feedbackService *services.FeedbackService
+	auditService    *services.AuditService
+}
+
+func NewFeedbackHandler(feedbackService *services.FeedbackService, auditService *services.AuditService) *FeedbackHandler {
+	return &FeedbackHandler{
+		feedbackService: feedbackService,
+		auditService:    auditService,
+	}
+}
+
+// parseMessagePath 解析路径中的对话ID和消息ID
+func parseMessagePath(c *gin.Context) (int, int, bool) {
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return 0, 0, false
+	}
+	messageID, err := strconv.Atoi(c.Param("messageId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return 0, 0, false
+	}
+	return conversationID, messageID, true
+}
+
+// SetFeedback 提交消息反馈
+// @Summary 提交消息反馈
+// @Description 对AI回复点赞或点踩，点踩时可以选择原因（out_of_character 不符合角色、factual_error 事实错误、too_long 太长、unsafe 不安全、other 其他）并填写说明。再次提交会覆盖之前的反馈
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Param messageId path int true "消息ID"
+// @Param request body models.MessageFeedbackRequest true "反馈"
+// @Success 200 {object} map[string]interface{} "反馈"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "消息不存在"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /conversations/{id}/messages/{messageId}/feedback [put]
+func (h *FeedbackHandler) SetFeedback(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	conversationID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	var req models.MessageFeedbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	feedback, err := h.feedbackService.SetFeedback(userID, conversationID, messageID, &req)
+	if err != nil {
+		switch err.Error() {
+		case "message not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "reason is only allowed for negative feedback", "feedback is only allowed on assistant messages":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"feedback": feedback})
+}
+
+// DeleteFeedback 撤销消息反馈
+// @Summary 撤销消息反馈
+// @Description 删除对AI回复的反馈
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Param messageId path int true "消息ID"
+// @Success 200 {object} map[string]string "撤销成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "反馈不存在"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /conversations/{id}/messages/{messageId}/feedback [delete]
+func (h *FeedbackHandler) DeleteFeedback(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	conversationID, messageID, ok := parseMessagePath(c)
+	if !ok {
+		return
+	}
+
+	if err := h.feedbackService.DeleteFeedback(userID, conversationID, messageID); err != nil {
+		if err.Error() == "feedback not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Feedback deleted successfully"})
+}
+
+// parseFeedbackFilter 解析反馈统计和导出的筛选参数
+func parseFeedbackFilter(c *gin.Context) (*models.FeedbackFilter, error) {
+	filter := &models.FeedbackFilter{}
+	if characterID := c.Query("character_id"); characterID != "" {
+		id, err := strconv.Atoi(characterID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid character_id")
+		}
+		filter.CharacterID = id
+	}
+	if err := parseTimeRange(c, &filter.From, &filter.To); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// GetFeedbackReport 反馈统计
+// @Summary 反馈统计
+// @Description 按角色和模型汇总AI回复的点赞、点踩数和点踩原因，用于调整角色提示词
+// @Tags 管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param character_id query int false "角色ID"
+// @Param from query string false "开始时间（RFC3339或YYYY-MM-DD）"
+// @Param to query string false "结束时间（RFC3339或YYYY-MM-DD，包含当天）"
+// @Success 200 {object} map[string]interface{} "统计结果"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /admin/feedback/report [get]
+func (h *FeedbackHandler) GetFeedbackReport(c *gin.Context) {
+	filter, err := parseFeedbackFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := h.feedbackService.Report(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"report": report})
+}
+
+// ExportPreferencePairs 导出偏好数据
+// @Summary 导出偏好数据
+// @Description 导出JSONL格式的偏好对，每行包含对话历史（prompt）、同一上下文下获得点赞的回复（chosen）和获得点踩的回复（rejected），用于后续调优（仅管理员）
+// @Tags 管理
+// @Accept json
+// @Produce application/x-ndjson
+// @Security BearerAuth
+// @Param character_id query int false "角色ID"
+// @Param from query string false "开始时间（RFC3339或YYYY-MM-DD）"
+// @Param to query string false "结束时间（RFC3339或YYYY-MM-DD，包含当天）"
+// @Success 200 {file} file "JSONL文件"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /admin/feedback/preference-pairs [get]
+func (h *FeedbackHandler) ExportPreferencePairs(c *gin.Context) {
+	filter, err := parseFeedbackFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("preference-pairs-%s.jsonl", time.Now().UTC().Format("20060102-150405"))
+	c.Header("Content-Type", "application/x-ndjson; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Status(http.StatusOK)
+
+	count, err := h.feedbackService.WritePreferencePairs(filter, c.Writer)
+	if err != nil {
+		log.Printf("Failed to export preference pairs: %v", err)
+		if !c.Writer.Written() {
+			c.Header("Content-Disposition", "")
+			c.Header("Content-Type", "application/json; charset=utf-8")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export preference pairs"})
+		}
+		c.Abort()
+		return
+	}
+
+	h.auditService.Record(auditActor(c), models.AuditFeedbackExport, "feedback", "", map[string]interface{}{
+		"character_id": filter.CharacterID,
+		"pairs":        count,
+	})
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FolderHandler struct {
+	folderService *services.FolderService
+}
+
+func NewFolderHandler(folderService *services.FolderService) *FolderHandler {
+	return &FolderHandler{folderService: folderService}
+}
+
+// folderError 将文件夹和标签操作的错误映射为HTTP状态码
+func folderError(c *gin.Context, err error) {
+	message := err.Error()
+	switch {
+	case strings.HasSuffix(message, "not found"):
+		c.JSON(http.StatusNotFound, gin.H{"error": message})
+	case strings.HasSuffix(message, "already exists"), strings.HasPrefix(message, "too many"), message == "name cannot be empty":
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+}
+
+// GetFolders 获取文件夹列表
+// @Summary 获取对话文件夹
+// @Description 获取当前用户的对话文件夹及其中的对话数
+// @Tags 对话
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "文件夹列表"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /conversations/folders [get]
+func (h *FolderHandler) GetFolders(c *gin.Context) {
+	folders, err := h.folderService.GetFolders(c.GetInt("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"folders": folders})
+}
+
+// CreateFolder 创建文件夹
+// @Summary 创建对话文件夹
+// @Description 创建对话文件夹，名称在同一用户下唯一
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.FolderRequest true "文件夹名称"
+// @Success 201 {object} map[string]interface{} "创建成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /conversations/folders [post]
+func (h *FolderHandler) CreateFolder(c *gin.Context) {
+	var req models.FolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	folder, err := h.folderService.CreateFolder(c.GetInt("user_id"), req.Name)
+	if err != nil {
+		folderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"folder": folder})
+}
+
+// RenameFolder 重命名文件夹
+// @Summary 重命名对话文件夹
+// @Description 修改文件夹名称
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "文件夹ID"
+// @Param request body models.FolderRequest true "文件夹名称"
+// @Success 200 {object} map[string]interface{} "修改成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "文件夹不存在"
+// @Router /conversations/folders/{id} [put]
+func (h *FolderHandler) RenameFolder(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder ID"})
+		return
+	}
+
+	var req models.FolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	folder, err := h.folderService.RenameFolder(id, c.GetInt("user_id"), req.Name)
+	if err != nil {
+		folderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"folder": folder})
+}
+
+// DeleteFolder 删除文件夹
+// @Summary 删除对话文件夹
+// @Description 删除文件夹，其中的对话移出文件夹但不会被删除
+// @Tags 对话
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "文件夹ID"
+// @Success 200 {object} map[string]string "删除成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "文件夹不存在"
+// @Router /conversations/folders/{id} [delete]
+func (h *FolderHandler) DeleteFolder(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid folder ID"})
+		return
+	}
+
+	if err := h.folderService.DeleteFolder(id, c.GetInt("user_id")); err != nil {
+		folderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Folder deleted successfully"})
+}
+
+// GetLabels 获取标签列表
+// @Summary 获取对话标签
+// @Description 获取当前用户的对话标签及使用该标签的对话数
+// @Tags 对话
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "标签列表"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /conversations/labels [get]
+func (h *FolderHandler) GetLabels(c *gin.Context) {
+	labels, err := h.folderService.GetLabels(c.GetInt("user_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"labels": labels})
+}
+
+// CreateLabel 创建标签
+// @Summary 创建对话标签
+// @Description 创建对话标签，名称在同一用户下唯一
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.LabelRequest true "标签名称"
+// @Success 201 {object} map[string]interface{} "创建成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /conversations/labels [post]
+func (h *FolderHandler) CreateLabel(c *gin.Context) {
+	var req models.LabelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	label, err := h.folderService.CreateLabel(c.GetInt("user_id"), req.Name)
+	if err != nil {
+		folderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"label": label})
+}
+
+// RenameLabel 重命名标签
+// @Summary 重命名对话标签
+// @Description 修改标签名称
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "标签ID"
+// @Param request body models.LabelRequest true "标签名称"
+// @Success 200 {object} map[string]interface{} "修改成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "标签不存在"
+// @Router /conversations/labels/{id} [put]
+func (h *FolderHandler) RenameLabel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid label ID"})
+		return
+	}
+
+	var req models.LabelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	label, err := h.folderService.RenameLabel(id, c.GetInt("user_id"), req.Name)
+	if err != nil {
+		folderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"label": label})
+}
+
+// DeleteLabel 删除标签
+// @Summary 删除对话标签
+// @Description 删除标签并从所有对话上移除
+// @Tags 对话
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "标签ID"
+// @Success 200 {object} map[string]string "删除成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "标签不存在"
+// @Router /conversations/labels/{id} [delete]
+func (h *FolderHandler) DeleteLabel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid label ID"})
+		return
+	}
+
+	if err := h.folderService.DeleteLabel(id, c.GetInt("user_id")); err != nil {
+		folderError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Label deleted successfully"})
+}
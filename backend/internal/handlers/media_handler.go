@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MediaHandler struct {
+	mediaService *services.MediaService
+}
+
+func NewMediaHandler(mediaService *services.MediaService) *MediaHandler {
+	return &MediaHandler{mediaService: mediaService}
+}
+
+// UploadAvatar 上传角色头像
+// @Summary 上传角色头像
+// @Description 上传PNG、JPEG或GIF图片，按内容识别格式，缩放到长边不超过512像素后保存。返回的url可以作为角色的avatar_url，头像公开访问。
+// @Description 上传后24小时内没有被任何角色引用的头像会被自动清理
+// @Tags 管理
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param file formData file true "图片文件，最大5MB，边长不超过4096像素"
+// @Success 201 {object} models.MediaBlob
+// @Failure 400 {object} map[string]string "不支持的格式或图片无效"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 403 {object} map[string]string "权限不足"
+// @Failure 413 {object} map[string]string "文件过大"
+// @Router /admin/characters/avatar [post]
+func (h *MediaHandler) UploadAvatar(c *gin.Context) {
+	data, ok := readUploadedFile(c, "file", "image", services.MaxAvatarFileSize)
+	if !ok {
+		return
+	}
+
+	blob, err := h.mediaService.UploadAvatar(c.Request.Context(), c.GetInt("user_id"), data)
+	if err != nil {
+		respondMediaError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, blob)
+}
+
+// UploadAudio 上传音频
+// @Summary 上传音频
+// @Description 上传录音，按内容识别格式后保存。url是保存到消息audio_url中的稳定引用，signed_url是可以直接播放的临时地址
+// @Tags 媒体
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param file formData file true "音频文件（webm、ogg、wav、mp3、m4a、flac），最大25MB"
+// @Success 201 {object} models.MediaBlob
+// @Failure 400 {object} map[string]string "不支持的格式"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 413 {object} map[string]string "文件过大"
+// @Router /media/audio [post]
+func (h *MediaHandler) UploadAudio(c *gin.Context) {
+	data, ok := readUploadedFile(c, "file", "audio", services.MaxAudioFileSize)
+	if !ok {
+		return
+	}
+
+	blob, err := h.mediaService.UploadAudio(c.Request.Context(), c.GetInt("user_id"), data)
+	if err != nil {
+		respondMediaError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, blob)
+}
+
+// SignURL 获取媒体文件的临时下载地址
+// @Summary 获取媒体文件的临时下载地址
+// @Description 为消息中的audio_url等媒体引用生成带签名的临时地址，只能获取自己上传或自己对话中的音频。头像公开访问，原样返回
+// @Tags 媒体
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.SignMediaRequest true "媒体引用"
+// @Success 200 {object} map[string]string "临时下载地址"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "媒体文件不存在"
+// @Router /media/sign [post]
+func (h *MediaHandler) SignURL(c *gin.Context) {
+	var req models.SignMediaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	signedURL, err := h.mediaService.SignURL(c.Request.Context(), c.GetInt("user_id"), req.URL)
+	if err != nil {
+		respondMediaError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"url": signedURL})
+}
+
+// GetMedia 下载媒体文件
+// @Summary 下载媒体文件
+// @Description 头像不需要认证；音频需要通过签名地址访问，audio标签无法携带认证头，因此不使用登录认证。
+// @Description 使用S3存储时重定向到对象存储的预签名地址
+// @Tags 媒体
+// @Produce octet-stream
+// @Param key path string true "文件key，如 audio/xxx.webm"
+// @Param expires query int false "签名过期时间（Unix时间戳）"
+// @Param signature query string false "签名"
+// @Success 200 {file} file "文件内容"
+// @Success 302 {string} string "重定向到对象存储"
+// @Failure 403 {object} map[string]string "签名无效或已过期"
+// @Failure 404 {object} map[string]string "文件不存在"
+// @Router /media/{key} [get]
+func (h *MediaHandler) GetMedia(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	download, err := h.mediaService.Open(c.Request.Context(), key, c.Query("expires"), c.Query("signature"))
+	if err != nil {
+		respondMediaError(c, err)
+		return
+	}
+
+	if download.RedirectURL != "" {
+		c.Header("Cache-Control", "no-store")
+		c.Redirect(http.StatusFound, download.RedirectURL)
+		return
+	}
+	defer download.Body.Close()
+
+	// key中的令牌是随机生成的，内容不会变化
+	if strings.HasPrefix(key, "avatars/") {
+		c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		c.Header("Cache-Control", "private, max-age=3600")
+	}
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.DataFromReader(http.StatusOK, download.Size, download.ContentType, download.Body, nil)
+}
+
+// readUploadedFile 读取multipart上传的文件，超过大小限制或读取失败时写入错误响应并返回false
+func readUploadedFile(c *gin.Context, field, label string, maxSize int64) ([]byte, bool) {
+	// 预留multipart边界和其他字段的空间
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+64<<10)
+	fileHeader, err := c.FormFile(field)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("%s too large, maximum is %d MB", label, maxSize>>20)})
+			return nil, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": label + " file is required"})
+		return nil, false
+	}
+	if fileHeader.Size > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("%s too large, maximum is %d MB", label, maxSize>>20)})
+		return nil, false
+	}
+	f, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to open " + label + " file"})
+		return nil, false
+	}
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read " + label + " file"})
+		return nil, false
+	}
+	return data, true
+}
+
+func respondMediaError(c *gin.Context, err error) {
+	switch {
+	case err.Error() == "media not found":
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case err.Error() == "invalid or expired signature":
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case strings.HasPrefix(err.Error(), "failed to"):
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
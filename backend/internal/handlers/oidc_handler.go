@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"role-play-ai/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCHandler struct {
+	oidcService *services.OIDCService
+	authHandler *AuthHandler
+	appBaseURL  string
+}
+
+func NewOIDCHandler(oidcService *services.OIDCService, authHandler *AuthHandler, appBaseURL string) *OIDCHandler {
+	return &OIDCHandler{
+		oidcService: oidcService,
+		authHandler: authHandler,
+		appBaseURL:  strings.TrimRight(appBaseURL, "/"),
+	}
+}
+
+// Login 跳转到身份提供方登录
+// @Summary 单点登录
+// @Description 重定向到配置的OpenID Connect身份提供方（授权码模式 + PKCE）
+// @Tags 认证
+// @Success 302 "重定向到身份提供方"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /auth/oidc/login [get]
+func (h *OIDCHandler) Login(c *gin.Context) {
+	authURL, err := h.oidcService.AuthorizationURL()
+	if err != nil {
+		log.Printf("Failed to start OIDC login: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start single sign-on"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback 身份提供方回调
+// @Summary 单点登录回调
+// @Description 用授权码换取ID Token，映射或创建本地用户并签发会话，然后重定向回前端（token在URL片段中）。
+// @Description 开启两步验证的用户不签发会话，URL片段中返回two_factor_required和challenge_token，需调用 /auth/login/2fa 完成登录
+// @Tags 认证
+// @Param code query string true "授权码"
+// @Param state query string true "state"
+// @Success 302 "重定向到前端"
+// @Router /auth/oidc/callback [get]
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	if errCode := c.Query("error"); errCode != "" {
+		h.redirectWithFragment(c, url.Values{"error": {errCode}})
+		return
+	}
+
+	state := c.Query("state")
+	code := c.Query("code")
+	if state == "" || code == "" {
+		h.redirectWithFragment(c, url.Values{"error": {"invalid_request"}})
+		return
+	}
+
+	claims, err := h.oidcService.Exchange(state, code)
+	if err != nil {
+		log.Printf("OIDC exchange failed: %v", err)
+		h.redirectWithFragment(c, url.Values{"error": {"login_failed"}})
+		return
+	}
+
+	user, err := h.oidcService.FindOrCreateUser(claims)
+	if err != nil {
+		log.Printf("OIDC account mapping failed: %v", err)
+		h.redirectWithFragment(c, url.Values{"error": {"account_link_failed"}})
+		return
+	}
+
+	// 开启两步验证的用户与密码登录一样先签发挑战令牌，由前端回调页提交验证码后完成登录
+	enabled, err := h.authHandler.twoFactorService.IsEnabled(user.ID)
+	if err != nil {
+		log.Printf("Failed to check two-factor status for OIDC login: %v", err)
+		h.redirectWithFragment(c, url.Values{"error": {"session_failed"}})
+		return
+	}
+	if enabled {
+		challengeToken, err := h.authHandler.twoFactorService.CreateLoginChallenge(user.ID)
+		if err != nil {
+			log.Printf("Failed to create login challenge for OIDC login: %v", err)
+			h.redirectWithFragment(c, url.Values{"error": {"session_failed"}})
+			return
+		}
+		h.redirectWithFragment(c, url.Values{
+			"two_factor_required": {"true"},
+			"challenge_token":     {challengeToken},
+		})
+		return
+	}
+
+	// 身份提供方已完成认证，这里直接创建与密码登录相同的会话
+	token, err := h.authHandler.createSession(c, user.ID)
+	if err != nil {
+		log.Printf("Failed to create session for OIDC login: %v", err)
+		h.redirectWithFragment(c, url.Values{"error": {"session_failed"}})
+		return
+	}
+	h.authHandler.recordLogin(c, user.ID, token, "oidc")
+
+	h.redirectWithFragment(c, url.Values{"token": {token}})
+}
+
+// redirectWithFragment 重定向到前端回调页，参数放在URL片段中避免token出现在服务器日志和Referer里
+func (h *OIDCHandler) redirectWithFragment(c *gin.Context, values url.Values) {
+	c.Redirect(http.StatusFound, h.appBaseURL+"/oidc/callback#"+values.Encode())
+}
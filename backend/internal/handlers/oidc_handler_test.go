@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"role-play-ai/internal/config"
+	"role-play-ai/internal/database"
+	"role-play-ai/internal/oidctest"
+	"role-play-ai/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+type oidcCallbackTest struct {
+	router   *gin.Engine
+	service  *services.OIDCService
+	provider *oidctest.Provider
+	mock     sqlmock.Sqlmock
+	redis    *miniredis.Miniredis
+}
+
+func newOIDCCallbackTest(t *testing.T) *oidcCallbackTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	mr := miniredis.RunT(t)
+	database.RedisClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	provider := oidctest.NewProvider("role-play-ai")
+	t.Cleanup(provider.Close)
+
+	cfg := &config.Config{
+		OIDCIssuer:      provider.Issuer(),
+		OIDCClientID:    "role-play-ai",
+		OIDCRedirectURL: "http://localhost:8080/api/v1/auth/oidc/callback",
+		OIDCScopes:      "openid email profile",
+		AppBaseURL:      "http://localhost:3000",
+	}
+	auditService := services.NewAuditService(db)
+	userService := services.NewUserService(db, nil, auditService, cfg)
+	oidcService := services.NewOIDCService(db, userService, cfg)
+	authHandler := NewAuthHandler(userService, services.NewTwoFactorService(db, "test"), services.NewLoginGuard(), auditService, "test-secret")
+	handler := NewOIDCHandler(oidcService, authHandler, cfg.AppBaseURL)
+
+	router := gin.New()
+	router.GET("/api/v1/auth/oidc/callback", handler.Callback)
+
+	return &oidcCallbackTest{router: router, service: oidcService, provider: provider, mock: mock, redis: mr}
+}
+
+// login 走完授权流程并请求回调，返回前端回调页URL片段中的参数
+func (tt *oidcCallbackTest) login(t *testing.T, identity oidctest.Identity) url.Values {
+	t.Helper()
+
+	authURL, err := tt.service.AuthorizationURL()
+	if err != nil {
+		t.Fatalf("AuthorizationURL: %v", err)
+	}
+	code, state, err := tt.provider.Authorize(authURL, identity)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), nil)
+	tt.router.ServeHTTP(w, req)
+
+	return callbackFragment(t, w)
+}
+
+func callbackFragment(t *testing.T, w *httptest.ResponseRecorder) url.Values {
+	t.Helper()
+
+	if w.Code != http.StatusFound {
+		t.Fatalf("expected redirect, got %d: %s", w.Code, w.Body.String())
+	}
+	location := w.Header().Get("Location")
+	base, fragment, ok := strings.Cut(location, "#")
+	if !ok || base != "http://localhost:3000/oidc/callback" {
+		t.Fatalf("unexpected redirect location %q", location)
+	}
+	values, err := url.ParseQuery(fragment)
+	if err != nil {
+		t.Fatalf("invalid fragment %q: %v", fragment, err)
+	}
+	return values
+}
+
+func (tt *oidcCallbackTest) expectLinkedUser(id int, totpEnabled bool) {
+	tt.mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM user_identities")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(id))
+	tt.mock.ExpectExec(regexp.QuoteMeta("UPDATE user_identities SET last_login_at")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	tt.mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE id = ?")).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "role", "email_verified_at", "deletion_scheduled_at", "created_at", "updated_at"}).
+			AddRow(id, "alice", "alice@example.com", "user", time.Now(), nil, time.Now(), time.Now()))
+	tt.mock.ExpectQuery(regexp.QuoteMeta("SELECT totp_enabled FROM users WHERE id = ?")).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"totp_enabled"}).AddRow(totpEnabled))
+}
+
+func TestOIDCCallbackCreatesSession(t *testing.T) {
+	tt := newOIDCCallbackTest(t)
+	tt.expectLinkedUser(7, false)
+	tt.mock.ExpectExec(regexp.QuoteMeta("INSERT INTO audit_events")).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	values := tt.login(t, oidctest.Identity{Subject: "sub-1", Email: "alice@example.com", EmailVerified: true})
+
+	if values.Get("token") == "" || values.Get("error") != "" {
+		t.Fatalf("expected token in fragment, got %v", values)
+	}
+	sessions, err := tt.redis.SMembers("user_sessions:7")
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("expected one stored session for user 7, got %v (%v)", sessions, err)
+	}
+	if err := tt.mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCCallbackRequiresTwoFactor(t *testing.T) {
+	tt := newOIDCCallbackTest(t)
+	tt.expectLinkedUser(7, true)
+
+	values := tt.login(t, oidctest.Identity{Subject: "sub-1", Email: "alice@example.com", EmailVerified: true})
+
+	if values.Get("token") != "" {
+		t.Fatalf("session must not be issued before the second factor, got %v", values)
+	}
+	if values.Get("two_factor_required") != "true" || values.Get("challenge_token") == "" {
+		t.Fatalf("expected two-factor challenge, got %v", values)
+	}
+	if err := tt.mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCCallbackRejectsUnverifiedEmailLink(t *testing.T) {
+	tt := newOIDCCallbackTest(t)
+	tt.mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM user_identities")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	tt.mock.ExpectBegin()
+	tt.mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE email = ? FOR UPDATE")).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	tt.mock.ExpectRollback()
+
+	values := tt.login(t, oidctest.Identity{Subject: "sub-1", Email: "alice@example.com", EmailVerified: false})
+
+	if values.Get("error") != "account_link_failed" || values.Get("token") != "" {
+		t.Fatalf("expected account_link_failed, got %v", values)
+	}
+	if err := tt.mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOIDCCallbackProviderError(t *testing.T) {
+	tt := newOIDCCallbackTest(t)
+
+	w := httptest.NewRecorder()
+	tt.router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/auth/oidc/callback?error=access_denied", nil))
+
+	if values := callbackFragment(t, w); values.Get("error") != "access_denied" {
+		t.Fatalf("expected access_denied, got %v", values)
+	}
+}
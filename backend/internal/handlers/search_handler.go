@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SearchHandler struct {
+	searchService *services.SearchService
+}
+
+func NewSearchHandler(searchService *services.SearchService) *SearchHandler {
+	return &SearchHandler{
+		searchService: searchService,
+	}
+}
+
+// SearchMessages 搜索消息
+// @Summary 搜索消息
+// @Description 在当前用户自己的消息中全文搜索，多个关键词用空格分隔（需全部匹配），按相关度排序。snippet为HTML转义后的片段，匹配部分用<mark>标出
+// @Tags 对话
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param q query string true "搜索关键词"
+// @Param character_id query int false "只搜索与该角色的对话"
+// @Param conversation_id query int false "只搜索该对话"
+// @Param page query int false "页码" default(1)
+// @Param limit query int false "每页数量，最大50" default(20)
+// @Success 200 {object} map[string]interface{} "搜索结果"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 500 {object} map[string]string "服务器内部错误"
+// @Router /conversations/search [get]
+func (h *SearchHandler) SearchMessages(c *gin.Context) {
+	userID := c.GetInt("user_id")
+
+	query := &models.MessageSearchQuery{Query: strings.TrimSpace(c.Query("q"))}
+	if query.Query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is required"})
+		return
+	}
+	if utf8.RuneCountInString(query.Query) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query is too long"})
+		return
+	}
+
+	for _, param := range []struct {
+		name string
+		dest *int
+	}{{"character_id", &query.CharacterID}, {"conversation_id", &query.ConversationID}} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid %s", param.name)})
+			return
+		}
+		*param.dest = id
+	}
+
+	query.Page, _ = strconv.Atoi(c.DefaultQuery("page", "1"))
+	query.Limit, _ = strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.Limit < 1 || query.Limit > 50 {
+		query.Limit = 20
+	}
+
+	hits, total, err := h.searchService.SearchMessages(userID, query)
+	if err != nil {
+		if err.Error() == "search query is empty" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": hits,
+		"total":   total,
+		"page":    query.Page,
+		"limit":   query.Limit,
+	})
+}
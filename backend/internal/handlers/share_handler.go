@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ShareHandler struct {
+	shareService *services.ShareService
+}
+
+func NewShareHandler(shareService *services.ShareService) *ShareHandler {
+	return &ShareHandler{shareService: shareService}
+}
+
+// CreateShare 创建分享链接
+// @Summary 创建对话分享链接
+// @Description 为对话创建只读的公开链接，创建时复制当前已有的消息作为快照，之后编辑或删除消息不影响链接内容。链接地址只返回一次，可以设置有效期并随时撤销
+// @Tags 分享
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Param request body models.CreateShareRequest false "有效期"
+// @Success 201 {object} map[string]interface{} "创建成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "对话不存在"
+// @Router /conversations/{id}/share [post]
+func (h *ShareHandler) CreateShare(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	var req models.CreateShareRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	share, url, err := h.shareService.CreateShare(auditActor(c), userID, id, &req)
+	if err != nil {
+		switch err.Error() {
+		case "conversation not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "conversation has no messages", "too many share links, revoke unused links first":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Share link created, store it now: it will not be shown again",
+		"share":   share,
+		"url":     url,
+	})
+}
+
+// ListShares 获取对话的分享链接
+// @Summary 获取对话分享链接
+// @Description 获取对话未撤销的分享链接及访问次数，不包含链接地址
+// @Tags 分享
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Success 200 {object} map[string]interface{} "分享链接列表"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "对话不存在"
+// @Router /conversations/{id}/shares [get]
+func (h *ShareHandler) ListShares(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+
+	shares, err := h.shareService.ListShares(userID, id)
+	if err != nil {
+		if err.Error() == "conversation not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"shares": shares})
+}
+
+// RevokeShare 撤销分享链接
+// @Summary 撤销对话分享链接
+// @Description 撤销后链接立即失效，已复制到其他账户的对话不受影响
+// @Tags 分享
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Param shareId path int true "分享链接ID"
+// @Success 200 {object} map[string]string "撤销成功"
+// @Failure 400 {object} map[string]string "请求参数错误"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "分享链接不存在"
+// @Router /conversations/{id}/shares/{shareId} [delete]
+func (h *ShareHandler) RevokeShare(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	shareID, err := strconv.Atoi(c.Param("shareId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid share ID"})
+		return
+	}
+
+	if err := h.shareService.RevokeShare(auditActor(c), userID, id, shareID); err != nil {
+		if err.Error() == "share not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share link revoked"})
+}
+
+// GetSharedConversation 查看分享的对话
+// @Summary 查看分享的对话
+// @Description 公开接口，返回分享链接对应的对话快照，只包含标题、角色和消息内容，不包含用户信息和内部ID
+// @Tags 分享
+// @Produce json
+// @Param token path string true "分享令牌"
+// @Success 200 {object} models.SharedConversation "对话快照"
+// @Failure 404 {object} map[string]string "链接不存在、已撤销或已过期"
+// @Router /shared/{token} [get]
+func (h *ShareHandler) GetSharedConversation(c *gin.Context) {
+	shared, err := h.shareService.GetSharedConversation(c.Param("token"))
+	if err != nil {
+		if err.Error() == "share not found" {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 快照只在撤销或过期前有效，不允许中间代理缓存
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("X-Robots-Tag", "noindex")
+	c.JSON(http.StatusOK, shared)
+}
+
+// ForkSharedConversation 复制分享的对话
+// @Summary 复制分享的对话到自己的账户
+// @Description 将分享的对话快照复制为当前用户的新对话，之后可以继续聊天，原对话不受影响
+// @Tags 分享
+// @Produce json
+// @Security BearerAuth
+// @Param token path string true "分享令牌"
+// @Success 201 {object} map[string]interface{} "复制成功"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "链接不存在或角色已下架"
+// @Router /shared/{token}/fork [post]
+func (h *ShareHandler) ForkSharedConversation(c *gin.Context) {
+	conversation, err := h.shareService.ForkSharedConversation(c.GetInt("user_id"), c.Param("token"))
+	if err != nil {
+		switch err.Error() {
+		case "share not found", "character not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"conversation": conversation})
+}
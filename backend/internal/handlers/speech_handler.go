@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"role-play-ai/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SpeechHandler struct {
+	speechService *services.SpeechService
+}
+
+func NewSpeechHandler(speechService *services.SpeechService) *SpeechHandler {
+	return &SpeechHandler{speechService: speechService}
+}
+
+// SynthesizeMessage 朗读AI消息
+// @Summary 合成AI消息的语音
+// @Description 使用角色的音色合成AI回复的语音，保存后写入消息的audio_url。已经合成过的消息直接返回已有的语音。
+// @Description 消息中的audio_url是稳定引用，播放时使用返回的audio_url（带签名的临时地址）
+// @Tags 对话
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "对话ID"
+// @Param messageId path int true "消息ID"
+// @Success 200 {object} map[string]interface{} "消息和语音的签名地址"
+// @Failure 400 {object} map[string]string "不是AI消息或没有可朗读的内容"
+// @Failure 401 {object} map[string]string "未授权"
+// @Failure 404 {object} map[string]string "消息不存在"
+// @Failure 502 {object} map[string]string "语音合成服务出错"
+// @Failure 503 {object} map[string]string "未启用语音合成"
+// @Router /conversations/{id}/messages/{messageId}/speech [post]
+func (h *SpeechHandler) SynthesizeMessage(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	conversationID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid conversation ID"})
+		return
+	}
+	messageID, err := strconv.Atoi(c.Param("messageId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message ID"})
+		return
+	}
+
+	message, audioURL, err := h.speechService.SynthesizeMessage(c.Request.Context(), userID, conversationID, messageID)
+	if err != nil {
+		switch {
+		case err.Error() == "message not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case err.Error() == "text-to-speech is not configured":
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		case strings.HasPrefix(err.Error(), "failed to synthesize"):
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		case strings.HasPrefix(err.Error(), "failed to"):
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message, "audio_url": audioURL})
+}
@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sentenceSpeaker 在流式回复过程中逐句合成语音，按句子顺序以audio事件写入SSE流。
+// 它同时作为回复内容的writer，保证文本事件和语音事件不会交错写入
+type sentenceSpeaker struct {
+	mu        sync.Mutex
+	w         gin.ResponseWriter
+	splitter  services.SentenceSplitter
+	sentences chan string
+	done      chan struct{}
+}
+
+func newSentenceSpeaker(ctx context.Context, w gin.ResponseWriter, speechService *services.SpeechService, userID int, character *models.Character) *sentenceSpeaker {
+	s := &sentenceSpeaker{
+		w:         w,
+		sentences: make(chan string, 64),
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+		index := 0
+		for sentence := range s.sentences {
+			audioURL, err := speechService.SynthesizeSentence(ctx, userID, character, sentence)
+			if err != nil {
+				// 没有可朗读内容的句子（如只有标点）直接跳过，不占用序号
+				if err.Error() != "nothing to speak" {
+					log.Printf("Failed to synthesize sentence: %v", err)
+				}
+				continue
+			}
+			event, _ := json.Marshal(gin.H{
+				"type":      "audio",
+				"index":     index,
+				"text":      sentence,
+				"audio_url": audioURL,
+			})
+			index++
+			s.mu.Lock()
+			fmt.Fprintf(s.w, "data: %s\n\n", event)
+			s.w.Flush()
+			s.mu.Unlock()
+		}
+	}()
+
+	return s
+}
+
+func (s *sentenceSpeaker) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+func (s *sentenceSpeaker) Flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.w.Flush()
+}
+
+// Add 接收一段回复内容，完整的句子交给后台合成
+func (s *sentenceSpeaker) Add(delta string) {
+	for _, sentence := range s.splitter.Add(delta) {
+		s.sentences <- sentence
+	}
+}
+
+// Close 合成最后不完整的句子，并等待全部语音事件写完
+func (s *sentenceSpeaker) Close() {
+	if rest := s.splitter.Flush(); rest != "" {
+		s.sentences <- rest
+	}
+	close(s.sentences)
+	<-s.done
+}
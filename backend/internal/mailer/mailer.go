@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"log"
+
+	"role-play-ai/internal/config"
+)
+
+// Message 待发送的邮件
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer 邮件发送接口
+type Mailer interface {
+	Send(msg *Message) error
+}
+
+// New 根据配置创建邮件发送器，MAIL_DRIVER 支持 smtp 和 log
+func New(cfg *config.Config) Mailer {
+	switch cfg.MailDriver {
+	case "smtp":
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	case "log", "":
+		return NewLogMailer(cfg.MailLogDir, cfg.MailFrom)
+	default:
+		log.Printf("Unknown mail driver %q, falling back to log mailer", cfg.MailDriver)
+		return NewLogMailer(cfg.MailLogDir, cfg.MailFrom)
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyAuthenticator 校验API密钥
+type APIKeyAuthenticator interface {
+	Authenticate(key string) (*models.APIKey, error)
+}
+
+// APIKeyOrRedisAuthMiddleware 同时接受个人API密钥和登录会话的认证中间件
+// API密钥可以通过 X-API-Key 请求头或 Authorization: Bearer rpa_... 传递
+func APIKeyOrRedisAuthMiddleware(jwtSecret string, keys APIKeyAuthenticator) gin.HandlerFunc {
+	sessionAuth := RedisAuthMiddleware(jwtSecret)
+
+	return func(c *gin.Context) {
+		apiKey := c.GetHeader("X-API-Key")
+		if apiKey == "" {
+			if bearer := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer "); services.IsAPIKey(bearer) {
+				apiKey = bearer
+			}
+		}
+
+		if apiKey == "" {
+			sessionAuth(c)
+			return
+		}
+
+		key, err := keys.Authenticate(apiKey)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired API key"})
+			c.Abort()
+			return
+		}
+
+		// 与会话认证使用相同的user_id，限流和配额按用户统一计算
+		c.Set("user_id", key.UserID)
+		c.Set("api_key_id", key.ID)
+		c.Set("api_key_scopes", key.Scopes)
+		c.Next()
+	}
+}
+
+// RequireScope 要求API密钥具备指定权限，会话认证的请求不受限制
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, exists := c.Get("api_key_scopes")
+		if !exists {
+			c.Next()
+			return
+		}
+
+		scopes, _ := value.([]string)
+		for _, s := range scopes {
+			if s == scope {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":          "API key does not have the required scope",
+			"required_scope": scope,
+		})
+		c.Abort()
+	}
+}
+
+// RequireSessionAuth 拒绝API密钥认证的请求，用于只允许登录会话访问的接口
+func RequireSessionAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, exists := c.Get("api_key_id"); exists {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint cannot be accessed with an API key"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 // RateLimitConfig 限流配置
 type RateLimitConfig struct {
+	Name        string                    // 限流器名称，用于按用户覆盖限额
 	MaxRequests int                       // 最大请求数
 	Window      time.Duration             // 时间窗口
 	KeyFunc     func(*gin.Context) string // 限流键生成函数
@@ -20,6 +21,7 @@ type RateLimitConfig struct {
 
 // DefaultRateLimitConfig 默认限流配置
 var DefaultRateLimitConfig = RateLimitConfig{
+	Name:        "default",
 	MaxRequests: 100, // 每分钟100个请求
 	Window:      time.Minute,
 	KeyFunc: func(c *gin.Context) string {
@@ -30,6 +32,7 @@ var DefaultRateLimitConfig = RateLimitConfig{
 
 // APIRateLimitConfig API限流配置
 var APIRateLimitConfig = RateLimitConfig{
+	Name:        "api",
 	MaxRequests: 60, // 每分钟60个API请求
 	Window:      time.Minute,
 	KeyFunc: func(c *gin.Context) string {
@@ -44,6 +47,7 @@ var APIRateLimitConfig = RateLimitConfig{
 
 // AIChatRateLimitConfig AI聊天限流配置
 var AIChatRateLimitConfig = RateLimitConfig{
+	Name:        "ai_chat",
 	MaxRequests: 10, // 每分钟10次AI聊天请求
 	Window:      time.Minute,
 	KeyFunc: func(c *gin.Context) string {
@@ -69,8 +73,16 @@ func RateLimitMiddleware(config RateLimitConfig) gin.HandlerFunc {
 			return
 		}
 
+		// 管理员可以为单个用户覆盖限额
+		maxRequests := config.MaxRequests
+		if userID, exists := c.Get("user_id"); exists {
+			if override, ok := getRateLimitOverride(config.Name, userID.(int)); ok {
+				maxRequests = override
+			}
+		}
+
 		// 检查是否超过限制
-		if currentCount > int64(config.MaxRequests) {
+		if currentCount > int64(maxRequests) {
 			c.JSON(http.StatusTooManyRequests, gin.H{
 				"error":       "Rate limit exceeded",
 				"retry_after": config.Window.Seconds(),
@@ -80,8 +92,8 @@ func RateLimitMiddleware(config RateLimitConfig) gin.HandlerFunc {
 		}
 
 		// 设置响应头
-		c.Header("X-RateLimit-Limit", strconv.Itoa(config.MaxRequests))
-		c.Header("X-RateLimit-Remaining", strconv.Itoa(config.MaxRequests-int(currentCount)))
+		c.Header("X-RateLimit-Limit", strconv.Itoa(maxRequests))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(maxRequests-int(currentCount)))
 		c.Header("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(config.Window).Unix(), 10))
 
 		c.Next()
@@ -123,3 +135,54 @@ func GetRateLimitStatus(key string, config RateLimitConfig) (current int64, limi
 
 	return current, limit, remaining, resetTime, nil
 }
+
+func rateLimitOverrideKey(name string, userID int) string {
+	key := &database.CacheKey{Prefix: database.RateLimitOverridePrefix, ID: fmt.Sprintf("%s:user:%d", name, userID)}
+	return key.String()
+}
+
+func getRateLimitOverride(name string, userID int) (int, bool) {
+	cached, err := database.GetCache(rateLimitOverrideKey(name, userID))
+	if err != nil {
+		return 0, false
+	}
+	value, err := strconv.Atoi(cached)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+// SetRateLimitOverride 为用户设置限流覆盖，ttl为0表示永久有效
+func SetRateLimitOverride(name string, userID, maxRequests int, ttl time.Duration) error {
+	return database.SetCache(rateLimitOverrideKey(name, userID), maxRequests, ttl)
+}
+
+// DeleteRateLimitOverride 删除用户的限流覆盖
+func DeleteRateLimitOverride(name string, userID int) error {
+	return database.DeleteCache(rateLimitOverrideKey(name, userID))
+}
+
+// GetRateLimitOverrides 获取用户在各限流器上的覆盖限额
+func GetRateLimitOverrides(userID int) map[string]int {
+	overrides := make(map[string]int)
+	for _, config := range []RateLimitConfig{APIRateLimitConfig, AIChatRateLimitConfig} {
+		if value, ok := getRateLimitOverride(config.Name, userID); ok {
+			overrides[config.Name] = value
+		}
+	}
+	return overrides
+}
+
+// PurgeUserRateLimits 清除用户的限流计数和覆盖配置，用于删除账户
+func PurgeUserRateLimits(userID int) error {
+	keys := []string{}
+	for _, config := range []RateLimitConfig{APIRateLimitConfig, AIChatRateLimitConfig} {
+		keys = append(keys, rateLimitOverrideKey(config.Name, userID))
+	}
+	for _, id := range []string{fmt.Sprintf("user:%d", userID), fmt.Sprintf("ai_chat:user:%d", userID)} {
+		key := &database.CacheKey{Prefix: database.RateLimitPrefix, ID: id}
+		keys = append(keys, key.String())
+	}
+	return database.RedisClient.Del(database.Ctx, keys...).Err()
+}
@@ -1,17 +1,29 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"time"
 
 	"role-play-ai/internal/database"
+	"role-play-ai/internal/models"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
 )
 
+// ErrSessionNotFound 会话不存在或不属于该用户
+var ErrSessionNotFound = errors.New("session not found")
+
+// sessionTouchInterval 最后访问时间的最小更新间隔，避免每个请求都写Redis
+const sessionTouchInterval = time.Minute
+
 // RedisAuthMiddleware 基于Redis的认证中间件
 func RedisAuthMiddleware(jwtSecret string) gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -32,10 +44,10 @@ func RedisAuthMiddleware(jwtSecret string) gin.HandlerFunc {
 
 		tokenString := authHeader[7:]
 
-		// 检查Redis中是否存在该token
-		sessionKey := &database.CacheKey{Prefix: database.SessionCachePrefix, ID: tokenString}
-		exists, err := database.ExistsCache(sessionKey.String())
-		if err != nil || !exists {
+		// 检查Redis中是否存在该会话
+		sessionID := SessionID(tokenString)
+		session, err := getSession(sessionID)
+		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
@@ -51,7 +63,7 @@ func RedisAuthMiddleware(jwtSecret string) gin.HandlerFunc {
 
 		if err != nil || !token.Valid {
 			// 如果token无效，从Redis中删除
-			database.DeleteCache(sessionKey.String())
+			revokeSession(session.UserID, sessionID)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
@@ -66,7 +78,7 @@ func RedisAuthMiddleware(jwtSecret string) gin.HandlerFunc {
 		}
 
 		userID, ok := claims["user_id"].(float64)
-		if !ok {
+		if !ok || int(userID) != session.UserID {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid user ID in token"})
 			c.Abort()
 			return
@@ -75,64 +87,162 @@ func RedisAuthMiddleware(jwtSecret string) gin.HandlerFunc {
 		// 将用户ID存储到上下文中
 		c.Set("user_id", int(userID))
 		c.Set("token", tokenString)
-
-		// 更新token的最后访问时间
-		userSessionKey := &database.CacheKey{Prefix: database.SessionCachePrefix, ID: fmt.Sprintf("user:%d", int(userID))}
-		sessionData := map[string]interface{}{
-			"user_id":    int(userID),
-			"token":      tokenString,
-			"last_seen":  time.Now().Unix(),
-			"created_at": claims["iat"],
-		}
-		if data, err := json.Marshal(sessionData); err == nil {
-			database.SetCache(userSessionKey.String(), string(data), database.SessionCacheExpiry)
+		c.Set("session_id", sessionID)
+
+		// 更新会话的最后访问时间
+		now := time.Now()
+		if now.Sub(time.Unix(session.LastSeen, 0)) >= sessionTouchInterval {
+			session.LastSeen = now.Unix()
+			session.IP = c.ClientIP()
+			if data, err := json.Marshal(session); err == nil {
+				database.SetCache(sessionKey(sessionID), string(data), redis.KeepTTL)
+			}
 		}
 
 		c.Next()
 	}
 }
 
-// StoreSession 存储用户会话到Redis
-func StoreSession(userID int, token string) error {
-	// 存储token到会话
-	sessionKey := &database.CacheKey{Prefix: database.SessionCachePrefix, ID: token}
-	sessionData := map[string]interface{}{
-		"user_id":    userID,
-		"token":      token,
-		"last_seen":  time.Now().Unix(),
-		"created_at": time.Now().Unix(),
+// SessionID 根据token计算会话ID，Redis中不保存原始token
+func SessionID(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:16])
+}
+
+func sessionKey(sessionID string) string {
+	key := &database.CacheKey{Prefix: database.SessionCachePrefix, ID: sessionID}
+	return key.String()
+}
+
+func userSessionsKey(userID int) string {
+	key := &database.CacheKey{Prefix: database.UserSessionsPrefix, ID: userID}
+	return key.String()
+}
+
+func getSession(sessionID string) (*models.Session, error) {
+	cached, err := database.GetCache(sessionKey(sessionID))
+	if err != nil {
+		return nil, err
 	}
 
-	if data, err := json.Marshal(sessionData); err == nil {
-		return database.SetCache(sessionKey.String(), string(data), database.SessionCacheExpiry)
+	var session models.Session
+	if err := json.Unmarshal([]byte(cached), &session); err != nil {
+		return nil, err
 	}
-	return nil
+	return &session, nil
 }
 
-// RevokeSession 撤销用户会话
+// StoreSession 存储用户会话到Redis，并登记到该用户的会话集合
+func StoreSession(userID int, token, ip, userAgent string) error {
+	now := time.Now().Unix()
+	session := &models.Session{
+		ID:        SessionID(token),
+		UserID:    userID,
+		IP:        ip,
+		UserAgent: userAgent,
+		CreatedAt: now,
+		LastSeen:  now,
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	pipe := database.RedisClient.TxPipeline()
+	pipe.Set(database.Ctx, sessionKey(session.ID), string(data), database.SessionCacheExpiry)
+	pipe.SAdd(database.Ctx, userSessionsKey(userID), session.ID)
+	pipe.Expire(database.Ctx, userSessionsKey(userID), database.SessionCacheExpiry)
+	_, err = pipe.Exec(database.Ctx)
+	return err
+}
+
+// RevokeSession 撤销token对应的会话
 func RevokeSession(token string) error {
-	sessionKey := &database.CacheKey{Prefix: database.SessionCachePrefix, ID: token}
-	return database.DeleteCache(sessionKey.String())
+	sessionID := SessionID(token)
+	session, err := getSession(sessionID)
+	if err != nil {
+		return database.DeleteCache(sessionKey(sessionID))
+	}
+	return revokeSession(session.UserID, sessionID)
+}
+
+// RevokeUserSession 撤销用户的指定会话
+func RevokeUserSession(userID int, sessionID string) error {
+	isMember, err := database.RedisClient.SIsMember(database.Ctx, userSessionsKey(userID), sessionID).Result()
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return ErrSessionNotFound
+	}
+	return revokeSession(userID, sessionID)
+}
+
+// RevokeOtherUserSessions 撤销用户除当前会话外的所有会话，返回撤销数量
+func RevokeOtherUserSessions(userID int, keepSessionID string) (int, error) {
+	sessionIDs, err := database.RedisClient.SMembers(database.Ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, sessionID := range sessionIDs {
+		if sessionID == keepSessionID {
+			continue
+		}
+		if err := revokeSession(userID, sessionID); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
 }
 
 // RevokeAllUserSessions 撤销用户的所有会话
 func RevokeAllUserSessions(userID int) error {
-	userSessionKey := &database.CacheKey{Prefix: database.SessionCachePrefix, ID: fmt.Sprintf("user:%d", userID)}
-	return database.DeleteCache(userSessionKey.String())
+	sessionIDs, err := database.RedisClient.SMembers(database.Ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(sessionIDs)+1)
+	for _, sessionID := range sessionIDs {
+		keys = append(keys, sessionKey(sessionID))
+	}
+	keys = append(keys, userSessionsKey(userID))
+	return database.RedisClient.Del(database.Ctx, keys...).Err()
 }
 
-// GetUserSessions 获取用户的所有活跃会话
-func GetUserSessions(userID int) ([]map[string]interface{}, error) {
-	userSessionKey := &database.CacheKey{Prefix: database.SessionCachePrefix, ID: fmt.Sprintf("user:%d", userID)}
-	cached, err := database.GetCache(userSessionKey.String())
+// GetUserSessions 获取用户的所有活跃会话，按最后访问时间倒序
+func GetUserSessions(userID int) ([]*models.Session, error) {
+	sessionIDs, err := database.RedisClient.SMembers(database.Ctx, userSessionsKey(userID)).Result()
 	if err != nil {
 		return nil, err
 	}
 
-	var sessions []map[string]interface{}
-	if cached != "" {
-		json.Unmarshal([]byte(cached), &sessions)
+	sessions := make([]*models.Session, 0, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		session, err := getSession(sessionID)
+		if err != nil {
+			// 会话已过期，从集合中清理
+			database.RedisClient.SRem(database.Ctx, userSessionsKey(userID), sessionID)
+			continue
+		}
+		sessions = append(sessions, session)
 	}
 
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeen > sessions[j].LastSeen
+	})
+
 	return sessions, nil
 }
+
+func revokeSession(userID int, sessionID string) error {
+	pipe := database.RedisClient.TxPipeline()
+	pipe.Del(database.Ctx, sessionKey(sessionID))
+	pipe.SRem(database.Ctx, userSessionsKey(userID), sessionID)
+	_, err := pipe.Exec(database.Ctx)
+	return err
+}
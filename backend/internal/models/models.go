@@ -1,14 +1,28 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
+
+// 用户角色
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
 
 // User 用户模型
 type User struct {
-	ID        int       `json:"id" db:"id"`
-	Username  string    `json:"username" db:"username"`
-	Email     string    `json:"email" db:"email"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID                  int        `json:"id" db:"id"`
+	Username            string     `json:"username" db:"username"`
+	Email               string     `json:"email" db:"email"`
+	Role                string     `json:"role" db:"role"`
+	EmailVerified       bool       `json:"email_verified"`
+	EmailVerifiedAt     *time.Time `json:"email_verified_at,omitempty" db:"email_verified_at"`
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at" db:"updated_at"`
 }
 
 // UserRegister 用户注册请求
@@ -24,6 +38,114 @@ type UserLogin struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// ChangePasswordRequest 修改密码请求
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+// ForgotPasswordRequest 忘记密码请求
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest 重置密码请求
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=6"`
+}
+
+// VerifyEmailRequest 邮箱验证请求
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// DeleteAccountRequest 申请删除账户请求，未设置密码的账户（仅单点登录）可不填
+type DeleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+// ConversationExport 单个对话的导出格式，也是导入时支持的JSON格式
+type ConversationExport struct {
+	Version      int           `json:"version"`
+	ExportedAt   time.Time     `json:"exported_at"`
+	Conversation *Conversation `json:"conversation"`
+	Messages     []*Message    `json:"messages"`
+}
+
+// ImportSkippedLine 导入时跳过的行
+type ImportSkippedLine struct {
+	Line    int    `json:"line"`
+	Reason  string `json:"reason"`
+	Content string `json:"content,omitempty"`
+}
+
+// ImportConversationResult 导入对话结果
+type ImportConversationResult struct {
+	Conversation     *Conversation        `json:"conversation"`
+	Format           string               `json:"format"`
+	ImportedMessages int                  `json:"imported_messages"`
+	CharacterCreated bool                 `json:"character_created"`
+	Skipped          []*ImportSkippedLine `json:"skipped"`
+}
+
+// TwoFactorCodeRequest 两步验证码请求（TOTP验证码或恢复码）
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// DisableTwoFactorRequest 关闭两步验证请求，未设置密码的账户（仅单点登录）可不填密码
+type DisableTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code" binding:"required"`
+}
+
+// LoginTwoFactorRequest 两步验证登录请求
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// Session 用户登录会话
+type Session struct {
+	ID        string `json:"id"`
+	UserID    int    `json:"user_id"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	CreatedAt int64  `json:"created_at"`
+	LastSeen  int64  `json:"last_seen"`
+	Current   bool   `json:"current"`
+}
+
+// API密钥权限范围
+const (
+	ScopeConversationsRead = "conversations:read"
+	ScopeChat              = "chat"
+	ScopeCharactersManage  = "characters:manage"
+)
+
+// APIKeyScopes 所有可分配的API密钥权限
+var APIKeyScopes = []string{ScopeConversationsRead, ScopeChat, ScopeCharactersManage}
+
+// APIKey 个人API密钥（不含密钥明文）
+type APIKey struct {
+	ID         int        `json:"id" db:"id"`
+	UserID     int        `json:"user_id" db:"user_id"`
+	Name       string     `json:"name" db:"name"`
+	Prefix     string     `json:"prefix" db:"key_prefix"`
+	Scopes     []string   `json:"scopes" db:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty" db:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// CreateAPIKeyRequest 创建API密钥请求
+type CreateAPIKeyRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays *int     `json:"expires_in_days,omitempty" binding:"omitempty,min=1,max=365"`
+}
+
 // Character 角色模型
 type Character struct {
 	ID           int       `json:"id" db:"id"`
@@ -32,21 +154,330 @@ type Character struct {
 	AvatarURL    string    `json:"avatar_url" db:"avatar_url"`
 	SystemPrompt string    `json:"system_prompt" db:"system_prompt"`
 	Category     string    `json:"category" db:"category"`
+	IsPublished  bool      `json:"is_published" db:"is_published"`
+	Voice        string    `json:"voice" db:"voice"`
+	VoiceSpeed   float64   `json:"voice_speed" db:"voice_speed"`
+	Tags         []string  `json:"tags"`
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+
+	// 统计数据由后台任务异步更新，可能有短暂延迟
+	ConversationCount int     `json:"conversation_count"`
+	MessageCount      int     `json:"message_count"`
+	FavoriteCount     int     `json:"favorite_count"`
+	RatingCount       int     `json:"rating_count"`
+	AverageRating     float64 `json:"average_rating"`
+}
+
+// CharacterRating 用户对角色的评分和评价
+type CharacterRating struct {
+	CharacterID int       `json:"character_id" db:"character_id"`
+	Username    string    `json:"username"`
+	Rating      int       `json:"rating" db:"rating"`
+	Review      string    `json:"review" db:"review"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// RateCharacterRequest 评分请求，评价可选
+type RateCharacterRequest struct {
+	Rating int    `json:"rating" binding:"required,min=1,max=5"`
+	Review string `json:"review" binding:"max=2000"`
+}
+
+// CharacterRequest 创建/更新角色请求，更新时Tags为空表示不修改标签，传空数组清除标签
+type CharacterRequest struct {
+	Name         string   `json:"name" binding:"required,max=100"`
+	Description  string   `json:"description"`
+	AvatarURL    string   `json:"avatar_url" binding:"max=255"`
+	SystemPrompt string   `json:"system_prompt" binding:"required"`
+	Category     string   `json:"category" binding:"max=50"`
+	IsPublished  *bool    `json:"is_published,omitempty"`
+	Tags         []string `json:"tags,omitempty" binding:"omitempty,max=10,dive,min=1,max=50"`
+	// Voice 语音合成音色，为空时使用默认音色；VoiceSpeed 为空时创建使用1.0，更新时不修改
+	Voice      string   `json:"voice" binding:"max=100"`
+	VoiceSpeed *float64 `json:"voice_speed,omitempty" binding:"omitempty,min=0.5,max=2"`
+}
+
+// CharacterQuery 角色发现查询条件，多个标签需全部匹配
+type CharacterQuery struct {
+	Query    string
+	Category string
+	Tags     []string
+	Sort     string
+	Page     int
+	Limit    int
+}
+
+// CharacterFacet 分面统计项
+type CharacterFacet struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// CharacterFacets 当前搜索条件下各分类和标签的角色数，分类统计不受分类筛选影响
+type CharacterFacets struct {
+	Categories []*CharacterFacet `json:"categories"`
+	Tags       []*CharacterFacet `json:"tags"`
+}
+
+// CharacterList 角色发现结果
+type CharacterList struct {
+	Characters []*Character     `json:"characters"`
+	Total      int              `json:"total"`
+	Page       int              `json:"page"`
+	Limit      int              `json:"limit"`
+	Sort       string           `json:"sort"`
+	Facets     *CharacterFacets `json:"facets"`
+}
+
+// UpdateUserRoleRequest 修改用户角色请求
+type UpdateUserRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=user moderator admin"`
+}
+
+// 审计事件类型
+const (
+	AuditLogin                   = "auth.login"
+	AuditLoginFailed             = "auth.login_failed"
+	AuditLogout                  = "auth.logout"
+	AuditPasswordChange          = "auth.password_change"
+	AuditPasswordReset           = "auth.password_reset"
+	AuditSessionRevoke           = "session.revoke"
+	AuditSessionRevokeOthers     = "session.revoke_others"
+	AuditSessionRevokeAll        = "session.revoke_all"
+	AuditUserRoleChange          = "user.role_change"
+	AuditUserUnlock              = "user.unlock"
+	AuditCharacterCreate         = "character.create"
+	AuditCharacterUpdate         = "character.update"
+	AuditCharacterPublish        = "character.publish"
+	AuditCharacterUnpublish      = "character.unpublish"
+	AuditCharacterDelete         = "character.delete"
+	AuditConversationDelete      = "conversation.delete"
+	AuditConversationBatchDelete = "conversation.batch_delete"
+	AuditConversationRestore     = "conversation.restore"
+	AuditConversationShare       = "conversation.share"
+	AuditConversationUnshare     = "conversation.unshare"
+	AuditConversationPurge       = "conversation.purge"
+	AuditConversationImport      = "conversation.import"
+	AuditFeedbackExport          = "feedback.export"
+	AuditAccountDeleteRequest    = "account.delete_request"
+	AuditAccountDeleteCancel     = "account.delete_cancel"
+	AuditAccountPurge            = "account.purge"
+	AuditAccountExport           = "account.export"
+)
+
+// AuditActor 审计事件的操作者，由处理器根据请求上下文构造
+type AuditActor struct {
+	UserID    int
+	APIKeyID  int
+	IP        string
+	UserAgent string
+}
+
+// AuditEvent 审计事件
+type AuditEvent struct {
+	ID            int64           `json:"id"`
+	ActorUserID   *int            `json:"actor_user_id,omitempty"`
+	ActorAPIKeyID *int            `json:"actor_api_key_id,omitempty"`
+	IP            string          `json:"ip"`
+	UserAgent     string          `json:"user_agent"`
+	Action        string          `json:"action"`
+	TargetType    string          `json:"target_type"`
+	TargetID      string          `json:"target_id"`
+	Metadata      json.RawMessage `json:"metadata,omitempty" swaggertype:"object"`
+	CreatedAt     time.Time       `json:"created_at"`
+}
+
+// AuditEventFilter 审计事件查询条件
+type AuditEventFilter struct {
+	ActorUserID int
+	Action      string
+	TargetType  string
+	TargetID    string
+	IP          string
+	From        *time.Time
+	To          *time.Time
+	Page        int
+	Limit       int
+}
+
+// LoginFailure 登录失败记录
+type LoginFailure struct {
+	Email     string    `json:"email"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PublishCharacterRequest 发布/下架角色请求
+type PublishCharacterRequest struct {
+	Published *bool `json:"published" binding:"required"`
+}
+
+// RateLimitOverrideRequest 设置用户限流覆盖请求
+type RateLimitOverrideRequest struct {
+	Limiter     string `json:"limiter" binding:"required,oneof=api ai_chat"`
+	MaxRequests int    `json:"max_requests" binding:"required,min=1"`
+	TTLHours    int    `json:"ttl_hours,omitempty" binding:"omitempty,min=1"`
 }
 
 // Conversation 对话会话模型
 type Conversation struct {
-	ID          int        `json:"id" db:"id"`
-	UserID      int        `json:"user_id" db:"user_id"`
-	CharacterID int        `json:"character_id" db:"character_id"`
-	Title       string     `json:"title" db:"title"`
-	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
-	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
-	Character   *Character `json:"character,omitempty"`
+	ID           int                  `json:"id" db:"id"`
+	UserID       int                  `json:"user_id" db:"user_id"`
+	CharacterID  int                  `json:"character_id" db:"character_id"`
+	Title        string               `json:"title" db:"title"`
+	TitleAuto    bool                 `json:"title_auto" db:"title_auto"`
+	IsArchived   bool                 `json:"is_archived" db:"is_archived"`
+	IsPinned     bool                 `json:"is_pinned"`
+	PinnedAt     *time.Time           `json:"pinned_at,omitempty" db:"pinned_at"`
+	FolderID     *int                 `json:"folder_id" db:"folder_id"`
+	DeletedAt    *time.Time           `json:"deleted_at,omitempty" db:"deleted_at"`
+	CreatedAt    time.Time            `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time            `json:"updated_at" db:"updated_at"`
+	Character    *Character           `json:"character,omitempty"`
+	Labels       []*ConversationLabel `json:"labels"`
+	MessageCount int                  `json:"message_count"`
+	LastMessage  *MessagePreview      `json:"last_message,omitempty"`
 }
 
+// ConversationFolder 对话文件夹
+type ConversationFolder struct {
+	ID                int       `json:"id" db:"id"`
+	Name              string    `json:"name" db:"name"`
+	ConversationCount int       `json:"conversation_count"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+}
+
+// ConversationLabel 对话标签，在对话详情中只包含ID和名称
+type ConversationLabel struct {
+	ID                int        `json:"id" db:"id"`
+	Name              string     `json:"name" db:"name"`
+	ConversationCount *int       `json:"conversation_count,omitempty"`
+	CreatedAt         *time.Time `json:"created_at,omitempty" db:"created_at"`
+}
+
+// FolderRequest 创建或重命名文件夹请求
+type FolderRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// LabelRequest 创建或重命名标签请求
+type LabelRequest struct {
+	Name string `json:"name" binding:"required,max=50"`
+}
+
+// UpdateConversationRequest 修改对话的置顶、归档和文件夹状态，未提供的字段保持不变
+type UpdateConversationRequest struct {
+	IsPinned   *bool `json:"is_pinned,omitempty"`
+	IsArchived *bool `json:"is_archived,omitempty"`
+	FolderID   *int  `json:"folder_id,omitempty"` // 为0时移出文件夹
+}
+
+// SetConversationLabelsRequest 设置对话的全部标签
+type SetConversationLabelsRequest struct {
+	LabelIDs []int `json:"label_ids" binding:"max=20"`
+}
+
+// BatchArchiveRequest 批量归档或取消归档
+type BatchArchiveRequest struct {
+	IDs      []int `json:"ids" binding:"required,min=1,max=100"`
+	Archived bool  `json:"archived"`
+}
+
+// BatchMoveRequest 批量移动到文件夹，folder_id为0时移出文件夹
+type BatchMoveRequest struct {
+	IDs      []int `json:"ids" binding:"required,min=1,max=100"`
+	FolderID int   `json:"folder_id"`
+}
+
+// BatchLabelRequest 批量添加或移除标签
+type BatchLabelRequest struct {
+	IDs     []int `json:"ids" binding:"required,min=1,max=100"`
+	LabelID int   `json:"label_id" binding:"required"`
+	Remove  bool  `json:"remove"`
+}
+
+// RenameConversationRequest 修改对话标题请求
+type RenameConversationRequest struct {
+	Title string `json:"title" binding:"required,max=200"`
+}
+
+// CloneConversationRequest 复制对话请求，MessageID 为空时复制全部消息，CharacterID 为空时沿用原角色
+type CloneConversationRequest struct {
+	MessageID   *int   `json:"message_id"`
+	CharacterID *int   `json:"character_id"`
+	Title       string `json:"title" binding:"max=200"`
+}
+
+// ConversationShare 对话的只读分享链接，链接中的令牌只在创建时返回一次
+type ConversationShare struct {
+	ID             int        `json:"id" db:"id"`
+	ConversationID int        `json:"conversation_id" db:"conversation_id"`
+	MessageCount   int        `json:"message_count"`
+	ExpiresAt      *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	ViewCount      int        `json:"view_count" db:"view_count"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+// CreateShareRequest 创建分享链接请求，不设置有效期时长期有效，直到撤销
+type CreateShareRequest struct {
+	ExpiresInDays *int `json:"expires_in_days,omitempty" binding:"omitempty,min=1,max=365"`
+}
+
+// SharedConversation 公开的对话快照，不包含用户信息和任何内部ID
+type SharedConversation struct {
+	Title     string           `json:"title"`
+	Character *SharedCharacter `json:"character"`
+	Messages  []*SharedMessage `json:"messages"`
+	SharedAt  time.Time        `json:"shared_at"`
+	ExpiresAt *time.Time       `json:"expires_at,omitempty"`
+}
+
+// SharedCharacter 分享快照中的角色信息
+type SharedCharacter struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+// SharedMessage 分享快照中的消息
+type SharedMessage struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// MessagePreview 对话列表中显示的最后一条消息摘要
+type MessagePreview struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ConversationFilter 对话列表查询条件，时间范围按最后活动时间（updated_at）筛选
+type ConversationFilter struct {
+	CharacterID int
+	From        *time.Time
+	To          *time.Time
+	Archived    *bool // 为空时不按归档状态筛选
+	FolderID    *int  // 为0时只返回不在任何文件夹中的对话
+	LabelID     int
+	Deleted     bool // 为true时查询回收站中的对话，按删除时间倒序
+	Sort        string
+	Page        int
+	Limit       int
+}
+
+// 对话列表排序方式，置顶的对话始终排在最前
+const (
+	ConversationSortRecent  = "recent"  // 最后活动时间
+	ConversationSortCreated = "created" // 创建时间
+	ConversationSortTitle   = "title"   // 标题
+)
+
 // Message 消息模型
 type Message struct {
 	ID             int       `json:"id" db:"id"`
@@ -54,7 +485,99 @@ type Message struct {
 	Role           string    `json:"role" db:"role"`
 	Content        string    `json:"content" db:"content"`
 	AudioURL       *string   `json:"audio_url,omitempty" db:"audio_url"`
+	Model          *string   `json:"model,omitempty" db:"model"`
 	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+
+	Feedback *MessageFeedback `json:"feedback,omitempty"`
+}
+
+// 消息反馈
+const (
+	FeedbackUp   = "up"
+	FeedbackDown = "down"
+)
+
+// 负面反馈的原因
+const (
+	FeedbackReasonOutOfCharacter = "out_of_character"
+	FeedbackReasonFactualError   = "factual_error"
+	FeedbackReasonTooLong        = "too_long"
+	FeedbackReasonUnsafe         = "unsafe"
+	FeedbackReasonOther          = "other"
+)
+
+// MessageFeedback 用户对AI消息的反馈
+type MessageFeedback struct {
+	MessageID int       `json:"message_id" db:"message_id"`
+	Rating    string    `json:"rating" db:"rating"`
+	Reason    string    `json:"reason,omitempty" db:"reason"`
+	Comment   string    `json:"comment,omitempty" db:"comment"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// MessageFeedbackRequest 提交反馈请求，原因只用于负面反馈
+type MessageFeedbackRequest struct {
+	Rating  string `json:"rating" binding:"required,oneof=up down"`
+	Reason  string `json:"reason" binding:"omitempty,oneof=out_of_character factual_error too_long unsafe other"`
+	Comment string `json:"comment" binding:"max=2000"`
+}
+
+// FeedbackFilter 反馈统计和导出的筛选条件，时间为反馈的更新时间
+type FeedbackFilter struct {
+	CharacterID int
+	From        *time.Time
+	To          *time.Time
+}
+
+// FeedbackReportRow 按角色和模型汇总的反馈
+type FeedbackReportRow struct {
+	CharacterID   int            `json:"character_id"`
+	CharacterName string         `json:"character_name"`
+	Model         string         `json:"model"`
+	Total         int            `json:"total"`
+	Up            int            `json:"up"`
+	Down          int            `json:"down"`
+	UpRate        float64        `json:"up_rate"`
+	Reasons       map[string]int `json:"reasons"`
+}
+
+// MessagePageQuery 消息分页查询，Before和After为消息ID游标，都为空时返回最新的一页
+type MessagePageQuery struct {
+	Before int
+	After  int
+	Limit  int
+}
+
+// MessagePage 一页消息，按时间正序排列。HasMore表示查询方向上是否还有更多消息，
+// NextCursor为继续加载时传入同一参数（before或after）的消息ID
+type MessagePage struct {
+	Messages   []*Message `json:"messages"`
+	HasMore    bool       `json:"has_more"`
+	NextCursor *int       `json:"next_cursor,omitempty"`
+}
+
+// MessageSearchQuery 消息搜索条件
+type MessageSearchQuery struct {
+	Query          string
+	CharacterID    int
+	ConversationID int
+	Page           int
+	Limit          int
+}
+
+// MessageSearchHit 消息搜索结果，Snippet为HTML转义后的片段，匹配的关键词用<mark>标出
+type MessageSearchHit struct {
+	MessageID         int       `json:"message_id"`
+	ConversationID    int       `json:"conversation_id"`
+	ConversationTitle string    `json:"conversation_title"`
+	CharacterID       int       `json:"character_id"`
+	CharacterName     string    `json:"character_name"`
+	Role              string    `json:"role"`
+	Snippet           string    `json:"snippet"`
+	Score             float64   `json:"score"`
+	CreatedAt         time.Time `json:"created_at"`
+	Content           string    `json:"-"`
 }
 
 // CreateConversationRequest 创建对话请求
@@ -72,3 +595,26 @@ type SendMessageRequest struct {
 type AIResponse struct {
 	Content string `json:"content"`
 }
+
+// 媒体文件类型
+const (
+	MediaKindAvatar = "avatar" // 角色头像，公开访问
+	MediaKindAudio  = "audio"  // 语音消息和合成语音，需签名URL访问
+)
+
+// MediaBlob 已上传的媒体文件，URL为保存到数据库中的稳定引用，
+// 音频需要通过SignedURL这样的临时签名地址下载
+type MediaBlob struct {
+	Key         string    `json:"key"`
+	URL         string    `json:"url"`
+	SignedURL   string    `json:"signed_url,omitempty"`
+	Kind        string    `json:"kind"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// SignMediaRequest 为媒体引用生成临时下载地址
+type SignMediaRequest struct {
+	URL string `json:"url" binding:"required"`
+}
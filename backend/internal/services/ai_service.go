@@ -52,6 +52,11 @@ func NewAIService(cfg *config.Config) *AIService {
 	}
 }
 
+// Model 当前使用的模型名称，随AI消息一起保存
+func (s *AIService) Model() string {
+	return s.model
+}
+
 func (s *AIService) GenerateResponse(character *models.Character, messages []*models.Message) (string, error) {
 	// 生成缓存键
 	cacheKey := s.generateCacheKey(character, messages)
@@ -78,6 +83,19 @@ func (s *AIService) GenerateResponse(character *models.Character, messages []*mo
 		})
 	}
 
+	content, err := s.complete(ollamaMessages)
+	if err != nil {
+		return "", err
+	}
+
+	// 缓存响应
+	database.SetCache(cacheKey, content, database.AICacheExpiry)
+
+	return content, nil
+}
+
+// complete 发送非流式请求，返回模型的完整回复
+func (s *AIService) complete(ollamaMessages []Message) (string, error) {
 	// 构建请求
 	request := OllamaRequest{
 		Model:    s.model,
@@ -112,14 +130,72 @@ func (s *AIService) GenerateResponse(character *models.Character, messages []*mo
 		return "", fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	// 缓存响应
-	database.SetCache(cacheKey, ollamaResp.Message.Content, database.AICacheExpiry)
-
 	return ollamaResp.Message.Content, nil
 }
 
-// GenerateStreamingResponse 生成流式响应
-func (s *AIService) GenerateStreamingResponse(character *models.Character, messages []*models.Message, writer io.Writer) (string, error) {
+// 标题生成
+const (
+	titlePrompt         = "你是对话标题生成器。请根据下面的对话内容，用不超过15个字概括对话主题。只输出标题本身，不要加引号、书名号、句末标点或任何解释。"
+	maxTitleLength      = 30  // 生成的标题最多保留的字符数
+	titleMessageExcerpt = 500 // 每条消息最多提供给模型的字符数
+)
+
+// GenerateTitle 根据对话开头的几条消息生成简短标题
+func (s *AIService) GenerateTitle(character *models.Character, messages []*models.Message) (string, error) {
+	var transcript strings.Builder
+	for _, msg := range messages {
+		speaker := "用户"
+		if msg.Role == "assistant" {
+			speaker = character.Name
+		}
+		content := []rune(msg.Content)
+		if len(content) > titleMessageExcerpt {
+			content = append(content[:titleMessageExcerpt], '…')
+		}
+		fmt.Fprintf(&transcript, "%s：%s\n", speaker, string(content))
+	}
+
+	content, err := s.complete([]Message{
+		{Role: "system", Content: titlePrompt},
+		{Role: "user", Content: transcript.String()},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	title := cleanTitle(content)
+	if title == "" {
+		return "", fmt.Errorf("model returned an empty title")
+	}
+	return title, nil
+}
+
+// cleanTitle 取模型输出的第一行，去掉常见的前缀、引号和句末标点
+func cleanTitle(content string) string {
+	title := strings.TrimSpace(content)
+	// 部分模型会先输出思考过程
+	if i := strings.LastIndex(title, "</think>"); i >= 0 {
+		title = strings.TrimSpace(title[i+len("</think>"):])
+	}
+	if i := strings.IndexAny(title, "\r\n"); i >= 0 {
+		title = title[:i]
+	}
+	for _, prefix := range []string{"标题：", "标题:", "Title:", "title:"} {
+		title = strings.TrimPrefix(title, prefix)
+	}
+	const quotes, punctuation = " \t\"'“”‘’「」『』《》*#", "。！？.!?，,；;"
+	title = strings.Trim(strings.TrimRight(title, punctuation), quotes)
+	title = strings.TrimRight(title, punctuation)
+
+	runes := []rune(title)
+	if len(runes) > maxTitleLength {
+		title = string(runes[:maxTitleLength])
+	}
+	return strings.TrimSpace(title)
+}
+
+// GenerateStreamingResponse 生成流式响应，onContent不为空时每收到一段内容调用一次
+func (s *AIService) GenerateStreamingResponse(character *models.Character, messages []*models.Message, writer io.Writer, onContent func(string)) (string, error) {
 	// 构建消息历史
 	ollamaMessages := []Message{
 		{
@@ -185,6 +261,9 @@ func (s *AIService) GenerateStreamingResponse(character *models.Character, messa
 		if streamResp.Message.Content != "" {
 			// 累积完整响应
 			fullResponse.WriteString(streamResp.Message.Content)
+			if onContent != nil {
+				onContent(streamResp.Message.Content)
+			}
 
 			// 创建AI消息对象（第一次时创建，后续更新）
 			if aiMessageID == 0 {
@@ -0,0 +1,242 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"role-play-ai/internal/models"
+)
+
+const (
+	// APIKeyPrefix 所有API密钥的固定前缀，便于识别和密钥扫描
+	APIKeyPrefix = "rpa_"
+
+	maxAPIKeysPerUser       = 20
+	apiKeyLastUsedPrecision = time.Minute
+)
+
+type APIKeyService struct {
+	db *sql.DB
+}
+
+func NewAPIKeyService(db *sql.DB) *APIKeyService {
+	return &APIKeyService{db: db}
+}
+
+// IsAPIKey 判断凭据是否为API密钥格式
+func IsAPIKey(credential string) bool {
+	return strings.HasPrefix(credential, APIKeyPrefix)
+}
+
+// CreateAPIKey 创建API密钥，明文只在创建时返回一次
+func (s *APIKeyService) CreateAPIKey(userID int, req *models.CreateAPIKeyRequest) (*models.APIKey, string, error) {
+	scopes, err := normalizeScopes(req.Scopes)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var count int
+	err = s.db.QueryRow(
+		"SELECT COUNT(*) FROM api_keys WHERE user_id = ? AND revoked_at IS NULL",
+		userID,
+	).Scan(&count)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to count API keys: %w", err)
+	}
+	if count >= maxAPIKeysPerUser {
+		return nil, "", fmt.Errorf("too many API keys, revoke unused keys first")
+	}
+
+	prefixBytes := make([]byte, 4)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return nil, "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	prefix := hex.EncodeToString(prefixBytes)
+	secret, err := generateSecureToken(32)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	plaintext := APIKeyPrefix + prefix + "_" + secret
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays != nil {
+		t := time.Now().Add(time.Duration(*req.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+
+	result, err := s.db.Exec(
+		"INSERT INTO api_keys (user_id, name, key_prefix, key_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, req.Name, prefix, hashToken(plaintext), strings.Join(scopes, ","), expiresAt,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create API key: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get API key ID: %w", err)
+	}
+
+	key, err := s.getAPIKey(int(id), userID)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get created API key: %w", err)
+	}
+
+	return key, plaintext, nil
+}
+
+// ListAPIKeys 获取用户未撤销的API密钥
+func (s *APIKeyService) ListAPIKeys(userID int) ([]*models.APIKey, error) {
+	rows, err := s.db.Query(`
+		SELECT id, user_id, name, key_prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_keys
+		WHERE user_id = ? AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query API keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := []*models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan API key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey 撤销API密钥
+func (s *APIKeyService) RevokeAPIKey(id, userID int) error {
+	result, err := s.db.Exec(
+		"UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		id, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to revoke API key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("API key not found")
+	}
+
+	return nil
+}
+
+// Authenticate 校验API密钥，返回密钥信息（含用户ID和权限范围）
+func (s *APIKeyService) Authenticate(plaintext string) (*models.APIKey, error) {
+	rest := strings.TrimPrefix(plaintext, APIKeyPrefix)
+	prefix, _, found := strings.Cut(rest, "_")
+	if rest == plaintext || !found {
+		return nil, fmt.Errorf("invalid API key")
+	}
+
+	var keyHash string
+	var revokedAt, deletionScheduledAt sql.NullTime
+	key, err := scanAPIKey(s.db.QueryRow(`
+		SELECT k.id, k.user_id, k.name, k.key_prefix, k.scopes, k.expires_at, k.last_used_at, k.created_at,
+			k.key_hash, k.revoked_at, u.deletion_scheduled_at
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.key_prefix = ?
+	`, prefix), &keyHash, &revokedAt, &deletionScheduledAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("invalid API key")
+		}
+		return nil, fmt.Errorf("failed to get API key: %w", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(plaintext)), []byte(keyHash)) != 1 {
+		return nil, fmt.Errorf("invalid API key")
+	}
+	if revokedAt.Valid {
+		return nil, fmt.Errorf("API key revoked")
+	}
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil, fmt.Errorf("API key expired")
+	}
+	// 申请删除账户后的宽限期内密钥停止生效，撤销删除申请后自动恢复
+	if deletionScheduledAt.Valid {
+		return nil, fmt.Errorf("account pending deletion")
+	}
+
+	// 降低写入频率，最后使用时间精确到分钟即可
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) >= apiKeyLastUsedPrecision {
+		s.db.Exec("UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", key.ID)
+	}
+
+	return key, nil
+}
+
+func (s *APIKeyService) getAPIKey(id, userID int) (*models.APIKey, error) {
+	key, err := scanAPIKey(s.db.QueryRow(`
+		SELECT id, user_id, name, key_prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_keys
+		WHERE id = ? AND user_id = ?
+	`, id, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("API key not found")
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+func scanAPIKey(row rowScanner, extra ...interface{}) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	dest := append([]interface{}{
+		&key.ID, &key.UserID, &key.Name, &key.Prefix, &scopes, &expiresAt, &lastUsedAt, &key.CreatedAt,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	key.Scopes = strings.Split(scopes, ",")
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	return key, nil
+}
+
+// normalizeScopes 校验并去重权限范围
+func normalizeScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool, len(scopes))
+	normalized := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		valid := false
+		for _, allowed := range models.APIKeyScopes {
+			if scope == allowed {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid scope: %s", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			normalized = append(normalized, scope)
+		}
+	}
+	return normalized, nil
+}
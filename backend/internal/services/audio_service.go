@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"role-play-ai/internal/transcriber"
+)
+
+// MaxAudioFileSize 上传语音的最大字节数
+const MaxAudioFileSize = 25 << 20
+
+// AudioService 将用户上传的语音转写为文字，音频保存到媒体存储
+type AudioService struct {
+	transcriber  transcriber.Transcriber
+	mediaService *MediaService
+}
+
+func NewAudioService(t transcriber.Transcriber, mediaService *MediaService) *AudioService {
+	return &AudioService{transcriber: t, mediaService: mediaService}
+}
+
+// TranscribeUpload 转写上传的语音并保存音频文件，返回转写文本和音频引用。
+// 转写失败或没有识别到内容时不保存音频
+func (s *AudioService) TranscribeUpload(ctx context.Context, userID int, data []byte, language string) (string, string, error) {
+	if s.transcriber == nil {
+		return "", "", fmt.Errorf("speech-to-text is not configured")
+	}
+	if len(data) == 0 {
+		return "", "", fmt.Errorf("audio file is empty")
+	}
+	// 按文件内容识别格式，不信任上传的文件名
+	ext, _ := sniffAudio(data)
+	if ext == "" {
+		return "", "", fmt.Errorf("unsupported audio format")
+	}
+
+	transcript, err := s.transcriber.Transcribe(ctx, &transcriber.Audio{
+		Filename: "audio" + ext,
+		Data:     data,
+		Language: language,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to transcribe audio: %w", err)
+	}
+	if transcript == "" {
+		return "", "", fmt.Errorf("no speech detected")
+	}
+
+	blob, err := s.mediaService.UploadAudio(ctx, userID, data)
+	if err != nil {
+		return "", "", err
+	}
+	return transcript, blob.URL, nil
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"role-play-ai/internal/config"
+	"role-play-ai/internal/storage"
+	"role-play-ai/internal/transcriber"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// testWAV 最小的WAV文件头，足以按内容识别格式
+var testWAV = append([]byte("RIFF\x24\x00\x00\x00WAVEfmt "), make([]byte, 32)...)
+
+// errorTranscriber 模拟转写服务出错
+type errorTranscriber struct{}
+
+func (errorTranscriber) Transcribe(ctx context.Context, audio *transcriber.Audio) (string, error) {
+	return "", errors.New("upstream unavailable")
+}
+
+func newTestAudioService(t *testing.T, tr transcriber.Transcriber) (*AudioService, sqlmock.Sqlmock, string) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	dir := t.TempDir()
+	mediaService := NewMediaService(db, storage.NewLocalStore(dir), &config.Config{})
+	return NewAudioService(tr, mediaService), mock, dir
+}
+
+func TestTranscribeUpload(t *testing.T) {
+	service, mock, dir := newTestAudioService(t, transcriber.NewFakeTranscriber("你好，世界"))
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO media_blobs")).
+		WithArgs(sqlmock.AnyArg(), 7, "audio", "audio/wav", len(testWAV)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	transcript, audioURL, err := service.TranscribeUpload(context.Background(), 7, testWAV, "zh")
+	if err != nil {
+		t.Fatalf("TranscribeUpload: %v", err)
+	}
+	if transcript != "你好，世界" {
+		t.Fatalf("unexpected transcript %q", transcript)
+	}
+	key := mediaKey(audioURL)
+	if !strings.HasPrefix(key, audioKeyPrefix) || !strings.HasSuffix(key, ".wav") {
+		t.Fatalf("unexpected audio url %q", audioURL)
+	}
+	if _, err := os.Stat(filepath.Join(dir, key)); err != nil {
+		t.Fatalf("expected audio to be stored: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTranscribeUploadErrors(t *testing.T) {
+	tests := []struct {
+		name        string
+		transcriber transcriber.Transcriber
+		data        []byte
+		wantErr     string
+	}{
+		{"not configured", nil, testWAV, "speech-to-text is not configured"},
+		{"empty audio", transcriber.NewFakeTranscriber(""), nil, "audio file is empty"},
+		{"unsupported format", transcriber.NewFakeTranscriber(""), []byte("not audio"), "unsupported audio format"},
+		{"transcriber error", errorTranscriber{}, testWAV, "failed to transcribe audio: upstream unavailable"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, mock, dir := newTestAudioService(t, tt.transcriber)
+
+			_, _, err := service.TranscribeUpload(context.Background(), 7, tt.data, "")
+			if err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("expected %q, got %v", tt.wantErr, err)
+			}
+			// 失败时不保存音频
+			if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+				t.Fatalf("expected no stored audio, found %d entries", len(entries))
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
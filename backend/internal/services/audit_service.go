@@ -0,0 +1,175 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"role-play-ai/internal/models"
+)
+
+const (
+	auditUserAgentMaxLength = 255
+	auditExportMaxRows      = 100000
+)
+
+// AuditService 审计日志，只提供写入和查询，不提供修改和删除
+type AuditService struct {
+	db *sql.DB
+}
+
+func NewAuditService(db *sql.DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// Record 写入审计事件。写入失败只记录日志，不影响业务操作
+func (s *AuditService) Record(actor *models.AuditActor, action, targetType, targetID string, metadata map[string]interface{}) {
+	if actor == nil {
+		actor = &models.AuditActor{}
+	}
+
+	var actorUserID, actorAPIKeyID interface{}
+	if actor.UserID != 0 {
+		actorUserID = actor.UserID
+	}
+	if actor.APIKeyID != 0 {
+		actorAPIKeyID = actor.APIKeyID
+	}
+
+	var data interface{}
+	if len(metadata) > 0 {
+		encoded, err := json.Marshal(metadata)
+		if err != nil {
+			log.Printf("Failed to encode audit metadata for %s: %v", action, err)
+		} else {
+			data = string(encoded)
+		}
+	}
+
+	userAgent := actor.UserAgent
+	if len(userAgent) > auditUserAgentMaxLength {
+		userAgent = userAgent[:auditUserAgentMaxLength]
+	}
+
+	_, err := s.db.Exec(
+		"INSERT INTO audit_events (actor_user_id, actor_api_key_id, ip, user_agent, action, target_type, target_id, metadata) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		actorUserID, actorAPIKeyID, actor.IP, userAgent, action, targetType, targetID, data,
+	)
+	if err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
+
+// ListEvents 按条件分页查询审计事件（最新的在前）
+func (s *AuditService) ListEvents(filter *models.AuditEventFilter) ([]*models.AuditEvent, int, error) {
+	where, args := auditEventConditions(filter)
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM audit_events"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count audit events: %w", err)
+	}
+
+	events := []*models.AuditEvent{}
+	err := s.queryEvents(where+" ORDER BY id DESC LIMIT ? OFFSET ?", append(args, filter.Limit, (filter.Page-1)*filter.Limit), func(event *models.AuditEvent) error {
+		events = append(events, event)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+// ExportEvents 按条件逐行导出审计事件，避免一次性加载到内存
+func (s *AuditService) ExportEvents(filter *models.AuditEventFilter, fn func(*models.AuditEvent) error) error {
+	where, args := auditEventConditions(filter)
+	return s.queryEvents(where+" ORDER BY id DESC LIMIT ?", append(args, auditExportMaxRows), fn)
+}
+
+func (s *AuditService) queryEvents(clause string, args []interface{}, fn func(*models.AuditEvent) error) error {
+	rows, err := s.db.Query(`
+		SELECT id, actor_user_id, actor_api_key_id, ip, user_agent, action, target_type, target_id, metadata, created_at
+		FROM audit_events`+clause, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query audit events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		event := &models.AuditEvent{}
+		var actorUserID, actorAPIKeyID sql.NullInt64
+		var metadata sql.NullString
+		err := rows.Scan(
+			&event.ID, &actorUserID, &actorAPIKeyID, &event.IP, &event.UserAgent,
+			&event.Action, &event.TargetType, &event.TargetID, &metadata, &event.CreatedAt,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to scan audit event: %w", err)
+		}
+		if actorUserID.Valid {
+			id := int(actorUserID.Int64)
+			event.ActorUserID = &id
+		}
+		if actorAPIKeyID.Valid {
+			id := int(actorAPIKeyID.Int64)
+			event.ActorAPIKeyID = &id
+		}
+		if metadata.Valid {
+			event.Metadata = json.RawMessage(metadata.String)
+		}
+
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func auditEventConditions(filter *models.AuditEventFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+
+	if filter.ActorUserID != 0 {
+		conditions = append(conditions, "actor_user_id = ?")
+		args = append(args, filter.ActorUserID)
+	}
+	if filter.Action != "" {
+		// 以"."结尾时按前缀匹配，例如 "auth." 匹配所有认证事件
+		if strings.HasSuffix(filter.Action, ".") {
+			conditions = append(conditions, "action LIKE ?")
+			args = append(args, filter.Action+"%")
+		} else {
+			conditions = append(conditions, "action = ?")
+			args = append(args, filter.Action)
+		}
+	}
+	if filter.TargetType != "" {
+		conditions = append(conditions, "target_type = ?")
+		args = append(args, filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		conditions = append(conditions, "target_id = ?")
+		args = append(args, filter.TargetID)
+	}
+	if filter.IP != "" {
+		conditions = append(conditions, "ip = ?")
+		args = append(args, filter.IP)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, *filter.To)
+	}
+
+	if len(conditions) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
@@ -1,73 +1,400 @@
 package services
 
 import (
+	"crypto/sha1"
 	"database/sql"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"role-play-ai/internal/database"
 	"role-play-ai/internal/models"
 )
 
+const characterColumns = `ch.id, ch.name, ch.description, ch.avatar_url, ch.system_prompt, ch.category, ch.is_published,
+	ch.voice, ch.voice_speed, ch.created_at, ch.updated_at,
+	COALESCE(cs.conversation_count, 0), COALESCE(cs.message_count, 0), COALESCE(cs.favorite_count, 0),
+	COALESCE(cs.rating_count, 0), COALESCE(cs.average_rating, 0)`
+
+// characterTables 角色及其统计，查询角色时使用 "SELECT characterColumns FROM characterTables"
+const characterTables = "characters ch LEFT JOIN character_stats cs ON cs.character_id = ch.id"
+
 type CharacterService struct {
-	db *sql.DB
+	db           *sql.DB
+	auditService *AuditService
+}
+
+func NewCharacterService(db *sql.DB, auditService *AuditService) *CharacterService {
+	return &CharacterService{db: db, auditService: auditService}
 }
 
-func NewCharacterService(db *sql.DB) *CharacterService {
-	return &CharacterService{db: db}
+func scanCharacter(row rowScanner, extra ...interface{}) (*models.Character, error) {
+	character := &models.Character{}
+	var description, avatarURL, category, voice sql.NullString
+	dest := append([]interface{}{
+		&character.ID, &character.Name, &description, &avatarURL, &character.SystemPrompt,
+		&category, &character.IsPublished, &voice, &character.VoiceSpeed, &character.CreatedAt, &character.UpdatedAt,
+		&character.ConversationCount, &character.MessageCount, &character.FavoriteCount,
+		&character.RatingCount, &character.AverageRating,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	character.Description = description.String
+	character.AvatarURL = avatarURL.String
+	character.Category = category.String
+	character.Voice = voice.String
+	return character, nil
 }
 
-func (s *CharacterService) GetCharacters() ([]*models.Character, error) {
+// 角色发现的排序方式
+const (
+	CharacterSortRelevance = "relevance" // 相关度，仅在有搜索词时有效
+	CharacterSortPopular   = "popular"   // 对话数，其次消息数
+	CharacterSortRating    = "rating"    // 平均评分（按评分人数加权）
+	CharacterSortFavorites = "favorites" // 收藏数
+	CharacterSortRecent    = "recent"    // 创建时间
+	CharacterSortName      = "name"
+)
+
+// weightedRating 贝叶斯平均：相当于每个角色预先有5个3分评价，避免评分人数很少的角色排在前面
+const weightedRating = "(COALESCE(cs.average_rating, 0) * COALESCE(cs.rating_count, 0) + 3 * 5) / (COALESCE(cs.rating_count, 0) + 5)"
+
+// characterListGenerationKey 角色列表缓存的版本号，版本号是列表缓存键的一部分
+var characterListGenerationKey = (&database.CacheKey{Prefix: database.CharacterCachePrefix, ID: "list_generation"}).String()
+
+// characterListCacheKey 按查询条件和当前版本号生成角色列表缓存键
+func characterListCacheKey(query *models.CharacterQuery) string {
+	generation, err := database.GetCache(characterListGenerationKey)
+	if err != nil {
+		generation = "0"
+	}
+	data, _ := json.Marshal(query)
+	sum := sha1.Sum(data)
+	key := &database.CacheKey{Prefix: database.CharacterCachePrefix, ID: "list:" + generation + ":" + hex.EncodeToString(sum[:])}
+	return key.String()
+}
+
+// invalidateCharacterListCache 递增版本号使所有角色列表缓存失效，旧版本的缓存不再被读取，到期后自然清除。
+// 不按模式删除，避免频繁执行阻塞Redis的KEYS扫描
+func invalidateCharacterListCache() {
+	if _, err := database.IncrementCache(characterListGenerationKey, database.CharacterCacheExpiry); err != nil {
+		log.Printf("Failed to invalidate character list cache: %v", err)
+	}
+}
+
+// characterFilter 构造已发布角色的筛选条件，skipCategory为true时忽略分类条件（用于分类分面统计）
+func characterFilter(query *models.CharacterQuery, skipCategory bool) (string, []interface{}) {
+	conditions := []string{"ch.is_published = TRUE"}
+	args := []interface{}{}
+
+	if query.Query != "" {
+		if utf8.RuneCountInString(query.Query) >= ngramTokenSize {
+			conditions = append(conditions, `(MATCH(ch.name, ch.description, ch.category) AGAINST (? IN NATURAL LANGUAGE MODE)
+				OR EXISTS (SELECT 1 FROM character_tags ct JOIN tags t ON t.id = ct.tag_id WHERE ct.character_id = ch.id AND t.name = ?))`)
+			args = append(args, query.Query, query.Query)
+		} else {
+			// 单个字符无法通过ngram全文索引匹配
+			like := "%" + escapeLike(query.Query) + "%"
+			conditions = append(conditions, "(ch.name LIKE ? OR ch.description LIKE ? OR ch.category LIKE ?)")
+			args = append(args, like, like, like)
+		}
+	}
+	if query.Category != "" && !skipCategory {
+		conditions = append(conditions, "ch.category = ?")
+		args = append(args, query.Category)
+	}
+	for _, tag := range query.Tags {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM character_tags ct JOIN tags t ON t.id = ct.tag_id WHERE ct.character_id = ch.id AND t.name = ?)")
+		args = append(args, tag)
+	}
+
+	return strings.Join(conditions, " AND "), args
+}
+
+// DiscoverCharacters 按搜索词、分类和标签查询已发布的角色，支持排序、分页和分面统计
+func (s *CharacterService) DiscoverCharacters(query *models.CharacterQuery) (*models.CharacterList, error) {
+	if query.Sort == "" {
+		query.Sort = CharacterSortPopular
+		if query.Query != "" {
+			query.Sort = CharacterSortRelevance
+		}
+	}
+	if query.Sort == CharacterSortRelevance && query.Query == "" {
+		query.Sort = CharacterSortPopular
+	}
+
 	// 尝试从Redis缓存获取
-	cacheKey := &database.CacheKey{Prefix: database.CharacterCachePrefix, ID: "all"}
-	cached, err := database.GetCache(cacheKey.String())
+	cacheKey := characterListCacheKey(query)
+	cached, err := database.GetCache(cacheKey)
 	if err == nil && cached != "" {
-		var characters []*models.Character
-		if json.Unmarshal([]byte(cached), &characters) == nil {
-			return characters, nil
+		var list models.CharacterList
+		if json.Unmarshal([]byte(cached), &list) == nil {
+			return &list, nil
 		}
 	}
 
-	// 从数据库查询
+	where, args := characterFilter(query, false)
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM characters ch WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, fmt.Errorf("failed to count characters: %w", err)
+	}
+
+	// 相关度：名称完全匹配和前缀匹配优先，其次是全文匹配得分
+	scoreExpr := "0"
+	scoreArgs := []interface{}{}
+	if query.Query != "" {
+		scoreExpr = "(ch.name = ?) * 100 + (ch.name LIKE ?) * 10"
+		scoreArgs = append(scoreArgs, query.Query, escapeLike(query.Query)+"%")
+		if utf8.RuneCountInString(query.Query) >= ngramTokenSize {
+			scoreExpr += " + MATCH(ch.name, ch.description, ch.category) AGAINST (? IN NATURAL LANGUAGE MODE)"
+			scoreArgs = append(scoreArgs, query.Query)
+		}
+	}
+
+	var orderBy string
+	switch query.Sort {
+	case CharacterSortRelevance:
+		orderBy = "score DESC, COALESCE(cs.conversation_count, 0) DESC, ch.id"
+	case CharacterSortRating:
+		orderBy = weightedRating + " DESC, COALESCE(cs.rating_count, 0) DESC, ch.id"
+	case CharacterSortFavorites:
+		orderBy = "COALESCE(cs.favorite_count, 0) DESC, ch.id"
+	case CharacterSortRecent:
+		orderBy = "ch.created_at DESC, ch.id DESC"
+	case CharacterSortName:
+		orderBy = "ch.name, ch.id"
+	default:
+		orderBy = "COALESCE(cs.conversation_count, 0) DESC, COALESCE(cs.message_count, 0) DESC, ch.id"
+	}
+
+	queryArgs := append(append(scoreArgs, args...), query.Limit, (query.Page-1)*query.Limit)
 	rows, err := s.db.Query(`
-		SELECT id, name, description, avatar_url, system_prompt, category, created_at, updated_at 
-		FROM characters 
-		ORDER BY name
-	`)
+		SELECT `+characterColumns+`, `+scoreExpr+` AS score
+		FROM `+characterTables+`
+		WHERE `+where+`
+		ORDER BY `+orderBy+`
+		LIMIT ? OFFSET ?
+	`, queryArgs...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query characters: %w", err)
 	}
 	defer rows.Close()
 
-	var characters []*models.Character
+	characters := []*models.Character{}
 	for rows.Next() {
-		character := &models.Character{}
-		err := rows.Scan(
-			&character.ID,
-			&character.Name,
-			&character.Description,
-			&character.AvatarURL,
-			&character.SystemPrompt,
-			&character.Category,
-			&character.CreatedAt,
-			&character.UpdatedAt,
+		var score float64
+		character, err := scanCharacter(rows, &score)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan character: %w", err)
+		}
+		characters = append(characters, character)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query characters: %w", err)
+	}
+
+	if err := s.loadTags(characters); err != nil {
+		return nil, err
+	}
+
+	facets, err := s.characterFacets(query)
+	if err != nil {
+		return nil, err
+	}
+
+	list := &models.CharacterList{
+		Characters: characters,
+		Total:      total,
+		Page:       query.Page,
+		Limit:      query.Limit,
+		Sort:       query.Sort,
+		Facets:     facets,
+	}
+
+	// 缓存到Redis，列表包含对话数排序，缓存时间较短
+	if data, err := json.Marshal(list); err == nil {
+		database.SetCache(cacheKey, string(data), database.CharacterListCacheExpiry)
+	}
+
+	return list, nil
+}
+
+// characterFacets 统计当前条件下各分类和标签的角色数
+func (s *CharacterService) characterFacets(query *models.CharacterQuery) (*models.CharacterFacets, error) {
+	facets := &models.CharacterFacets{Categories: []*models.CharacterFacet{}, Tags: []*models.CharacterFacet{}}
+
+	where, args := characterFilter(query, true)
+	rows, err := s.db.Query(`
+		SELECT ch.category, COUNT(*)
+		FROM characters ch
+		WHERE `+where+` AND ch.category IS NOT NULL AND ch.category != ''
+		GROUP BY ch.category
+		ORDER BY COUNT(*) DESC, ch.category
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query category facets: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		facet := &models.CharacterFacet{}
+		if err := rows.Scan(&facet.Value, &facet.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan category facet: %w", err)
+		}
+		facets.Categories = append(facets.Categories, facet)
+	}
+
+	where, args = characterFilter(query, false)
+	tagRows, err := s.db.Query(`
+		SELECT t.name, COUNT(*)
+		FROM character_tags ctf
+		JOIN tags t ON t.id = ctf.tag_id
+		JOIN characters ch ON ch.id = ctf.character_id
+		WHERE `+where+`
+		GROUP BY t.id, t.name
+		ORDER BY COUNT(*) DESC, t.name
+		LIMIT 30
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query tag facets: %w", err)
+	}
+	defer tagRows.Close()
+	for tagRows.Next() {
+		facet := &models.CharacterFacet{}
+		if err := tagRows.Scan(&facet.Value, &facet.Count); err != nil {
+			return nil, fmt.Errorf("failed to scan tag facet: %w", err)
+		}
+		facets.Tags = append(facets.Tags, facet)
+	}
+
+	return facets, nil
+}
+
+// loadTags 批量填充角色的标签
+func (s *CharacterService) loadTags(characters []*models.Character) error {
+	if len(characters) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*models.Character, len(characters))
+	placeholders := make([]string, len(characters))
+	args := make([]interface{}, len(characters))
+	for i, character := range characters {
+		character.Tags = []string{}
+		byID[character.ID] = character
+		placeholders[i] = "?"
+		args[i] = character.ID
+	}
+
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT ct.character_id, t.name
+		FROM character_tags ct
+		JOIN tags t ON t.id = ct.tag_id
+		WHERE ct.character_id IN (%s)
+		ORDER BY t.name
+	`, strings.Join(placeholders, ",")), args...)
+	if err != nil {
+		return fmt.Errorf("failed to query character tags: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var characterID int
+		var tag string
+		if err := rows.Scan(&characterID, &tag); err != nil {
+			return fmt.Errorf("failed to scan character tag: %w", err)
+		}
+		if character, ok := byID[characterID]; ok {
+			character.Tags = append(character.Tags, tag)
+		}
+	}
+
+	return rows.Err()
+}
+
+// normalizeTags 去掉首尾空白并去重（不区分大小写），保持原有顺序
+func normalizeTags(tags []string) []string {
+	seen := map[string]bool{}
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		key := strings.ToLower(tag)
+		if tag == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// setTags 替换角色的全部标签，不存在的标签自动创建
+func setTags(tx *sql.Tx, characterID int, tags []string) error {
+	if _, err := tx.Exec("DELETE FROM character_tags WHERE character_id = ?", characterID); err != nil {
+		return fmt.Errorf("failed to clear character tags: %w", err)
+	}
+
+	for _, tag := range normalizeTags(tags) {
+		if _, err := tx.Exec("INSERT IGNORE INTO tags (name) VALUES (?)", tag); err != nil {
+			return fmt.Errorf("failed to create tag: %w", err)
+		}
+		_, err := tx.Exec(
+			"INSERT IGNORE INTO character_tags (character_id, tag_id) SELECT ?, id FROM tags WHERE name = ?",
+			characterID, tag,
 		)
+		if err != nil {
+			return fmt.Errorf("failed to add character tag: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ListAllCharacters 获取全部公共角色（包括未发布的），供管理接口使用。
+// 用户导入对话时创建的私有角色不在管理范围内
+func (s *CharacterService) ListAllCharacters() ([]*models.Character, error) {
+	rows, err := s.db.Query("SELECT " + characterColumns + " FROM " + characterTables + " WHERE ch.owner_id IS NULL ORDER BY ch.id")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query characters: %w", err)
+	}
+	defer rows.Close()
+
+	characters := []*models.Character{}
+	for rows.Next() {
+		character, err := scanCharacter(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan character: %w", err)
 		}
 		characters = append(characters, character)
 	}
 
-	// 缓存到Redis
-	if data, err := json.Marshal(characters); err == nil {
-		database.SetCache(cacheKey.String(), string(data), database.CharacterCacheExpiry)
+	if err := s.loadTags(characters); err != nil {
+		return nil, err
 	}
 
 	return characters, nil
 }
 
+// GetCharacter 获取已发布的角色
 func (s *CharacterService) GetCharacter(id int) (*models.Character, error) {
+	character, err := s.getCharacter(id)
+	if err != nil {
+		return nil, err
+	}
+	if !character.IsPublished {
+		return nil, fmt.Errorf("character not found")
+	}
+	return character, nil
+}
+
+// getCharacter 获取公共角色（包括未发布的），供管理接口使用，私有角色视为不存在
+func (s *CharacterService) getCharacter(id int) (*models.Character, error) {
 	// 尝试从Redis缓存获取
 	cacheKey := &database.CacheKey{Prefix: database.CharacterCachePrefix, ID: id}
 	cached, err := database.GetCache(cacheKey.String())
@@ -79,21 +406,11 @@ func (s *CharacterService) GetCharacter(id int) (*models.Character, error) {
 	}
 
 	// 从数据库查询
-	character := &models.Character{}
-	err = s.db.QueryRow(`
-		SELECT id, name, description, avatar_url, system_prompt, category, created_at, updated_at 
-		FROM characters 
-		WHERE id = ?
-	`, id).Scan(
-		&character.ID,
-		&character.Name,
-		&character.Description,
-		&character.AvatarURL,
-		&character.SystemPrompt,
-		&character.Category,
-		&character.CreatedAt,
-		&character.UpdatedAt,
-	)
+	character, err := scanCharacter(s.db.QueryRow(`
+		SELECT `+characterColumns+`
+		FROM `+characterTables+`
+		WHERE ch.id = ? AND ch.owner_id IS NULL
+	`, id))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -102,6 +419,10 @@ func (s *CharacterService) GetCharacter(id int) (*models.Character, error) {
 		return nil, fmt.Errorf("failed to get character: %w", err)
 	}
 
+	if err := s.loadTags([]*models.Character{character}); err != nil {
+		return nil, err
+	}
+
 	// 缓存到Redis
 	if data, err := json.Marshal(character); err == nil {
 		database.SetCache(cacheKey.String(), string(data), database.CharacterCacheExpiry)
@@ -110,39 +431,197 @@ func (s *CharacterService) GetCharacter(id int) (*models.Character, error) {
 	return character, nil
 }
 
-func (s *CharacterService) SearchCharacters(query string) ([]*models.Character, error) {
-	searchTerm := "%" + strings.ToLower(query) + "%"
+// CreateCharacter 创建角色
+func (s *CharacterService) CreateCharacter(actor *models.AuditActor, req *models.CharacterRequest) (*models.Character, error) {
+	isPublished := true
+	if req.IsPublished != nil {
+		isPublished = *req.IsPublished
+	}
+	voiceSpeed := 1.0
+	if req.VoiceSpeed != nil {
+		voiceSpeed = *req.VoiceSpeed
+	}
 
-	rows, err := s.db.Query(`
-		SELECT id, name, description, avatar_url, system_prompt, category, created_at, updated_at 
-		FROM characters 
-		WHERE LOWER(name) LIKE ? OR LOWER(description) LIKE ? OR LOWER(category) LIKE ?
-		ORDER BY name
-	`, searchTerm, searchTerm, searchTerm)
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
+	result, err := tx.Exec(
+		"INSERT INTO characters (name, description, avatar_url, system_prompt, category, is_published, voice, voice_speed) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		req.Name, req.Description, req.AvatarURL, req.SystemPrompt, req.Category, isPublished, req.Voice, voiceSpeed,
+	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to search characters: %w", err)
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return nil, fmt.Errorf("character name already exists")
+		}
+		return nil, fmt.Errorf("failed to create character: %w", err)
 	}
-	defer rows.Close()
 
-	var characters []*models.Character
-	for rows.Next() {
-		character := &models.Character{}
-		err := rows.Scan(
-			&character.ID,
-			&character.Name,
-			&character.Description,
-			&character.AvatarURL,
-			&character.SystemPrompt,
-			&character.Category,
-			&character.CreatedAt,
-			&character.UpdatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan character: %w", err)
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get character ID: %w", err)
+	}
+
+	if err := setTags(tx, int(id), req.Tags); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.invalidateCache(int(id))
+	s.auditService.Record(actor, models.AuditCharacterCreate, "character", strconv.Itoa(int(id)), map[string]interface{}{
+		"name":         req.Name,
+		"is_published": isPublished,
+		"tags":         normalizeTags(req.Tags),
+	})
+	return s.getCharacter(int(id))
+}
+
+// UpdateCharacter 更新角色
+func (s *CharacterService) UpdateCharacter(actor *models.AuditActor, id int, req *models.CharacterRequest) (*models.Character, error) {
+	existing, err := s.getCharacter(id)
+	if err != nil {
+		return nil, err
+	}
+
+	isPublished := existing.IsPublished
+	if req.IsPublished != nil {
+		isPublished = *req.IsPublished
+	}
+	voiceSpeed := existing.VoiceSpeed
+	if req.VoiceSpeed != nil {
+		voiceSpeed = *req.VoiceSpeed
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"UPDATE characters SET name = ?, description = ?, avatar_url = ?, system_prompt = ?, category = ?, is_published = ?, voice = ?, voice_speed = ? WHERE id = ?",
+		req.Name, req.Description, req.AvatarURL, req.SystemPrompt, req.Category, isPublished, req.Voice, voiceSpeed, id,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return nil, fmt.Errorf("character name already exists")
 		}
-		characters = append(characters, character)
+		return nil, fmt.Errorf("failed to update character: %w", err)
 	}
 
-	return characters, nil
+	if req.Tags != nil {
+		if err := setTags(tx, id, req.Tags); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	s.invalidateCache(id)
+	s.auditService.Record(actor, models.AuditCharacterUpdate, "character", strconv.Itoa(id), map[string]interface{}{
+		"changed": characterChanges(existing, req, isPublished, voiceSpeed),
+	})
+	return s.getCharacter(id)
+}
+
+// SetCharacterPublished 发布或下架角色，下架后不再出现在列表中，也不能发起新对话
+func (s *CharacterService) SetCharacterPublished(actor *models.AuditActor, id int, published bool) error {
+	result, err := s.db.Exec("UPDATE characters SET is_published = ? WHERE id = ? AND owner_id IS NULL", published, id)
+	if err != nil {
+		return fmt.Errorf("failed to update character: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		if _, err := s.getCharacter(id); err != nil {
+			return err
+		}
+	}
+
+	s.invalidateCache(id)
+	action := models.AuditCharacterUnpublish
+	if published {
+		action = models.AuditCharacterPublish
+	}
+	s.auditService.Record(actor, action, "character", strconv.Itoa(id), nil)
+	return nil
+}
+
+// DeleteCharacter 删除角色，已有对话的角色只能下架，避免级联删除用户的对话
+func (s *CharacterService) DeleteCharacter(actor *models.AuditActor, id int) error {
+	existing, err := s.getCharacter(id)
+	if err != nil {
+		return err
+	}
+
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM conversations WHERE character_id = ?", id).Scan(&count); err != nil {
+		return fmt.Errorf("failed to check conversations: %w", err)
+	}
+	if count > 0 {
+		return fmt.Errorf("character has conversations, unpublish it instead")
+	}
+
+	result, err := s.db.Exec("DELETE FROM characters WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete character: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("character not found")
+	}
+
+	s.invalidateCache(id)
+	s.auditService.Record(actor, models.AuditCharacterDelete, "character", strconv.Itoa(id), map[string]interface{}{
+		"name": existing.Name,
+	})
+	return nil
+}
+
+// characterChanges 返回更新中发生变化的字段名，审计记录不保存完整的系统提示词
+func characterChanges(existing *models.Character, req *models.CharacterRequest, isPublished bool, voiceSpeed float64) []string {
+	changed := []string{}
+	if existing.Name != req.Name {
+		changed = append(changed, "name")
+	}
+	if existing.Description != req.Description {
+		changed = append(changed, "description")
+	}
+	if existing.AvatarURL != req.AvatarURL {
+		changed = append(changed, "avatar_url")
+	}
+	if existing.SystemPrompt != req.SystemPrompt {
+		changed = append(changed, "system_prompt")
+	}
+	if existing.Category != req.Category {
+		changed = append(changed, "category")
+	}
+	if existing.IsPublished != isPublished {
+		changed = append(changed, "is_published")
+	}
+	if existing.Voice != req.Voice || existing.VoiceSpeed != voiceSpeed {
+		changed = append(changed, "voice")
+	}
+	if req.Tags != nil && strings.Join(existing.Tags, ",") != strings.Join(sortedTags(req.Tags), ",") {
+		changed = append(changed, "tags")
+	}
+	return changed
+}
+
+// sortedTags 规范化并按名称排序，与数据库中读取的标签顺序一致
+func sortedTags(tags []string) []string {
+	normalized := normalizeTags(tags)
+	sort.Strings(normalized)
+	return normalized
+}
+
+// invalidateCache 清除角色详情和所有角色列表缓存
+func (s *CharacterService) invalidateCache(id int) {
+	itemKey := &database.CacheKey{Prefix: database.CharacterCachePrefix, ID: id}
+	database.DeleteCache(itemKey.String())
+	invalidateCharacterListCache()
 }
@@ -0,0 +1,352 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"role-play-ai/internal/database"
+)
+
+// 角色统计的增量。写操作只把计数变化累加到Redis哈希中，由后台任务定期合并到character_stats，避免热门角色的计数行成为写入热点
+var (
+	// 字段为 "角色ID:列名"
+	characterStatsDeltaKey = (&database.CacheKey{Prefix: database.CharacterCachePrefix, ID: "stats:delta"}).String()
+	// 字段为对话ID，值为新增的消息数，合并时再换算成角色，发消息时不用额外查询角色ID
+	conversationMessagesDeltaKey = (&database.CacheKey{Prefix: database.ConversationCachePrefix, ID: "stats:delta"}).String()
+)
+
+// characterStatsColumns 可以按增量更新的统计列
+var characterStatsColumns = []string{"conversation_count", "message_count", "favorite_count", "rating_count", "rating_sum"}
+
+// characterStatsDelta 单个角色统计的变化量
+type characterStatsDelta struct {
+	Conversations int64
+	Messages      int64
+	Favorites     int64
+	Ratings       int64
+	RatingSum     int64
+}
+
+func (d *characterStatsDelta) values() []int64 {
+	return []int64{d.Conversations, d.Messages, d.Favorites, d.Ratings, d.RatingSum}
+}
+
+func (d *characterStatsDelta) add(column string, n int64) {
+	switch column {
+	case "conversation_count":
+		d.Conversations += n
+	case "message_count":
+		d.Messages += n
+	case "favorite_count":
+		d.Favorites += n
+	case "rating_count":
+		d.Ratings += n
+	case "rating_sum":
+		d.RatingSum += n
+	}
+}
+
+// addCharacterStatsDelta 累加角色统计的变化量，失败只记录日志，定期全量重算会修正遗漏
+func addCharacterStatsDelta(characterID int, delta *characterStatsDelta) {
+	increments := make(map[string]int64)
+	for i, n := range delta.values() {
+		if n != 0 {
+			increments[fmt.Sprintf("%d:%s", characterID, characterStatsColumns[i])] = n
+		}
+	}
+	if len(increments) == 0 {
+		return
+	}
+	if err := database.IncrementHash(characterStatsDeltaKey, increments); err != nil {
+		log.Printf("Failed to record character stats delta: %v", err)
+	}
+}
+
+// addConversationMessages 累加对话新增的消息数
+func addConversationMessages(conversationID int, count int64) {
+	if err := database.IncrementHash(conversationMessagesDeltaKey, map[string]int64{strconv.Itoa(conversationID): count}); err != nil {
+		log.Printf("Failed to record conversation activity: %v", err)
+	}
+}
+
+type CharacterStatsService struct {
+	db *sql.DB
+}
+
+func NewCharacterStatsService(db *sql.DB) *CharacterStatsService {
+	return &CharacterStatsService{db: db}
+}
+
+// FlushDirty 将累计的增量合并到角色统计，失败时把增量放回Redis，下次再试。
+// 还没有统计行的角色（如新建的角色）按源数据重算
+func (s *CharacterStatsService) FlushDirty() error {
+	characterFields, err := takeDeltas(characterStatsDeltaKey)
+	if err != nil {
+		return err
+	}
+	conversationFields, err := takeDeltas(conversationMessagesDeltaKey)
+	if err != nil {
+		restoreDeltas(characterStatsDeltaKey, characterFields)
+		return err
+	}
+	if len(characterFields) == 0 && len(conversationFields) == 0 {
+		return nil
+	}
+
+	deltas := make(map[int]*characterStatsDelta)
+	deltaFor := func(characterID int) *characterStatsDelta {
+		if deltas[characterID] == nil {
+			deltas[characterID] = &characterStatsDelta{}
+		}
+		return deltas[characterID]
+	}
+	for field, n := range characterFields {
+		id, column, _ := strings.Cut(field, ":")
+		if characterID, err := strconv.Atoi(id); err == nil {
+			deltaFor(characterID).add(column, n)
+		}
+	}
+
+	conversationIDs := make([]int, 0, len(conversationFields))
+	for field := range conversationFields {
+		if id, err := strconv.Atoi(field); err == nil {
+			conversationIDs = append(conversationIDs, id)
+		}
+	}
+	characters, err := s.conversationCharacters(conversationIDs)
+	if err == nil {
+		for conversationID, characterID := range characters {
+			deltaFor(characterID).Messages += conversationFields[strconv.Itoa(conversationID)]
+		}
+		err = s.apply(deltas)
+	}
+	if err != nil {
+		restoreDeltas(characterStatsDeltaKey, characterFields)
+		restoreDeltas(conversationMessagesDeltaKey, conversationFields)
+		return err
+	}
+	return nil
+}
+
+// RefreshAll 全量重算所有角色的统计，修正增量更新可能遗漏的变化（如用户账户被删除）。
+// 重算前丢弃尚未合并的增量，它们已经包含在重算结果中
+func (s *CharacterStatsService) RefreshAll() error {
+	if _, err := takeDeltas(characterStatsDeltaKey); err != nil {
+		return err
+	}
+	if _, err := takeDeltas(conversationMessagesDeltaKey); err != nil {
+		return err
+	}
+	return s.refresh(nil)
+}
+
+// takeDeltas 取出并清空增量哈希
+func takeDeltas(key string) (map[string]int64, error) {
+	fields, err := database.TakeHash(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to take stats deltas: %w", err)
+	}
+	deltas := make(map[string]int64, len(fields))
+	for field, value := range fields {
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil && n != 0 {
+			deltas[field] = n
+		}
+	}
+	return deltas, nil
+}
+
+// restoreDeltas 把未能合并的增量放回哈希
+func restoreDeltas(key string, deltas map[string]int64) {
+	if len(deltas) == 0 {
+		return
+	}
+	if err := database.IncrementHash(key, deltas); err != nil {
+		log.Printf("Failed to restore stats deltas: %v", err)
+	}
+}
+
+// conversationCharacters 查询对话所属的角色ID。回收站中的对话也要换算，
+// 移入回收站时减去的消息数包含了尚未合并的新消息
+func (s *CharacterStatsService) conversationCharacters(conversationIDs []int) (map[int]int, error) {
+	characters := make(map[int]int)
+	if len(conversationIDs) == 0 {
+		return characters, nil
+	}
+
+	placeholders, args := inPlaceholders(conversationIDs)
+	rows, err := s.db.Query("SELECT id, character_id FROM conversations WHERE id IN ("+placeholders+")", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversation characters: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversationID, characterID int
+		if err := rows.Scan(&conversationID, &characterID); err != nil {
+			return nil, fmt.Errorf("failed to scan character ID: %w", err)
+		}
+		characters[conversationID] = characterID
+	}
+	return characters, rows.Err()
+}
+
+// apply 在一个事务中把增量加到已有的统计行上，没有统计行的角色按源数据重算
+func (s *CharacterStatsService) apply(deltas map[int]*characterStatsDelta) error {
+	ids := make([]int, 0, len(deltas))
+	for id := range deltas {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	// 固定加锁顺序，避免多个实例同时合并时死锁
+	sort.Ints(ids)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	placeholders, args := inPlaceholders(ids)
+	rows, err := tx.Query("SELECT character_id FROM character_stats WHERE character_id IN ("+placeholders+") FOR UPDATE", args...)
+	if err != nil {
+		return fmt.Errorf("failed to query character stats: %w", err)
+	}
+	existing := make(map[int]bool, len(ids))
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan character ID: %w", err)
+		}
+		existing[id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query character stats: %w", err)
+	}
+
+	// MySQL按顺序执行赋值，计算平均分时使用的是更新后的评分数和总和
+	stmt, err := tx.Prepare(`
+		UPDATE character_stats SET
+			conversation_count = GREATEST(conversation_count + ?, 0),
+			message_count = GREATEST(message_count + ?, 0),
+			favorite_count = GREATEST(favorite_count + ?, 0),
+			rating_count = GREATEST(rating_count + ?, 0),
+			rating_sum = GREATEST(rating_sum + ?, 0),
+			average_rating = IF(rating_count > 0, rating_sum / rating_count, 0)
+		WHERE character_id = ?
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare character stats update: %w", err)
+	}
+	defer stmt.Close()
+
+	missing := []int{}
+	for _, id := range ids {
+		if !existing[id] {
+			missing = append(missing, id)
+			continue
+		}
+		values := deltas[id].values()
+		if _, err := stmt.Exec(values[0], values[1], values[2], values[3], values[4], id); err != nil {
+			return fmt.Errorf("failed to update character stats: %w", err)
+		}
+	}
+
+	// 在同一事务中重算，失败时增量全部回滚，放回Redis后不会重复计算。已删除的角色重算时不会插入统计行
+	if err := recountCharacterStats(tx, missing); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	invalidateCharacterStatsCache(ids)
+	return nil
+}
+
+// refresh 根据源数据重算角色统计，ids为nil时重算全部角色
+func (s *CharacterStatsService) refresh(ids []int) error {
+	if err := recountCharacterStats(s.db, ids); err != nil {
+		return err
+	}
+
+	if ids == nil {
+		itemKey := &database.CacheKey{Prefix: database.CharacterCachePrefix, ID: "[0-9]*"}
+		database.DeleteCachePattern(itemKey.String())
+		invalidateCharacterListCache()
+		return nil
+	}
+	invalidateCharacterStatsCache(ids)
+	return nil
+}
+
+// execer 可以执行语句的数据库连接或事务
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// recountCharacterStats 根据源数据重写角色的统计行，ids为nil时重算全部角色。回收站中的对话不计入
+func recountCharacterStats(db execer, ids []int) error {
+	where := ""
+	var args []interface{}
+	if ids != nil {
+		if len(ids) == 0 {
+			return nil
+		}
+		var placeholders string
+		placeholders, args = inPlaceholders(ids)
+		where = "WHERE ch.id IN (" + placeholders + ")"
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO character_stats (character_id, conversation_count, message_count, favorite_count, rating_count, rating_sum, average_rating)
+		SELECT ch.id,
+			(SELECT COUNT(*) FROM conversations c WHERE c.character_id = ch.id AND c.deleted_at IS NULL),
+			(SELECT COUNT(*) FROM messages m JOIN conversations c ON c.id = m.conversation_id WHERE c.character_id = ch.id AND c.deleted_at IS NULL),
+			(SELECT COUNT(*) FROM character_favorites f WHERE f.character_id = ch.id),
+			(SELECT COUNT(*) FROM character_ratings r WHERE r.character_id = ch.id),
+			(SELECT COALESCE(SUM(r.rating), 0) FROM character_ratings r WHERE r.character_id = ch.id),
+			(SELECT COALESCE(AVG(r.rating), 0) FROM character_ratings r WHERE r.character_id = ch.id)
+		FROM characters ch
+		`+where+`
+		ON DUPLICATE KEY UPDATE
+			conversation_count = VALUES(conversation_count),
+			message_count = VALUES(message_count),
+			favorite_count = VALUES(favorite_count),
+			rating_count = VALUES(rating_count),
+			rating_sum = VALUES(rating_sum),
+			average_rating = VALUES(average_rating)
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to refresh character stats: %w", err)
+	}
+	return nil
+}
+
+// invalidateCharacterStatsCache 统计随角色详情和列表一起缓存，更新统计后清除
+func invalidateCharacterStatsCache(ids []int) {
+	for _, id := range ids {
+		itemKey := &database.CacheKey{Prefix: database.CharacterCachePrefix, ID: id}
+		database.DeleteCache(itemKey.String())
+	}
+	invalidateCharacterListCache()
+}
+
+// inPlaceholders 生成IN子句的占位符和参数
+func inPlaceholders(ids []int) (string, []interface{}) {
+	placeholders := make([]string, len(ids))
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+	return strings.Join(placeholders, ","), args
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"regexp"
+	"testing"
+
+	"role-play-ai/internal/database"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestCharacterStatsService(t *testing.T) (*CharacterStatsService, sqlmock.Sqlmock, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	database.RedisClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return NewCharacterStatsService(db), mock, mr
+}
+
+const characterStatsUpdate = "UPDATE character_stats SET"
+
+func TestFlushDirtyAppliesIncrements(t *testing.T) {
+	service, mock, mr := newTestCharacterStatsService(t)
+
+	addCharacterStatsDelta(1, &characterStatsDelta{Conversations: 1, Favorites: 1})
+	addCharacterStatsDelta(1, &characterStatsDelta{Ratings: 1, RatingSum: 4})
+	addConversationMessages(10, 1)
+	addConversationMessages(10, 1)
+	addConversationMessages(11, 1)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, character_id FROM conversations WHERE id IN")).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "character_id"}).AddRow(10, 1).AddRow(11, 2))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT character_id FROM character_stats WHERE character_id IN (?,?) FOR UPDATE")).
+		WithArgs(1, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"character_id"}).AddRow(1).AddRow(2))
+	update := mock.ExpectPrepare(regexp.QuoteMeta(characterStatsUpdate))
+	update.ExpectExec().WithArgs(int64(1), int64(2), int64(1), int64(1), int64(4), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	update.ExpectExec().WithArgs(int64(0), int64(1), int64(0), int64(0), int64(0), 2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := service.FlushDirty(); err != nil {
+		t.Fatalf("FlushDirty: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(characterStatsDeltaKey) || mr.Exists(conversationMessagesDeltaKey) {
+		t.Fatal("expected deltas to be consumed")
+	}
+	// 列表缓存通过递增版本号失效
+	if got, _ := mr.Get(characterListGenerationKey); got != "1" {
+		t.Fatalf("expected list cache generation 1, got %q", got)
+	}
+
+	// 没有新的增量时不访问数据库
+	if err := service.FlushDirty(); err != nil {
+		t.Fatalf("FlushDirty: %v", err)
+	}
+}
+
+func TestFlushDirtyRecountsCharactersWithoutStats(t *testing.T) {
+	service, mock, _ := newTestCharacterStatsService(t)
+
+	addCharacterStatsDelta(5, &characterStatsDelta{Conversations: 1, Messages: 3})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT character_id FROM character_stats")).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"character_id"}))
+	mock.ExpectPrepare(regexp.QuoteMeta(characterStatsUpdate))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO character_stats")).
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := service.FlushDirty(); err != nil {
+		t.Fatalf("FlushDirty: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFlushDirtyRestoresDeltasOnFailure(t *testing.T) {
+	service, mock, mr := newTestCharacterStatsService(t)
+
+	addCharacterStatsDelta(1, &characterStatsDelta{Favorites: -1})
+	addConversationMessages(10, 2)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, character_id FROM conversations")).
+		WillReturnError(sqlmock.ErrCancelled)
+
+	if err := service.FlushDirty(); err == nil {
+		t.Fatal("expected FlushDirty to fail")
+	}
+	if got := mr.HGet(characterStatsDeltaKey, "1:favorite_count"); got != "-1" {
+		t.Fatalf("expected character delta to be restored, got %q", got)
+	}
+	if got := mr.HGet(conversationMessagesDeltaKey, "10"); got != "2" {
+		t.Fatalf("expected conversation delta to be restored, got %q", got)
+	}
+}
+
+func TestFlushDirtyRecountFailureRollsBackIncrements(t *testing.T) {
+	service, mock, mr := newTestCharacterStatsService(t)
+
+	addCharacterStatsDelta(1, &characterStatsDelta{Favorites: 1})
+	addCharacterStatsDelta(5, &characterStatsDelta{Conversations: 1})
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT character_id FROM character_stats")).
+		WithArgs(1, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"character_id"}).AddRow(1))
+	update := mock.ExpectPrepare(regexp.QuoteMeta(characterStatsUpdate))
+	update.ExpectExec().WithArgs(int64(0), int64(0), int64(1), int64(0), int64(0), 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO character_stats")).
+		WithArgs(5).
+		WillReturnError(sqlmock.ErrCancelled)
+	mock.ExpectRollback()
+
+	if err := service.FlushDirty(); err == nil {
+		t.Fatal("expected FlushDirty to fail")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	// 已执行的增量随事务回滚，放回的增量不会重复计算
+	if got := mr.HGet(characterStatsDeltaKey, "1:favorite_count"); got != "1" {
+		t.Fatalf("expected rolled back delta to be restored once, got %q", got)
+	}
+	if got := mr.HGet(characterStatsDeltaKey, "5:conversation_count"); got != "1" {
+		t.Fatalf("expected recount delta to be restored, got %q", got)
+	}
+}
+
+func TestRefreshAllDiscardsPendingDeltas(t *testing.T) {
+	service, mock, mr := newTestCharacterStatsService(t)
+
+	addCharacterStatsDelta(1, &characterStatsDelta{Conversations: 1})
+	addConversationMessages(10, 1)
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO character_stats")).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	if err := service.RefreshAll(); err != nil {
+		t.Fatalf("RefreshAll: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if mr.Exists(characterStatsDeltaKey) || mr.Exists(conversationMessagesDeltaKey) {
+		t.Fatal("expected pending deltas to be discarded")
+	}
+}
@@ -4,126 +4,387 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log"
+	"strconv"
 	"strings"
 	"time"
 
+	"role-play-ai/internal/config"
 	"role-play-ai/internal/database"
 	"role-play-ai/internal/models"
 )
 
+// 对话列表中最后一条消息预览的最大字符数
+const messagePreviewLength = 100
+
 type ConversationService struct {
-	db *sql.DB
+	db             *sql.DB
+	auditService   *AuditService
+	searchIndex    SearchIndex
+	trashRetention time.Duration
 }
 
-func NewConversationService(db *sql.DB) *ConversationService {
-	return &ConversationService{db: db}
+func NewConversationService(db *sql.DB, auditService *AuditService, searchIndex SearchIndex, cfg *config.Config) *ConversationService {
+	retentionDays, err := strconv.Atoi(cfg.TrashRetentionDays)
+	if err != nil || retentionDays < 0 {
+		retentionDays = 30
+	}
+
+	return &ConversationService{
+		db:             db,
+		auditService:   auditService,
+		searchIndex:    searchIndex,
+		trashRetention: time.Duration(retentionDays) * 24 * time.Hour,
+	}
 }
 
-func (s *ConversationService) GetConversations(userID int) ([]*models.Conversation, error) {
-	// 尝试从Redis缓存获取
-	cacheKey := &database.CacheKey{Prefix: database.ConversationCachePrefix, ID: userID}
-	cached, err := database.GetCache(cacheKey.String())
-	if err == nil && cached != "" {
-		var conversations []*models.Conversation
-		if json.Unmarshal([]byte(cached), &conversations) == nil {
-			return conversations, nil
+// conversationColumns 对话查询的公共列，角色只取列表展示需要的字段，不包含系统提示词
+const conversationColumns = `
+	c.id, c.user_id, c.character_id, c.title, c.title_auto, c.is_archived, c.pinned_at, c.folder_id, c.deleted_at, c.created_at, c.updated_at,
+	ch.id, ch.name, ch.description, ch.avatar_url, ch.category, ch.is_published, ch.created_at, ch.updated_at`
+
+func scanConversation(row rowScanner, extra ...interface{}) (*models.Conversation, error) {
+	conversation := &models.Conversation{}
+	character := &models.Character{}
+	var title, description, avatarURL, category sql.NullString
+	var pinnedAt, deletedAt sql.NullTime
+	var folderID sql.NullInt64
+	dest := append([]interface{}{
+		&conversation.ID, &conversation.UserID, &conversation.CharacterID, &title, &conversation.TitleAuto, &conversation.IsArchived,
+		&pinnedAt, &folderID, &deletedAt, &conversation.CreatedAt, &conversation.UpdatedAt,
+		&character.ID, &character.Name, &description, &avatarURL, &category, &character.IsPublished,
+		&character.CreatedAt, &character.UpdatedAt,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	conversation.Title = title.String
+	if pinnedAt.Valid {
+		conversation.IsPinned = true
+		conversation.PinnedAt = &pinnedAt.Time
+	}
+	if folderID.Valid {
+		id := int(folderID.Int64)
+		conversation.FolderID = &id
+	}
+	if deletedAt.Valid {
+		conversation.DeletedAt = &deletedAt.Time
+	}
+	character.Description = description.String
+	character.AvatarURL = avatarURL.String
+	character.Category = category.String
+	conversation.Character = character
+	return conversation, nil
+}
+
+// conversationOrders 对话列表的排序子句，置顶的对话始终排在最前
+var conversationOrders = map[string]string{
+	models.ConversationSortRecent:  "c.updated_at DESC, c.id DESC",
+	models.ConversationSortCreated: "c.created_at DESC, c.id DESC",
+	models.ConversationSortTitle:   "c.title, c.id DESC",
+}
+
+// ListConversations 分页查询用户的对话列表，置顶的在前，其余按指定方式排序，返回当前页和总数。
+// 查询回收站时按删除时间倒序
+func (s *ConversationService) ListConversations(userID int, filter *models.ConversationFilter) ([]*models.Conversation, int, error) {
+	conditions := []string{"c.user_id = ?", "c.deleted_at IS NULL"}
+	args := []interface{}{userID}
+	if filter.Deleted {
+		conditions[1] = "c.deleted_at IS NOT NULL"
+	}
+
+	if filter.Archived != nil {
+		conditions = append(conditions, "c.is_archived = ?")
+		args = append(args, *filter.Archived)
+	}
+	if filter.CharacterID != 0 {
+		conditions = append(conditions, "c.character_id = ?")
+		args = append(args, filter.CharacterID)
+	}
+	if filter.FolderID != nil {
+		if *filter.FolderID == 0 {
+			conditions = append(conditions, "c.folder_id IS NULL")
+		} else {
+			conditions = append(conditions, "c.folder_id = ?")
+			args = append(args, *filter.FolderID)
 		}
 	}
+	if filter.LabelID != 0 {
+		conditions = append(conditions, "EXISTS (SELECT 1 FROM conversation_label_links l WHERE l.conversation_id = c.id AND l.label_id = ?)")
+		args = append(args, filter.LabelID)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, "c.updated_at >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conditions = append(conditions, "c.updated_at < ?")
+		args = append(args, *filter.To)
+	}
+	where := strings.Join(conditions, " AND ")
+
+	order, ok := conversationOrders[filter.Sort]
+	if !ok {
+		order = conversationOrders[models.ConversationSortRecent]
+	}
+	order = "c.pinned_at IS NULL, c.pinned_at DESC, " + order
+	if filter.Deleted {
+		order = "c.deleted_at DESC, c.id DESC"
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM conversations c WHERE "+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count conversations: %w", err)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+conversationColumns+`
+		FROM conversations c
+		JOIN characters ch ON c.character_id = ch.id
+		WHERE `+where+`
+		ORDER BY `+order+`
+		LIMIT ? OFFSET ?
+	`, append(args, filter.Limit, (filter.Page-1)*filter.Limit)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query conversations: %w", err)
+	}
+	defer rows.Close()
+
+	conversations := []*models.Conversation{}
+	for rows.Next() {
+		conversation, err := scanConversation(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan conversation: %w", err)
+		}
+		conversations = append(conversations, conversation)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to query conversations: %w", err)
+	}
+
+	if err := s.loadConversationStats(conversations); err != nil {
+		return nil, 0, err
+	}
+	if err := s.loadConversationLabels(conversations); err != nil {
+		return nil, 0, err
+	}
+
+	return conversations, total, nil
+}
 
-	// 从数据库查询
+// GetConversations 获取用户的全部对话（包括已归档和回收站中的），用于数据导出，回收站中的对话带有deleted_at
+func (s *ConversationService) GetConversations(userID int) ([]*models.Conversation, error) {
 	rows, err := s.db.Query(`
-		SELECT c.id, c.user_id, c.character_id, c.title, c.created_at, c.updated_at,
-		       ch.id, ch.name, ch.description, ch.avatar_url, ch.system_prompt, ch.category, ch.created_at, ch.updated_at
+		SELECT `+conversationColumns+`
 		FROM conversations c
 		JOIN characters ch ON c.character_id = ch.id
 		WHERE c.user_id = ?
-		ORDER BY c.updated_at DESC
+		ORDER BY c.updated_at DESC, c.id DESC
 	`, userID)
-
 	if err != nil {
 		return nil, fmt.Errorf("failed to query conversations: %w", err)
 	}
 	defer rows.Close()
 
-	var conversations []*models.Conversation
+	conversations := []*models.Conversation{}
 	for rows.Next() {
-		conversation := &models.Conversation{}
-		character := &models.Character{}
-
-		err := rows.Scan(
-			&conversation.ID,
-			&conversation.UserID,
-			&conversation.CharacterID,
-			&conversation.Title,
-			&conversation.CreatedAt,
-			&conversation.UpdatedAt,
-			&character.ID,
-			&character.Name,
-			&character.Description,
-			&character.AvatarURL,
-			&character.SystemPrompt,
-			&character.Category,
-			&character.CreatedAt,
-			&character.UpdatedAt,
-		)
+		conversation, err := scanConversation(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan conversation: %w", err)
 		}
-
-		conversation.Character = character
 		conversations = append(conversations, conversation)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query conversations: %w", err)
+	}
 
-	// 缓存到Redis
-	if data, err := json.Marshal(conversations); err == nil {
-		database.SetCache(cacheKey.String(), string(data), database.ConversationCacheExpiry)
+	if err := s.loadConversationStats(conversations); err != nil {
+		return nil, err
+	}
+	if err := s.loadConversationLabels(conversations); err != nil {
+		return nil, err
 	}
 
 	return conversations, nil
 }
 
+// GetConversation 获取对话详情，包含角色的系统提示词和语音配置。回收站中的对话视为不存在
 func (s *ConversationService) GetConversation(id, userID int) (*models.Conversation, error) {
-	conversation := &models.Conversation{}
-	character := &models.Character{}
-
-	err := s.db.QueryRow(`
-		SELECT c.id, c.user_id, c.character_id, c.title, c.created_at, c.updated_at,
-		       ch.id, ch.name, ch.description, ch.avatar_url, ch.system_prompt, ch.category, ch.created_at, ch.updated_at
+	var systemPrompt string
+	var voice sql.NullString
+	var voiceSpeed float64
+	conversation, err := scanConversation(s.db.QueryRow(`
+		SELECT `+conversationColumns+`, ch.system_prompt, ch.voice, ch.voice_speed
 		FROM conversations c
 		JOIN characters ch ON c.character_id = ch.id
-		WHERE c.id = ? AND c.user_id = ?
-	`, id, userID).Scan(
-		&conversation.ID,
-		&conversation.UserID,
-		&conversation.CharacterID,
-		&conversation.Title,
-		&conversation.CreatedAt,
-		&conversation.UpdatedAt,
-		&character.ID,
-		&character.Name,
-		&character.Description,
-		&character.AvatarURL,
-		&character.SystemPrompt,
-		&character.Category,
-		&character.CreatedAt,
-		&character.UpdatedAt,
-	)
-
+		WHERE c.id = ? AND c.user_id = ? AND c.deleted_at IS NULL
+	`, id, userID), &systemPrompt, &voice, &voiceSpeed)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("conversation not found")
 		}
 		return nil, fmt.Errorf("failed to get conversation: %w", err)
 	}
+	conversation.Character.SystemPrompt = systemPrompt
+	conversation.Character.Voice = voice.String
+	conversation.Character.VoiceSpeed = voiceSpeed
+
+	if err := s.loadConversationStats([]*models.Conversation{conversation}); err != nil {
+		return nil, err
+	}
+	if err := s.loadConversationLabels([]*models.Conversation{conversation}); err != nil {
+		return nil, err
+	}
 
-	conversation.Character = character
 	return conversation, nil
 }
 
+// conversationStats 对话的消息数和最后一条消息，按对话单独缓存，只在该对话有新消息时失效
+type conversationStats struct {
+	MessageCount int                    `json:"message_count"`
+	LastMessage  *models.MessagePreview `json:"last_message,omitempty"`
+}
+
+func conversationStatsKey(conversationID int) string {
+	key := &database.CacheKey{Prefix: database.ConversationCachePrefix, ID: fmt.Sprintf("%d:stats", conversationID)}
+	return key.String()
+}
+
+// invalidateConversationStats 清除对话统计缓存，新增、修改或删除消息后调用
+func invalidateConversationStats(conversationIDs ...int) {
+	for _, id := range conversationIDs {
+		database.DeleteCache(conversationStatsKey(id))
+	}
+}
+
+// loadConversationStats 填充对话的消息数和最后一条消息，优先读取缓存，未命中的一次查询补齐
+func (s *ConversationService) loadConversationStats(conversations []*models.Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(conversations))
+	for i, conversation := range conversations {
+		keys[i] = conversationStatsKey(conversation.ID)
+	}
+
+	missing := map[int]*models.Conversation{}
+	cached, err := database.GetCacheMulti(keys...)
+	for i, conversation := range conversations {
+		var stats conversationStats
+		if err == nil {
+			if value, ok := cached[i].(string); ok && json.Unmarshal([]byte(value), &stats) == nil {
+				conversation.MessageCount = stats.MessageCount
+				conversation.LastMessage = stats.LastMessage
+				continue
+			}
+		}
+		missing[conversation.ID] = conversation
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	placeholders := make([]string, 0, len(missing))
+	args := make([]interface{}, 0, len(missing))
+	for id := range missing {
+		placeholders = append(placeholders, "?")
+		args = append(args, id)
+	}
+
+	// 只取内容的前几个字符作为预览，避免读取整条长消息
+	rows, err := s.db.Query(fmt.Sprintf(`
+		SELECT s.conversation_id, s.message_count, m.role, LEFT(m.content, %d), m.created_at
+		FROM (
+			SELECT conversation_id, COUNT(*) AS message_count, MAX(id) AS last_id
+			FROM messages
+			WHERE conversation_id IN (%s)
+			GROUP BY conversation_id
+		) s
+		JOIN messages m ON m.id = s.last_id
+	`, messagePreviewLength+1, strings.Join(placeholders, ",")), args...)
+	if err != nil {
+		return fmt.Errorf("failed to query conversation stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := map[int]*conversationStats{}
+	for rows.Next() {
+		var conversationID int
+		item := &conversationStats{LastMessage: &models.MessagePreview{}}
+		if err := rows.Scan(&conversationID, &item.MessageCount, &item.LastMessage.Role, &item.LastMessage.Content, &item.LastMessage.CreatedAt); err != nil {
+			return fmt.Errorf("failed to scan conversation stats: %w", err)
+		}
+		if runes := []rune(item.LastMessage.Content); len(runes) > messagePreviewLength {
+			item.LastMessage.Content = string(runes[:messagePreviewLength]) + "…"
+		}
+		stats[conversationID] = item
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to query conversation stats: %w", err)
+	}
+
+	for id, conversation := range missing {
+		item, ok := stats[id]
+		if !ok {
+			item = &conversationStats{}
+		}
+		conversation.MessageCount = item.MessageCount
+		conversation.LastMessage = item.LastMessage
+		if data, err := json.Marshal(item); err == nil {
+			database.SetCache(conversationStatsKey(id), string(data), database.ConversationCacheExpiry)
+		}
+	}
+
+	return nil
+}
+
+// loadConversationLabels 填充对话的标签
+func (s *ConversationService) loadConversationLabels(conversations []*models.Conversation) error {
+	if len(conversations) == 0 {
+		return nil
+	}
+
+	byID := make(map[int]*models.Conversation, len(conversations))
+	ids := make([]int, len(conversations))
+	for i, conversation := range conversations {
+		conversation.Labels = []*models.ConversationLabel{}
+		byID[conversation.ID] = conversation
+		ids[i] = conversation.ID
+	}
+
+	placeholders, args := inPlaceholders(ids)
+	rows, err := s.db.Query(`
+		SELECT l.conversation_id, cl.id, cl.name
+		FROM conversation_label_links l
+		JOIN conversation_labels cl ON cl.id = l.label_id
+		WHERE l.conversation_id IN (`+placeholders+`)
+		ORDER BY cl.name
+	`, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query conversation labels: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var conversationID int
+		label := &models.ConversationLabel{}
+		if err := rows.Scan(&conversationID, &label.ID, &label.Name); err != nil {
+			return fmt.Errorf("failed to scan conversation label: %w", err)
+		}
+		byID[conversationID].Labels = append(byID[conversationID].Labels, label)
+	}
+	return rows.Err()
+}
+
 func (s *ConversationService) CreateConversation(userID int, req *models.CreateConversationRequest) (*models.Conversation, error) {
-	// 验证角色是否存在
+	// 验证角色是否存在，除已发布的角色外，用户也可以使用自己导入对话时创建的私有角色
 	var characterName string
-	err := s.db.QueryRow("SELECT name FROM characters WHERE id = ?", req.CharacterID).Scan(&characterName)
+	err := s.db.QueryRow(
+		"SELECT name FROM characters WHERE id = ? AND (is_published = TRUE OR owner_id = ?)",
+		req.CharacterID, userID,
+	).Scan(&characterName)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("character not found")
@@ -145,10 +406,7 @@ func (s *ConversationService) CreateConversation(userID int, req *models.CreateC
 	if err != nil {
 		return nil, fmt.Errorf("failed to get conversation ID: %w", err)
 	}
-
-	// 清除用户对话列表缓存
-	cacheKey := &database.CacheKey{Prefix: database.ConversationCachePrefix, ID: userID}
-	database.DeleteCache(cacheKey.String())
+	addCharacterStatsDelta(req.CharacterID, &characterStatsDelta{Conversations: 1})
 
 	// 获取创建的对话
 	conversation, err := s.GetConversation(int(conversationID), userID)
@@ -159,8 +417,125 @@ func (s *ConversationService) CreateConversation(userID int, req *models.CreateC
 	return conversation, nil
 }
 
-func (s *ConversationService) DeleteConversation(id, userID int) error {
-	result, err := s.db.Exec("DELETE FROM conversations WHERE id = ? AND user_id = ?", id, userID)
+// CloneConversation 将对话的全部消息或截止到指定消息的部分复制为新对话，可以换用其他角色，原对话不受影响
+func (s *ConversationService) CloneConversation(id, userID int, req *models.CloneConversationRequest) (*models.Conversation, error) {
+	source, err := s.GetConversation(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var lastMessageID sql.NullInt64
+	if req.MessageID != nil {
+		err = s.db.QueryRow("SELECT id FROM messages WHERE id = ? AND conversation_id = ?", *req.MessageID, id).Scan(&lastMessageID)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("message not found")
+		}
+	} else {
+		err = s.db.QueryRow("SELECT MAX(id) FROM messages WHERE conversation_id = ?", id).Scan(&lastMessageID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last message: %w", err)
+	}
+
+	characterID := source.CharacterID
+	if req.CharacterID != nil {
+		characterID = *req.CharacterID
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		// 标题列最长200个字符，为后缀留出空间
+		title = source.Title
+		if runes := []rune(title); len(runes) > 196 {
+			title = string(runes[:196])
+		}
+		title += "（副本）"
+	}
+
+	// 没有消息的对话复制为空对话。保留原消息的时间，新消息ID仍按原顺序递增
+	return s.copyConversation(userID, characterID, title, func(tx *sql.Tx, conversationID int64) (sql.Result, error) {
+		return tx.Exec(`
+			INSERT INTO messages (conversation_id, role, content, audio_url, model, created_at)
+			SELECT ?, role, content, audio_url, model, created_at
+			FROM messages
+			WHERE conversation_id = ? AND id <= ?
+			ORDER BY id
+		`, conversationID, id, lastMessageID.Int64)
+	})
+}
+
+// copyConversation 在一个事务中为用户创建新对话，并由copyMessages把消息写入新对话
+func (s *ConversationService) copyConversation(userID, characterID int, title string, copyMessages func(tx *sql.Tx, conversationID int64) (sql.Result, error)) (*models.Conversation, error) {
+	var found int
+	err := s.db.QueryRow(
+		"SELECT 1 FROM characters WHERE id = ? AND (is_published = TRUE OR owner_id = ?)",
+		characterID, userID,
+	).Scan(&found)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("character not found")
+		}
+		return nil, fmt.Errorf("failed to verify character: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO conversations (user_id, character_id, title, title_auto) VALUES (?, ?, ?, FALSE)",
+		userID, characterID, title,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	}
+	conversationID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation ID: %w", err)
+	}
+
+	result, err = copyMessages(tx, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy messages: %w", err)
+	}
+	copied, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	addCharacterStatsDelta(characterID, &characterStatsDelta{Conversations: 1, Messages: copied})
+	s.reindexConversation(int(conversationID))
+
+	return s.GetConversation(int(conversationID), userID)
+}
+
+// DeleteConversation 将对话移入回收站，保留期内可以恢复
+func (s *ConversationService) DeleteConversation(actor *models.AuditActor, id, userID int) error {
+	// 删除前记下角色ID和消息数，用于更新角色统计
+	var characterID int
+	var messageCount int64
+	err := s.db.QueryRow(`
+		SELECT c.character_id, (SELECT COUNT(*) FROM messages m WHERE m.conversation_id = c.id)
+		FROM conversations c
+		WHERE c.id = ? AND c.user_id = ? AND c.deleted_at IS NULL
+	`, id, userID).Scan(&characterID, &messageCount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("conversation not found")
+		}
+		return fmt.Errorf("failed to get conversation: %w", err)
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE conversations SET deleted_at = ?, updated_at = updated_at WHERE id = ? AND user_id = ? AND deleted_at IS NULL",
+		time.Now(), id, userID,
+	)
 	if err != nil {
 		return fmt.Errorf("failed to delete conversation: %w", err)
 	}
@@ -174,14 +549,16 @@ func (s *ConversationService) DeleteConversation(id, userID int) error {
 		return fmt.Errorf("conversation not found")
 	}
 
-	// 清除用户对话列表缓存
-	cacheKey := &database.CacheKey{Prefix: database.ConversationCachePrefix, ID: userID}
-	database.DeleteCache(cacheKey.String())
+	invalidateConversationStats(id)
+	addCharacterStatsDelta(characterID, &characterStatsDelta{Conversations: -1, Messages: -messageCount})
+	s.removeFromSearchIndex(id)
 
+	s.auditService.Record(actor, models.AuditConversationDelete, "conversation", strconv.Itoa(id), nil)
 	return nil
 }
 
-func (s *ConversationService) BatchDeleteConversations(ids []int, userID int) (int, error) {
+// BatchDeleteConversations 将多个对话移入回收站
+func (s *ConversationService) BatchDeleteConversations(actor *models.AuditActor, ids []int, userID int) (int, error) {
 	if len(ids) == 0 {
 		return 0, fmt.Errorf("no conversation IDs provided")
 	}
@@ -196,10 +573,44 @@ func (s *ConversationService) BatchDeleteConversations(ids []int, userID int) (i
 	}
 	args[len(ids)] = userID
 
-	query := fmt.Sprintf("DELETE FROM conversations WHERE id IN (%s) AND user_id = ?",
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 先锁定并记录实际属于该用户的对话ID，审计日志只记录真正删除的对话
+	rows, err := tx.Query(fmt.Sprintf(`
+		SELECT c.id, c.character_id, (SELECT COUNT(*) FROM messages m WHERE m.conversation_id = c.id)
+		FROM conversations c
+		WHERE c.id IN (%s) AND c.user_id = ? AND c.deleted_at IS NULL
+		FOR UPDATE`,
+		strings.Join(placeholders, ",")), args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query conversations: %w", err)
+	}
+	deletedIDs := []int{}
+	deltas := make(map[int]*characterStatsDelta)
+	for rows.Next() {
+		var id, characterID int
+		var messageCount int64
+		if err := rows.Scan(&id, &characterID, &messageCount); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan conversation ID: %w", err)
+		}
+		deletedIDs = append(deletedIDs, id)
+		if deltas[characterID] == nil {
+			deltas[characterID] = &characterStatsDelta{}
+		}
+		deltas[characterID].Conversations--
+		deltas[characterID].Messages -= messageCount
+	}
+	rows.Close()
+
+	query := fmt.Sprintf("UPDATE conversations SET deleted_at = ?, updated_at = updated_at WHERE id IN (%s) AND user_id = ? AND deleted_at IS NULL",
 		strings.Join(placeholders, ","))
 
-	result, err := s.db.Exec(query, args...)
+	result, err := tx.Exec(query, append([]interface{}{time.Now()}, args...)...)
 	if err != nil {
 		return 0, fmt.Errorf("failed to batch delete conversations: %w", err)
 	}
@@ -209,18 +620,183 @@ func (s *ConversationService) BatchDeleteConversations(ids []int, userID int) (i
 		return 0, fmt.Errorf("failed to get rows affected: %w", err)
 	}
 
-	// 清除用户对话列表缓存
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	if rowsAffected > 0 {
-		cacheKey := &database.CacheKey{Prefix: database.ConversationCachePrefix, ID: userID}
-		database.DeleteCache(cacheKey.String())
+		invalidateConversationStats(deletedIDs...)
+		for characterID, delta := range deltas {
+			addCharacterStatsDelta(characterID, delta)
+		}
+		s.removeFromSearchIndex(deletedIDs...)
+
+		s.auditService.Record(actor, models.AuditConversationBatchDelete, "conversation", "", map[string]interface{}{
+			"requested_ids": ids,
+			"deleted_ids":   deletedIDs,
+		})
 	}
 
 	return int(rowsAffected), nil
 }
 
+// RestoreConversation 从回收站恢复对话
+func (s *ConversationService) RestoreConversation(actor *models.AuditActor, id, userID int) (*models.Conversation, error) {
+	var characterID int
+	var messageCount int64
+	err := s.db.QueryRow(`
+		SELECT c.character_id, (SELECT COUNT(*) FROM messages m WHERE m.conversation_id = c.id)
+		FROM conversations c
+		WHERE c.id = ? AND c.user_id = ? AND c.deleted_at IS NOT NULL
+	`, id, userID).Scan(&characterID, &messageCount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("conversation not found")
+		}
+		return nil, fmt.Errorf("failed to get conversation: %w", err)
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE conversations SET deleted_at = NULL, updated_at = updated_at WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL",
+		id, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore conversation: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return nil, fmt.Errorf("conversation not found")
+	}
+
+	addCharacterStatsDelta(characterID, &characterStatsDelta{Conversations: 1, Messages: messageCount})
+	s.reindexConversation(id)
+
+	s.auditService.Record(actor, models.AuditConversationRestore, "conversation", strconv.Itoa(id), nil)
+	return s.GetConversation(id, userID)
+}
+
+// PermanentlyDeleteConversation 永久删除回收站中的对话，消息随之级联删除
+func (s *ConversationService) PermanentlyDeleteConversation(actor *models.AuditActor, id, userID int) error {
+	result, err := s.db.Exec("DELETE FROM conversations WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL", id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete conversation: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("conversation not found")
+	}
+
+	invalidateConversationStats(id)
+	s.removeFromSearchIndex(id)
+
+	s.auditService.Record(actor, models.AuditConversationPurge, "conversation", strconv.Itoa(id), nil)
+	return nil
+}
+
+// EmptyTrash 永久删除用户回收站中的全部对话，返回删除的数量
+func (s *ConversationService) EmptyTrash(actor *models.AuditActor, userID int) (int, error) {
+	ids, err := s.purge("user_id = ? AND deleted_at IS NOT NULL", userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ids) > 0 {
+		s.auditService.Record(actor, models.AuditConversationPurge, "conversation", "", map[string]interface{}{
+			"deleted_ids": ids,
+		})
+	}
+	return len(ids), nil
+}
+
+// PurgeTrash 永久删除在回收站中超过保留期的对话，由后台任务定期调用
+func (s *ConversationService) PurgeTrash() (int, error) {
+	ids, err := s.purge("deleted_at IS NOT NULL AND deleted_at <= ?", time.Now().Add(-s.trashRetention))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(ids) > 0 {
+		s.auditService.Record(nil, models.AuditConversationPurge, "conversation", "", map[string]interface{}{
+			"deleted_ids": ids,
+			"reason":      "retention",
+		})
+	}
+	return len(ids), nil
+}
+
+// purge 永久删除符合条件的对话，并清理统计缓存和搜索索引
+func (s *ConversationService) purge(where string, args ...interface{}) ([]int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query("SELECT id FROM conversations WHERE "+where+" FOR UPDATE", args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversations: %w", err)
+	}
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan conversation ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	placeholders, idArgs := inPlaceholders(ids)
+	if _, err := tx.Exec("DELETE FROM conversations WHERE id IN ("+placeholders+")", idArgs...); err != nil {
+		return nil, fmt.Errorf("failed to purge conversations: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	invalidateConversationStats(ids...)
+	s.removeFromSearchIndex(ids...)
+	return ids, nil
+}
+
+// reindexConversation 恢复对话后重新索引其消息，失败只记录日志
+func (s *ConversationService) reindexConversation(id int) {
+	rows, err := s.db.Query("SELECT "+messageColumns+" FROM messages WHERE conversation_id = ? ORDER BY id", id)
+	if err != nil {
+		log.Printf("Failed to reindex conversation %d: %v", id, err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			log.Printf("Failed to reindex conversation %d: %v", id, err)
+			return
+		}
+		if err := s.searchIndex.IndexMessage(message); err != nil {
+			log.Printf("Failed to index message %d: %v", message.ID, err)
+		}
+	}
+}
+
+// removeFromSearchIndex 从搜索索引中删除对话的消息，失败只记录日志
+func (s *ConversationService) removeFromSearchIndex(ids ...int) {
+	if err := s.searchIndex.DeleteConversations(ids...); err != nil {
+		log.Printf("Failed to remove conversations %v from search index: %v", ids, err)
+	}
+}
+
+// UpdateConversationTitle 更新自动生成的标题，用户已手动改名的对话不会被覆盖
 func (s *ConversationService) UpdateConversationTitle(id, userID int, title string) error {
 	_, err := s.db.Exec(
-		"UPDATE conversations SET title = ?, updated_at = ? WHERE id = ? AND user_id = ?",
+		"UPDATE conversations SET title = ?, updated_at = ? WHERE id = ? AND user_id = ? AND title_auto = TRUE AND deleted_at IS NULL",
 		title, time.Now(), id, userID,
 	)
 	if err != nil {
@@ -229,3 +805,260 @@ func (s *ConversationService) UpdateConversationTitle(id, userID int, title stri
 
 	return nil
 }
+
+// RenameConversation 用户手动修改标题，之后不再自动生成标题
+func (s *ConversationService) RenameConversation(id, userID int, title string) (*models.Conversation, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return nil, fmt.Errorf("title cannot be empty")
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE conversations SET title = ?, title_auto = FALSE WHERE id = ? AND user_id = ? AND deleted_at IS NULL",
+		title, id, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rename conversation: %w", err)
+	}
+	// 标题未变化时MySQL返回的影响行数为0，需要再确认对话是否存在
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		var count int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM conversations WHERE id = ? AND user_id = ? AND deleted_at IS NULL", id, userID).Scan(&count); err != nil {
+			return nil, fmt.Errorf("failed to verify conversation: %w", err)
+		}
+		if count == 0 {
+			return nil, fmt.Errorf("conversation not found")
+		}
+	}
+
+	return s.GetConversation(id, userID)
+}
+
+// UpdateConversation 修改对话的置顶、归档和文件夹状态，不改变最后活动时间
+func (s *ConversationService) UpdateConversation(id, userID int, req *models.UpdateConversationRequest) (*models.Conversation, error) {
+	sets := []string{"updated_at = updated_at"}
+	args := []interface{}{}
+	if req.IsPinned != nil {
+		if *req.IsPinned {
+			sets = append(sets, "pinned_at = COALESCE(pinned_at, ?)")
+			args = append(args, time.Now())
+		} else {
+			sets = append(sets, "pinned_at = NULL")
+		}
+	}
+	if req.IsArchived != nil {
+		sets = append(sets, "is_archived = ?")
+		args = append(args, *req.IsArchived)
+	}
+	if req.FolderID != nil {
+		folderID, err := s.folderValue(*req.FolderID, userID)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, "folder_id = ?")
+		args = append(args, folderID)
+	}
+
+	_, err := s.db.Exec("UPDATE conversations SET "+strings.Join(sets, ", ")+" WHERE id = ? AND user_id = ? AND deleted_at IS NULL",
+		append(args, id, userID)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update conversation: %w", err)
+	}
+
+	// 状态未变化时影响行数为0，由GetConversation确认对话是否存在
+	return s.GetConversation(id, userID)
+}
+
+// SetConversationLabels 替换对话的全部标签
+func (s *ConversationService) SetConversationLabels(id, userID int, labelIDs []int) (*models.Conversation, error) {
+	labelIDs = uniqueIDs(labelIDs)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	owned, err := ownedConversationIDs(tx, []int{id}, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(owned) == 0 {
+		return nil, fmt.Errorf("conversation not found")
+	}
+	if err := s.verifyLabels(tx, labelIDs, userID); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec("DELETE FROM conversation_label_links WHERE conversation_id = ?", id); err != nil {
+		return nil, fmt.Errorf("failed to clear conversation labels: %w", err)
+	}
+	for _, labelID := range labelIDs {
+		if _, err := tx.Exec("INSERT INTO conversation_label_links (conversation_id, label_id) VALUES (?, ?)", id, labelID); err != nil {
+			return nil, fmt.Errorf("failed to add conversation label: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return s.GetConversation(id, userID)
+}
+
+// BatchArchiveConversations 批量归档或取消归档，返回属于该用户的对话数
+func (s *ConversationService) BatchArchiveConversations(ids []int, userID int, archived bool) (int, error) {
+	return s.batchUpdate(ids, userID, func(tx *sql.Tx, owned []int) error {
+		placeholders, args := inPlaceholders(owned)
+		_, err := tx.Exec("UPDATE conversations SET is_archived = ?, updated_at = updated_at WHERE id IN ("+placeholders+")",
+			append([]interface{}{archived}, args...)...)
+		if err != nil {
+			return fmt.Errorf("failed to batch archive conversations: %w", err)
+		}
+		return nil
+	})
+}
+
+// BatchMoveConversations 批量移动到文件夹，folderID为0时移出文件夹
+func (s *ConversationService) BatchMoveConversations(ids []int, userID, folderID int) (int, error) {
+	folder, err := s.folderValue(folderID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.batchUpdate(ids, userID, func(tx *sql.Tx, owned []int) error {
+		placeholders, args := inPlaceholders(owned)
+		_, err := tx.Exec("UPDATE conversations SET folder_id = ?, updated_at = updated_at WHERE id IN ("+placeholders+")",
+			append([]interface{}{folder}, args...)...)
+		if err != nil {
+			return fmt.Errorf("failed to batch move conversations: %w", err)
+		}
+		return nil
+	})
+}
+
+// BatchLabelConversations 批量添加或移除标签
+func (s *ConversationService) BatchLabelConversations(ids []int, userID, labelID int, remove bool) (int, error) {
+	return s.batchUpdate(ids, userID, func(tx *sql.Tx, owned []int) error {
+		if err := s.verifyLabels(tx, []int{labelID}, userID); err != nil {
+			return err
+		}
+
+		placeholders, args := inPlaceholders(owned)
+		if remove {
+			_, err := tx.Exec("DELETE FROM conversation_label_links WHERE label_id = ? AND conversation_id IN ("+placeholders+")",
+				append([]interface{}{labelID}, args...)...)
+			if err != nil {
+				return fmt.Errorf("failed to batch remove label: %w", err)
+			}
+			return nil
+		}
+
+		for _, id := range owned {
+			if _, err := tx.Exec("INSERT IGNORE INTO conversation_label_links (conversation_id, label_id) VALUES (?, ?)", id, labelID); err != nil {
+				return fmt.Errorf("failed to batch add label: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// batchUpdate 在事务中锁定属于该用户的对话后执行批量修改，与批量删除一样忽略不属于该用户的ID
+func (s *ConversationService) batchUpdate(ids []int, userID int, update func(tx *sql.Tx, owned []int) error) (int, error) {
+	if len(ids) == 0 {
+		return 0, fmt.Errorf("no conversation IDs provided")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	owned, err := ownedConversationIDs(tx, uniqueIDs(ids), userID)
+	if err != nil {
+		return 0, err
+	}
+	if len(owned) == 0 {
+		return 0, nil
+	}
+
+	if err := update(tx, owned); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return len(owned), nil
+}
+
+// ownedConversationIDs 锁定并返回其中属于该用户且不在回收站中的对话ID
+func ownedConversationIDs(tx *sql.Tx, ids []int, userID int) ([]int, error) {
+	placeholders, args := inPlaceholders(ids)
+	rows, err := tx.Query("SELECT id FROM conversations WHERE id IN ("+placeholders+") AND user_id = ? AND deleted_at IS NULL FOR UPDATE",
+		append(args, userID)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query conversations: %w", err)
+	}
+	defer rows.Close()
+
+	owned := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan conversation ID: %w", err)
+		}
+		owned = append(owned, id)
+	}
+	return owned, rows.Err()
+}
+
+// folderValue 校验文件夹属于该用户，folderID为0时返回NULL
+func (s *ConversationService) folderValue(folderID, userID int) (interface{}, error) {
+	if folderID == 0 {
+		return nil, nil
+	}
+
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM conversation_folders WHERE id = ? AND user_id = ?", folderID, userID).Scan(&count); err != nil {
+		return nil, fmt.Errorf("failed to verify folder: %w", err)
+	}
+	if count == 0 {
+		return nil, fmt.Errorf("folder not found")
+	}
+	return folderID, nil
+}
+
+// verifyLabels 校验标签都属于该用户
+func (s *ConversationService) verifyLabels(tx *sql.Tx, labelIDs []int, userID int) error {
+	if len(labelIDs) == 0 {
+		return nil
+	}
+
+	placeholders, args := inPlaceholders(labelIDs)
+	var count int
+	err := tx.QueryRow("SELECT COUNT(*) FROM conversation_labels WHERE id IN ("+placeholders+") AND user_id = ?",
+		append(args, userID)...).Scan(&count)
+	if err != nil {
+		return fmt.Errorf("failed to verify labels: %w", err)
+	}
+	if count != len(labelIDs) {
+		return fmt.Errorf("label not found")
+	}
+	return nil
+}
+
+// uniqueIDs 去掉重复的ID，保持原有顺序
+func uniqueIDs(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	unique := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,226 @@
+package services
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"mime"
+	"regexp"
+	"strings"
+	"time"
+
+	"role-play-ai/internal/models"
+)
+
+// 单个对话支持的导出格式
+const (
+	ExportFormatMarkdown = "markdown"
+	ExportFormatJSON     = "json"
+	ExportFormatHTML     = "html"
+	ExportFormatJSONL    = "jsonl"
+	ExportFormatText     = "txt"
+)
+
+const (
+	// ConversationExportVersion 对话导出JSON格式的版本号，格式不兼容时递增
+	ConversationExportVersion = 1
+
+	exportTimeLayout = "2006-01-02 15:04:05"
+)
+
+var unsafeFilenameChars = regexp.MustCompile(`[^\p{L}\p{N}_-]+`)
+
+// newConversationExport 构造对话导出结构，不包含角色的系统提示词
+func newConversationExport(conversation *models.Conversation, messages []*models.Message) *models.ConversationExport {
+	exported := *conversation
+	if conversation.Character != nil {
+		character := *conversation.Character
+		character.SystemPrompt = ""
+		exported.Character = &character
+	}
+	if messages == nil {
+		messages = []*models.Message{}
+	}
+
+	return &models.ConversationExport{
+		Version:      ConversationExportVersion,
+		ExportedAt:   time.Now().UTC(),
+		Conversation: &exported,
+		Messages:     messages,
+	}
+}
+
+// speakerName 返回消息发送者的显示名称
+func speakerName(conversation *models.Conversation, role string) string {
+	if role == "assistant" && conversation.Character != nil {
+		return conversation.Character.Name
+	}
+	return "我"
+}
+
+func characterName(conversation *models.Conversation) string {
+	if conversation.Character != nil {
+		return conversation.Character.Name
+	}
+	return ""
+}
+
+// renderConversationMarkdown 将对话渲染为Markdown，每条消息以发送者为小标题并标注时间。
+// audioFiles 为消息ID到导出包内录音文件相对路径的映射，没有对应文件的语音消息不输出链接，
+// 存储中的音频引用需要签名才能访问，不能直接写入导出文件
+func renderConversationMarkdown(conversation *models.Conversation, messages []*models.Message, audioFiles map[int]string) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "# %s\n\n", conversationTitle(conversation))
+	fmt.Fprintf(&b, "- 角色：%s\n", characterName(conversation))
+	fmt.Fprintf(&b, "- 创建时间：%s\n", conversation.CreatedAt.UTC().Format(exportTimeLayout))
+	fmt.Fprintf(&b, "- 消息数：%d\n", len(messages))
+
+	for _, message := range messages {
+		fmt.Fprintf(&b, "\n## %s\n\n", speakerName(conversation, message.Role))
+		fmt.Fprintf(&b, "*%s*\n\n", message.CreatedAt.UTC().Format(exportTimeLayout))
+		b.WriteString(strings.TrimSpace(message.Content))
+		b.WriteString("\n")
+		if path, ok := audioFiles[message.ID]; ok {
+			fmt.Fprintf(&b, "\n[语音](%s)\n", path)
+		}
+	}
+
+	return b.String()
+}
+
+// renderConversationText 将对话渲染为纯文本
+func renderConversationText(conversation *models.Conversation, messages []*models.Message) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s\n", conversationTitle(conversation))
+	fmt.Fprintf(&b, "角色：%s\n", characterName(conversation))
+	fmt.Fprintf(&b, "创建时间：%s\n", conversation.CreatedAt.UTC().Format(exportTimeLayout))
+
+	for _, message := range messages {
+		fmt.Fprintf(&b, "\n[%s] %s：\n", message.CreatedAt.UTC().Format(exportTimeLayout), speakerName(conversation, message.Role))
+		b.WriteString(strings.TrimSpace(message.Content))
+		b.WriteString("\n")
+	}
+
+	return b.String()
+}
+
+// renderConversationJSONL 将对话渲染为微调数据集常用的chat格式（一行一个对话），角色设定作为system消息
+func renderConversationJSONL(conversation *models.Conversation, messages []*models.Message) ([]byte, error) {
+	type chatMessage struct {
+		Role    string `json:"role"`
+		Content string `json:"content"`
+	}
+
+	chat := make([]chatMessage, 0, len(messages)+1)
+	if conversation.Character != nil && conversation.Character.SystemPrompt != "" {
+		chat = append(chat, chatMessage{Role: "system", Content: conversation.Character.SystemPrompt})
+	}
+	for _, message := range messages {
+		chat = append(chat, chatMessage{Role: message.Role, Content: message.Content})
+	}
+
+	// Encode会在末尾追加换行，正好作为JSONL的行分隔
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(map[string]interface{}{"messages": chat}); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+var conversationHTMLTemplate = template.Must(template.New("conversation").Funcs(template.FuncMap{
+	"time": func(t time.Time) string { return t.UTC().Format(exportTimeLayout) },
+}).Parse(`<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; padding: 24px; background: #f5f5f7; font-family: -apple-system, "PingFang SC", "Microsoft YaHei", sans-serif; color: #1d1d1f; }
+.container { max-width: 760px; margin: 0 auto; }
+header { display: flex; align-items: center; gap: 16px; margin-bottom: 24px; }
+header img { width: 64px; height: 64px; border-radius: 50%; background: #fff; }
+h1 { margin: 0; font-size: 22px; }
+.meta { color: #86868b; font-size: 13px; }
+.message { display: flex; gap: 12px; margin: 16px 0; }
+.message.user { flex-direction: row-reverse; }
+.message img { width: 36px; height: 36px; border-radius: 50%; background: #fff; flex-shrink: 0; }
+.bubble { max-width: 80%; padding: 10px 14px; border-radius: 14px; background: #fff; white-space: pre-wrap; word-wrap: break-word; line-height: 1.6; }
+.message.user .bubble { background: #0071e3; color: #fff; }
+.time { font-size: 12px; color: #86868b; margin-top: 4px; }
+</style>
+</head>
+<body>
+<div class="container">
+<header>
+{{if .AvatarURL}}<img src="{{.AvatarURL}}" alt="{{.CharacterName}}">{{end}}
+<div>
+<h1>{{.Title}}</h1>
+<div class="meta">{{.CharacterName}} · {{time .CreatedAt}} · {{len .Messages}} 条消息</div>
+</div>
+</header>
+{{range .Messages}}
+<div class="message {{.Role}}">
+{{if and (eq .Role "assistant") $.AvatarURL}}<img src="{{$.AvatarURL}}" alt="{{$.CharacterName}}">{{end}}
+<div>
+<div class="bubble">{{.Content}}</div>
+<div class="time">{{time .CreatedAt}}</div>
+</div>
+</div>
+{{end}}
+</div>
+</body>
+</html>
+`))
+
+// renderConversationHTML 将对话渲染为独立的HTML页面，头像以data URI内嵌，离线打开也能显示
+func renderConversationHTML(conversation *models.Conversation, messages []*models.Message, avatarURL template.URL) ([]byte, error) {
+	var b bytes.Buffer
+	err := conversationHTMLTemplate.Execute(&b, map[string]interface{}{
+		"Title":         conversationTitle(conversation),
+		"CharacterName": characterName(conversation),
+		"AvatarURL":     avatarURL,
+		"CreatedAt":     conversation.CreatedAt,
+		"Messages":      messages,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// imageDataURI 将图片内容编码为data URI，不是图片类型时返回空
+func imageDataURI(data []byte, contentType string) template.URL {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(mediaType, "image/") || len(data) == 0 {
+		return ""
+	}
+	// 内容来自本服务的存储或前端静态资源，类型已校验为图片
+	return template.URL("data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data))
+}
+
+func conversationTitle(conversation *models.Conversation) string {
+	if conversation.Title != "" {
+		return conversation.Title
+	}
+	return fmt.Sprintf("对话 %d", conversation.ID)
+}
+
+// exportFilename 生成安全的导出文件名（不含扩展名）
+func exportFilename(conversation *models.Conversation) string {
+	name := unsafeFilenameChars.ReplaceAllString(conversationTitle(conversation), "-")
+	name = strings.Trim(name, "-")
+	if runes := []rune(name); len(runes) > 50 {
+		name = string(runes[:50])
+	}
+	if name == "" {
+		return fmt.Sprintf("conversation-%d", conversation.ID)
+	}
+	return fmt.Sprintf("%d-%s", conversation.ID, name)
+}
@@ -0,0 +1,386 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"role-play-ai/internal/models"
+)
+
+const exportReadme = `个人数据导出
+
+profile.json                 账户信息、关联的单点登录身份和API密钥（不含密钥明文）
+characters.json              收藏的角色和对角色的评分、评价
+conversations.json           对话列表，包括回收站中尚未永久删除的对话（带有deleted_at）
+conversations/*.json         每个对话的完整消息和对AI回复的反馈（可用于导入）
+conversations/*.md           每个对话的Markdown版本，便于阅读，语音消息链接到audio目录中的录音
+audio/                       语音消息的录音，文件名与消息中的audio_url对应
+`
+
+type ExportService struct {
+	db                  *sql.DB
+	userService         *UserService
+	conversationService *ConversationService
+	messageService      *MessageService
+	apiKeyService       *APIKeyService
+	mediaService        *MediaService
+	appBaseURL          string
+	client              *http.Client
+}
+
+func NewExportService(db *sql.DB, userService *UserService, conversationService *ConversationService, messageService *MessageService, apiKeyService *APIKeyService, mediaService *MediaService, appBaseURL string) *ExportService {
+	return &ExportService{
+		db:                  db,
+		userService:         userService,
+		conversationService: conversationService,
+		messageService:      messageService,
+		apiKeyService:       apiKeyService,
+		mediaService:        mediaService,
+		appBaseURL:          strings.TrimRight(appBaseURL, "/"),
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// ExportedFile 导出的文件
+type ExportedFile struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// ExportConversation 按指定格式导出单个对话
+func (s *ExportService) ExportConversation(id, userID int, format string) (*ExportedFile, error) {
+	conversation, err := s.conversationService.GetConversation(id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages, err := s.messageService.GetMessages(id)
+	if err != nil {
+		return nil, err
+	}
+
+	file := &ExportedFile{Filename: exportFilename(conversation)}
+	switch format {
+	case ExportFormatMarkdown:
+		file.Filename += ".md"
+		file.ContentType = "text/markdown; charset=utf-8"
+		file.Data = []byte(renderConversationMarkdown(conversation, messages, nil))
+	case ExportFormatText:
+		file.Filename += ".txt"
+		file.ContentType = "text/plain; charset=utf-8"
+		file.Data = []byte(renderConversationText(conversation, messages))
+	case ExportFormatJSON:
+		file.Filename += ".json"
+		file.ContentType = "application/json; charset=utf-8"
+		file.Data, err = json.MarshalIndent(newConversationExport(conversation, messages), "", "  ")
+	case ExportFormatJSONL:
+		file.Filename += ".jsonl"
+		file.ContentType = "application/jsonl; charset=utf-8"
+		file.Data, err = renderConversationJSONL(conversation, messages)
+	case ExportFormatHTML:
+		file.Filename += ".html"
+		file.ContentType = "text/html; charset=utf-8"
+		file.Data, err = renderConversationHTML(conversation, messages, s.avatarDataURI(context.Background(), conversation))
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to render conversation: %w", err)
+	}
+
+	return file, nil
+}
+
+type linkedIdentity struct {
+	Issuer      string     `json:"issuer"`
+	Email       string     `json:"email,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// WriteUserArchive 将用户的全部个人数据以ZIP格式写入w，按对话逐个写入，不会一次性加载全部消息
+func (s *ExportService) WriteUserArchive(userID int, w io.Writer) error {
+	user, err := s.userService.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	identities, err := s.getIdentities(userID)
+	if err != nil {
+		return err
+	}
+
+	apiKeys, err := s.apiKeyService.ListAPIKeys(userID)
+	if err != nil {
+		return err
+	}
+
+	var twoFactorEnabled bool
+	if err := s.db.QueryRow("SELECT totp_enabled FROM users WHERE id = ?", userID).Scan(&twoFactorEnabled); err != nil {
+		return fmt.Errorf("failed to get two-factor status: %w", err)
+	}
+
+	favorites, err := s.getFavorites(userID)
+	if err != nil {
+		return err
+	}
+
+	ratings, err := s.getRatings(userID)
+	if err != nil {
+		return err
+	}
+
+	conversations, err := s.conversationService.GetConversations(userID)
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+
+	if err := writeZipFile(zw, "README.txt", []byte(exportReadme)); err != nil {
+		return err
+	}
+
+	profile := map[string]interface{}{
+		"exported_at":        time.Now().UTC(),
+		"user":               user,
+		"two_factor_enabled": twoFactorEnabled,
+		"identities":         identities,
+		"api_keys":           apiKeys,
+	}
+	if err := writeZipJSON(zw, "profile.json", profile); err != nil {
+		return err
+	}
+
+	if err := writeZipJSON(zw, "characters.json", map[string]interface{}{
+		"favorites": favorites,
+		"ratings":   ratings,
+	}); err != nil {
+		return err
+	}
+
+	summaries := make([]*models.Conversation, 0, len(conversations))
+	for _, conversation := range conversations {
+		messages, err := s.messageService.GetMessages(conversation.ID)
+		if err != nil {
+			return err
+		}
+		if err := loadMessageFeedback(s.db, messages); err != nil {
+			return err
+		}
+
+		export := newConversationExport(conversation, messages)
+		summaries = append(summaries, export.Conversation)
+
+		filename := "conversations/" + exportFilename(conversation)
+		if err := writeZipJSON(zw, filename+".json", export); err != nil {
+			return err
+		}
+		audioFiles, err := s.writeAudioFiles(context.Background(), zw, messages)
+		if err != nil {
+			return err
+		}
+		if err := writeZipFile(zw, filename+".md", []byte(renderConversationMarkdown(conversation, messages, audioFiles))); err != nil {
+			return err
+		}
+	}
+
+	if err := writeZipJSON(zw, "conversations.json", summaries); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func (s *ExportService) getIdentities(userID int) ([]*linkedIdentity, error) {
+	rows, err := s.db.Query(
+		"SELECT issuer, email, created_at, last_login_at FROM user_identities WHERE user_id = ? ORDER BY created_at",
+		userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query identities: %w", err)
+	}
+	defer rows.Close()
+
+	identities := []*linkedIdentity{}
+	for rows.Next() {
+		identity := &linkedIdentity{}
+		var email sql.NullString
+		var lastLoginAt sql.NullTime
+		if err := rows.Scan(&identity.Issuer, &email, &identity.CreatedAt, &lastLoginAt); err != nil {
+			return nil, fmt.Errorf("failed to scan identity: %w", err)
+		}
+		identity.Email = email.String
+		if lastLoginAt.Valid {
+			identity.LastLoginAt = &lastLoginAt.Time
+		}
+		identities = append(identities, identity)
+	}
+
+	return identities, nil
+}
+
+type exportedFavorite struct {
+	CharacterID   int       `json:"character_id"`
+	CharacterName string    `json:"character_name"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+func (s *ExportService) getFavorites(userID int) ([]*exportedFavorite, error) {
+	rows, err := s.db.Query(`
+		SELECT f.character_id, ch.name, f.created_at
+		FROM character_favorites f
+		JOIN characters ch ON ch.id = f.character_id
+		WHERE f.user_id = ?
+		ORDER BY f.created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query favorites: %w", err)
+	}
+	defer rows.Close()
+
+	favorites := []*exportedFavorite{}
+	for rows.Next() {
+		favorite := &exportedFavorite{}
+		if err := rows.Scan(&favorite.CharacterID, &favorite.CharacterName, &favorite.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan favorite: %w", err)
+		}
+		favorites = append(favorites, favorite)
+	}
+
+	return favorites, nil
+}
+
+func (s *ExportService) getRatings(userID int) ([]*models.CharacterRating, error) {
+	rows, err := s.db.Query(`
+		SELECT `+ratingColumns+`
+		FROM character_ratings r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.user_id = ?
+		ORDER BY r.created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ratings: %w", err)
+	}
+	defer rows.Close()
+
+	ratings := []*models.CharacterRating{}
+	for rows.Next() {
+		rating, err := scanRating(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan rating: %w", err)
+		}
+		ratings = append(ratings, rating)
+	}
+
+	return ratings, nil
+}
+
+// writeAudioFiles 将消息中本服务保存的录音写入audio目录，已删除的文件跳过，
+// 返回消息ID到录音文件相对于conversations目录的路径
+func (s *ExportService) writeAudioFiles(ctx context.Context, zw *zip.Writer, messages []*models.Message) (map[int]string, error) {
+	files := make(map[int]string)
+	for _, message := range messages {
+		name, data, err := s.mediaService.readMessageAudio(ctx, message.AudioURL)
+		if err != nil {
+			return nil, err
+		}
+		if name == "" {
+			continue
+		}
+		// 音频已经是压缩格式，直接存储
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     "audio/" + name,
+			Method:   zip.Store,
+			Modified: message.CreatedAt,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create audio/%s: %w", name, err)
+		}
+		if _, err := f.Write(data); err != nil {
+			return nil, fmt.Errorf("failed to write audio/%s: %w", name, err)
+		}
+		files[message.ID] = "../audio/" + name
+	}
+	return files, nil
+}
+
+// avatarDataURI 读取角色头像并编码为data URI。本服务保存的头像从存储读取，
+// 相对路径的头像是前端静态资源，从前端地址获取；外部地址不获取，读取失败时不显示头像
+func (s *ExportService) avatarDataURI(ctx context.Context, conversation *models.Conversation) template.URL {
+	if conversation.Character == nil || conversation.Character.AvatarURL == "" {
+		return ""
+	}
+	avatarURL := conversation.Character.AvatarURL
+
+	data, contentType, err := s.mediaService.readAvatar(ctx, avatarURL)
+	if err == nil && data == nil && mediaKey(avatarURL) == "" &&
+		strings.HasPrefix(avatarURL, "/") && !strings.HasPrefix(avatarURL, "//") {
+		data, contentType, err = s.fetchStaticAvatar(ctx, avatarURL)
+	}
+	if err != nil {
+		log.Printf("Failed to embed avatar in export: %v", err)
+		return ""
+	}
+	return imageDataURI(data, contentType)
+}
+
+// fetchStaticAvatar 从前端地址获取静态资源中的头像
+func (s *ExportService) fetchStaticAvatar(ctx context.Context, path string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.appBaseURL+path, nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create avatar request: %w", err)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to fetch avatar %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("failed to fetch avatar %s: status %d", path, resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, MaxAvatarFileSize+1))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read avatar %s: %w", path, err)
+	}
+	if len(data) > MaxAvatarFileSize {
+		return nil, "", fmt.Errorf("avatar %s too large", path)
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+func writeZipJSON(zw *zip.Writer, name string, value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", name, err)
+	}
+	return writeZipFile(zw, name, data)
+}
+
+func writeZipFile(zw *zip.Writer, name string, data []byte) error {
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
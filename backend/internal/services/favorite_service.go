@@ -0,0 +1,83 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+
+	"role-play-ai/internal/models"
+)
+
+type FavoriteService struct {
+	db               *sql.DB
+	characterService *CharacterService
+}
+
+func NewFavoriteService(db *sql.DB, characterService *CharacterService) *FavoriteService {
+	return &FavoriteService{db: db, characterService: characterService}
+}
+
+// AddFavorite 收藏角色，重复收藏不报错
+func (s *FavoriteService) AddFavorite(userID, characterID int) error {
+	if _, err := s.characterService.GetCharacter(characterID); err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec("INSERT IGNORE INTO character_favorites (user_id, character_id) VALUES (?, ?)", userID, characterID)
+	if err != nil {
+		return fmt.Errorf("failed to add favorite: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+		addCharacterStatsDelta(characterID, &characterStatsDelta{Favorites: 1})
+	}
+	return nil
+}
+
+// RemoveFavorite 取消收藏
+func (s *FavoriteService) RemoveFavorite(userID, characterID int) error {
+	result, err := s.db.Exec("DELETE FROM character_favorites WHERE user_id = ? AND character_id = ?", userID, characterID)
+	if err != nil {
+		return fmt.Errorf("failed to remove favorite: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("favorite not found")
+	}
+
+	addCharacterStatsDelta(characterID, &characterStatsDelta{Favorites: -1})
+	return nil
+}
+
+// GetFavorites 获取用户收藏的已发布角色，最近收藏的在前
+func (s *FavoriteService) GetFavorites(userID int) ([]*models.Character, error) {
+	rows, err := s.db.Query(`
+		SELECT `+characterColumns+`
+		FROM `+characterTables+`
+		JOIN character_favorites f ON f.character_id = ch.id
+		WHERE f.user_id = ? AND ch.is_published = TRUE
+		ORDER BY f.created_at DESC, ch.id
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query favorites: %w", err)
+	}
+	defer rows.Close()
+
+	characters := []*models.Character{}
+	for rows.Next() {
+		character, err := scanCharacter(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan character: %w", err)
+		}
+		characters = append(characters, character)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query favorites: %w", err)
+	}
+
+	if err := s.characterService.loadTags(characters); err != nil {
+		return nil, err
+	}
+	return characters, nil
+}
@@ -0,0 +1,373 @@
+package services
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"role-play-ai/internal/models"
+)
+
+// maxPreferencePairs 单次导出的偏好对上限
+const maxPreferencePairs = 50000
+
+type FeedbackService struct {
+	db *sql.DB
+}
+
+func NewFeedbackService(db *sql.DB) *FeedbackService {
+	return &FeedbackService{db: db}
+}
+
+const feedbackColumns = "message_id, rating, reason, comment, created_at, updated_at"
+
+func scanFeedback(row rowScanner) (*models.MessageFeedback, error) {
+	feedback := &models.MessageFeedback{}
+	var reason, comment sql.NullString
+	err := row.Scan(&feedback.MessageID, &feedback.Rating, &reason, &comment, &feedback.CreatedAt, &feedback.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	feedback.Reason = reason.String
+	feedback.Comment = comment.String
+	return feedback, nil
+}
+
+// SetFeedback 提交或修改对AI消息的反馈，消息必须属于该用户的对话
+func (s *FeedbackService) SetFeedback(userID, conversationID, messageID int, req *models.MessageFeedbackRequest) (*models.MessageFeedback, error) {
+	if req.Rating == models.FeedbackUp && req.Reason != "" {
+		return nil, fmt.Errorf("reason is only allowed for negative feedback")
+	}
+
+	var role string
+	var characterID int
+	err := s.db.QueryRow(`
+		SELECT m.role, c.character_id
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.id = ? AND m.conversation_id = ? AND c.user_id = ? AND c.deleted_at IS NULL
+	`, messageID, conversationID, userID).Scan(&role, &characterID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("message not found")
+		}
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+	if role != "assistant" {
+		return nil, fmt.Errorf("feedback is only allowed on assistant messages")
+	}
+
+	hash, err := s.contextHash(conversationID, characterID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	var reason, comment interface{}
+	if req.Reason != "" {
+		reason = req.Reason
+	}
+	if text := strings.TrimSpace(req.Comment); text != "" {
+		comment = text
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO message_feedback (message_id, character_id, rating, reason, comment, context_hash) VALUES (?, ?, ?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE rating = VALUES(rating), reason = VALUES(reason), comment = VALUES(comment), context_hash = VALUES(context_hash)
+	`, messageID, characterID, req.Rating, reason, comment, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save feedback: %w", err)
+	}
+
+	feedback, err := scanFeedback(s.db.QueryRow("SELECT "+feedbackColumns+" FROM message_feedback WHERE message_id = ?", messageID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get feedback: %w", err)
+	}
+	return feedback, nil
+}
+
+// DeleteFeedback 撤销反馈
+func (s *FeedbackService) DeleteFeedback(userID, conversationID, messageID int) error {
+	result, err := s.db.Exec(`
+		DELETE f FROM message_feedback f
+		JOIN messages m ON m.id = f.message_id
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE f.message_id = ? AND m.conversation_id = ? AND c.user_id = ? AND c.deleted_at IS NULL
+	`, messageID, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete feedback: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("feedback not found")
+	}
+	return nil
+}
+
+// contextHash 计算角色和消息之前全部对话内容的摘要，相同摘要的回复面对的是完全相同的上下文
+func (s *FeedbackService) contextHash(conversationID, characterID, messageID int) (string, error) {
+	rows, err := s.db.Query(
+		"SELECT role, content FROM messages WHERE conversation_id = ? AND id < ? ORDER BY id",
+		conversationID, messageID,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to query message context: %w", err)
+	}
+	defer rows.Close()
+
+	h := sha256.New()
+	fmt.Fprintf(h, "character:%d\x00", characterID)
+	for rows.Next() {
+		var role, content string
+		if err := rows.Scan(&role, &content); err != nil {
+			return "", fmt.Errorf("failed to scan message context: %w", err)
+		}
+		fmt.Fprintf(h, "%s\x00%s\x00", role, content)
+	}
+	if err := rows.Err(); err != nil {
+		return "", fmt.Errorf("failed to query message context: %w", err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadMessageFeedback 批量填充AI消息的反馈
+func loadMessageFeedback(db *sql.DB, messages []*models.Message) error {
+	byID := map[int]*models.Message{}
+	ids := []int{}
+	for _, message := range messages {
+		if message.Role == "assistant" {
+			byID[message.ID] = message
+			ids = append(ids, message.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	placeholders, args := inPlaceholders(ids)
+	rows, err := db.Query("SELECT "+feedbackColumns+" FROM message_feedback WHERE message_id IN ("+placeholders+")", args...)
+	if err != nil {
+		return fmt.Errorf("failed to query feedback: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		feedback, err := scanFeedback(rows)
+		if err != nil {
+			return fmt.Errorf("failed to scan feedback: %w", err)
+		}
+		byID[feedback.MessageID].Feedback = feedback
+	}
+	return rows.Err()
+}
+
+// feedbackConditions 构造反馈筛选条件，f为message_feedback的别名
+func feedbackConditions(filter *models.FeedbackFilter, alias string) (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+	if filter.CharacterID != 0 {
+		conditions = append(conditions, alias+".character_id = ?")
+		args = append(args, filter.CharacterID)
+	}
+	if filter.From != nil {
+		conditions = append(conditions, alias+".updated_at >= ?")
+		args = append(args, *filter.From)
+	}
+	if filter.To != nil {
+		conditions = append(conditions, alias+".updated_at < ?")
+		args = append(args, *filter.To)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// Report 按角色和模型汇总反馈，负面反馈按原因分类计数。没有记录模型的旧消息归为 "unknown"，回收站中的对话不计入
+func (s *FeedbackService) Report(filter *models.FeedbackFilter) ([]*models.FeedbackReportRow, error) {
+	where, args := feedbackConditions(filter, "f")
+	rows, err := s.db.Query(`
+		SELECT f.character_id, ch.name, COALESCE(m.model, 'unknown'), f.rating, COALESCE(f.reason, ''), COUNT(*)
+		FROM message_feedback f
+		JOIN messages m ON m.id = f.message_id
+		JOIN conversations c ON c.id = m.conversation_id AND c.deleted_at IS NULL
+		JOIN characters ch ON ch.id = f.character_id
+		WHERE `+where+`
+		GROUP BY f.character_id, ch.name, COALESCE(m.model, 'unknown'), f.rating, COALESCE(f.reason, '')
+		ORDER BY f.character_id, COALESCE(m.model, 'unknown')
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query feedback report: %w", err)
+	}
+	defer rows.Close()
+
+	report := []*models.FeedbackReportRow{}
+	byKey := map[string]*models.FeedbackReportRow{}
+	for rows.Next() {
+		var characterID, count int
+		var characterName, model, rating, reason string
+		if err := rows.Scan(&characterID, &characterName, &model, &rating, &reason, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan feedback report: %w", err)
+		}
+
+		key := strconv.Itoa(characterID) + "\x00" + model
+		row, ok := byKey[key]
+		if !ok {
+			row = &models.FeedbackReportRow{
+				CharacterID:   characterID,
+				CharacterName: characterName,
+				Model:         model,
+				Reasons:       map[string]int{},
+			}
+			byKey[key] = row
+			report = append(report, row)
+		}
+
+		row.Total += count
+		if rating == models.FeedbackUp {
+			row.Up += count
+		} else {
+			row.Down += count
+			if reason != "" {
+				row.Reasons[reason] += count
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query feedback report: %w", err)
+	}
+
+	for _, row := range report {
+		row.UpRate = float64(row.Up) / float64(row.Total)
+	}
+	return report, nil
+}
+
+// preferenceMessage 偏好数据中的一条消息
+type preferenceMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// preferenceResponse 偏好对中的一个回复
+type preferenceResponse struct {
+	MessageID int    `json:"message_id"`
+	Content   string `json:"content"`
+	Model     string `json:"model,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Comment   string `json:"comment,omitempty"`
+}
+
+// preferencePair 同一上下文下获得好评（chosen）和差评（rejected）的两个候选回复
+type preferencePair struct {
+	CharacterID   int                  `json:"character_id"`
+	CharacterName string               `json:"character_name"`
+	SystemPrompt  string               `json:"system_prompt"`
+	Prompt        []*preferenceMessage `json:"prompt"`
+	Chosen        *preferenceResponse  `json:"chosen"`
+	Rejected      *preferenceResponse  `json:"rejected"`
+	RatedAt       time.Time            `json:"rated_at"`
+}
+
+// WritePreferencePairs 以JSONL格式写出偏好对，每行一对，返回写出的数量。
+// 只有上下文摘要相同、一个好评一个差评、内容不同的两条回复才组成一对，时间筛选按差评的更新时间，回收站中的对话不参与
+func (s *FeedbackService) WritePreferencePairs(filter *models.FeedbackFilter, w io.Writer) (int, error) {
+	where, args := feedbackConditions(filter, "rejected")
+	rows, err := s.db.Query(`
+		SELECT rejected.character_id, ch.name, ch.system_prompt,
+			cm.id, cm.conversation_id, cm.content, COALESCE(cm.model, ''),
+			rm.id, rm.content, COALESCE(rm.model, ''), COALESCE(rejected.reason, ''), COALESCE(rejected.comment, ''),
+			GREATEST(chosen.updated_at, rejected.updated_at)
+		FROM message_feedback rejected
+		JOIN message_feedback chosen
+			ON chosen.context_hash = rejected.context_hash AND chosen.character_id = rejected.character_id AND chosen.rating = 'up'
+		JOIN messages cm ON cm.id = chosen.message_id
+		JOIN messages rm ON rm.id = rejected.message_id
+		JOIN conversations cc ON cc.id = cm.conversation_id AND cc.deleted_at IS NULL
+		JOIN conversations rc ON rc.id = rm.conversation_id AND rc.deleted_at IS NULL
+		JOIN characters ch ON ch.id = rejected.character_id
+		WHERE rejected.rating = 'down' AND cm.content != rm.content AND `+where+`
+		ORDER BY rejected.context_hash, cm.id, rm.id
+		LIMIT ?
+	`, append(args, maxPreferencePairs)...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query preference pairs: %w", err)
+	}
+	defer rows.Close()
+
+	type pairRow struct {
+		pair                 *preferencePair
+		chosenConversationID int
+	}
+	pairs := []*pairRow{}
+	for rows.Next() {
+		pair := &preferencePair{Chosen: &preferenceResponse{}, Rejected: &preferenceResponse{}}
+		row := &pairRow{pair: pair}
+		err := rows.Scan(&pair.CharacterID, &pair.CharacterName, &pair.SystemPrompt,
+			&pair.Chosen.MessageID, &row.chosenConversationID, &pair.Chosen.Content, &pair.Chosen.Model,
+			&pair.Rejected.MessageID, &pair.Rejected.Content, &pair.Rejected.Model, &pair.Rejected.Reason, &pair.Rejected.Comment,
+			&pair.RatedAt)
+		if err != nil {
+			return 0, fmt.Errorf("failed to scan preference pair: %w", err)
+		}
+		pairs = append(pairs, row)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query preference pairs: %w", err)
+	}
+	rows.Close()
+
+	// 上下文相同的偏好对共用一份对话历史，按好评消息缓存
+	prompts := map[int][]*preferenceMessage{}
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	encoder.SetEscapeHTML(false)
+	for _, row := range pairs {
+		prompt, ok := prompts[row.pair.Chosen.MessageID]
+		if !ok {
+			prompt, err = s.promptBefore(row.chosenConversationID, row.pair.Chosen.MessageID)
+			if err != nil {
+				return 0, err
+			}
+			prompts[row.pair.Chosen.MessageID] = prompt
+		}
+		row.pair.Prompt = prompt
+
+		if err := encoder.Encode(row.pair); err != nil {
+			return 0, fmt.Errorf("failed to write preference pair: %w", err)
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to write preference pairs: %w", err)
+	}
+
+	return len(pairs), nil
+}
+
+// promptBefore 获取消息之前的对话历史
+func (s *FeedbackService) promptBefore(conversationID, messageID int) ([]*preferenceMessage, error) {
+	rows, err := s.db.Query(
+		"SELECT role, content FROM messages WHERE conversation_id = ? AND id < ? ORDER BY id",
+		conversationID, messageID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query prompt: %w", err)
+	}
+	defer rows.Close()
+
+	prompt := []*preferenceMessage{}
+	for rows.Next() {
+		message := &preferenceMessage{}
+		if err := rows.Scan(&message.Role, &message.Content); err != nil {
+			return nil, fmt.Errorf("failed to scan prompt: %w", err)
+		}
+		prompt = append(prompt, message)
+	}
+	return prompt, rows.Err()
+}
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"role-play-ai/internal/models"
+)
+
+// 每个用户最多可创建的文件夹和标签数
+const (
+	maxFoldersPerUser = 100
+	maxLabelsPerUser  = 100
+)
+
+// FolderService 管理用户的对话文件夹和标签
+type FolderService struct {
+	db *sql.DB
+}
+
+func NewFolderService(db *sql.DB) *FolderService {
+	return &FolderService{db: db}
+}
+
+// GetFolders 获取用户的文件夹及其中的对话数，按名称排序
+func (s *FolderService) GetFolders(userID int) ([]*models.ConversationFolder, error) {
+	rows, err := s.db.Query(`
+		SELECT f.id, f.name, f.created_at, COUNT(c.id)
+		FROM conversation_folders f
+		LEFT JOIN conversations c ON c.folder_id = f.id AND c.deleted_at IS NULL
+		WHERE f.user_id = ?
+		GROUP BY f.id, f.name, f.created_at
+		ORDER BY f.name
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query folders: %w", err)
+	}
+	defer rows.Close()
+
+	folders := []*models.ConversationFolder{}
+	for rows.Next() {
+		folder := &models.ConversationFolder{}
+		if err := rows.Scan(&folder.ID, &folder.Name, &folder.CreatedAt, &folder.ConversationCount); err != nil {
+			return nil, fmt.Errorf("failed to scan folder: %w", err)
+		}
+		folders = append(folders, folder)
+	}
+	return folders, rows.Err()
+}
+
+// CreateFolder 创建文件夹
+func (s *FolderService) CreateFolder(userID int, name string) (*models.ConversationFolder, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("name cannot be empty")
+	}
+
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM conversation_folders WHERE user_id = ?", userID).Scan(&count); err != nil {
+		return nil, fmt.Errorf("failed to count folders: %w", err)
+	}
+	if count >= maxFoldersPerUser {
+		return nil, fmt.Errorf("too many folders")
+	}
+
+	result, err := s.db.Exec("INSERT INTO conversation_folders (user_id, name) VALUES (?, ?)", userID, name)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return nil, fmt.Errorf("folder already exists")
+		}
+		return nil, fmt.Errorf("failed to create folder: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get folder ID: %w", err)
+	}
+
+	return s.getFolder(int(id), userID)
+}
+
+// RenameFolder 重命名文件夹
+func (s *FolderService) RenameFolder(id, userID int, name string) (*models.ConversationFolder, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("name cannot be empty")
+	}
+
+	if _, err := s.db.Exec("UPDATE conversation_folders SET name = ? WHERE id = ? AND user_id = ?", name, id, userID); err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return nil, fmt.Errorf("folder already exists")
+		}
+		return nil, fmt.Errorf("failed to rename folder: %w", err)
+	}
+
+	return s.getFolder(id, userID)
+}
+
+// DeleteFolder 删除文件夹，其中的对话移出文件夹但不会被删除
+func (s *FolderService) DeleteFolder(id, userID int) error {
+	result, err := s.db.Exec("DELETE FROM conversation_folders WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete folder: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("folder not found")
+	}
+	return nil
+}
+
+func (s *FolderService) getFolder(id, userID int) (*models.ConversationFolder, error) {
+	folder := &models.ConversationFolder{}
+	err := s.db.QueryRow(`
+		SELECT f.id, f.name, f.created_at, (SELECT COUNT(*) FROM conversations c WHERE c.folder_id = f.id AND c.deleted_at IS NULL)
+		FROM conversation_folders f
+		WHERE f.id = ? AND f.user_id = ?
+	`, id, userID).Scan(&folder.ID, &folder.Name, &folder.CreatedAt, &folder.ConversationCount)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("folder not found")
+		}
+		return nil, fmt.Errorf("failed to get folder: %w", err)
+	}
+	return folder, nil
+}
+
+// GetLabels 获取用户的标签及使用该标签的对话数，按名称排序
+func (s *FolderService) GetLabels(userID int) ([]*models.ConversationLabel, error) {
+	rows, err := s.db.Query(`
+		SELECT cl.id, cl.name, cl.created_at, COUNT(c.id)
+		FROM conversation_labels cl
+		LEFT JOIN conversation_label_links l ON l.label_id = cl.id
+		LEFT JOIN conversations c ON c.id = l.conversation_id AND c.deleted_at IS NULL
+		WHERE cl.user_id = ?
+		GROUP BY cl.id, cl.name, cl.created_at
+		ORDER BY cl.name
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query labels: %w", err)
+	}
+	defer rows.Close()
+
+	labels := []*models.ConversationLabel{}
+	for rows.Next() {
+		label, err := scanLabel(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan label: %w", err)
+		}
+		labels = append(labels, label)
+	}
+	return labels, rows.Err()
+}
+
+// CreateLabel 创建标签
+func (s *FolderService) CreateLabel(userID int, name string) (*models.ConversationLabel, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("name cannot be empty")
+	}
+
+	var count int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM conversation_labels WHERE user_id = ?", userID).Scan(&count); err != nil {
+		return nil, fmt.Errorf("failed to count labels: %w", err)
+	}
+	if count >= maxLabelsPerUser {
+		return nil, fmt.Errorf("too many labels")
+	}
+
+	result, err := s.db.Exec("INSERT INTO conversation_labels (user_id, name) VALUES (?, ?)", userID, name)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return nil, fmt.Errorf("label already exists")
+		}
+		return nil, fmt.Errorf("failed to create label: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get label ID: %w", err)
+	}
+
+	return s.getLabel(int(id), userID)
+}
+
+// RenameLabel 重命名标签
+func (s *FolderService) RenameLabel(id, userID int, name string) (*models.ConversationLabel, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, fmt.Errorf("name cannot be empty")
+	}
+
+	if _, err := s.db.Exec("UPDATE conversation_labels SET name = ? WHERE id = ? AND user_id = ?", name, id, userID); err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return nil, fmt.Errorf("label already exists")
+		}
+		return nil, fmt.Errorf("failed to rename label: %w", err)
+	}
+
+	return s.getLabel(id, userID)
+}
+
+// DeleteLabel 删除标签，同时从所有对话上移除
+func (s *FolderService) DeleteLabel(id, userID int) error {
+	result, err := s.db.Exec("DELETE FROM conversation_labels WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete label: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("label not found")
+	}
+	return nil
+}
+
+func (s *FolderService) getLabel(id, userID int) (*models.ConversationLabel, error) {
+	label, err := scanLabel(s.db.QueryRow(`
+		SELECT cl.id, cl.name, cl.created_at, (SELECT COUNT(*) FROM conversation_label_links l JOIN conversations c ON c.id = l.conversation_id WHERE l.label_id = cl.id AND c.deleted_at IS NULL)
+		FROM conversation_labels cl
+		WHERE cl.id = ? AND cl.user_id = ?
+	`, id, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("label not found")
+		}
+		return nil, fmt.Errorf("failed to get label: %w", err)
+	}
+	return label, nil
+}
+
+func scanLabel(row rowScanner) (*models.ConversationLabel, error) {
+	label := &models.ConversationLabel{}
+	var createdAt sql.NullTime
+	var count int
+	if err := row.Scan(&label.ID, &label.Name, &createdAt, &count); err != nil {
+		return nil, err
+	}
+	label.CreatedAt = &createdAt.Time
+	label.ConversationCount = &count
+	return label, nil
+}
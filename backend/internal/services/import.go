@@ -0,0 +1,336 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"role-play-ai/internal/models"
+)
+
+// 支持导入的格式
+const (
+	ImportFormatJSON       = "json"       // 本系统导出的JSON
+	ImportFormatJSONL      = "jsonl"      // 每行一条消息或一个对话的JSONL
+	ImportFormatTranscript = "transcript" // "名字: 内容" 形式的纯文本
+)
+
+const (
+	maxImportMessages      = 5000
+	maxImportMessageLength = 60000 // messages.content 为 TEXT 类型，最大64KB
+	maxSkippedContent      = 100
+)
+
+// defaultUserSpeakers 纯文本和JSONL中默认视为用户本人的发言者名称（不区分大小写）
+var defaultUserSpeakers = []string{"user", "human", "you", "me", "我", "用户"}
+
+var (
+	// 本系统纯文本导出的发言行，例如 "[2024-01-02 15:04:05] 哈利·波特："
+	textExportSpeakerLine = regexp.MustCompile(`^\[(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2})\] (.{1,100})[:：]$`)
+	// 通用的 "名字: 内容" 发言行
+	transcriptSpeakerLine = regexp.MustCompile(`^([^:：\[\]]{1,50})[:：]\s*(.*)$`)
+)
+
+// importedMessage 解析后待导入的消息
+type importedMessage struct {
+	Role      string
+	Speaker   string
+	Content   string
+	CreatedAt *time.Time
+}
+
+// parsedImport 解析结果
+type parsedImport struct {
+	Format        string
+	Title         string
+	CharacterName string
+	SystemPrompt  string
+	Messages      []*importedMessage
+	Skipped       []*models.ImportSkippedLine
+}
+
+func (p *parsedImport) skip(line int, reason, content string) {
+	content = strings.TrimSpace(content)
+	if utf8.RuneCountInString(content) > maxSkippedContent {
+		content = string([]rune(content)[:maxSkippedContent]) + "…"
+	}
+	p.Skipped = append(p.Skipped, &models.ImportSkippedLine{Line: line, Reason: reason, Content: content})
+}
+
+// add 添加一条消息，内容为空或超长时记为跳过
+func (p *parsedImport) add(line int, message *importedMessage) {
+	message.Content = strings.TrimSpace(message.Content)
+	switch {
+	case message.Content == "":
+		p.skip(line, "empty message", "")
+	case len(message.Content) > maxImportMessageLength:
+		p.skip(line, "message too long", message.Content)
+	case len(p.Messages) >= maxImportMessages:
+		p.skip(line, "too many messages", message.Content)
+	default:
+		if message.Role == "assistant" && p.CharacterName == "" && message.Speaker != "" {
+			p.CharacterName = message.Speaker
+		}
+		p.Messages = append(p.Messages, message)
+	}
+}
+
+// detectImportFormat 根据内容推断导入格式
+func detectImportFormat(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] == '{' {
+		var probe map[string]json.RawMessage
+		if json.Unmarshal(trimmed, &probe) == nil {
+			if _, ok := probe["conversation"]; ok {
+				return ImportFormatJSON
+			}
+		}
+		return ImportFormatJSONL
+	}
+	return ImportFormatTranscript
+}
+
+// parseImport 按格式解析导入内容，userSpeakers为视为用户本人的发言者名称
+func parseImport(data []byte, format string, userSpeakers []string) (*parsedImport, error) {
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("file must be UTF-8 encoded")
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	if format == "" {
+		format = detectImportFormat(data)
+	}
+
+	isUser := func(speaker string) bool {
+		speaker = strings.TrimSpace(speaker)
+		for _, name := range append(userSpeakers, defaultUserSpeakers...) {
+			if strings.EqualFold(speaker, name) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var parsed *parsedImport
+	var err error
+	switch format {
+	case ImportFormatJSON:
+		parsed, err = parseJSONExport(data)
+	case ImportFormatJSONL:
+		parsed, err = parseJSONL(data, isUser)
+	case ImportFormatTranscript:
+		parsed, err = parseTranscript(data, isUser)
+	default:
+		return nil, fmt.Errorf("unsupported import format: %s", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(parsed.Messages) == 0 {
+		return nil, fmt.Errorf("no messages found in file")
+	}
+	return parsed, nil
+}
+
+// parseJSONExport 解析本系统导出的对话JSON
+func parseJSONExport(data []byte) (*parsedImport, error) {
+	var export models.ConversationExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, fmt.Errorf("invalid JSON export: %w", err)
+	}
+	if export.Version > ConversationExportVersion {
+		return nil, fmt.Errorf("unsupported export version: %d", export.Version)
+	}
+
+	parsed := &parsedImport{Format: ImportFormatJSON}
+	if export.Conversation != nil {
+		parsed.Title = export.Conversation.Title
+		if export.Conversation.Character != nil {
+			parsed.CharacterName = export.Conversation.Character.Name
+		}
+	}
+
+	for i, message := range export.Messages {
+		// JSON没有行号，用消息序号代替
+		if message == nil || (message.Role != "user" && message.Role != "assistant") {
+			parsed.skip(i+1, "unknown role", "")
+			continue
+		}
+		imported := &importedMessage{Role: message.Role, Content: message.Content}
+		if !message.CreatedAt.IsZero() {
+			createdAt := message.CreatedAt
+			imported.CreatedAt = &createdAt
+		}
+		parsed.add(i+1, imported)
+	}
+
+	return parsed, nil
+}
+
+// jsonlEntry JSONL中的一行，兼容 {"role","content"}、{"name","content"} 和 ShareGPT 的 {"from","value"}
+type jsonlEntry struct {
+	Role     string        `json:"role"`
+	Name     string        `json:"name"`
+	Content  string        `json:"content"`
+	From     string        `json:"from"`
+	Value    string        `json:"value"`
+	Messages []*jsonlEntry `json:"messages"`
+}
+
+// parseJSONL 解析JSONL，每行可以是一条消息，也可以是包含messages数组的整段对话（微调数据集格式）
+func parseJSONL(data []byte, isUser func(string) bool) (*parsedImport, error) {
+	parsed := &parsedImport{Format: ImportFormatJSONL}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportMessageLength*4)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var entry jsonlEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			parsed.skip(lineNumber, "invalid JSON", line)
+			continue
+		}
+
+		entries := []*jsonlEntry{&entry}
+		if len(entry.Messages) > 0 {
+			entries = entry.Messages
+		}
+		for _, e := range entries {
+			addJSONLEntry(parsed, lineNumber, e, isUser)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return parsed, nil
+}
+
+func addJSONLEntry(parsed *parsedImport, line int, entry *jsonlEntry, isUser func(string) bool) {
+	content := entry.Content
+	if content == "" {
+		content = entry.Value
+	}
+
+	role := strings.ToLower(entry.Role)
+	if role == "" {
+		role = strings.ToLower(entry.From)
+	}
+
+	switch role {
+	case "system":
+		// 系统提示词用作新建角色的设定
+		if parsed.SystemPrompt == "" {
+			parsed.SystemPrompt = strings.TrimSpace(content)
+		}
+		return
+	case "user", "human":
+		parsed.add(line, &importedMessage{Role: "user", Speaker: entry.Name, Content: content})
+	case "assistant", "gpt", "bot", "model", "ai", "char", "character":
+		parsed.add(line, &importedMessage{Role: "assistant", Speaker: entry.Name, Content: content})
+	case "":
+		if entry.Name == "" {
+			parsed.skip(line, "missing role", content)
+			return
+		}
+		if isUser(entry.Name) {
+			parsed.add(line, &importedMessage{Role: "user", Speaker: entry.Name, Content: content})
+		} else {
+			parsed.add(line, &importedMessage{Role: "assistant", Speaker: entry.Name, Content: content})
+		}
+	default:
+		parsed.skip(line, "unknown role", content)
+	}
+}
+
+// parseTranscript 解析 "名字: 内容" 形式的纯文本，没有发言者前缀的行视为上一条消息的续行。
+// 本系统导出的纯文本只按带时间的发言行分段，开头的标题等信息记为跳过
+func parseTranscript(data []byte, isUser func(string) bool) (*parsedImport, error) {
+	parsed := &parsedImport{Format: ImportFormatTranscript}
+	textExport := false
+	for _, line := range strings.Split(string(data), "\n") {
+		if textExportSpeakerLine.MatchString(strings.TrimRight(line, " \t\r")) {
+			textExport = true
+			break
+		}
+	}
+
+	var current *importedMessage
+	currentLine := 0
+	flush := func() {
+		if current != nil {
+			parsed.add(currentLine, current)
+			current = nil
+		}
+	}
+	start := func(line int, speaker, content string, createdAt *time.Time) {
+		flush()
+		role := "assistant"
+		if isUser(speaker) {
+			role = "user"
+		}
+		current = &importedMessage{Role: role, Speaker: strings.TrimSpace(speaker), Content: content, CreatedAt: createdAt}
+		currentLine = line
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportMessageLength*4)
+	lineNumber := 0
+	pendingBlank := false
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if strings.TrimSpace(line) == "" {
+			pendingBlank = current != nil
+			continue
+		}
+
+		if match := textExportSpeakerLine.FindStringSubmatch(line); match != nil {
+			var createdAt *time.Time
+			if t, err := time.Parse(exportTimeLayout, match[1]); err == nil {
+				createdAt = &t
+			}
+			start(lineNumber, match[2], "", createdAt)
+			pendingBlank = false
+			continue
+		}
+
+		if match := transcriptSpeakerLine.FindStringSubmatch(line); match != nil && !textExport {
+			start(lineNumber, match[1], match[2], nil)
+			pendingBlank = false
+			continue
+		}
+
+		if current == nil {
+			parsed.skip(lineNumber, "no speaker", line)
+			continue
+		}
+		if current.Content != "" {
+			if pendingBlank {
+				current.Content += "\n\n"
+			} else {
+				current.Content += "\n"
+			}
+		}
+		current.Content += line
+		pendingBlank = false
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	flush()
+
+	return parsed, nil
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"role-play-ai/internal/models"
+)
+
+// MaxImportFileSize 导入文件大小上限
+const MaxImportFileSize = 5 << 20
+
+type ImportService struct {
+	db                  *sql.DB
+	characterService    *CharacterService
+	conversationService *ConversationService
+	auditService        *AuditService
+	searchIndex         SearchIndex
+}
+
+func NewImportService(db *sql.DB, characterService *CharacterService, conversationService *ConversationService, auditService *AuditService, searchIndex SearchIndex) *ImportService {
+	return &ImportService{
+		db:                  db,
+		characterService:    characterService,
+		conversationService: conversationService,
+		auditService:        auditService,
+		searchIndex:         searchIndex,
+	}
+}
+
+// ImportOptions 导入选项
+type ImportOptions struct {
+	Format        string   // 为空时自动识别
+	CharacterID   int      // 指定已发布的角色或用户自己的私有角色
+	CharacterName string   // 按名称匹配或新建私有角色
+	UserNames     []string // 额外视为用户本人的发言者名称
+	Title         string
+}
+
+// ImportConversation 导入对话，角色和对话、消息在同一事务中创建
+func (s *ImportService) ImportConversation(actor *models.AuditActor, userID int, data []byte, opts *ImportOptions) (*models.ImportConversationResult, error) {
+	if len(data) > MaxImportFileSize {
+		return nil, fmt.Errorf("file too large, maximum is %d MB", MaxImportFileSize>>20)
+	}
+
+	parsed, err := parseImport(data, opts.Format, opts.UserNames)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	characterID, characterCreated, err := s.resolveCharacter(tx, userID, opts, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	// 导入的对话不会触发自动生成标题，文件或参数中带有标题时视为用户指定
+	title := opts.Title
+	if title == "" {
+		title = parsed.Title
+	}
+	titleAuto := title == ""
+	if title == "" {
+		var name string
+		if err := tx.QueryRow("SELECT name FROM characters WHERE id = ?", characterID).Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to get character: %w", err)
+		}
+		title = fmt.Sprintf("与 %s 的对话（导入）", name)
+	}
+	if runes := []rune(title); len(runes) > 200 {
+		title = string(runes[:200])
+	}
+
+	// 没有时间戳的消息从当前时间往前按秒排列，保证顺序稳定
+	now := time.Now().Truncate(time.Second)
+	timestamps := make([]time.Time, len(parsed.Messages))
+	for i, message := range parsed.Messages {
+		timestamps[i] = now.Add(time.Duration(i-len(parsed.Messages)+1) * time.Second)
+		if message.CreatedAt != nil && message.CreatedAt.Year() > 1970 && message.CreatedAt.Year() < 2038 {
+			timestamps[i] = *message.CreatedAt
+		}
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO conversations (user_id, character_id, title, title_auto, created_at, updated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, characterID, title, titleAuto, timestamps[0], timestamps[len(timestamps)-1],
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create conversation: %w", err)
+	}
+	conversationID, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get conversation ID: %w", err)
+	}
+
+	stmt, err := tx.Prepare("INSERT INTO messages (conversation_id, role, content, created_at) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to prepare message insert: %w", err)
+	}
+	defer stmt.Close()
+	created := make([]*models.Message, len(parsed.Messages))
+	for i, message := range parsed.Messages {
+		result, err := stmt.Exec(conversationID, message.Role, message.Content, timestamps[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to create message: %w", err)
+		}
+		messageID, err := result.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get message ID: %w", err)
+		}
+		created[i] = &models.Message{
+			ID:             int(messageID),
+			ConversationID: int(conversationID),
+			Role:           message.Role,
+			Content:        message.Content,
+			CreatedAt:      timestamps[i],
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	addCharacterStatsDelta(characterID, &characterStatsDelta{Conversations: 1, Messages: int64(len(created))})
+	for _, message := range created {
+		if err := s.searchIndex.IndexMessage(message); err != nil {
+			log.Printf("Failed to index imported message %d: %v", message.ID, err)
+		}
+	}
+
+	if characterCreated {
+		s.characterService.invalidateCache(characterID)
+		s.auditService.Record(actor, models.AuditCharacterCreate, "character", strconv.Itoa(characterID), map[string]interface{}{
+			"name":         parsed.CharacterName,
+			"is_published": false,
+			"owner_id":     userID,
+			"source":       "import",
+		})
+	}
+
+	s.auditService.Record(actor, models.AuditConversationImport, "conversation", strconv.FormatInt(conversationID, 10), map[string]interface{}{
+		"format":            parsed.Format,
+		"character_id":      characterID,
+		"character_created": characterCreated,
+		"imported_messages": len(parsed.Messages),
+		"skipped_lines":     len(parsed.Skipped),
+	})
+
+	conversation, err := s.conversationService.GetConversation(int(conversationID), userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get imported conversation: %w", err)
+	}
+
+	skipped := parsed.Skipped
+	if skipped == nil {
+		skipped = []*models.ImportSkippedLine{}
+	}
+	return &models.ImportConversationResult{
+		Conversation:     conversation,
+		Format:           parsed.Format,
+		ImportedMessages: len(parsed.Messages),
+		CharacterCreated: characterCreated,
+		Skipped:          skipped,
+	}, nil
+}
+
+// resolveCharacter 确定导入对话所属的角色：优先使用指定的角色ID，其次按名称匹配已发布的角色或用户自己的私有角色，
+// 都没有时为用户新建一个私有角色。未发布的公共角色不参与匹配，避免通过导入读取其系统提示词
+func (s *ImportService) resolveCharacter(tx *sql.Tx, userID int, opts *ImportOptions, parsed *parsedImport) (int, bool, error) {
+	if opts.CharacterID != 0 {
+		var id int
+		err := tx.QueryRow(
+			"SELECT id FROM characters WHERE id = ? AND (is_published = TRUE OR owner_id = ?)",
+			opts.CharacterID, userID,
+		).Scan(&id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return 0, false, fmt.Errorf("character not found")
+			}
+			return 0, false, fmt.Errorf("failed to verify character: %w", err)
+		}
+		return id, false, nil
+	}
+
+	name := strings.TrimSpace(opts.CharacterName)
+	if name == "" {
+		name = strings.TrimSpace(parsed.CharacterName)
+	}
+	if name == "" {
+		return 0, false, fmt.Errorf("cannot determine character, please specify character_id or character_name")
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	parsed.CharacterName = name
+
+	// 同名时优先使用已发布的角色
+	var id int
+	err := tx.QueryRow(
+		"SELECT id FROM characters WHERE name = ? AND (is_published = TRUE OR owner_id = ?) ORDER BY is_published DESC LIMIT 1",
+		name, userID,
+	).Scan(&id)
+	if err == nil {
+		return id, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, false, fmt.Errorf("failed to find character: %w", err)
+	}
+
+	systemPrompt := parsed.SystemPrompt
+	if systemPrompt == "" {
+		systemPrompt = fmt.Sprintf("你是%s。请以%s的身份、语气和性格与用户对话。", name, name)
+	}
+	result, err := tx.Exec(
+		"INSERT INTO characters (name, description, avatar_url, system_prompt, category, is_published, owner_id) VALUES (?, ?, ?, ?, ?, FALSE, ?)",
+		name, "从导入的对话创建", "", systemPrompt, "导入", userID,
+	)
+	if err != nil {
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			return 0, false, fmt.Errorf("character name already exists, please retry")
+		}
+		return 0, false, fmt.Errorf("failed to create character: %w", err)
+	}
+	newID, err := result.LastInsertId()
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get character ID: %w", err)
+	}
+
+	return int(newID), true, nil
+}
@@ -0,0 +1,182 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"role-play-ai/internal/database"
+	"role-play-ai/internal/models"
+)
+
+const (
+	emailLockThreshold = 5  // 同一邮箱连续失败5次后开始锁定
+	ipLockThreshold    = 20 // 同一IP失败20次后开始锁定（IP可能被多人共享，阈值更高）
+	loginLockBase      = time.Minute
+	loginLockMax       = time.Hour
+
+	loginAuditPerEmail = 50
+	loginAuditRecent   = 1000
+)
+
+// LoginGuard 登录防暴力破解：按邮箱和IP统计失败次数，超过阈值后按指数退避临时锁定
+type LoginGuard struct{}
+
+func NewLoginGuard() *LoginGuard {
+	return &LoginGuard{}
+}
+
+// Check 检查邮箱或IP是否处于锁定状态，返回剩余锁定时间，未锁定时返回0
+func (g *LoginGuard) Check(email, ip string) (time.Duration, error) {
+	var retryAfter time.Duration
+	for _, key := range []string{loginLockKey("email", normalizeEmail(email)), loginLockKey("ip", ip)} {
+		ttl, err := database.RedisClient.TTL(database.Ctx, key).Result()
+		if err != nil {
+			return 0, fmt.Errorf("failed to check login lock: %w", err)
+		}
+		if ttl > retryAfter {
+			retryAfter = ttl
+		}
+	}
+	return retryAfter, nil
+}
+
+// RecordFailure 记录一次登录失败，达到阈值时锁定邮箱或IP，返回锁定时间
+func (g *LoginGuard) RecordFailure(email, ip, userAgent, reason string) (time.Duration, error) {
+	email = normalizeEmail(email)
+	g.audit(&models.LoginFailure{
+		Email:     email,
+		IP:        ip,
+		UserAgent: userAgent,
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	})
+
+	emailLock, err := g.fail("email", email, emailLockThreshold)
+	if err != nil {
+		return 0, err
+	}
+	ipLock, err := g.fail("ip", ip, ipLockThreshold)
+	if err != nil {
+		return 0, err
+	}
+
+	if ipLock > emailLock {
+		return ipLock, nil
+	}
+	return emailLock, nil
+}
+
+// RecordSuccess 登录成功后清除邮箱的失败计数，IP计数不清除，避免攻击者用自己的账户重置计数
+func (g *LoginGuard) RecordSuccess(email string) {
+	g.Unlock(email)
+}
+
+// Unlock 解除邮箱的锁定并清除失败计数
+func (g *LoginGuard) Unlock(email string) error {
+	email = normalizeEmail(email)
+	return database.RedisClient.Del(database.Ctx, loginFailureKey("email", email), loginLockKey("email", email)).Err()
+}
+
+// UnlockIP 解除IP的锁定并清除失败计数
+func (g *LoginGuard) UnlockIP(ip string) error {
+	return database.RedisClient.Del(database.Ctx, loginFailureKey("ip", ip), loginLockKey("ip", ip)).Err()
+}
+
+// GetFailures 获取登录失败记录（最新的在前），email为空时返回全部最近记录
+func (g *LoginGuard) GetFailures(email string, limit int) ([]*models.LoginFailure, error) {
+	key := loginAuditKey("recent")
+	if email != "" {
+		key = loginAuditKey("email:" + normalizeEmail(email))
+	}
+
+	entries, err := database.RedisClient.LRange(database.Ctx, key, 0, int64(limit-1)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get login failures: %w", err)
+	}
+
+	failures := make([]*models.LoginFailure, 0, len(entries))
+	for _, entry := range entries {
+		var failure models.LoginFailure
+		if json.Unmarshal([]byte(entry), &failure) == nil {
+			failures = append(failures, &failure)
+		}
+	}
+	return failures, nil
+}
+
+// fail 增加失败计数，超过阈值后每多失败一次锁定时间翻倍
+func (g *LoginGuard) fail(kind, value string, threshold int64) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	countKey := loginFailureKey(kind, value)
+	count, err := database.IncrementCache(countKey, database.LoginFailureExpiry)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record login failure: %w", err)
+	}
+
+	lock := loginLockDuration(count, threshold)
+	if lock == 0 {
+		return 0, nil
+	}
+
+	if err := database.SetCache(loginLockKey(kind, value), count, lock); err != nil {
+		return 0, fmt.Errorf("failed to lock login: %w", err)
+	}
+	// 计数至少保留到锁定结束之后，解锁后再失败会继续翻倍
+	database.RedisClient.Expire(database.Ctx, countKey, lock+database.LoginFailureExpiry)
+	return lock, nil
+}
+
+func (g *LoginGuard) audit(failure *models.LoginFailure) {
+	data, err := json.Marshal(failure)
+	if err != nil {
+		return
+	}
+
+	emailKey := loginAuditKey("email:" + failure.Email)
+	recentKey := loginAuditKey("recent")
+	pipe := database.RedisClient.TxPipeline()
+	pipe.LPush(database.Ctx, emailKey, data)
+	pipe.LTrim(database.Ctx, emailKey, 0, loginAuditPerEmail-1)
+	pipe.Expire(database.Ctx, emailKey, database.LoginAuditExpiry)
+	pipe.LPush(database.Ctx, recentKey, data)
+	pipe.LTrim(database.Ctx, recentKey, 0, loginAuditRecent-1)
+	pipe.Exec(database.Ctx)
+}
+
+func loginLockDuration(count, threshold int64) time.Duration {
+	if count < threshold {
+		return 0
+	}
+	lock := loginLockBase
+	for i := threshold; i < count && lock < loginLockMax; i++ {
+		lock *= 2
+	}
+	if lock > loginLockMax {
+		lock = loginLockMax
+	}
+	return lock
+}
+
+func loginFailureKey(kind, value string) string {
+	key := &database.CacheKey{Prefix: database.LoginFailurePrefix, ID: kind + ":" + value}
+	return key.String()
+}
+
+func loginLockKey(kind, value string) string {
+	key := &database.CacheKey{Prefix: database.LoginLockPrefix, ID: kind + ":" + value}
+	return key.String()
+}
+
+func loginAuditKey(id string) string {
+	key := &database.CacheKey{Prefix: database.LoginAuditPrefix, ID: id}
+	return key.String()
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,523 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"role-play-ai/internal/config"
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/storage"
+)
+
+// MaxAvatarFileSize 上传头像的最大字节数
+const MaxAvatarFileSize = 5 << 20
+
+const (
+	// avatarSize 头像缩放后的最大边长
+	avatarSize = 512
+	// maxAvatarSourceSize 原图的最大边长，超过时拒绝解码，防止解压炸弹
+	maxAvatarSourceSize = 4096
+)
+
+// mediaURLPrefix 媒体文件的访问路径前缀，数据库中保存 前缀+key 作为稳定引用，
+// 音频下载时需要在后面附加签名参数
+const mediaURLPrefix = "/api/v1/media/"
+
+// 媒体文件在对象存储中的目录
+const (
+	avatarKeyPrefix = "avatars/"
+	audioKeyPrefix  = "audio/"
+)
+
+// 每次垃圾回收最多删除的记录数
+const mediaGCBatchSize = 1000
+
+var mediaKeyPattern = regexp.MustCompile(`^(avatars|audio)/[A-Za-z0-9_-]{43}\.[a-z0-9]+$`)
+
+// MediaDownload 媒体文件的下载方式，对象存储支持预签名时返回RedirectURL，否则返回文件内容
+type MediaDownload struct {
+	RedirectURL string
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64
+}
+
+// MediaService 保存头像和音频到对象存储，生成签名下载地址并清理不再引用的文件
+type MediaService struct {
+	db         *sql.DB
+	store      storage.BlobStore
+	signingKey []byte
+	urlTTL     time.Duration
+	gcGrace    time.Duration
+}
+
+func NewMediaService(db *sql.DB, store storage.BlobStore, cfg *config.Config) *MediaService {
+	ttlMinutes, err := strconv.Atoi(cfg.MediaURLTTLMinutes)
+	if err != nil || ttlMinutes <= 0 {
+		ttlMinutes = 60
+	}
+	graceHours, err := strconv.Atoi(cfg.MediaGCGraceHours)
+	if err != nil || graceHours < 0 {
+		graceHours = 24
+	}
+
+	// 签名密钥由JWT密钥派生，避免直接复用
+	mac := hmac.New(sha256.New, []byte(cfg.JWTSecret))
+	mac.Write([]byte("media-url"))
+
+	return &MediaService{
+		db:         db,
+		store:      store,
+		signingKey: mac.Sum(nil),
+		urlTTL:     time.Duration(ttlMinutes) * time.Minute,
+		gcGrace:    time.Duration(graceHours) * time.Hour,
+	}
+}
+
+// UploadAvatar 校验并缩放头像图片后保存，GIF只保留第一帧
+func (s *MediaService) UploadAvatar(ctx context.Context, userID int, data []byte) (*models.MediaBlob, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("file is empty")
+	}
+
+	// 按内容判断格式，不信任文件名和请求头
+	format := http.DetectContentType(data)
+	switch format {
+	case "image/png", "image/jpeg", "image/gif":
+	default:
+		return nil, fmt.Errorf("unsupported image format")
+	}
+
+	imgConfig, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image")
+	}
+	if imgConfig.Width > maxAvatarSourceSize || imgConfig.Height > maxAvatarSourceSize {
+		return nil, fmt.Errorf("image dimensions too large, maximum is %dx%d", maxAvatarSourceSize, maxAvatarSourceSize)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid image")
+	}
+
+	// 重新编码可以去掉EXIF等元数据
+	var buf bytes.Buffer
+	ext, contentType := ".png", "image/png"
+	if format == "image/jpeg" {
+		ext, contentType = ".jpg", "image/jpeg"
+		err = jpeg.Encode(&buf, resizeToFit(img, avatarSize), &jpeg.Options{Quality: 90})
+	} else {
+		err = png.Encode(&buf, resizeToFit(img, avatarSize))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return s.storeBlob(ctx, userID, models.MediaKindAvatar, ext, contentType, buf.Bytes())
+}
+
+// UploadAudio 校验音频格式后保存，返回的SignedURL可以直接播放
+func (s *MediaService) UploadAudio(ctx context.Context, userID int, data []byte) (*models.MediaBlob, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("audio file is empty")
+	}
+	ext, contentType := sniffAudio(data)
+	if ext == "" {
+		return nil, fmt.Errorf("unsupported audio format")
+	}
+
+	blob, err := s.storeBlob(ctx, userID, models.MediaKindAudio, ext, contentType, data)
+	if err != nil {
+		return nil, err
+	}
+	blob.SignedURL = s.sign(blob.Key, time.Now())
+	return blob, nil
+}
+
+// sniffAudio 按文件头识别音频格式，返回扩展名和MIME类型，无法识别时返回空
+func sniffAudio(data []byte) (string, string) {
+	switch http.DetectContentType(data) {
+	case "audio/wave":
+		return ".wav", "audio/wav"
+	case "audio/mpeg":
+		return ".mp3", "audio/mpeg"
+	case "application/ogg":
+		return ".ogg", "audio/ogg"
+	case "video/webm":
+		// 浏览器MediaRecorder录制的webm只有音轨
+		return ".webm", "audio/webm"
+	case "video/mp4":
+		return ".m4a", "audio/mp4"
+	}
+	switch {
+	case bytes.HasPrefix(data, []byte("fLaC")):
+		return ".flac", "audio/flac"
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		// Safari录制的mp4音频，品牌不在DetectContentType识别的范围内
+		return ".m4a", "audio/mp4"
+	}
+	return "", ""
+}
+
+// storeBlob 以随机key保存文件并记录到media_blobs，记录失败时删除已上传的文件
+func (s *MediaService) storeBlob(ctx context.Context, userID int, kind, ext, contentType string, data []byte) (*models.MediaBlob, error) {
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate media key: %w", err)
+	}
+	prefix := audioKeyPrefix
+	if kind == models.MediaKindAvatar {
+		prefix = avatarKeyPrefix
+	}
+	key := prefix + token + ext
+
+	if err := s.store.Put(ctx, key, data, contentType); err != nil {
+		return nil, fmt.Errorf("failed to store media: %w", err)
+	}
+
+	var owner interface{}
+	if userID > 0 {
+		owner = userID
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO media_blobs (blob_key, user_id, kind, content_type, size_bytes)
+		VALUES (?, ?, ?, ?, ?)
+	`, key, owner, kind, contentType, len(data))
+	if err != nil {
+		if delErr := s.store.Delete(context.Background(), key); delErr != nil {
+			log.Printf("Failed to delete media %s after insert error: %v", key, delErr)
+		}
+		return nil, fmt.Errorf("failed to save media: %w", err)
+	}
+
+	return &models.MediaBlob{
+		Key:         key,
+		URL:         mediaURLPrefix + key,
+		Kind:        kind,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		CreatedAt:   time.Now(),
+	}, nil
+}
+
+// SignURL 为媒体引用生成带签名的临时下载地址。头像公开访问，原样返回；
+// 音频只有上传者或对话中引用了该音频的用户可以获取
+func (s *MediaService) SignURL(ctx context.Context, userID int, ref string) (string, error) {
+	key := mediaKey(ref)
+	if key == "" {
+		return "", fmt.Errorf("media not found")
+	}
+	if strings.HasPrefix(key, avatarKeyPrefix) {
+		return mediaURLPrefix + key, nil
+	}
+
+	var allowed bool
+	err := s.db.QueryRowContext(ctx, `
+		SELECT EXISTS(SELECT 1 FROM media_blobs WHERE blob_key = ? AND user_id = ?)
+			OR EXISTS(
+				SELECT 1 FROM messages m
+				JOIN conversations c ON c.id = m.conversation_id
+				WHERE m.audio_url = ? AND c.user_id = ?
+			)
+	`, key, userID, mediaURLPrefix+key, userID).Scan(&allowed)
+	if err != nil {
+		return "", fmt.Errorf("failed to check media access: %w", err)
+	}
+	if !allowed {
+		return "", fmt.Errorf("media not found")
+	}
+
+	return s.sign(key, time.Now()), nil
+}
+
+// signMessageAudio 为消息中本服务保存的音频生成签名地址，其他地址原样返回
+func (s *MediaService) signMessageAudio(audioURL string) string {
+	key := mediaKey(audioURL)
+	if key == "" || !strings.HasPrefix(key, audioKeyPrefix) {
+		return audioURL
+	}
+	return s.sign(key, time.Now())
+}
+
+func (s *MediaService) sign(key string, now time.Time) string {
+	expires := strconv.FormatInt(now.Add(s.urlTTL).Unix(), 10)
+	return mediaURLPrefix + key + "?expires=" + expires + "&signature=" + s.signature(key, expires)
+}
+
+func (s *MediaService) signature(key, expires string) string {
+	mac := hmac.New(sha256.New, s.signingKey)
+	mac.Write([]byte(key + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Open 校验签名后返回媒体文件的下载方式，头像不需要签名
+func (s *MediaService) Open(ctx context.Context, key, expires, signature string) (*MediaDownload, error) {
+	if !mediaKeyPattern.MatchString(key) {
+		return nil, fmt.Errorf("media not found")
+	}
+
+	ttl := s.urlTTL
+	if strings.HasPrefix(key, audioKeyPrefix) {
+		expiresAt, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil || !hmac.Equal([]byte(signature), []byte(s.signature(key, expires))) {
+			return nil, fmt.Errorf("invalid or expired signature")
+		}
+		ttl = time.Until(time.Unix(expiresAt, 0))
+		if ttl <= 0 {
+			return nil, fmt.Errorf("invalid or expired signature")
+		}
+	}
+
+	if presigner, ok := s.store.(storage.Presigner); ok {
+		redirectURL, err := presigner.PresignGet(key, ttl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign media url: %w", err)
+		}
+		return &MediaDownload{RedirectURL: redirectURL}, nil
+	}
+
+	body, info, err := s.store.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, fmt.Errorf("media not found")
+		}
+		return nil, fmt.Errorf("failed to read media: %w", err)
+	}
+	return &MediaDownload{Body: body, ContentType: info.ContentType, Size: info.Size}, nil
+}
+
+// readMessageAudio 读取消息中本服务保存的音频，返回文件名和内容，外部地址或文件已删除时返回空
+func (s *MediaService) readMessageAudio(ctx context.Context, audioURL *string) (string, []byte, error) {
+	if audioURL == nil {
+		return "", nil, nil
+	}
+	key := mediaKey(*audioURL)
+	if key == "" || !strings.HasPrefix(key, audioKeyPrefix) {
+		return "", nil, nil
+	}
+
+	body, _, err := s.store.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return "", nil, nil
+		}
+		return "", nil, fmt.Errorf("failed to read audio %s: %w", key, err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read audio %s: %w", key, err)
+	}
+	return strings.TrimPrefix(key, audioKeyPrefix), data, nil
+}
+
+// readAvatar 读取本服务保存的头像，返回内容和类型，不是本服务的地址或文件已删除时返回空
+func (s *MediaService) readAvatar(ctx context.Context, avatarURL string) ([]byte, string, error) {
+	key := mediaKey(avatarURL)
+	if key == "" || !strings.HasPrefix(key, avatarKeyPrefix) {
+		return nil, "", nil
+	}
+
+	body, info, err := s.store.Get(ctx, key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, "", nil
+		}
+		return nil, "", fmt.Errorf("failed to read avatar %s: %w", key, err)
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read avatar %s: %w", key, err)
+	}
+	return data, info.ContentType, nil
+}
+
+// mediaKey 从媒体引用或签名地址中取出对象key，不是本服务的地址时返回空
+func mediaKey(ref string) string {
+	if !strings.HasPrefix(ref, mediaURLPrefix) {
+		return ""
+	}
+	key := strings.TrimPrefix(ref, mediaURLPrefix)
+	if i := strings.IndexByte(key, '?'); i >= 0 {
+		key = key[:i]
+	}
+	if !mediaKeyPattern.MatchString(key) {
+		return ""
+	}
+	return key
+}
+
+// CollectGarbage 删除超过保留期且不再被角色头像或消息引用的媒体文件，
+// 以及对象存储中没有记录的残留文件，返回删除的文件数。
+// 保留期内的文件不删除，给上传后尚未保存到角色或消息的文件留出时间
+func (s *MediaService) CollectGarbage(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-s.gcGrace)
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT b.blob_key
+		FROM media_blobs b
+		WHERE b.created_at < ?
+			AND NOT EXISTS (SELECT 1 FROM characters ch WHERE ch.avatar_url = CONCAT(?, b.blob_key))
+			AND NOT EXISTS (SELECT 1 FROM messages m WHERE m.audio_url = CONCAT(?, b.blob_key))
+		LIMIT ?
+	`, cutoff, mediaURLPrefix, mediaURLPrefix, mediaGCBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query orphaned media: %w", err)
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan media: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to query orphaned media: %w", err)
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		// 先删文件再删记录，删除文件失败时下次重试
+		if err := s.store.Delete(ctx, key); err != nil {
+			return deleted, fmt.Errorf("failed to delete media %s: %w", key, err)
+		}
+		if _, err := s.db.ExecContext(ctx, "DELETE FROM media_blobs WHERE blob_key = ?", key); err != nil {
+			return deleted, fmt.Errorf("failed to delete media record: %w", err)
+		}
+		deleted++
+	}
+
+	for _, prefix := range []string{avatarKeyPrefix, audioKeyPrefix} {
+		n, err := s.deleteUntracked(ctx, prefix, cutoff)
+		deleted += n
+		if err != nil {
+			return deleted, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// deleteUntracked 删除对象存储中没有media_blobs记录的文件，如上传后写入记录前进程退出留下的文件
+func (s *MediaService) deleteUntracked(ctx context.Context, prefix string, cutoff time.Time) (int, error) {
+	var candidates []string
+	err := s.store.List(ctx, prefix, func(info *storage.BlobInfo) error {
+		if info.ModifiedAt.Before(cutoff) {
+			candidates = append(candidates, info.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list media: %w", err)
+	}
+
+	deleted := 0
+	for start := 0; start < len(candidates); start += mediaGCBatchSize {
+		batch := candidates[start:min(start+mediaGCBatchSize, len(candidates))]
+
+		placeholders := strings.TrimSuffix(strings.Repeat("?,", len(batch)), ",")
+		args := make([]interface{}, len(batch))
+		for i, key := range batch {
+			args[i] = key
+		}
+		rows, err := s.db.QueryContext(ctx, "SELECT blob_key FROM media_blobs WHERE blob_key IN ("+placeholders+")", args...)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to query media: %w", err)
+		}
+		tracked := make(map[string]bool, len(batch))
+		for rows.Next() {
+			var key string
+			if err := rows.Scan(&key); err != nil {
+				rows.Close()
+				return deleted, fmt.Errorf("failed to scan media: %w", err)
+			}
+			tracked[key] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return deleted, fmt.Errorf("failed to query media: %w", err)
+		}
+
+		for _, key := range batch {
+			if tracked[key] {
+				continue
+			}
+			if err := s.store.Delete(ctx, key); err != nil {
+				return deleted, fmt.Errorf("failed to delete media %s: %w", key, err)
+			}
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// resizeToFit 按比例缩小图片使长边不超过size，使用区域平均避免锯齿，小图原样返回
+func resizeToFit(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+
+	dw, dh := size, h*size/w
+	if h > w {
+		dw, dh = w*size/h, size
+	}
+	dw, dh = max(dw, 1), max(dh, 1)
+
+	// 统一转换为RGBA（预乘alpha），便于直接读取像素并按通道平均
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint64(p[0])
+					g += uint64(p[1])
+					b += uint64(p[2])
+					a += uint64(p[3])
+					n++
+				}
+			}
+			i := y*dst.Stride + x*4
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"image"
+	"image/color"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"role-play-ai/internal/config"
+	"role-play-ai/internal/storage"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// gcCutoff 匹配距当前时间约为保留期的截止时间
+type gcCutoff struct {
+	grace time.Duration
+}
+
+func (c gcCutoff) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && time.Since(t.Add(c.grace)).Abs() < time.Minute
+}
+
+func TestCollectGarbage(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	dir := t.TempDir()
+	store := storage.NewLocalStore(dir)
+	service := NewMediaService(db, store, &config.Config{MediaGCGraceHours: "24"})
+
+	blobKey := func(prefix, c, ext string) string {
+		return prefix + strings.Repeat(c, 43) + "." + ext
+	}
+	var (
+		orphaned        = blobKey(avatarKeyPrefix, "a", "png") // 有记录但不再被引用，超过保留期
+		referenced      = blobKey(avatarKeyPrefix, "b", "png") // 被角色头像引用
+		untrackedOld    = blobKey(avatarKeyPrefix, "c", "png") // 没有记录，超过保留期
+		untrackedRecent = blobKey(avatarKeyPrefix, "d", "png") // 没有记录，仍在保留期内
+		recentAudio     = blobKey(audioKeyPrefix, "e", "wav")  // 刚上传，尚未保存到消息
+	)
+	old := time.Now().Add(-48 * time.Hour)
+	for _, key := range []string{orphaned, referenced, untrackedOld, untrackedRecent, recentAudio} {
+		if err := store.Put(context.Background(), key, []byte("data"), ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, key := range []string{orphaned, referenced, untrackedOld} {
+		if err := os.Chtimes(filepath.Join(dir, key), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 引用和保留期由查询条件过滤，只返回可以删除的记录
+	mock.ExpectQuery(`WHERE b\.created_at < \?\s+AND NOT EXISTS \(SELECT 1 FROM characters ch WHERE ch\.avatar_url = CONCAT\(\?, b\.blob_key\)\)\s+AND NOT EXISTS \(SELECT 1 FROM messages m WHERE m\.audio_url = CONCAT\(\?, b\.blob_key\)\)`).
+		WithArgs(gcCutoff{24 * time.Hour}, mediaURLPrefix, mediaURLPrefix, mediaGCBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"blob_key"}).AddRow(orphaned))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM media_blobs WHERE blob_key = ?")).
+		WithArgs(orphaned).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// 保留期外的文件逐个确认是否有记录，保留期内的文件不检查
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT blob_key FROM media_blobs WHERE blob_key IN (?,?)")).
+		WithArgs(referenced, untrackedOld).
+		WillReturnRows(sqlmock.NewRows([]string{"blob_key"}).AddRow(referenced))
+
+	deleted, err := service.CollectGarbage(context.Background())
+	if err != nil {
+		t.Fatalf("CollectGarbage: %v", err)
+	}
+	if deleted != 2 {
+		t.Fatalf("expected 2 deleted files, got %d", deleted)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, wantExists := range map[string]bool{
+		orphaned:        false,
+		untrackedOld:    false,
+		referenced:      true,
+		untrackedRecent: true,
+		recentAudio:     true,
+	} {
+		_, err := os.Stat(filepath.Join(dir, key))
+		if exists := err == nil; exists != wantExists {
+			t.Errorf("%s: expected exists=%v, got %v", key, wantExists, exists)
+		}
+	}
+}
+
+func TestResizeToFit(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		wantW, wantH  int
+	}{
+		{"small image unchanged", 100, 50, 100, 50},
+		{"wide image", 1024, 512, 512, 256},
+		{"tall image", 300, 1200, 128, 512},
+		{"extreme ratio keeps one pixel", 5000, 2, 512, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewRGBA(image.Rect(0, 0, tt.width, tt.height))
+			got := resizeToFit(src, avatarSize).Bounds()
+			if got.Dx() != tt.wantW || got.Dy() != tt.wantH {
+				t.Fatalf("expected %dx%d, got %dx%d", tt.wantW, tt.wantH, got.Dx(), got.Dy())
+			}
+		})
+	}
+}
+
+func TestResizeToFitAveragesPixels(t *testing.T) {
+	// 黑白相间的棋盘格缩小一半后每个像素是灰色
+	src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			if (x+y)%2 == 0 {
+				src.Set(x, y, color.White)
+			} else {
+				src.Set(x, y, color.Black)
+			}
+		}
+	}
+
+	dst := resizeToFit(src, 2)
+	r, g, b, a := dst.At(1, 1).RGBA()
+	if r>>8 != 127 || g>>8 != 127 || b>>8 != 127 || a>>8 != 255 {
+		t.Fatalf("expected gray, got %d %d %d %d", r>>8, g>>8, b>>8, a>>8)
+	}
+}
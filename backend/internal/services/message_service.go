@@ -3,24 +3,35 @@ package services
 import (
 	"database/sql"
 	"fmt"
+	"log"
 
 	"role-play-ai/internal/models"
 )
 
+const messageColumns = "id, conversation_id, role, content, audio_url, model, created_at"
+
+// 消息分页大小
+const (
+	DefaultMessagePageSize = 50
+	MaxMessagePageSize     = 200
+)
+
 type MessageService struct {
-	db *sql.DB
+	db          *sql.DB
+	searchIndex SearchIndex
 }
 
-func NewMessageService(db *sql.DB) *MessageService {
-	return &MessageService{db: db}
+func NewMessageService(db *sql.DB, searchIndex SearchIndex) *MessageService {
+	return &MessageService{db: db, searchIndex: searchIndex}
 }
 
+// GetMessages 获取对话的完整消息历史，仅供服务端构建AI上下文和导出使用，客户端请求使用GetMessagePage分页
 func (s *MessageService) GetMessages(conversationID int) ([]*models.Message, error) {
 	rows, err := s.db.Query(`
-		SELECT id, conversation_id, role, content, audio_url, created_at
+		SELECT `+messageColumns+`
 		FROM messages
 		WHERE conversation_id = ?
-		ORDER BY created_at ASC
+		ORDER BY created_at ASC, id ASC
 	`, conversationID)
 
 	if err != nil {
@@ -30,15 +41,7 @@ func (s *MessageService) GetMessages(conversationID int) ([]*models.Message, err
 
 	var messages []*models.Message
 	for rows.Next() {
-		message := &models.Message{}
-		err := rows.Scan(
-			&message.ID,
-			&message.ConversationID,
-			&message.Role,
-			&message.Content,
-			&message.AudioURL,
-			&message.CreatedAt,
-		)
+		message, err := scanMessage(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan message: %w", err)
 		}
@@ -49,9 +52,18 @@ func (s *MessageService) GetMessages(conversationID int) ([]*models.Message, err
 }
 
 func (s *MessageService) CreateMessage(conversationID int, role, content string, audioURL *string) (*models.Message, error) {
+	return s.createMessage(conversationID, role, content, audioURL, nil)
+}
+
+// CreateAssistantMessage 保存模型生成的回复，记录生成该回复的模型
+func (s *MessageService) CreateAssistantMessage(conversationID int, content, model string) (*models.Message, error) {
+	return s.createMessage(conversationID, "assistant", content, nil, &model)
+}
+
+func (s *MessageService) createMessage(conversationID int, role, content string, audioURL, model *string) (*models.Message, error) {
 	result, err := s.db.Exec(
-		"INSERT INTO messages (conversation_id, role, content, audio_url) VALUES (?, ?, ?, ?)",
-		conversationID, role, content, audioURL,
+		"INSERT INTO messages (conversation_id, role, content, audio_url, model) VALUES (?, ?, ?, ?, ?)",
+		conversationID, role, content, audioURL, model,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create message: %w", err)
@@ -62,29 +74,29 @@ func (s *MessageService) CreateMessage(conversationID int, role, content string,
 		return nil, fmt.Errorf("failed to get message ID: %w", err)
 	}
 
+	// 更新对话的最后活动时间，对话列表按此排序
+	if _, err := s.db.Exec("UPDATE conversations SET updated_at = CURRENT_TIMESTAMP WHERE id = ?", conversationID); err != nil {
+		return nil, fmt.Errorf("failed to update conversation: %w", err)
+	}
+	invalidateConversationStats(conversationID)
+	addConversationMessages(conversationID, 1)
+
 	// 获取创建的消息
 	message, err := s.GetMessage(int(messageID))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get created message: %w", err)
 	}
 
+	s.indexMessage(message)
 	return message, nil
 }
 
 func (s *MessageService) GetMessage(id int) (*models.Message, error) {
-	message := &models.Message{}
-	err := s.db.QueryRow(`
-		SELECT id, conversation_id, role, content, audio_url, created_at
+	message, err := scanMessage(s.db.QueryRow(`
+		SELECT `+messageColumns+`
 		FROM messages
 		WHERE id = ?
-	`, id).Scan(
-		&message.ID,
-		&message.ConversationID,
-		&message.Role,
-		&message.Content,
-		&message.AudioURL,
-		&message.CreatedAt,
-	)
+	`, id))
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -96,10 +108,11 @@ func (s *MessageService) GetMessage(id int) (*models.Message, error) {
 	return message, nil
 }
 
-func (s *MessageService) GetConversationMessages(conversationID, userID int) ([]*models.Message, error) {
+// GetMessagePage 按消息ID游标分页获取对话消息，同时验证对话属于该用户
+func (s *MessageService) GetMessagePage(conversationID, userID int, query *models.MessagePageQuery) (*models.MessagePage, error) {
 	// 验证对话是否属于用户
 	var count int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM conversations WHERE id = ? AND user_id = ?", conversationID, userID).Scan(&count)
+	err := s.db.QueryRow("SELECT COUNT(*) FROM conversations WHERE id = ? AND user_id = ? AND deleted_at IS NULL", conversationID, userID).Scan(&count)
 	if err != nil {
 		return nil, fmt.Errorf("failed to verify conversation: %w", err)
 	}
@@ -107,14 +120,123 @@ func (s *MessageService) GetConversationMessages(conversationID, userID int) ([]
 		return nil, fmt.Errorf("conversation not found")
 	}
 
-	return s.GetMessages(conversationID)
+	limit := query.Limit
+	if limit < 1 || limit > MaxMessagePageSize {
+		limit = DefaultMessagePageSize
+	}
+
+	// 消息ID随插入递增，按ID排序与对话顺序一致，并且可以直接使用conversation_id索引（隐含主键）
+	var rows *sql.Rows
+	if query.After > 0 {
+		rows, err = s.db.Query(`
+			SELECT `+messageColumns+`
+			FROM messages
+			WHERE conversation_id = ? AND id > ?
+			ORDER BY id ASC
+			LIMIT ?
+		`, conversationID, query.After, limit+1)
+	} else {
+		cond, args := "", []interface{}{conversationID}
+		if query.Before > 0 {
+			cond = " AND id < ?"
+			args = append(args, query.Before)
+		}
+		rows, err = s.db.Query(`
+			SELECT `+messageColumns+`
+			FROM messages
+			WHERE conversation_id = ?`+cond+`
+			ORDER BY id DESC
+			LIMIT ?
+		`, append(args, limit+1)...)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+	defer rows.Close()
+
+	messages := []*models.Message{}
+	for rows.Next() {
+		message, err := scanMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan message: %w", err)
+		}
+		messages = append(messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query messages: %w", err)
+	}
+
+	// 多查询的一条只用来判断是否还有更多
+	page := &models.MessagePage{HasMore: len(messages) > limit}
+	if page.HasMore {
+		messages = messages[:limit]
+	}
+
+	// 向前翻页时是倒序查询的，翻转为正序
+	if query.After == 0 {
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	page.Messages = messages
+
+	if err := loadMessageFeedback(s.db, messages); err != nil {
+		return nil, err
+	}
+
+	if page.HasMore {
+		cursor := messages[0].ID
+		if query.After > 0 {
+			cursor = messages[len(messages)-1].ID
+		}
+		page.NextCursor = &cursor
+	}
+
+	return page, nil
+}
+
+func scanMessage(row rowScanner) (*models.Message, error) {
+	message := &models.Message{}
+	err := row.Scan(
+		&message.ID,
+		&message.ConversationID,
+		&message.Role,
+		&message.Content,
+		&message.AudioURL,
+		&message.Model,
+		&message.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return message, nil
 }
 
 // UpdateMessage 更新消息内容
 func (s *MessageService) UpdateMessage(messageID int, content string) error {
+	var conversationID int
+	if err := s.db.QueryRow("SELECT conversation_id FROM messages WHERE id = ?", messageID).Scan(&conversationID); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("message not found")
+		}
+		return fmt.Errorf("failed to get message: %w", err)
+	}
+
 	_, err := s.db.Exec("UPDATE messages SET content = ? WHERE id = ?", content, messageID)
 	if err != nil {
 		return fmt.Errorf("failed to update message: %w", err)
 	}
+	invalidateConversationStats(conversationID)
+
+	if message, err := s.GetMessage(messageID); err == nil {
+		s.indexMessage(message)
+	}
 	return nil
 }
+
+// indexMessage 同步消息到搜索索引，失败只记录日志，不影响消息本身的保存
+func (s *MessageService) indexMessage(message *models.Message) {
+	if err := s.searchIndex.IndexMessage(message); err != nil {
+		log.Printf("Failed to index message %d: %v", message.ID, err)
+	}
+}
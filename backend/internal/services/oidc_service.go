@@ -0,0 +1,511 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"role-play-ai/internal/config"
+	"role-play-ai/internal/database"
+	"role-play-ai/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	oidcDiscoveryTTL     = time.Hour
+	oidcJWKSRefreshLimit = time.Minute
+)
+
+var usernameSanitizer = regexp.MustCompile(`[^a-zA-Z0-9_\-\p{Han}]+`)
+
+// OIDCService OpenID Connect授权码登录（PKCE）
+type OIDCService struct {
+	db           *sql.DB
+	userService  *UserService
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	scopes       string
+	client       *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	discoveredAt  time.Time
+	keys          map[string]interface{}
+	keysFetchedAt time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type oidcAuthState struct {
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+type oidcJWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// OIDCClaims ID Token中用于账户映射的声明
+type OIDCClaims struct {
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Nonce             string `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+func NewOIDCService(db *sql.DB, userService *UserService, cfg *config.Config) *OIDCService {
+	return &OIDCService{
+		db:           db,
+		userService:  userService,
+		issuer:       strings.TrimRight(cfg.OIDCIssuer, "/"),
+		clientID:     cfg.OIDCClientID,
+		clientSecret: cfg.OIDCClientSecret,
+		redirectURL:  cfg.OIDCRedirectURL,
+		scopes:       cfg.OIDCScopes,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Enabled 是否配置了OIDC登录
+func (s *OIDCService) Enabled() bool {
+	return s.issuer != "" && s.clientID != ""
+}
+
+// AuthorizationURL 生成授权请求地址，state、nonce和PKCE验证码保存在Redis中
+func (s *OIDCService) AuthorizationURL() (string, error) {
+	discovery, err := s.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	state, err := generateSecureToken(24)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate state: %w", err)
+	}
+	nonce, err := generateSecureToken(24)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	verifier, err := generateSecureToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate code verifier: %w", err)
+	}
+
+	data, err := json.Marshal(&oidcAuthState{Nonce: nonce, CodeVerifier: verifier})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal state: %w", err)
+	}
+	stateKey := &database.CacheKey{Prefix: database.OIDCStatePrefix, ID: hashToken(state)}
+	if err := database.SetCache(stateKey.String(), string(data), database.OIDCStateExpiry); err != nil {
+		return "", fmt.Errorf("failed to store state: %w", err)
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", s.clientID)
+	params.Set("redirect_uri", s.redirectURL)
+	params.Set("scope", s.scopes)
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return discovery.AuthorizationEndpoint + separator + params.Encode(), nil
+}
+
+// Exchange 校验state后用授权码换取并验证ID Token
+func (s *OIDCService) Exchange(state, code string) (*OIDCClaims, error) {
+	stateKey := &database.CacheKey{Prefix: database.OIDCStatePrefix, ID: hashToken(state)}
+	cached, err := database.GetDelCache(stateKey.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired state")
+	}
+
+	var authState oidcAuthState
+	if err := json.Unmarshal([]byte(cached), &authState); err != nil {
+		return nil, fmt.Errorf("invalid or expired state")
+	}
+
+	discovery, err := s.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", s.redirectURL)
+	form.Set("client_id", s.clientID)
+	form.Set("code_verifier", authState.CodeVerifier)
+
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("token endpoint error (status %d): %s", resp.StatusCode, string(body))
+	}
+
+	var tokenResp struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if tokenResp.IDToken == "" {
+		return nil, fmt.Errorf("token response missing id_token")
+	}
+
+	return s.verifyIDToken(tokenResp.IDToken, authState.Nonce)
+}
+
+// FindOrCreateUser 将OIDC身份映射到本地用户：已绑定的直接返回，邮箱已验证且已注册的自动绑定，否则创建新用户
+func (s *OIDCService) FindOrCreateUser(claims *OIDCClaims) (*models.User, error) {
+	var userID int
+	err := s.db.QueryRow(
+		"SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?",
+		s.issuer, claims.Subject,
+	).Scan(&userID)
+	if err == nil {
+		s.db.Exec(
+			"UPDATE user_identities SET last_login_at = CURRENT_TIMESTAMP, email = ? WHERE issuer = ? AND subject = ?",
+			claims.Email, s.issuer, claims.Subject,
+		)
+		return s.userService.GetUserByID(userID)
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to query identity: %w", err)
+	}
+
+	if claims.Email == "" {
+		return nil, fmt.Errorf("identity provider did not return an email")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("SELECT id FROM users WHERE email = ? FOR UPDATE", claims.Email).Scan(&userID)
+	switch {
+	case err == nil:
+		// 只有身份提供方确认过邮箱时才绑定同邮箱的已有账户，防止账户接管
+		if !claims.EmailVerified {
+			return nil, fmt.Errorf("email already registered; verify it with the identity provider to link accounts")
+		}
+		if _, err := tx.Exec(
+			"UPDATE users SET email_verified_at = COALESCE(email_verified_at, CURRENT_TIMESTAMP) WHERE id = ?",
+			userID,
+		); err != nil {
+			return nil, fmt.Errorf("failed to update user: %w", err)
+		}
+	case err == sql.ErrNoRows:
+		username, err := s.uniqueUsername(tx, claims)
+		if err != nil {
+			return nil, err
+		}
+
+		var verifiedAt interface{}
+		if claims.EmailVerified {
+			verifiedAt = time.Now()
+		}
+
+		// 第三方登录创建的账户没有本地密码，password_hash为空时无法通过密码登录
+		result, err := tx.Exec(
+			"INSERT INTO users (username, email, password_hash, email_verified_at) VALUES (?, ?, '', ?)",
+			username, claims.Email, verifiedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create user: %w", err)
+		}
+		id, err := result.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user ID: %w", err)
+		}
+		userID = int(id)
+	default:
+		return nil, fmt.Errorf("failed to query user: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)",
+		userID, s.issuer, claims.Subject, claims.Email,
+	); err != nil {
+		return nil, fmt.Errorf("failed to link identity: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return s.userService.GetUserByID(userID)
+}
+
+// uniqueUsername 根据声明生成不重复的用户名
+func (s *OIDCService) uniqueUsername(tx *sql.Tx, claims *OIDCClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base = claims.Name
+	}
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameSanitizer.ReplaceAllString(base, "")
+	if len([]rune(base)) < 3 {
+		base = "user_" + base
+	}
+	if runes := []rune(base); len(runes) > 40 {
+		base = string(runes[:40])
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", candidate).Scan(&count); err != nil {
+			return "", fmt.Errorf("failed to check username: %w", err)
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+
+		suffix, err := generateSecureToken(3)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate username: %w", err)
+		}
+		candidate = base + "_" + strings.ToLower(suffix)
+	}
+
+	return "", fmt.Errorf("failed to generate unique username")
+}
+
+func (s *OIDCService) verifyIDToken(rawToken, nonce string) (*OIDCClaims, error) {
+	claims := &OIDCClaims{}
+	_, err := jwt.ParseWithClaims(rawToken, claims, s.keyFunc,
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(s.issuer),
+		jwt.WithAudience(s.clientID),
+		jwt.WithLeeway(time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+
+	if claims.ExpiresAt == nil {
+		return nil, fmt.Errorf("invalid id_token: missing exp")
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("invalid id_token: missing sub")
+	}
+	if claims.Nonce != nonce {
+		return nil, fmt.Errorf("invalid id_token: nonce mismatch")
+	}
+
+	return claims, nil
+}
+
+func (s *OIDCService) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.lookupKey(kid); ok {
+		return key, nil
+	}
+
+	// 未知的kid可能是提供方轮换了密钥，限频重新拉取JWKS
+	if time.Since(s.keysFetchedAt) < oidcJWKSRefreshLimit && s.keys != nil {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if err := s.fetchKeys(); err != nil {
+		return nil, err
+	}
+
+	if key, ok := s.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey 查找签名公钥，调用方需持有锁
+func (s *OIDCService) lookupKey(kid string) (interface{}, bool) {
+	if kid != "" {
+		key, ok := s.keys[kid]
+		return key, ok
+	}
+	// 没有kid时只有在提供方仅有一把密钥的情况下才能确定使用哪一把
+	if len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// fetchKeys 拉取JWKS，调用方需持有锁
+func (s *OIDCService) fetchKeys() error {
+	discovery, err := s.loadDiscovery()
+	if err != nil {
+		return err
+	}
+
+	var jwks struct {
+		Keys []oidcJWK `json:"keys"`
+	}
+	if err := s.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(jwks.Keys))
+	for _, jwk := range jwks.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+
+	s.keys = keys
+	s.keysFetchedAt = time.Now()
+	return nil
+}
+
+func (s *OIDCService) getDiscovery() (*oidcDiscovery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loadDiscovery()
+}
+
+// loadDiscovery 获取并缓存提供方元数据，调用方需持有锁
+func (s *OIDCService) loadDiscovery() (*oidcDiscovery, error) {
+	if s.discovery != nil && time.Since(s.discoveredAt) < oidcDiscoveryTTL {
+		return s.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := s.getJSON(s.issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("failed to fetch OIDC discovery document: %w", err)
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != s.issuer {
+		return nil, fmt.Errorf("OIDC issuer mismatch: %s", discovery.Issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document is incomplete")
+	}
+
+	s.discovery = &discovery
+	s.discoveredAt = time.Now()
+	return s.discovery, nil
+}
+
+func (s *OIDCService) getJSON(rawURL string, v interface{}) error {
+	resp, err := s.client.Get(rawURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, rawURL)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func (k *oidcJWK) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("invalid EC key")
+		}
+		return key, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
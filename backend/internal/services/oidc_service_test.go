@@ -0,0 +1,220 @@
+package services
+
+import (
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"role-play-ai/internal/config"
+	"role-play-ai/internal/database"
+	"role-play-ai/internal/oidctest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const testOIDCClientID = "role-play-ai"
+
+func newTestOIDCService(t *testing.T) (*OIDCService, *oidctest.Provider, sqlmock.Sqlmock) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	database.RedisClient = redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	provider := oidctest.NewProvider(testOIDCClientID)
+	t.Cleanup(provider.Close)
+
+	cfg := &config.Config{
+		OIDCIssuer:      provider.Issuer(),
+		OIDCClientID:    testOIDCClientID,
+		OIDCRedirectURL: "http://localhost:8080/api/v1/auth/oidc/callback",
+		OIDCScopes:      "openid email profile",
+	}
+	userService := NewUserService(db, nil, NewAuditService(db), cfg)
+	return NewOIDCService(db, userService, cfg), provider, mock
+}
+
+var testUserColumns = []string{"id", "username", "email", "role", "email_verified_at", "deletion_scheduled_at", "created_at", "updated_at"}
+
+func expectGetUser(mock sqlmock.Sqlmock, id int, email string, verified bool) {
+	var verifiedAt interface{}
+	if verified {
+		verifiedAt = time.Now()
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT " + userColumns + " FROM users WHERE id = ?")).
+		WithArgs(id).
+		WillReturnRows(sqlmock.NewRows(testUserColumns).
+			AddRow(id, "alice", email, "user", verifiedAt, nil, time.Now(), time.Now()))
+}
+
+func TestOIDCExchange(t *testing.T) {
+	service, provider, _ := newTestOIDCService(t)
+
+	authURL, err := service.AuthorizationURL()
+	if err != nil {
+		t.Fatalf("AuthorizationURL: %v", err)
+	}
+	code, state, err := provider.Authorize(authURL, oidctest.Identity{
+		Subject:       "sub-1",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+	})
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	claims, err := service.Exchange(state, code)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if claims.Subject != "sub-1" || claims.Email != "alice@example.com" || !claims.EmailVerified {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	// state只能使用一次
+	if _, err := service.Exchange(state, code); err == nil || err.Error() != "invalid or expired state" {
+		t.Fatalf("expected reused state to be rejected, got %v", err)
+	}
+}
+
+func TestOIDCExchangeRejectsUnknownState(t *testing.T) {
+	service, provider, _ := newTestOIDCService(t)
+
+	authURL, err := service.AuthorizationURL()
+	if err != nil {
+		t.Fatalf("AuthorizationURL: %v", err)
+	}
+	code, _, err := provider.Authorize(authURL, oidctest.Identity{Subject: "sub-1", Email: "alice@example.com"})
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+
+	if _, err := service.Exchange("forged-state", code); err == nil {
+		t.Fatal("expected forged state to be rejected")
+	}
+}
+
+func TestFindOrCreateUserExistingIdentity(t *testing.T) {
+	service, _, mock := newTestOIDCService(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?")).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(7))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE user_identities SET last_login_at")).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectGetUser(mock, 7, "alice@example.com", true)
+
+	user, err := service.FindOrCreateUser(&OIDCClaims{Email: "alice@example.com", RegisteredClaims: jwt.RegisteredClaims{Subject: "sub-1"}})
+	if err != nil {
+		t.Fatalf("FindOrCreateUser: %v", err)
+	}
+	if user.ID != 7 {
+		t.Fatalf("expected user 7, got %d", user.ID)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindOrCreateUserLinksVerifiedEmail(t *testing.T) {
+	service, _, mock := newTestOIDCService(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM user_identities")).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE email = ? FOR UPDATE")).
+		WithArgs("alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE users SET email_verified_at")).
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_identities")).
+		WithArgs(7, service.issuer, "sub-1", "alice@example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	expectGetUser(mock, 7, "alice@example.com", true)
+
+	user, err := service.FindOrCreateUser(&OIDCClaims{
+		Email:            "alice@example.com",
+		EmailVerified:    true,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "sub-1"},
+	})
+	if err != nil {
+		t.Fatalf("FindOrCreateUser: %v", err)
+	}
+	if user.ID != 7 {
+		t.Fatalf("expected linked user 7, got %d", user.ID)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindOrCreateUserRejectsUnverifiedEmail(t *testing.T) {
+	service, _, mock := newTestOIDCService(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM user_identities")).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE email = ? FOR UPDATE")).
+		WithArgs("alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectRollback()
+
+	_, err := service.FindOrCreateUser(&OIDCClaims{
+		Email:            "alice@example.com",
+		EmailVerified:    false,
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "sub-1"},
+	})
+	if err == nil {
+		t.Fatal("expected unverified email not to be linked to an existing account")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFindOrCreateUserCreatesUser(t *testing.T) {
+	service, _, mock := newTestOIDCService(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT user_id FROM user_identities")).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id FROM users WHERE email = ? FOR UPDATE")).
+		WithArgs("bob@example.com").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM users WHERE username = ?")).
+		WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO users (username, email, password_hash, email_verified_at)")).
+		WithArgs("bob", "bob@example.com", nil).
+		WillReturnResult(sqlmock.NewResult(9, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_identities")).
+		WithArgs(9, service.issuer, "sub-2", "bob@example.com").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	expectGetUser(mock, 9, "bob@example.com", false)
+
+	user, err := service.FindOrCreateUser(&OIDCClaims{
+		Email:             "bob@example.com",
+		PreferredUsername: "bob",
+		RegisteredClaims:  jwt.RegisteredClaims{Subject: "sub-2"},
+	})
+	if err != nil {
+		t.Fatalf("FindOrCreateUser: %v", err)
+	}
+	if user.ID != 9 {
+		t.Fatalf("expected new user 9, got %d", user.ID)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
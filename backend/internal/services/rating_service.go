@@ -0,0 +1,158 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"role-play-ai/internal/models"
+)
+
+type RatingService struct {
+	db               *sql.DB
+	characterService *CharacterService
+}
+
+func NewRatingService(db *sql.DB, characterService *CharacterService) *RatingService {
+	return &RatingService{db: db, characterService: characterService}
+}
+
+const ratingColumns = "r.character_id, u.username, r.rating, r.review, r.created_at, r.updated_at"
+
+func scanRating(row rowScanner) (*models.CharacterRating, error) {
+	rating := &models.CharacterRating{}
+	var review sql.NullString
+	err := row.Scan(&rating.CharacterID, &rating.Username, &rating.Rating, &review, &rating.CreatedAt, &rating.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	rating.Review = review.String
+	return rating, nil
+}
+
+// RateCharacter 评分或修改评分，每个用户对每个角色只保留一条
+func (s *RatingService) RateCharacter(userID, characterID int, req *models.RateCharacterRequest) (*models.CharacterRating, error) {
+	if _, err := s.characterService.GetCharacter(characterID); err != nil {
+		return nil, err
+	}
+
+	var review interface{}
+	if text := strings.TrimSpace(req.Review); text != "" {
+		review = text
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 锁定原评分，用于计算角色统计的变化量
+	var previous sql.NullInt64
+	err = tx.QueryRow("SELECT rating FROM character_ratings WHERE user_id = ? AND character_id = ? FOR UPDATE", userID, characterID).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get rating: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO character_ratings (user_id, character_id, rating, review) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE rating = VALUES(rating), review = VALUES(review)
+	`, userID, characterID, req.Rating, review)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save rating: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	delta := &characterStatsDelta{RatingSum: int64(req.Rating) - previous.Int64}
+	if !previous.Valid {
+		delta.Ratings = 1
+	}
+	addCharacterStatsDelta(characterID, delta)
+	return s.GetUserRating(userID, characterID)
+}
+
+// GetUserRating 获取用户对角色的评分
+func (s *RatingService) GetUserRating(userID, characterID int) (*models.CharacterRating, error) {
+	rating, err := scanRating(s.db.QueryRow(`
+		SELECT `+ratingColumns+`
+		FROM character_ratings r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.user_id = ? AND r.character_id = ?
+	`, userID, characterID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("rating not found")
+		}
+		return nil, fmt.Errorf("failed to get rating: %w", err)
+	}
+	return rating, nil
+}
+
+// DeleteRating 删除用户对角色的评分
+func (s *RatingService) DeleteRating(userID, characterID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var rating int
+	err = tx.QueryRow("SELECT rating FROM character_ratings WHERE user_id = ? AND character_id = ? FOR UPDATE", userID, characterID).Scan(&rating)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("rating not found")
+		}
+		return fmt.Errorf("failed to get rating: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM character_ratings WHERE user_id = ? AND character_id = ?", userID, characterID); err != nil {
+		return fmt.Errorf("failed to delete rating: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	addCharacterStatsDelta(characterID, &characterStatsDelta{Ratings: -1, RatingSum: -int64(rating)})
+	return nil
+}
+
+// GetCharacterRatings 分页获取已发布角色的评分，最近更新的在前
+func (s *RatingService) GetCharacterRatings(characterID, page, limit int) ([]*models.CharacterRating, int, error) {
+	if _, err := s.characterService.GetCharacter(characterID); err != nil {
+		return nil, 0, err
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM character_ratings WHERE character_id = ?", characterID).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count ratings: %w", err)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+ratingColumns+`
+		FROM character_ratings r
+		JOIN users u ON u.id = r.user_id
+		WHERE r.character_id = ?
+		ORDER BY r.updated_at DESC, r.user_id DESC
+		LIMIT ? OFFSET ?
+	`, characterID, limit, (page-1)*limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query ratings: %w", err)
+	}
+	defer rows.Close()
+
+	ratings := []*models.CharacterRating{}
+	for rows.Next() {
+		rating, err := scanRating(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan rating: %w", err)
+		}
+		ratings = append(ratings, rating)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to query ratings: %w", err)
+	}
+
+	return ratings, total, nil
+}
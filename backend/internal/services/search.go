@@ -0,0 +1,260 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"role-play-ai/internal/models"
+)
+
+// SearchIndex 用户消息的全文索引。MySQL实现由数据库的FULLTEXT索引自动维护，
+// 换成嵌入式索引（如Bleve）时通过IndexMessage和Delete*方法同步数据
+type SearchIndex interface {
+	// Search 在用户自己的消息中搜索，按相关度排序，返回当前页和总数
+	Search(userID int, query *models.MessageSearchQuery, terms []string) ([]*models.MessageSearchHit, int, error)
+	// IndexMessage 索引新增或修改后的消息
+	IndexMessage(message *models.Message) error
+	// DeleteConversations 删除对话的全部消息索引
+	DeleteConversations(conversationIDs ...int) error
+	// DeleteUser 删除用户的全部消息索引
+	DeleteUser(userID int) error
+}
+
+// ngramTokenSize 与MySQL的ngram_token_size一致，短于此长度的词无法通过全文索引匹配
+const ngramTokenSize = 2
+
+// MySQLSearchIndex 基于messages表FULLTEXT索引（ngram分词）的实现
+type MySQLSearchIndex struct {
+	db *sql.DB
+}
+
+func NewMySQLSearchIndex(db *sql.DB) *MySQLSearchIndex {
+	return &MySQLSearchIndex{db: db}
+}
+
+func (idx *MySQLSearchIndex) Search(userID int, query *models.MessageSearchQuery, terms []string) ([]*models.MessageSearchHit, int, error) {
+	conditions := []string{"c.user_id = ?", "c.deleted_at IS NULL"}
+	args := []interface{}{userID}
+	if query.CharacterID != 0 {
+		conditions = append(conditions, "c.character_id = ?")
+		args = append(args, query.CharacterID)
+	}
+	if query.ConversationID != 0 {
+		conditions = append(conditions, "m.conversation_id = ?")
+		args = append(args, query.ConversationID)
+	}
+
+	// 所有词都能被ngram切分时使用全文索引，否则（如单个汉字）退化为LIKE
+	useFullText := true
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < ngramTokenSize {
+			useFullText = false
+		}
+	}
+
+	scoreExpr := "0"
+	var scoreArgs []interface{}
+	if useFullText {
+		against := make([]string, len(terms))
+		for i, term := range terms {
+			// 词组匹配要求ngram连续出现，效果接近子串匹配
+			against[i] = `+"` + term + `"`
+		}
+		booleanQuery := strings.Join(against, " ")
+		conditions = append(conditions, "MATCH(m.content) AGAINST (? IN BOOLEAN MODE)")
+		args = append(args, booleanQuery)
+		scoreExpr = "MATCH(m.content) AGAINST (? IN BOOLEAN MODE)"
+		scoreArgs = []interface{}{booleanQuery}
+	} else {
+		for _, term := range terms {
+			conditions = append(conditions, "m.content LIKE ?")
+			args = append(args, "%"+escapeLike(term)+"%")
+		}
+	}
+	where := strings.Join(conditions, " AND ")
+
+	var total int
+	err := idx.db.QueryRow(`
+		SELECT COUNT(*)
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE `+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count search results: %w", err)
+	}
+
+	queryArgs := append(append(scoreArgs, args...), query.Limit, (query.Page-1)*query.Limit)
+	rows, err := idx.db.Query(`
+		SELECT m.id, m.conversation_id, c.title, ch.id, ch.name, m.role, m.content, m.created_at, `+scoreExpr+` AS score
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		JOIN characters ch ON ch.id = c.character_id
+		WHERE `+where+`
+		ORDER BY score DESC, m.id DESC
+		LIMIT ? OFFSET ?
+	`, queryArgs...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer rows.Close()
+
+	hits := []*models.MessageSearchHit{}
+	for rows.Next() {
+		hit := &models.MessageSearchHit{}
+		var title sql.NullString
+		err := rows.Scan(&hit.MessageID, &hit.ConversationID, &title, &hit.CharacterID, &hit.CharacterName,
+			&hit.Role, &hit.Content, &hit.CreatedAt, &hit.Score)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan search result: %w", err)
+		}
+		hit.ConversationTitle = title.String
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	return hits, total, nil
+}
+
+// IndexMessage FULLTEXT索引随messages表自动更新
+func (idx *MySQLSearchIndex) IndexMessage(message *models.Message) error {
+	return nil
+}
+
+// DeleteConversations 消息随对话级联删除，索引自动更新
+func (idx *MySQLSearchIndex) DeleteConversations(conversationIDs ...int) error {
+	return nil
+}
+
+// DeleteUser 消息随用户级联删除，索引自动更新
+func (idx *MySQLSearchIndex) DeleteUser(userID int) error {
+	return nil
+}
+
+// escapeLike 转义LIKE模式中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// 搜索结果片段的长度（字符数）
+const searchSnippetLength = 80
+
+type SearchService struct {
+	index SearchIndex
+}
+
+func NewSearchService(index SearchIndex) *SearchService {
+	return &SearchService{index: index}
+}
+
+// SearchMessages 搜索用户的消息并生成高亮片段
+func (s *SearchService) SearchMessages(userID int, query *models.MessageSearchQuery) ([]*models.MessageSearchHit, int, error) {
+	terms := searchTerms(query.Query)
+	if len(terms) == 0 {
+		return nil, 0, fmt.Errorf("search query is empty")
+	}
+
+	hits, total, err := s.index.Search(userID, query, terms)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, hit := range hits {
+		hit.Snippet = buildSnippet(hit.Content, terms, searchSnippetLength)
+	}
+	return hits, total, nil
+}
+
+// searchTerms 按空白拆分搜索词，去掉全文检索的布尔运算符，最多保留5个词
+func searchTerms(query string) []string {
+	terms := []string{}
+	for _, field := range strings.Fields(query) {
+		term := strings.Map(func(r rune) rune {
+			if strings.ContainsRune(`"+-<>()~*@`, r) {
+				return -1
+			}
+			return r
+		}, field)
+		if term != "" {
+			terms = append(terms, term)
+		}
+		if len(terms) == 5 {
+			break
+		}
+	}
+	return terms
+}
+
+// buildSnippet 截取第一个匹配词附近的内容，HTML转义后用<mark>标出所有匹配（不区分大小写）
+func buildSnippet(content string, terms []string, length int) string {
+	runes := []rune(content)
+	lower := make([]rune, len(runes))
+	for i, r := range runes {
+		lower[i] = unicode.ToLower(r)
+	}
+
+	// 标记每个字符是否属于某个匹配词
+	marked := make([]bool, len(runes))
+	first := -1
+	for _, term := range terms {
+		t := []rune(strings.ToLower(term))
+		for i := 0; i+len(t) <= len(lower); i++ {
+			if string(lower[i:i+len(t)]) != string(t) {
+				continue
+			}
+			for j := i; j < i+len(t); j++ {
+				marked[j] = true
+			}
+			if first == -1 || i < first {
+				first = i
+			}
+		}
+	}
+
+	start := 0
+	if first > length/4 {
+		start = first - length/4
+	}
+	end := start + length
+	if end > len(runes) {
+		// 靠近结尾时向前多取一些，保证片段长度
+		end = len(runes)
+		start = end - length
+		if start < 0 {
+			start = 0
+		}
+	}
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	inMark := false
+	for i := start; i < end; i++ {
+		if marked[i] != inMark {
+			if marked[i] {
+				b.WriteString("<mark>")
+			} else {
+				b.WriteString("</mark>")
+			}
+			inMark = marked[i]
+		}
+		r := runes[i]
+		if r == '\n' || r == '\r' {
+			r = ' '
+		}
+		b.WriteString(html.EscapeString(string(r)))
+	}
+	if inMark {
+		b.WriteString("</mark>")
+	}
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+	return b.String()
+}
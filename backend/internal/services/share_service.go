@@ -0,0 +1,287 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"role-play-ai/internal/models"
+)
+
+// 每个对话最多同时有效的分享链接数
+const maxSharesPerConversation = 20
+
+// ShareService 管理对话的只读分享链接
+type ShareService struct {
+	db                  *sql.DB
+	conversationService *ConversationService
+	auditService        *AuditService
+	appBaseURL          string
+}
+
+func NewShareService(db *sql.DB, conversationService *ConversationService, auditService *AuditService, appBaseURL string) *ShareService {
+	return &ShareService{
+		db:                  db,
+		conversationService: conversationService,
+		auditService:        auditService,
+		appBaseURL:          strings.TrimRight(appBaseURL, "/"),
+	}
+}
+
+const shareColumns = `
+	sh.id, sh.conversation_id, sh.expires_at, sh.view_count, sh.created_at,
+	(SELECT COUNT(*) FROM conversation_share_messages sm WHERE sm.share_id = sh.id)`
+
+func scanShare(row rowScanner) (*models.ConversationShare, error) {
+	share := &models.ConversationShare{}
+	var expiresAt sql.NullTime
+	err := row.Scan(&share.ID, &share.ConversationID, &expiresAt, &share.ViewCount, &share.CreatedAt, &share.MessageCount)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		share.ExpiresAt = &expiresAt.Time
+	}
+	return share, nil
+}
+
+// CreateShare 为对话创建分享链接，返回链接信息和只显示一次的访问地址。
+// 创建时复制已有消息的内容作为快照，之后的新消息以及对原消息的编辑和删除都不会出现在链接中
+func (s *ShareService) CreateShare(actor *models.AuditActor, userID, conversationID int, req *models.CreateShareRequest) (*models.ConversationShare, string, error) {
+	if _, err := s.conversationService.GetConversation(conversationID, userID); err != nil {
+		return nil, "", err
+	}
+
+	var lastMessageID sql.NullInt64
+	if err := s.db.QueryRow("SELECT MAX(id) FROM messages WHERE conversation_id = ?", conversationID).Scan(&lastMessageID); err != nil {
+		return nil, "", fmt.Errorf("failed to get last message: %w", err)
+	}
+	if !lastMessageID.Valid {
+		return nil, "", fmt.Errorf("conversation has no messages")
+	}
+
+	var count int
+	err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM conversation_shares WHERE conversation_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)",
+		conversationID, time.Now(),
+	).Scan(&count)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to count share links: %w", err)
+	}
+	if count >= maxSharesPerConversation {
+		return nil, "", fmt.Errorf("too many share links, revoke unused links first")
+	}
+
+	token, err := generateSecureToken(24)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate share token: %w", err)
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays != nil {
+		t := time.Now().Add(time.Duration(*req.ExpiresInDays) * 24 * time.Hour)
+		expiresAt = &t
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO conversation_shares (conversation_id, token_hash, last_message_id, expires_at) VALUES (?, ?, ?, ?)",
+		conversationID, hashToken(token), lastMessageID.Int64, expiresAt,
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create share link: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get share link ID: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO conversation_share_messages (share_id, role, content, created_at)
+		SELECT ?, role, content, created_at
+		FROM messages
+		WHERE conversation_id = ? AND id <= ?
+		ORDER BY id
+	`, id, conversationID, lastMessageID.Int64)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to save shared messages: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	share, err := scanShare(s.db.QueryRow("SELECT "+shareColumns+" FROM conversation_shares sh WHERE sh.id = ?", id))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get created share link: %w", err)
+	}
+
+	s.auditService.Record(actor, models.AuditConversationShare, "conversation", strconv.Itoa(conversationID), map[string]interface{}{
+		"share_id":   share.ID,
+		"expires_at": expiresAt,
+	})
+	return share, s.appBaseURL + "/shared/" + token, nil
+}
+
+// ListShares 获取对话未撤销的分享链接，包括已过期的
+func (s *ShareService) ListShares(userID, conversationID int) ([]*models.ConversationShare, error) {
+	if _, err := s.conversationService.GetConversation(conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`
+		SELECT `+shareColumns+`
+		FROM conversation_shares sh
+		WHERE sh.conversation_id = ? AND sh.revoked_at IS NULL
+		ORDER BY sh.created_at DESC
+	`, conversationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query share links: %w", err)
+	}
+	defer rows.Close()
+
+	shares := []*models.ConversationShare{}
+	for rows.Next() {
+		share, err := scanShare(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan share link: %w", err)
+		}
+		shares = append(shares, share)
+	}
+	return shares, rows.Err()
+}
+
+// RevokeShare 撤销分享链接，撤销后链接立即失效
+func (s *ShareService) RevokeShare(actor *models.AuditActor, userID, conversationID, shareID int) error {
+	result, err := s.db.Exec(`
+		UPDATE conversation_shares sh
+		JOIN conversations c ON c.id = sh.conversation_id
+		SET sh.revoked_at = ?
+		WHERE sh.id = ? AND sh.conversation_id = ? AND c.user_id = ? AND sh.revoked_at IS NULL
+	`, time.Now(), shareID, conversationID, userID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke share link: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("share not found")
+	}
+
+	s.auditService.Record(actor, models.AuditConversationUnshare, "conversation", strconv.Itoa(conversationID), map[string]interface{}{
+		"share_id": shareID,
+	})
+	return nil
+}
+
+// sharedSource 分享链接及其对应的对话
+type sharedSource struct {
+	shareID     int
+	characterID int
+	title       string
+	createdAt   time.Time
+	expiresAt   *time.Time
+}
+
+// resolveShare 查找有效的分享链接，已撤销、已过期或对话已在回收站中的链接视为不存在
+func (s *ShareService) resolveShare(token string) (*sharedSource, error) {
+	source := &sharedSource{}
+	var title sql.NullString
+	var expiresAt sql.NullTime
+	err := s.db.QueryRow(`
+		SELECT sh.id, c.character_id, c.title, sh.created_at, sh.expires_at
+		FROM conversation_shares sh
+		JOIN conversations c ON c.id = sh.conversation_id
+		WHERE sh.token_hash = ? AND sh.revoked_at IS NULL AND (sh.expires_at IS NULL OR sh.expires_at > ?)
+			AND c.deleted_at IS NULL
+	`, hashToken(token), time.Now()).Scan(&source.shareID, &source.characterID, &title, &source.createdAt, &expiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("share not found")
+		}
+		return nil, fmt.Errorf("failed to get share link: %w", err)
+	}
+
+	source.title = title.String
+	if expiresAt.Valid {
+		source.expiresAt = &expiresAt.Time
+	}
+	return source, nil
+}
+
+// GetSharedConversation 获取分享链接的消息快照，并累计访问次数。标题和角色信息按当前内容显示
+func (s *ShareService) GetSharedConversation(token string) (*models.SharedConversation, error) {
+	source, err := s.resolveShare(token)
+	if err != nil {
+		return nil, err
+	}
+
+	shared := &models.SharedConversation{
+		Title:     source.title,
+		Character: &models.SharedCharacter{},
+		Messages:  []*models.SharedMessage{},
+		SharedAt:  source.createdAt,
+		ExpiresAt: source.expiresAt,
+	}
+	var description, avatarURL sql.NullString
+	err = s.db.QueryRow("SELECT name, description, avatar_url FROM characters WHERE id = ?", source.characterID).
+		Scan(&shared.Character.Name, &description, &avatarURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get character: %w", err)
+	}
+	shared.Character.Description = description.String
+	shared.Character.AvatarURL = avatarURL.String
+
+	rows, err := s.db.Query(
+		"SELECT role, content, created_at FROM conversation_share_messages WHERE share_id = ? ORDER BY id",
+		source.shareID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query shared messages: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		message := &models.SharedMessage{}
+		if err := rows.Scan(&message.Role, &message.Content, &message.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan shared message: %w", err)
+		}
+		shared.Messages = append(shared.Messages, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to query shared messages: %w", err)
+	}
+
+	if _, err := s.db.Exec("UPDATE conversation_shares SET view_count = view_count + 1 WHERE id = ?", source.shareID); err != nil {
+		return nil, fmt.Errorf("failed to update view count: %w", err)
+	}
+
+	return shared, nil
+}
+
+// ForkSharedConversation 将分享的消息快照复制到当前用户的账户中继续对话，快照不包含语音
+func (s *ShareService) ForkSharedConversation(userID int, token string) (*models.Conversation, error) {
+	source, err := s.resolveShare(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.conversationService.copyConversation(userID, source.characterID, source.title, func(tx *sql.Tx, conversationID int64) (sql.Result, error) {
+		return tx.Exec(`
+			INSERT INTO messages (conversation_id, role, content, created_at)
+			SELECT ?, role, content, created_at
+			FROM conversation_share_messages
+			WHERE share_id = ?
+			ORDER BY id
+		`, conversationID, source.shareID)
+	})
+}
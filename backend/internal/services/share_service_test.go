@@ -0,0 +1,48 @@
+package services
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestGetSharedConversationReadsSnapshot(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	defer db.Close()
+
+	service := NewShareService(db, nil, NewAuditService(db), "")
+	now := time.Now()
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM conversation_shares sh")).
+		WithArgs(hashToken("token"), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "character_id", "title", "created_at", "expires_at"}).
+			AddRow(5, 3, "推理", now, nil))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT name, description, avatar_url FROM characters")).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"name", "description", "avatar_url"}).AddRow("福尔摩斯", nil, nil))
+	// 消息来自创建链接时保存的快照，而不是当前的消息表
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT role, content, created_at FROM conversation_share_messages WHERE share_id = ? ORDER BY id")).
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"role", "content", "created_at"}).
+			AddRow("user", "他是谁？", now).
+			AddRow("assistant", "一位刚从阿富汗回来的军医。", now))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE conversation_shares SET view_count = view_count + 1")).
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	shared, err := service.GetSharedConversation("token")
+	if err != nil {
+		t.Fatalf("GetSharedConversation: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if shared.Character.Name != "福尔摩斯" || len(shared.Messages) != 2 || shared.Messages[1].Content != "一位刚从阿富汗回来的军医。" {
+		t.Fatalf("unexpected shared conversation %+v", shared)
+	}
+}
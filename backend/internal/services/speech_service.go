@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"role-play-ai/internal/models"
+	"role-play-ai/internal/synthesizer"
+)
+
+// SpeechService 使用角色的音色将AI回复合成为语音
+type SpeechService struct {
+	db           *sql.DB
+	synthesizer  synthesizer.Synthesizer
+	mediaService *MediaService
+}
+
+func NewSpeechService(db *sql.DB, s synthesizer.Synthesizer, mediaService *MediaService) *SpeechService {
+	return &SpeechService{db: db, synthesizer: s, mediaService: mediaService}
+}
+
+// Enabled 是否配置了语音合成服务
+func (s *SpeechService) Enabled() bool {
+	return s.synthesizer != nil
+}
+
+// SynthesizeMessage 合成AI消息的语音并保存到消息的audio_url，已经有语音的消息直接返回。
+// 同时返回可以直接播放的签名地址
+func (s *SpeechService) SynthesizeMessage(ctx context.Context, userID, conversationID, messageID int) (*models.Message, string, error) {
+	if s.synthesizer == nil {
+		return nil, "", fmt.Errorf("text-to-speech is not configured")
+	}
+
+	message, err := scanMessage(s.db.QueryRow(`
+		SELECT m.id, m.conversation_id, m.role, m.content, m.audio_url, m.model, m.created_at
+		FROM messages m
+		JOIN conversations c ON c.id = m.conversation_id
+		WHERE m.id = ? AND m.conversation_id = ? AND c.user_id = ? AND c.deleted_at IS NULL
+	`, messageID, conversationID, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, "", fmt.Errorf("message not found")
+		}
+		return nil, "", fmt.Errorf("failed to get message: %w", err)
+	}
+	if message.Role != "assistant" {
+		return nil, "", fmt.Errorf("only assistant messages can be spoken")
+	}
+	if message.AudioURL != nil && *message.AudioURL != "" {
+		return message, s.mediaService.signMessageAudio(*message.AudioURL), nil
+	}
+
+	var voice sql.NullString
+	var speed float64
+	err = s.db.QueryRow(`
+		SELECT ch.voice, ch.voice_speed
+		FROM conversations c
+		JOIN characters ch ON ch.id = c.character_id
+		WHERE c.id = ?
+	`, conversationID).Scan(&voice, &speed)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get character voice: %w", err)
+	}
+
+	speech, err := s.synthesize(ctx, message.Content, &synthesizer.Voice{Name: voice.String, Speed: speed})
+	if err != nil {
+		return nil, "", err
+	}
+	blob, err := s.mediaService.UploadAudio(ctx, userID, speech.Data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// 并发请求时只保留先保存的语音，未被引用的文件由垃圾回收清理
+	if _, err := s.db.Exec("UPDATE messages SET audio_url = ? WHERE id = ? AND (audio_url IS NULL OR audio_url = '')", blob.URL, messageID); err != nil {
+		return nil, "", fmt.Errorf("failed to update message: %w", err)
+	}
+
+	message, err = scanMessage(s.db.QueryRow("SELECT "+messageColumns+" FROM messages WHERE id = ?", messageID))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get message: %w", err)
+	}
+	return message, s.mediaService.signMessageAudio(*message.AudioURL), nil
+}
+
+// SynthesizeSentence 用角色的音色合成一句话并保存，返回音频的签名地址，用于流式回复时逐句朗读。
+// 句子音频不关联消息，过了保留期后由垃圾回收清理
+func (s *SpeechService) SynthesizeSentence(ctx context.Context, userID int, character *models.Character, text string) (string, error) {
+	if s.synthesizer == nil {
+		return "", fmt.Errorf("text-to-speech is not configured")
+	}
+	speech, err := s.synthesize(ctx, text, &synthesizer.Voice{Name: character.Voice, Speed: character.VoiceSpeed})
+	if err != nil {
+		return "", err
+	}
+	blob, err := s.mediaService.UploadAudio(ctx, userID, speech.Data)
+	if err != nil {
+		return "", err
+	}
+	return blob.SignedURL, nil
+}
+
+func (s *SpeechService) synthesize(ctx context.Context, content string, voice *synthesizer.Voice) (*synthesizer.Speech, error) {
+	text := speechText(content)
+	if text == "" {
+		return nil, fmt.Errorf("nothing to speak")
+	}
+	speech, err := s.synthesizer.Synthesize(ctx, text, voice)
+	if err != nil {
+		return nil, fmt.Errorf("failed to synthesize speech: %w", err)
+	}
+	return speech, nil
+}
+
+var (
+	codeBlockPattern    = regexp.MustCompile("(?s)```.*?```")
+	markdownLinkPattern = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+	markdownMarkPattern = regexp.MustCompile("[*_#>`~|]+")
+)
+
+// speechText 去掉Markdown标记和代码块，只朗读文字内容
+func speechText(content string) string {
+	text := codeBlockPattern.ReplaceAllString(content, " ")
+	text = markdownLinkPattern.ReplaceAllString(text, "$1")
+	text = markdownMarkPattern.ReplaceAllString(text, "")
+	text = strings.Join(strings.Fields(text), " ")
+	if !strings.ContainsFunc(text, func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }) {
+		return ""
+	}
+	return text
+}
+
+// SentenceSplitter 将流式输出的文本切分为完整的句子，用于逐句合成语音
+type SentenceSplitter struct {
+	buf []rune
+}
+
+// sentenceEnds 句末标点，英文句点只在后面跟空白时断句，避免拆开小数和缩写
+const sentenceEnds = "。！？!?；;…\n"
+
+// Add 追加一段文本，返回已经完整的句子
+func (s *SentenceSplitter) Add(delta string) []string {
+	s.buf = append(s.buf, []rune(delta)...)
+
+	var sentences []string
+	start := 0
+	for i := 0; i < len(s.buf); i++ {
+		r := s.buf[i]
+		end := strings.ContainsRune(sentenceEnds, r)
+		if r == '.' && i+1 < len(s.buf) && unicode.IsSpace(s.buf[i+1]) {
+			end = true
+		}
+		if !end {
+			continue
+		}
+		// 连续的标点和后引号归入同一句
+		for i+1 < len(s.buf) && (strings.ContainsRune(sentenceEnds+".\"'”’」』）)", s.buf[i+1])) {
+			i++
+		}
+		if i+1 == len(s.buf) && r != '\n' {
+			// 还可能有后续标点，等下一段文本到达再断句
+			break
+		}
+		if sentence := strings.TrimSpace(string(s.buf[start : i+1])); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = i + 1
+	}
+	s.buf = s.buf[start:]
+	return sentences
+}
+
+// Flush 返回剩余的未完整句子
+func (s *SentenceSplitter) Flush() string {
+	rest := strings.TrimSpace(string(s.buf))
+	s.buf = nil
+	return rest
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"role-play-ai/internal/config"
+	"role-play-ai/internal/storage"
+	"role-play-ai/internal/synthesizer"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSentenceSplitter(t *testing.T) {
+	tests := []struct {
+		name   string
+		chunks []string
+		want   []string
+		rest   string
+	}{
+		{
+			name:   "cjk sentences",
+			chunks: []string{"你好。我是苏格拉底。"},
+			want:   []string{"你好。"},
+			rest:   "我是苏格拉底。",
+		},
+		{
+			name:   "punctuation split across chunks",
+			chunks: []string{"真的吗？", "！后来呢"},
+			want:   []string{"真的吗？！"},
+			rest:   "后来呢",
+		},
+		{
+			name:   "ellipsis split across chunks",
+			chunks: []string{"嗯…", "…好吧；", "走"},
+			want:   []string{"嗯……", "好吧；"},
+			rest:   "走",
+		},
+		{
+			name:   "decimal split across chunks",
+			chunks: []string{"圆周率约为3.", "14。", "对"},
+			want:   []string{"圆周率约为3.14。"},
+			rest:   "对",
+		},
+		{
+			name:   "trailing period waits for whitespace",
+			chunks: []string{"Hello world.", " How are you?"},
+			want:   []string{"Hello world."},
+			rest:   "How are you?",
+		},
+		{
+			name:   "closing quotes stay with the sentence",
+			chunks: []string{"他说：“走吧。”", "然后离开了。\"Bye!\" she said"},
+			want:   []string{"他说：“走吧。”", "然后离开了。\"", "Bye!\""},
+			rest:   "she said",
+		},
+		{
+			name:   "newline always ends a sentence",
+			chunks: []string{"第一行\n", "\n第二行"},
+			want:   []string{"第一行"},
+			rest:   "第二行",
+		},
+		{
+			name:   "no sentence end",
+			chunks: []string{"还没", "说完"},
+			want:   nil,
+			rest:   "还没说完",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var splitter SentenceSplitter
+			var got []string
+			for _, chunk := range tt.chunks {
+				got = append(got, splitter.Add(chunk)...)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected sentences %q, got %q", tt.want, got)
+			}
+			if rest := splitter.Flush(); rest != tt.rest {
+				t.Fatalf("expected rest %q, got %q", tt.rest, rest)
+			}
+		})
+	}
+}
+
+// capturedArg 匹配任意参数并记下它的值
+type capturedArg struct {
+	value driver.Value
+}
+
+func (a *capturedArg) Match(v driver.Value) bool {
+	a.value = v
+	return true
+}
+
+const (
+	speechMessageQuery = "WHERE m.id = ? AND m.conversation_id = ? AND c.user_id = ?"
+	speechAudioUpdate  = "UPDATE messages SET audio_url = ? WHERE id = ? AND (audio_url IS NULL OR audio_url = '')"
+)
+
+func newTestSpeechService(t *testing.T) (*SpeechService, sqlmock.Sqlmock, string) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to create sqlmock: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	dir := t.TempDir()
+	mediaService := NewMediaService(db, storage.NewLocalStore(dir), &config.Config{JWTSecret: "test"})
+	return NewSpeechService(db, synthesizer.NewFakeSynthesizer(), mediaService), mock, dir
+}
+
+func speechMessageRow(audioURL interface{}) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "conversation_id", "role", "content", "audio_url", "model", "created_at"}).
+		AddRow(100, 1, "assistant", "**你好**，我是苏格拉底。", audioURL, "test-model", time.Now())
+}
+
+// expectSynthesis 期望一次合成：查询音色、保存音频，并在消息还没有语音时写入audio_url
+func expectSynthesis(mock sqlmock.Sqlmock, savedURL *capturedArg, rowsAffected int64) {
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT ch.voice, ch.voice_speed")).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"voice", "voice_speed"}).AddRow(nil, 1.0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO media_blobs")).
+		WithArgs(sqlmock.AnyArg(), 7, "audio", "audio/wav", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta(speechAudioUpdate)).
+		WithArgs(savedURL, 100).
+		WillReturnResult(sqlmock.NewResult(0, rowsAffected))
+}
+
+func TestSynthesizeMessage(t *testing.T) {
+	service, mock, dir := newTestSpeechService(t)
+	storedURL := mediaURLPrefix + audioKeyPrefix + strings.Repeat("s", 43) + ".wav"
+
+	savedURL := &capturedArg{}
+	mock.ExpectQuery(regexp.QuoteMeta(speechMessageQuery)).
+		WithArgs(100, 1, 7).
+		WillReturnRows(speechMessageRow(nil))
+	expectSynthesis(mock, savedURL, 1)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM messages WHERE id = ?")).
+		WithArgs(100).
+		WillReturnRows(speechMessageRow(storedURL))
+
+	message, signedURL, err := service.SynthesizeMessage(context.Background(), 7, 1, 100)
+	if err != nil {
+		t.Fatalf("SynthesizeMessage: %v", err)
+	}
+	if message.AudioURL == nil || *message.AudioURL != storedURL {
+		t.Fatalf("expected audio_url %s, got %v", storedURL, message.AudioURL)
+	}
+	if !strings.HasPrefix(signedURL, storedURL+"?expires=") {
+		t.Fatalf("unexpected signed URL %s", signedURL)
+	}
+	// 合成的语音已保存，消息引用的正是这个文件
+	saved, _ := savedURL.value.(string)
+	if !strings.HasPrefix(saved, mediaURLPrefix+audioKeyPrefix) {
+		t.Fatalf("unexpected saved audio_url %v", savedURL.value)
+	}
+	if _, err := os.Stat(filepath.Join(dir, strings.TrimPrefix(saved, mediaURLPrefix))); err != nil {
+		t.Fatalf("expected synthesized audio to be stored at %q: %v", saved, err)
+	}
+
+	// 已有语音的消息不再合成，也不再写入
+	mock.ExpectQuery(regexp.QuoteMeta(speechMessageQuery)).
+		WithArgs(100, 1, 7).
+		WillReturnRows(speechMessageRow(storedURL))
+
+	message, signedURL, err = service.SynthesizeMessage(context.Background(), 7, 1, 100)
+	if err != nil {
+		t.Fatalf("SynthesizeMessage: %v", err)
+	}
+	if *message.AudioURL != storedURL || !strings.HasPrefix(signedURL, storedURL+"?expires=") {
+		t.Fatalf("expected existing audio to be reused, got %s", signedURL)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "audio")); len(entries) != 1 {
+		t.Fatalf("expected exactly one synthesized file, found %d", len(entries))
+	}
+}
+
+func TestSynthesizeMessageKeepsFirstAudio(t *testing.T) {
+	service, mock, _ := newTestSpeechService(t)
+	firstURL := mediaURLPrefix + audioKeyPrefix + strings.Repeat("f", 43) + ".wav"
+
+	// 并发请求先保存了语音，本次写入不生效，返回先保存的语音
+	savedURL := &capturedArg{}
+	mock.ExpectQuery(regexp.QuoteMeta(speechMessageQuery)).
+		WithArgs(100, 1, 7).
+		WillReturnRows(speechMessageRow(nil))
+	expectSynthesis(mock, savedURL, 0)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM messages WHERE id = ?")).
+		WithArgs(100).
+		WillReturnRows(speechMessageRow(firstURL))
+
+	message, signedURL, err := service.SynthesizeMessage(context.Background(), 7, 1, 100)
+	if err != nil {
+		t.Fatalf("SynthesizeMessage: %v", err)
+	}
+	if *message.AudioURL != firstURL || !strings.HasPrefix(signedURL, firstURL+"?expires=") {
+		t.Fatalf("expected the first saved audio, got %s", signedURL)
+	}
+	if savedURL.value == firstURL {
+		t.Fatal("expected a new audio file to be written before losing the race")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSynthesizeMessageRejectsUserMessages(t *testing.T) {
+	service, mock, _ := newTestSpeechService(t)
+
+	mock.ExpectQuery(regexp.QuoteMeta(speechMessageQuery)).
+		WithArgs(100, 1, 7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "conversation_id", "role", "content", "audio_url", "model", "created_at"}).
+			AddRow(100, 1, "user", "你好", nil, nil, time.Now()))
+
+	_, _, err := service.SynthesizeMessage(context.Background(), 7, 1, 100)
+	if err == nil || err.Error() != "only assistant messages can be spoken" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// RFC 6238 附录B的SHA-1测试密钥 "12345678901234567890"
+var (
+	rfc6238Key    = []byte("12345678901234567890")
+	rfc6238Secret = totpEncoding.EncodeToString(rfc6238Key)
+)
+
+func TestTOTPCodeRFC6238Vectors(t *testing.T) {
+	// 附录B给出8位验证码，6位验证码是其后6位
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},          // 94287082
+		{1111111109, "081804"},  // 07081804
+		{1111111111, "050471"},  // 14050471
+		{1234567890, "005924"},  // 89005924
+		{2000000000, "279037"},  // 69279037
+		{20000000000, "353130"}, // 65353130
+	}
+
+	for _, tt := range tests {
+		if got := totpCode(rfc6238Key, tt.unix/totpPeriod); got != tt.want {
+			t.Errorf("totpCode at %d: expected %s, got %s", tt.unix, tt.want, got)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	// 1111111111 属于时间步 37037037，对应验证码 050471
+	const step = 1111111111 / totpPeriod
+	at := func(offset int64) time.Time {
+		return time.Unix(1111111111+offset*totpPeriod, 0)
+	}
+
+	tests := []struct {
+		name     string
+		code     string
+		now      time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", "050471", at(0), step, true},
+		{"surrounding whitespace", " 050471 ", at(0), step, true},
+		{"client one step behind", "050471", at(1), step, true},
+		{"client one step ahead", "050471", at(-1), step, true},
+		{"two steps behind", "050471", at(2), 0, false},
+		{"two steps ahead", "050471", at(-2), 0, false},
+		{"wrong code", "123456", at(0), 0, false},
+		{"too short", "50471", at(0), 0, false},
+		{"too long", "14050471", at(0), 0, false},
+		{"empty", "", at(0), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := validateTOTP(rfc6238Secret, tt.code, tt.now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Fatalf("expected (%d, %v), got (%d, %v)", tt.wantStep, tt.wantOK, gotStep, ok)
+			}
+		})
+	}
+}
+
+func TestValidateTOTPInvalidSecret(t *testing.T) {
+	if _, ok := validateTOTP("not base32!", "050471", time.Unix(1111111111, 0)); ok {
+		t.Fatal("expected invalid secret to be rejected")
+	}
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"role-play-ai/internal/database"
+)
+
+const (
+	recoveryCodeCount          = 10
+	maxLoginChallengeAttempts  = 5
+	recoveryCodeAlphabet       = "abcdefghijkmnpqrstuvwxyz23456789" // 32个字符，取模无偏差
+	recoveryCodeHalfLength     = 5
+	totpReplayProtectionExpiry = (2*totpSkew + 1) * totpPeriod * time.Second
+)
+
+type TwoFactorService struct {
+	db     *sql.DB
+	issuer string
+}
+
+func NewTwoFactorService(db *sql.DB, issuer string) *TwoFactorService {
+	return &TwoFactorService{db: db, issuer: issuer}
+}
+
+// IsEnabled 检查用户是否开启了两步验证
+func (s *TwoFactorService) IsEnabled(userID int) (bool, error) {
+	var enabled bool
+	err := s.db.QueryRow("SELECT totp_enabled FROM users WHERE id = ?", userID).Scan(&enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, fmt.Errorf("user not found")
+		}
+		return false, fmt.Errorf("failed to get two-factor status: %w", err)
+	}
+	return enabled, nil
+}
+
+// RemainingRecoveryCodes 获取未使用的恢复码数量
+func (s *TwoFactorService) RemainingRecoveryCodes(userID int) (int, error) {
+	var count int
+	err := s.db.QueryRow(
+		"SELECT COUNT(*) FROM user_recovery_codes WHERE user_id = ? AND used_at IS NULL",
+		userID,
+	).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovery codes: %w", err)
+	}
+	return count, nil
+}
+
+// BeginEnrollment 生成新的TOTP密钥（尚未启用），返回密钥和otpauth URI
+func (s *TwoFactorService) BeginEnrollment(userID int) (string, string, error) {
+	var email string
+	var enabled bool
+	err := s.db.QueryRow("SELECT email, totp_enabled FROM users WHERE id = ?", userID).Scan(&email, &enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", fmt.Errorf("user not found")
+		}
+		return "", "", fmt.Errorf("failed to get user: %w", err)
+	}
+	if enabled {
+		return "", "", fmt.Errorf("two-factor authentication already enabled")
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate secret: %w", err)
+	}
+
+	_, err = s.db.Exec("UPDATE users SET totp_secret = ? WHERE id = ?", secret, userID)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to store secret: %w", err)
+	}
+
+	return secret, totpProvisioningURI(s.issuer, email, secret), nil
+}
+
+// ConfirmEnrollment 使用验证码确认并启用两步验证，返回一次性展示的恢复码
+func (s *TwoFactorService) ConfirmEnrollment(userID int, code string) ([]string, error) {
+	var secret sql.NullString
+	var enabled bool
+	err := s.db.QueryRow("SELECT totp_secret, totp_enabled FROM users WHERE id = ?", userID).Scan(&secret, &enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if enabled {
+		return nil, fmt.Errorf("two-factor authentication already enabled")
+	}
+	if !secret.Valid || secret.String == "" {
+		return nil, fmt.Errorf("two-factor enrollment not started")
+	}
+
+	step, ok := validateTOTP(secret.String, code, time.Now())
+	if !ok || !s.markStepUsed(userID, step) {
+		return nil, fmt.Errorf("invalid verification code")
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_enabled = TRUE WHERE id = ?", userID); err != nil {
+		return nil, fmt.Errorf("failed to enable two-factor authentication: %w", err)
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return codes, nil
+}
+
+// Disable 关闭两步验证并删除密钥和恢复码
+func (s *TwoFactorService) Disable(userID int, code string) error {
+	if err := s.Verify(userID, code); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE users SET totp_secret = NULL, totp_enabled = FALSE WHERE id = ?", userID); err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes 验证后重新生成恢复码，旧恢复码全部失效
+func (s *TwoFactorService) RegenerateRecoveryCodes(userID int, code string) ([]string, error) {
+	if err := s.Verify(userID, code); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return codes, nil
+}
+
+// Verify 校验TOTP验证码或恢复码，恢复码使用后即失效
+func (s *TwoFactorService) Verify(userID int, code string) error {
+	var secret sql.NullString
+	var enabled bool
+	err := s.db.QueryRow("SELECT totp_secret, totp_enabled FROM users WHERE id = ?", userID).Scan(&secret, &enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user not found")
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+	if !enabled || !secret.Valid {
+		return fmt.Errorf("two-factor authentication not enabled")
+	}
+
+	if step, ok := validateTOTP(secret.String, code, time.Now()); ok {
+		if !s.markStepUsed(userID, step) {
+			return fmt.Errorf("invalid verification code")
+		}
+		return nil
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE user_recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		userID, hashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to verify recovery code: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("invalid verification code")
+	}
+	return nil
+}
+
+// CreateLoginChallenge 密码验证通过后签发短期挑战令牌，完成两步验证后才创建会话
+func (s *TwoFactorService) CreateLoginChallenge(userID int) (string, error) {
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate challenge token: %w", err)
+	}
+
+	challengeKey := &database.CacheKey{Prefix: database.LoginChallengePrefix, ID: hashToken(token)}
+	if err := database.SetCache(challengeKey.String(), userID, database.LoginChallengeExpiry); err != nil {
+		return "", fmt.Errorf("failed to store challenge: %w", err)
+	}
+
+	return token, nil
+}
+
+// CompleteLoginChallenge 校验挑战令牌和验证码，成功后令牌失效并返回用户ID
+func (s *TwoFactorService) CompleteLoginChallenge(token, code string) (int, error) {
+	tokenHash := hashToken(token)
+	challengeKey := &database.CacheKey{Prefix: database.LoginChallengePrefix, ID: tokenHash}
+	attemptsKey := &database.CacheKey{Prefix: database.LoginChallengePrefix, ID: "attempts:" + tokenHash}
+
+	cached, err := database.GetCache(challengeKey.String())
+	if err != nil {
+		return 0, fmt.Errorf("invalid or expired challenge")
+	}
+	userID, err := strconv.Atoi(cached)
+	if err != nil {
+		return 0, fmt.Errorf("invalid or expired challenge")
+	}
+
+	if err := s.Verify(userID, code); err != nil {
+		// 限制单个挑战的尝试次数，防止暴力破解验证码
+		attempts, _ := database.IncrementCache(attemptsKey.String(), database.LoginChallengeExpiry)
+		if attempts >= maxLoginChallengeAttempts {
+			database.DeleteCache(challengeKey.String())
+			database.DeleteCache(attemptsKey.String())
+		}
+		return 0, err
+	}
+
+	database.DeleteCache(challengeKey.String())
+	database.DeleteCache(attemptsKey.String())
+	return userID, nil
+}
+
+// markStepUsed 记录已使用的时间步，同一验证码不能重复使用
+func (s *TwoFactorService) markStepUsed(userID int, step int64) bool {
+	key := &database.CacheKey{Prefix: database.TOTPUsedPrefix, ID: fmt.Sprintf("%d:%d", userID, step)}
+	ok, err := database.RedisClient.SetNX(database.Ctx, key.String(), 1, totpReplayProtectionExpiry).Result()
+	return err == nil && ok
+}
+
+func replaceRecoveryCodes(tx *sql.Tx, userID int) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		_, err = tx.Exec(
+			"INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID, hashToken(normalizeRecoveryCode(code)),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to store recovery code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode 生成形如 abcde-fghjk 的恢复码
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeHalfLength*2)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = recoveryCodeAlphabet[int(b[i])%len(recoveryCodeAlphabet)]
+	}
+	return string(b[:recoveryCodeHalfLength]) + "-" + string(b[recoveryCodeHalfLength:]), nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
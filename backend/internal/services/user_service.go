@@ -2,46 +2,120 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"role-play-ai/internal/config"
+	"role-play-ai/internal/database"
+	"role-play-ai/internal/mailer"
 	"role-play-ai/internal/models"
 
 	"golang.org/x/crypto/bcrypt"
 )
 
+const userColumns = "id, username, email, role, email_verified_at, deletion_scheduled_at, created_at, updated_at"
+
+// ErrInvalidCredentials 邮箱或密码错误
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// dummyPasswordHash 返回一个与真实密码同等代价的哈希，用于对不存在的账户做等时比较
+func dummyPasswordHash() []byte {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("role-play-ai-dummy-password"), bcrypt.DefaultCost)
+	})
+	return dummyHash
+}
+
 type UserService struct {
-	db *sql.DB
+	db           *sql.DB
+	mailer       mailer.Mailer
+	auditService *AuditService
+	appBaseURL   string
+	deleteGrace  time.Duration
 }
 
-func NewUserService(db *sql.DB) *UserService {
-	return &UserService{db: db}
+func NewUserService(db *sql.DB, m mailer.Mailer, auditService *AuditService, cfg *config.Config) *UserService {
+	graceDays, err := strconv.Atoi(cfg.DeleteGraceDays)
+	if err != nil || graceDays < 0 {
+		graceDays = 7
+	}
+
+	return &UserService{
+		db:           db,
+		mailer:       m,
+		auditService: auditService,
+		appBaseURL:   strings.TrimRight(cfg.AppBaseURL, "/"),
+		deleteGrace:  time.Duration(graceDays) * 24 * time.Hour,
+	}
 }
 
-func (s *UserService) CreateUser(req *models.UserRegister) (*models.User, error) {
-	// 检查用户名是否已存在
-	var count int
-	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", req.Username).Scan(&count)
-	if err != nil {
-		return nil, fmt.Errorf("failed to check username: %w", err)
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanUser(row rowScanner, extra ...interface{}) (*models.User, error) {
+	user := &models.User{}
+	var verifiedAt, deletionScheduledAt sql.NullTime
+	dest := append([]interface{}{&user.ID, &user.Username, &user.Email, &user.Role, &verifiedAt, &deletionScheduledAt, &user.CreatedAt, &user.UpdatedAt}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
 	}
-	if count > 0 {
-		return nil, fmt.Errorf("username already exists")
+	if verifiedAt.Valid {
+		user.EmailVerified = true
+		user.EmailVerifiedAt = &verifiedAt.Time
+	}
+	if deletionScheduledAt.Valid {
+		user.DeletionScheduledAt = &deletionScheduledAt.Time
 	}
+	return user, nil
+}
 
-	// 检查邮箱是否已存在
-	err = s.db.QueryRow("SELECT COUNT(*) FROM users WHERE email = ?", req.Email).Scan(&count)
+// Register 注册用户。用户名或邮箱已被占用时同样返回成功，具体结果通过邮件告知邮箱所有者，
+// 避免通过注册接口探测某个邮箱或用户名是否已注册
+func (s *UserService) Register(req *models.UserRegister) error {
+	// 所有分支都先完成密码哈希，避免响应时间泄露账户是否存在
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		return nil, fmt.Errorf("failed to check email: %w", err)
+		return fmt.Errorf("failed to hash password: %w", err)
 	}
-	if count > 0 {
-		return nil, fmt.Errorf("email already exists")
+
+	// 检查邮箱是否已存在
+	var existingUsername string
+	err = s.db.QueryRow("SELECT username FROM users WHERE email = ?", req.Email).Scan(&existingUsername)
+	if err == nil {
+		s.sendAsync(&mailer.Message{
+			To:      req.Email,
+			Subject: "有人尝试使用您的邮箱注册",
+			Body: fmt.Sprintf(
+				"%s，您好：\n\n有人刚刚尝试使用此邮箱注册新账户，但该邮箱已经注册过。\n\n如果是您本人，可以直接登录；忘记密码可以在这里重置：\n\n%s/forgot-password\n\n如果这不是您本人的操作，请忽略此邮件。\n",
+				existingUsername, s.appBaseURL,
+			),
+		})
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("failed to check email: %w", err)
 	}
 
-	// 加密密码
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	// 检查用户名是否已存在
+	var count int
+	err = s.db.QueryRow("SELECT COUNT(*) FROM users WHERE username = ?", req.Username).Scan(&count)
 	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %w", err)
+		return fmt.Errorf("failed to check username: %w", err)
+	}
+	if count > 0 {
+		s.sendUsernameTaken(req)
+		return nil
 	}
 
 	// 插入用户
@@ -50,30 +124,50 @@ func (s *UserService) CreateUser(req *models.UserRegister) (*models.User, error)
 		req.Username, req.Email, string(hashedPassword),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create user: %w", err)
+		// 并发注册时由唯一索引兜底
+		if strings.Contains(err.Error(), "Duplicate entry") {
+			s.sendUsernameTaken(req)
+			return nil
+		}
+		return fmt.Errorf("failed to create user: %w", err)
 	}
 
 	userID, err := result.LastInsertId()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user ID: %w", err)
+		return fmt.Errorf("failed to get user ID: %w", err)
 	}
 
-	// 获取创建的用户
-	user, err := s.GetUserByID(int(userID))
-	if err != nil {
-		return nil, fmt.Errorf("failed to get created user: %w", err)
-	}
+	go func() {
+		if err := s.SendEmailVerification(int(userID)); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", userID, err)
+		}
+	}()
 
-	return user, nil
+	return nil
 }
 
-func (s *UserService) GetUserByEmail(email string) (*models.User, error) {
-	user := &models.User{}
-	err := s.db.QueryRow(
-		"SELECT id, username, email, created_at, updated_at FROM users WHERE email = ?",
-		email,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.UpdatedAt)
+func (s *UserService) sendUsernameTaken(req *models.UserRegister) {
+	s.sendAsync(&mailer.Message{
+		To:      req.Email,
+		Subject: "注册未完成",
+		Body: fmt.Sprintf(
+			"您好：\n\n您刚刚尝试注册的用户名“%s”已被占用，账户未创建。请更换用户名后重新注册：\n\n%s\n\n如果这不是您本人的操作，请忽略此邮件。\n",
+			req.Username, s.appBaseURL,
+		),
+	})
+}
+
+// sendAsync 异步发送邮件，发送耗时不影响接口响应时间
+func (s *UserService) sendAsync(msg *mailer.Message) {
+	go func() {
+		if err := s.mailer.Send(msg); err != nil {
+			log.Printf("Failed to send email to %s: %v", msg.To, err)
+		}
+	}()
+}
 
+func (s *UserService) GetUserByEmail(email string) (*models.User, error) {
+	user, err := scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE email = ?", email))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user not found")
@@ -85,12 +179,7 @@ func (s *UserService) GetUserByEmail(email string) (*models.User, error) {
 }
 
 func (s *UserService) GetUserByID(id int) (*models.User, error) {
-	user := &models.User{}
-	err := s.db.QueryRow(
-		"SELECT id, username, email, created_at, updated_at FROM users WHERE id = ?",
-		id,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.UpdatedAt)
-
+	user, err := scanUser(s.db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, fmt.Errorf("user not found")
@@ -101,36 +190,425 @@ func (s *UserService) GetUserByID(id int) (*models.User, error) {
 	return user, nil
 }
 
-func (s *UserService) VerifyPassword(email, password string) (*models.User, error) {
-	var userID int
-	var username, emailAddr, passwordHash string
-	var createdAt, updatedAt interface{}
+// ListUsers 分页获取用户列表，query匹配用户名或邮箱
+func (s *UserService) ListUsers(query string, page, limit int) ([]*models.User, int, error) {
+	where := ""
+	args := []interface{}{}
+	if query != "" {
+		where = "WHERE username LIKE ? OR email LIKE ?"
+		searchTerm := "%" + query + "%"
+		args = append(args, searchTerm, searchTerm)
+	}
 
-	err := s.db.QueryRow(
-		"SELECT id, username, email, password_hash, created_at, updated_at FROM users WHERE email = ?",
-		email,
-	).Scan(&userID, &username, &emailAddr, &passwordHash, &createdAt, &updatedAt)
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM users "+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count users: %w", err)
+	}
 
+	rows, err := s.db.Query(
+		"SELECT "+userColumns+" FROM users "+where+" ORDER BY id DESC LIMIT ? OFFSET ?",
+		append(args, limit, (page-1)*limit)...,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []*models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	return users, total, nil
+}
+
+// GetUserRole 获取用户角色（带缓存），供权限中间件使用
+func (s *UserService) GetUserRole(userID int) (string, error) {
+	cacheKey := &database.CacheKey{Prefix: database.UserCachePrefix, ID: fmt.Sprintf("%d:role", userID)}
+	if cached, err := database.GetCache(cacheKey.String()); err == nil && cached != "" {
+		return cached, nil
+	}
+
+	var role string
+	err := s.db.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&role)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("invalid credentials")
+			return "", fmt.Errorf("user not found")
+		}
+		return "", fmt.Errorf("failed to get user role: %w", err)
+	}
+
+	database.SetCache(cacheKey.String(), role, database.UserCacheExpiry)
+	return role, nil
+}
+
+// SetUserRole 修改用户角色并清除角色缓存
+func (s *UserService) SetUserRole(actor *models.AuditActor, userID int, role string) error {
+	previousRole, err := s.GetUserRole(userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := s.db.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update user role: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		// 角色未变化时MySQL也返回0，需要区分用户是否存在
+		if _, err := s.GetUserByID(userID); err != nil {
+			return err
+		}
+	}
+
+	cacheKey := &database.CacheKey{Prefix: database.UserCachePrefix, ID: fmt.Sprintf("%d:role", userID)}
+	database.DeleteCache(cacheKey.String())
+
+	s.auditService.Record(actor, models.AuditUserRoleChange, "user", strconv.Itoa(userID), map[string]interface{}{
+		"from": previousRole,
+		"to":   role,
+	})
+
+	return nil
+}
+
+func (s *UserService) VerifyPassword(email, password string) (*models.User, error) {
+	var passwordHash string
+	user, err := scanUser(
+		s.db.QueryRow("SELECT "+userColumns+", password_hash FROM users WHERE email = ?", email),
+		&passwordHash,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			// 邮箱不存在时同样做一次哈希比较，避免通过响应时间区分账户是否存在
+			bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+			return nil, ErrInvalidCredentials
 		}
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
+	// 仅通过单点登录创建的账户没有密码
+	if passwordHash == "" {
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
 	// 验证密码
 	err = bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password))
 	if err != nil {
-		return nil, fmt.Errorf("invalid credentials")
+		return nil, ErrInvalidCredentials
+	}
+
+	return user, nil
+}
+
+// CheckPassword 验证指定用户的密码
+func (s *UserService) CheckPassword(userID int, password string) error {
+	var passwordHash string
+	err := s.db.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&passwordHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user not found")
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+		return fmt.Errorf("password is incorrect")
+	}
+
+	return nil
+}
+
+// ConfirmPassword 敏感操作前二次确认密码，仅通过单点登录创建的账户没有密码，跳过验证
+func (s *UserService) ConfirmPassword(userID int, password string) error {
+	var passwordHash string
+	err := s.db.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&passwordHash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user not found")
+		}
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if passwordHash == "" {
+		return nil
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+		return fmt.Errorf("password is incorrect")
+	}
+
+	return nil
+}
+
+// ChangePassword 修改密码，需要验证当前密码
+func (s *UserService) ChangePassword(actor *models.AuditActor, userID int, currentPassword, newPassword string) error {
+	if err := s.CheckPassword(userID, currentPassword); err != nil {
+		return err
+	}
+
+	if err := s.setPassword(userID, newPassword); err != nil {
+		return err
+	}
+
+	s.auditService.Record(actor, models.AuditPasswordChange, "user", strconv.Itoa(userID), nil)
+	return nil
+}
+
+// SendPasswordReset 生成一次性密码重置令牌并发送邮件，邮箱不存在时静默返回
+func (s *UserService) SendPasswordReset(email string) error {
+	user, err := s.GetUserByEmail(email)
+	if err != nil {
+		return nil
+	}
+
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	// 每个用户只保留最新的重置令牌
+	userKey := &database.CacheKey{Prefix: database.PasswordResetPrefix, ID: fmt.Sprintf("user:%d", user.ID)}
+	if previous, err := database.GetCache(userKey.String()); err == nil {
+		previousKey := &database.CacheKey{Prefix: database.PasswordResetPrefix, ID: previous}
+		database.DeleteCache(previousKey.String())
+	}
+
+	tokenHash := hashToken(token)
+	tokenKey := &database.CacheKey{Prefix: database.PasswordResetPrefix, ID: tokenHash}
+	if err := database.SetCache(tokenKey.String(), user.ID, database.PasswordResetExpiry); err != nil {
+		return fmt.Errorf("failed to store token: %w", err)
+	}
+	database.SetCache(userKey.String(), tokenHash, database.PasswordResetExpiry)
+
+	return s.mailer.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: "重置您的密码",
+		Body: fmt.Sprintf(
+			"%s，您好：\n\n我们收到了重置您账户密码的请求。请在1小时内打开以下链接设置新密码：\n\n%s/reset-password?token=%s\n\n如果这不是您本人的操作，请忽略此邮件。\n",
+			user.Username, s.appBaseURL, token,
+		),
+	})
+}
+
+// ResetPassword 使用重置令牌设置新密码，令牌只能使用一次，返回用户ID
+func (s *UserService) ResetPassword(actor *models.AuditActor, token, newPassword string) (int, error) {
+	tokenKey := &database.CacheKey{Prefix: database.PasswordResetPrefix, ID: hashToken(token)}
+	cached, err := database.GetDelCache(tokenKey.String())
+	if err != nil {
+		return 0, fmt.Errorf("invalid or expired token")
+	}
+
+	userID, err := strconv.Atoi(cached)
+	if err != nil {
+		return 0, fmt.Errorf("invalid or expired token")
+	}
+
+	userKey := &database.CacheKey{Prefix: database.PasswordResetPrefix, ID: fmt.Sprintf("user:%d", userID)}
+	database.DeleteCache(userKey.String())
+
+	if err := s.setPassword(userID, newPassword); err != nil {
+		return 0, err
+	}
+
+	// 持有重置令牌即代表用户本人
+	self := *actor
+	self.UserID = userID
+	s.auditService.Record(&self, models.AuditPasswordReset, "user", strconv.Itoa(userID), nil)
+	return userID, nil
+}
+
+// SendEmailVerification 生成邮箱验证令牌并发送验证邮件
+func (s *UserService) SendEmailVerification(userID int) error {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return fmt.Errorf("email already verified")
+	}
+
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate token: %w", err)
 	}
 
-	user := &models.User{
-		ID:        userID,
-		Username:  username,
-		Email:     emailAddr,
-		CreatedAt: createdAt.(time.Time),
-		UpdatedAt: updatedAt.(time.Time),
+	// 令牌绑定用户ID和当时的邮箱，邮箱变更后旧令牌自动失效
+	tokenKey := &database.CacheKey{Prefix: database.EmailVerifyPrefix, ID: hashToken(token)}
+	value := fmt.Sprintf("%d:%s", user.ID, user.Email)
+	if err := database.SetCache(tokenKey.String(), value, database.EmailVerifyExpiry); err != nil {
+		return fmt.Errorf("failed to store token: %w", err)
+	}
+
+	return s.mailer.Send(&mailer.Message{
+		To:      user.Email,
+		Subject: "验证您的邮箱",
+		Body: fmt.Sprintf(
+			"%s，您好：\n\n感谢您的注册！请在24小时内打开以下链接完成邮箱验证：\n\n%s/verify-email?token=%s\n\n如果这不是您本人的操作，请忽略此邮件。\n",
+			user.Username, s.appBaseURL, token,
+		),
+	})
+}
+
+// VerifyEmail 使用验证令牌确认邮箱
+func (s *UserService) VerifyEmail(token string) (*models.User, error) {
+	tokenKey := &database.CacheKey{Prefix: database.EmailVerifyPrefix, ID: hashToken(token)}
+	cached, err := database.GetDelCache(tokenKey.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid or expired token")
+	}
+
+	idStr, email, found := strings.Cut(cached, ":")
+	userID, err := strconv.Atoi(idStr)
+	if !found || err != nil {
+		return nil, fmt.Errorf("invalid or expired token")
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE users SET email_verified_at = CURRENT_TIMESTAMP WHERE id = ? AND email = ? AND email_verified_at IS NULL",
+		userID, email,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify email: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return nil, fmt.Errorf("invalid or expired token")
 	}
 
+	return s.GetUserByID(userID)
+}
+
+func (s *UserService) setPassword(userID int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	_, err = s.db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", string(hashedPassword), userID)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	return nil
+}
+
+// RequestDeletion 申请删除账户，宽限期结束后由清理任务永久删除，期间可以撤销
+func (s *UserService) RequestDeletion(actor *models.AuditActor, userID int, password string) (*models.User, error) {
+	var passwordHash string
+	user, err := scanUser(
+		s.db.QueryRow("SELECT "+userColumns+", password_hash FROM users WHERE id = ?", userID),
+		&passwordHash,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("user not found")
+		}
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user.DeletionScheduledAt != nil {
+		return nil, fmt.Errorf("account deletion already requested")
+	}
+
+	// 仅通过单点登录创建的账户没有密码，无法二次确认
+	if passwordHash != "" {
+		if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+			return nil, fmt.Errorf("password is incorrect")
+		}
+	}
+
+	scheduledAt := time.Now().Add(s.deleteGrace).Truncate(time.Second)
+	_, err = s.db.Exec(
+		"UPDATE users SET deletion_scheduled_at = ? WHERE id = ? AND deletion_scheduled_at IS NULL",
+		scheduledAt, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to schedule deletion: %w", err)
+	}
+	user.DeletionScheduledAt = &scheduledAt
+
+	s.auditService.Record(actor, models.AuditAccountDeleteRequest, "user", strconv.Itoa(userID), map[string]interface{}{
+		"scheduled_at": scheduledAt,
+	})
+
+	s.sendAsync(&mailer.Message{
+		To:      user.Email,
+		Subject: "您的账户将被删除",
+		Body: fmt.Sprintf(
+			"%s，您好：\n\n我们收到了删除您账户的申请。您的账户及全部对话将于 %s 永久删除，届时无法恢复。\n\n在此之前，您可以登录并撤销删除申请：\n\n%s\n\n如果这不是您本人的操作，请立即登录撤销并修改密码。\n",
+			user.Username, scheduledAt.UTC().Format("2006-01-02 15:04 UTC"), s.appBaseURL,
+		),
+	})
+
 	return user, nil
 }
+
+// CancelDeletion 撤销删除账户申请
+func (s *UserService) CancelDeletion(actor *models.AuditActor, userID int) error {
+	result, err := s.db.Exec(
+		"UPDATE users SET deletion_scheduled_at = NULL WHERE id = ? AND deletion_scheduled_at IS NOT NULL",
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to cancel deletion: %w", err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("account deletion not requested")
+	}
+
+	s.auditService.Record(actor, models.AuditAccountDeleteCancel, "user", strconv.Itoa(userID), nil)
+	return nil
+}
+
+// PurgeDeletedAccounts 永久删除宽限期已结束的账户，对话和消息通过外键级联删除，返回被删除的用户
+// 会话和限流等Redis数据由调用方清理
+func (s *UserService) PurgeDeletedAccounts() ([]*models.User, error) {
+	rows, err := s.db.Query(
+		"SELECT "+userColumns+" FROM users WHERE deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ?",
+		time.Now(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query accounts to purge: %w", err)
+	}
+
+	var users []*models.User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, user)
+	}
+	rows.Close()
+
+	purged := []*models.User{}
+	for _, user := range users {
+		// 再次检查删除时间，避免与撤销操作并发
+		result, err := s.db.Exec(
+			"DELETE FROM users WHERE id = ? AND deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= ?",
+			user.ID, time.Now(),
+		)
+		if err != nil {
+			return purged, fmt.Errorf("failed to delete user %d: %w", user.ID, err)
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+			continue
+		}
+
+		roleKey := &database.CacheKey{Prefix: database.UserCachePrefix, ID: fmt.Sprintf("%d:role", user.ID)}
+		database.DeleteCache(roleKey.String())
+
+		s.auditService.Record(nil, models.AuditAccountPurge, "user", strconv.Itoa(user.ID), nil)
+		purged = append(purged, user)
+	}
+
+	return purged, nil
+}
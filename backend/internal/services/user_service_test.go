@@ -0,0 +1,52 @@
+package services
+
+import (
+	"regexp"
+	"testing"
+
+	"role-play-ai/internal/config"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestConfirmPassword(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		passwordHash string
+		password     string
+		wantErr      string
+	}{
+		{"correct password", string(hash), "secret123", ""},
+		{"wrong password", string(hash), "wrong", "password is incorrect"},
+		{"sso only account", "", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("failed to create sqlmock: %v", err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT password_hash FROM users WHERE id = ?")).
+				WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"password_hash"}).AddRow(tt.passwordHash))
+
+			service := NewUserService(db, nil, NewAuditService(db), &config.Config{})
+			err = service.ConfirmPassword(7, tt.password)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("expected %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
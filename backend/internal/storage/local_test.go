@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStoreRejectsInvalidKeys(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "media")
+	store := NewLocalStore(dir)
+	ctx := context.Background()
+
+	keys := []string{
+		"",
+		"../outside.txt",
+		"avatars/../../outside.txt",
+		"/etc/passwd",
+		"avatars\\..\\..\\outside.txt",
+		"avatars//a.png",
+		"./avatars/a.png",
+		"avatars/.",
+		"avatars/",
+	}
+
+	for _, key := range keys {
+		t.Run(key, func(t *testing.T) {
+			if err := store.Put(ctx, key, []byte("data"), ""); err == nil {
+				t.Fatalf("expected Put(%q) to fail", key)
+			}
+			if _, _, err := store.Get(ctx, key); err != ErrNotFound {
+				t.Fatalf("expected Get(%q) to return ErrNotFound, got %v", key, err)
+			}
+			if err := store.Delete(ctx, key); err == nil {
+				t.Fatalf("expected Delete(%q) to fail", key)
+			}
+		})
+	}
+
+	// 没有写出存储目录之外
+	if _, err := os.Stat(filepath.Join(root, "outside.txt")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file outside the store, got %v", err)
+	}
+}
+
+func TestLocalStorePutGetDelete(t *testing.T) {
+	store := NewLocalStore(t.TempDir())
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "audio/a.wav", []byte("RIFF"), "audio/wav"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	body, info, err := store.Get(ctx, "audio/a.wav")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	body.Close()
+	if info.Size != 4 {
+		t.Fatalf("unexpected size %d", info.Size)
+	}
+
+	var keys []string
+	store.List(ctx, "audio/", func(info *BlobInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	})
+	if len(keys) != 1 || keys[0] != "audio/a.wav" {
+		t.Fatalf("unexpected list result %v", keys)
+	}
+
+	if err := store.Delete(ctx, "audio/a.wav"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, _, err := store.Get(ctx, "audio/a.wav"); err != ErrNotFound {
+		t.Fatalf("expected ErrNotFound after delete, got %v", err)
+	}
+}
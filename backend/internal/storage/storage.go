@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"role-play-ai/internal/config"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("blob not found")
+
+// BlobInfo 对象的元数据
+type BlobInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ModifiedAt  time.Time
+}
+
+// BlobStore 文件对象存储接口，key 使用 "/" 分隔的相对路径，如 avatars/xxx.png
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, *BlobInfo, error)
+	Delete(ctx context.Context, key string) error
+	// List 按key顺序遍历指定前缀下的全部对象，fn返回错误时停止遍历
+	List(ctx context.Context, prefix string, fn func(*BlobInfo) error) error
+}
+
+// Presigner 可以生成直接下载地址的存储，下载时重定向到该地址，不经过应用服务器
+type Presigner interface {
+	PresignGet(key string, expires time.Duration) (string, error)
+}
+
+// New 根据配置创建对象存储，STORAGE_DRIVER 支持 local 和 s3
+func New(cfg *config.Config) (BlobStore, error) {
+	switch cfg.StorageDriver {
+	case "s3":
+		return NewS3Store(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey, cfg.S3PathStyle == "true")
+	case "local", "":
+		return NewLocalStore(cfg.StorageLocalDir), nil
+	default:
+		log.Printf("Unknown storage driver %q, falling back to local storage", cfg.StorageDriver)
+		return NewLocalStore(cfg.StorageLocalDir), nil
+	}
+}
+
+// validateKey 拒绝空路径、绝对路径和包含 .. 的路径
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return fmt.Errorf("invalid blob key: %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid blob key: %q", key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package synthesizer
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf8"
+)
+
+// fakeSampleRate 假合成器输出音频的采样率
+const fakeSampleRate = 8000
+
+// FakeSynthesizer 本地开发和测试用的合成器，不调用外部服务，返回与文本长度相当的静音WAV
+type FakeSynthesizer struct{}
+
+func NewFakeSynthesizer() *FakeSynthesizer {
+	return &FakeSynthesizer{}
+}
+
+// Synthesize 每个字符生成0.1秒静音，语速越快时长越短
+func (s *FakeSynthesizer) Synthesize(ctx context.Context, text string, voice *Voice) (*Speech, error) {
+	chars := utf8.RuneCountInString(text)
+	if chars == 0 {
+		return nil, fmt.Errorf("empty text")
+	}
+	speed := 1.0
+	if voice != nil && voice.Speed > 0 {
+		speed = voice.Speed
+	}
+	samples := int(float64(chars) * fakeSampleRate / 10 / speed)
+	return &Speech{Data: silentWAV(samples), ContentType: "audio/wav", Extension: ".wav"}, nil
+}
+
+// silentWAV 生成8位单声道PCM静音WAV
+func silentWAV(samples int) []byte {
+	var b bytes.Buffer
+	b.WriteString("RIFF")
+	binary.Write(&b, binary.LittleEndian, uint32(36+samples))
+	b.WriteString("WAVEfmt ")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	binary.Write(&b, binary.LittleEndian, uint16(1)) // PCM
+	binary.Write(&b, binary.LittleEndian, uint16(1)) // 单声道
+	binary.Write(&b, binary.LittleEndian, uint32(fakeSampleRate))
+	binary.Write(&b, binary.LittleEndian, uint32(fakeSampleRate))
+	binary.Write(&b, binary.LittleEndian, uint16(1))
+	binary.Write(&b, binary.LittleEndian, uint16(8))
+	b.WriteString("data")
+	binary.Write(&b, binary.LittleEndian, uint32(samples))
+	// 8位PCM以128为零点
+	b.Write(bytes.Repeat([]byte{128}, samples))
+	return b.Bytes()
+}
@@ -0,0 +1,74 @@
+package synthesizer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PiperSynthesizer 调用Piper自带的HTTP服务（python -m piper.http_server），返回WAV音频
+type PiperSynthesizer struct {
+	baseURL      string
+	defaultVoice string
+	client       *http.Client
+}
+
+func NewPiperSynthesizer(baseURL, defaultVoice string) *PiperSynthesizer {
+	return &PiperSynthesizer{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		defaultVoice: defaultVoice,
+		client:       &http.Client{Timeout: 60 * time.Second},
+	}
+}
+
+type piperRequest struct {
+	Text        string  `json:"text"`
+	Voice       string  `json:"voice,omitempty"`
+	LengthScale float64 `json:"length_scale,omitempty"`
+}
+
+// Synthesize 合成语音，Piper用length_scale控制语速，数值越大越慢
+func (s *PiperSynthesizer) Synthesize(ctx context.Context, text string, voice *Voice) (*Speech, error) {
+	request := piperRequest{Text: text, Voice: s.defaultVoice}
+	if voice != nil {
+		if voice.Name != "" {
+			request.Voice = voice.Name
+		}
+		if voice.Speed > 0 {
+			request.LengthScale = 1 / voice.Speed
+		}
+	}
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.baseURL+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call speech service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("speech service error (status %d): %s", resp.StatusCode, string(data))
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read speech: %w", err)
+	}
+	return &Speech{Data: data, ContentType: "audio/wav", Extension: ".wav"}, nil
+}
@@ -0,0 +1,44 @@
+package synthesizer
+
+import (
+	"context"
+	"log"
+
+	"role-play-ai/internal/config"
+)
+
+// Voice 合成使用的音色
+type Voice struct {
+	// Name 音色名称，由合成服务解释，为空时使用服务的默认音色
+	Name string
+	// Speed 语速，1.0为正常速度
+	Speed float64
+}
+
+// Speech 合成的音频
+type Speech struct {
+	Data        []byte
+	ContentType string
+	// Extension 保存音频时使用的扩展名，如 .wav
+	Extension string
+}
+
+// Synthesizer 文字转语音接口
+type Synthesizer interface {
+	Synthesize(ctx context.Context, text string, voice *Voice) (*Speech, error)
+}
+
+// New 根据配置创建语音合成器，SYNTHESIZER_DRIVER 支持 piper 和 fake，为空时不启用语音合成
+func New(cfg *config.Config) Synthesizer {
+	switch cfg.SynthesizerDriver {
+	case "piper":
+		return NewPiperSynthesizer(cfg.SynthesizerBaseURL, cfg.SynthesizerVoice)
+	case "fake":
+		return NewFakeSynthesizer()
+	case "":
+		return nil
+	default:
+		log.Printf("Unknown synthesizer driver %q, text-to-speech is disabled", cfg.SynthesizerDriver)
+		return nil
+	}
+}
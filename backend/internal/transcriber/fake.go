@@ -0,0 +1,27 @@
+package transcriber
+
+import (
+	"context"
+	"fmt"
+)
+
+// FakeTranscriber 本地开发和测试用的转写器，不调用外部服务，返回固定文本
+type FakeTranscriber struct {
+	text string
+}
+
+// NewFakeTranscriber 创建返回固定文本的转写器，text为空时返回包含音频大小的占位文本
+func NewFakeTranscriber(text string) *FakeTranscriber {
+	return &FakeTranscriber{text: text}
+}
+
+// Transcribe 返回固定文本
+func (t *FakeTranscriber) Transcribe(ctx context.Context, audio *Audio) (string, error) {
+	if len(audio.Data) == 0 {
+		return "", fmt.Errorf("empty audio")
+	}
+	if t.text != "" {
+		return t.text, nil
+	}
+	return fmt.Sprintf("（语音消息，%d字节）", len(audio.Data)), nil
+}
@@ -0,0 +1,30 @@
+package transcriber
+
+import (
+	"context"
+	"strings"
+)
+
+// OpenAITranscriber 调用OpenAI兼容的/audio/transcriptions接口，baseURL包含版本路径，如 https://api.openai.com/v1
+type OpenAITranscriber struct {
+	baseURL string
+	apiKey  string
+	model   string
+}
+
+func NewOpenAITranscriber(baseURL, apiKey, model string) *OpenAITranscriber {
+	return &OpenAITranscriber{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		apiKey:  apiKey,
+		model:   model,
+	}
+}
+
+// Transcribe 转写音频
+func (t *OpenAITranscriber) Transcribe(ctx context.Context, audio *Audio) (string, error) {
+	return postMultipart(ctx, t.baseURL+"/audio/transcriptions", t.apiKey, map[string]string{
+		"model":           t.model,
+		"response_format": "json",
+		"language":        audio.Language,
+	}, audio)
+}
@@ -0,0 +1,100 @@
+package transcriber
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"time"
+
+	"role-play-ai/internal/config"
+)
+
+// Audio 待转写的音频
+type Audio struct {
+	Filename string
+	Data     []byte
+	// Language 音频语言，如 zh、en，为空时由服务自动识别
+	Language string
+}
+
+// Transcriber 语音转文字接口
+type Transcriber interface {
+	Transcribe(ctx context.Context, audio *Audio) (string, error)
+}
+
+// New 根据配置创建语音转写器，TRANSCRIBER_DRIVER 支持 whisper、openai 和 fake，为空时不启用语音转写
+func New(cfg *config.Config) Transcriber {
+	switch cfg.TranscriberDriver {
+	case "whisper":
+		return NewWhisperTranscriber(cfg.TranscriberBaseURL)
+	case "openai":
+		return NewOpenAITranscriber(cfg.TranscriberBaseURL, cfg.TranscriberAPIKey, cfg.TranscriberModel)
+	case "fake":
+		return NewFakeTranscriber("")
+	case "":
+		return nil
+	default:
+		log.Printf("Unknown transcriber driver %q, speech-to-text is disabled", cfg.TranscriberDriver)
+		return nil
+	}
+}
+
+var httpClient = &http.Client{Timeout: 60 * time.Second}
+
+// postMultipart 以multipart/form-data上传音频和其他字段，解析返回JSON中的text字段
+func postMultipart(ctx context.Context, url, apiKey string, fields map[string]string, audio *Audio) (string, error) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	for name, value := range fields {
+		if value == "" {
+			continue
+		}
+		if err := writer.WriteField(name, value); err != nil {
+			return "", fmt.Errorf("failed to write form field: %w", err)
+		}
+	}
+	part, err := writer.CreateFormFile("file", audio.Filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create form file: %w", err)
+	}
+	if _, err := part.Write(audio.Data); err != nil {
+		return "", fmt.Errorf("failed to write audio: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return "", fmt.Errorf("failed to close multipart writer: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, &body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to call transcription service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("transcription service error (status %d): %s", resp.StatusCode, string(data))
+	}
+
+	var result struct {
+		Text string `json:"text"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode transcription: %w", err)
+	}
+	return strings.TrimSpace(result.Text), nil
+}
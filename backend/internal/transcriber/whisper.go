@@ -0,0 +1,27 @@
+package transcriber
+
+import (
+	"context"
+	"strings"
+)
+
+// WhisperTranscriber 调用whisper.cpp自带的HTTP服务（examples/server）的/inference接口
+type WhisperTranscriber struct {
+	baseURL string
+}
+
+func NewWhisperTranscriber(baseURL string) *WhisperTranscriber {
+	return &WhisperTranscriber{baseURL: strings.TrimRight(baseURL, "/")}
+}
+
+// Transcribe 转写音频，未指定语言时由whisper自动识别
+func (t *WhisperTranscriber) Transcribe(ctx context.Context, audio *Audio) (string, error) {
+	language := audio.Language
+	if language == "" {
+		language = "auto"
+	}
+	return postMultipart(ctx, t.baseURL+"/inference", "", map[string]string{
+		"response_format": "json",
+		"language":        language,
+	}, audio)
+}
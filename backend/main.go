@@ -21,14 +21,21 @@
 package main
 
 import (
+	"context"
 	"log"
 	"os"
+	"time"
 
 	"role-play-ai/internal/config"
 	"role-play-ai/internal/database"
 	"role-play-ai/internal/handlers"
+	"role-play-ai/internal/mailer"
 	"role-play-ai/internal/middleware"
+	"role-play-ai/internal/models"
 	"role-play-ai/internal/services"
+	"role-play-ai/internal/storage"
+	"role-play-ai/internal/synthesizer"
+	"role-play-ai/internal/transcriber"
 
 	_ "role-play-ai/docs" // 导入生成的docs包
 
@@ -55,17 +62,83 @@ func main() {
 	}
 	defer database.CloseRedis()
 
+	// 初始化对象存储
+	blobStore, err := storage.New(cfg)
+	if err != nil {
+		log.Fatal("Failed to initialize blob storage:", err)
+	}
+
 	// 初始化服务
-	userService := services.NewUserService(db)
-	characterService := services.NewCharacterService(db)
-	conversationService := services.NewConversationService(db)
-	messageService := services.NewMessageService(db)
+	auditService := services.NewAuditService(db)
+	userService := services.NewUserService(db, mailer.New(cfg), auditService, cfg)
+	characterService := services.NewCharacterService(db, auditService)
+	characterStatsService := services.NewCharacterStatsService(db)
+	favoriteService := services.NewFavoriteService(db, characterService)
+	ratingService := services.NewRatingService(db, characterService)
+	searchIndex := services.NewMySQLSearchIndex(db)
+	conversationService := services.NewConversationService(db, auditService, searchIndex, cfg)
+	messageService := services.NewMessageService(db, searchIndex)
 	aiService := services.NewAIService(cfg)
+	twoFactorService := services.NewTwoFactorService(db, cfg.TOTPIssuer)
+	oidcService := services.NewOIDCService(db, userService, cfg)
+	apiKeyService := services.NewAPIKeyService(db)
+	mediaService := services.NewMediaService(db, blobStore, cfg)
+	audioService := services.NewAudioService(transcriber.New(cfg), mediaService)
+	speechService := services.NewSpeechService(db, synthesizer.New(cfg), mediaService)
+	exportService := services.NewExportService(db, userService, conversationService, messageService, apiKeyService, mediaService, cfg.AppBaseURL)
+	importService := services.NewImportService(db, characterService, conversationService, auditService, searchIndex)
+	searchService := services.NewSearchService(searchIndex)
+	feedbackService := services.NewFeedbackService(db)
+	folderService := services.NewFolderService(db)
+	shareService := services.NewShareService(db, conversationService, auditService, cfg.AppBaseURL)
+	loginGuard := services.NewLoginGuard()
 
 	// 初始化处理器
-	authHandler := handlers.NewAuthHandler(userService, cfg.JWTSecret)
-	characterHandler := handlers.NewCharacterHandler(characterService)
-	conversationHandler := handlers.NewConversationHandler(conversationService, messageService, aiService)
+	authHandler := handlers.NewAuthHandler(userService, twoFactorService, loginGuard, auditService, cfg.JWTSecret)
+	oidcHandler := handlers.NewOIDCHandler(oidcService, authHandler, cfg.AppBaseURL)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyService)
+	characterHandler := handlers.NewCharacterHandler(characterService, favoriteService, ratingService)
+	conversationHandler := handlers.NewConversationHandler(conversationService, messageService, aiService, exportService, importService, audioService, speechService)
+	adminHandler := handlers.NewAdminHandler(userService, characterService, aiService, loginGuard, auditService)
+	auditHandler := handlers.NewAuditHandler(auditService)
+	accountHandler := handlers.NewAccountHandler(userService, exportService, auditService)
+	searchHandler := handlers.NewSearchHandler(searchService)
+	feedbackHandler := handlers.NewFeedbackHandler(feedbackService, auditService)
+	folderHandler := handlers.NewFolderHandler(folderService)
+	shareHandler := handlers.NewShareHandler(shareService)
+	mediaHandler := handlers.NewMediaHandler(mediaService)
+	speechHandler := handlers.NewSpeechHandler(speechService)
+
+	// 定期永久删除宽限期已结束的账户
+	go runPeriodically(time.Hour, func() {
+		purgeDeletedAccounts(userService, loginGuard, searchIndex)
+	})
+
+	// 定期永久删除回收站中超过保留期的对话
+	go runPeriodically(time.Hour, func() {
+		if _, err := conversationService.PurgeTrash(); err != nil {
+			log.Printf("Failed to purge conversation trash: %v", err)
+		}
+	})
+
+	// 定期清理不再被角色或消息引用的媒体文件
+	go runPeriodically(time.Hour, func() {
+		if _, err := mediaService.CollectGarbage(context.Background()); err != nil {
+			log.Printf("Failed to collect media garbage: %v", err)
+		}
+	})
+
+	// 角色统计：定期合并累计的增量，并每天全量重算校正一次
+	go runPeriodically(30*time.Second, func() {
+		if err := characterStatsService.FlushDirty(); err != nil {
+			log.Printf("Failed to update character stats: %v", err)
+		}
+	})
+	go runPeriodically(24*time.Hour, func() {
+		if err := characterStatsService.RefreshAll(); err != nil {
+			log.Printf("Failed to refresh character stats: %v", err)
+		}
+	})
 
 	// 设置Gin模式
 	if os.Getenv("GIN_MODE") == "" {
@@ -79,7 +152,7 @@ func main() {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:5173"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "X-API-Key"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 	}))
@@ -98,10 +171,38 @@ func main() {
 	// 认证路由
 	auth := api.Group("/auth")
 	{
-		auth.POST("/register", authHandler.Register)
-		auth.POST("/login", authHandler.Login)
+		auth.POST("/register", middleware.DefaultRateLimit(), authHandler.Register)
+		auth.POST("/login", middleware.DefaultRateLimit(), authHandler.Login)
+		auth.POST("/login/2fa", middleware.DefaultRateLimit(), authHandler.LoginTwoFactor)
 		auth.POST("/logout", middleware.RedisAuthMiddleware(cfg.JWTSecret), authHandler.Logout)
 		auth.GET("/me", middleware.RedisAuthMiddleware(cfg.JWTSecret), authHandler.GetProfile)
+		auth.GET("/me/export", middleware.RedisAuthMiddleware(cfg.JWTSecret), accountHandler.ExportData)
+		auth.POST("/me/delete", middleware.RedisAuthMiddleware(cfg.JWTSecret), accountHandler.RequestDeletion)
+		auth.DELETE("/me/delete", middleware.RedisAuthMiddleware(cfg.JWTSecret), accountHandler.CancelDeletion)
+		auth.GET("/sessions", middleware.RedisAuthMiddleware(cfg.JWTSecret), authHandler.GetSessions)
+		auth.DELETE("/sessions", middleware.RedisAuthMiddleware(cfg.JWTSecret), authHandler.RevokeOtherSessions)
+		auth.DELETE("/sessions/:id", middleware.RedisAuthMiddleware(cfg.JWTSecret), authHandler.RevokeSession)
+		auth.PUT("/password", middleware.RedisAuthMiddleware(cfg.JWTSecret), authHandler.ChangePassword)
+		auth.POST("/password/forgot", middleware.DefaultRateLimit(), authHandler.ForgotPassword)
+		auth.POST("/password/reset", middleware.DefaultRateLimit(), authHandler.ResetPassword)
+		auth.POST("/email/verify", middleware.DefaultRateLimit(), authHandler.VerifyEmail)
+		auth.POST("/email/verify/resend", middleware.RedisAuthMiddleware(cfg.JWTSecret), authHandler.ResendVerification)
+		auth.GET("/2fa", middleware.RedisAuthMiddleware(cfg.JWTSecret), authHandler.GetTwoFactorStatus)
+		auth.POST("/2fa/setup", middleware.RedisAuthMiddleware(cfg.JWTSecret), authHandler.SetupTwoFactor)
+		auth.POST("/2fa/enable", middleware.RedisAuthMiddleware(cfg.JWTSecret), authHandler.EnableTwoFactor)
+		auth.POST("/2fa/disable", middleware.RedisAuthMiddleware(cfg.JWTSecret), authHandler.DisableTwoFactor)
+		auth.POST("/2fa/recovery-codes", middleware.RedisAuthMiddleware(cfg.JWTSecret), authHandler.RegenerateRecoveryCodes)
+
+		// API密钥管理只允许登录会话操作，不能用API密钥创建新密钥
+		auth.GET("/api-keys", middleware.RedisAuthMiddleware(cfg.JWTSecret), apiKeyHandler.GetAPIKeys)
+		auth.POST("/api-keys", middleware.RedisAuthMiddleware(cfg.JWTSecret), apiKeyHandler.CreateAPIKey)
+		auth.DELETE("/api-keys/:id", middleware.RedisAuthMiddleware(cfg.JWTSecret), apiKeyHandler.RevokeAPIKey)
+
+		// 单点登录（仅在配置了OIDC_ISSUER时启用）
+		if oidcService.Enabled() {
+			auth.GET("/oidc/login", middleware.DefaultRateLimit(), oidcHandler.Login)
+			auth.GET("/oidc/callback", middleware.DefaultRateLimit(), oidcHandler.Callback)
+		}
 	}
 
 	// 角色路由
@@ -110,21 +211,115 @@ func main() {
 	{
 		characters.GET("/", characterHandler.GetCharacters)
 		characters.GET("/search", characterHandler.SearchCharacters)
+		characters.GET("/favorites", middleware.RedisAuthMiddleware(cfg.JWTSecret), characterHandler.GetFavorites)
 		characters.GET("/:id", characterHandler.GetCharacter)
+		characters.GET("/:id/ratings", characterHandler.GetRatings)
+		characters.GET("/:id/rating", middleware.RedisAuthMiddleware(cfg.JWTSecret), characterHandler.GetMyRating)
+		characters.PUT("/:id/rating", middleware.RedisAuthMiddleware(cfg.JWTSecret), characterHandler.RateCharacter)
+		characters.DELETE("/:id/rating", middleware.RedisAuthMiddleware(cfg.JWTSecret), characterHandler.DeleteRating)
+		characters.POST("/:id/favorite", middleware.RedisAuthMiddleware(cfg.JWTSecret), characterHandler.AddFavorite)
+		characters.DELETE("/:id/favorite", middleware.RedisAuthMiddleware(cfg.JWTSecret), characterHandler.RemoveFavorite)
 	}
 
-	// 对话路由（需要认证）
+	// 对话路由（需要认证，支持登录会话和API密钥）
+	readConversations := middleware.RequireScope(models.ScopeConversationsRead)
+	chat := middleware.RequireScope(models.ScopeChat)
 	conversations := api.Group("/conversations")
-	conversations.Use(middleware.RedisAuthMiddleware(cfg.JWTSecret))
+	conversations.Use(middleware.APIKeyOrRedisAuthMiddleware(cfg.JWTSecret, apiKeyService))
 	conversations.Use(middleware.APIRateLimit())
 	{
-		conversations.GET("/", conversationHandler.GetConversations)
-		conversations.POST("/", conversationHandler.CreateConversation)
-		conversations.GET("/:id", conversationHandler.GetConversation)
-		conversations.POST("/:id/messages", middleware.AIChatRateLimit(), conversationHandler.SendMessage)
-		conversations.POST("/:id/messages/stream", middleware.AIChatRateLimit(), conversationHandler.SendMessageStream)
-		conversations.DELETE("/:id", conversationHandler.DeleteConversation)
-		conversations.DELETE("/batch", conversationHandler.BatchDeleteConversations)
+		conversations.GET("/", readConversations, conversationHandler.GetConversations)
+		conversations.POST("/", chat, conversationHandler.CreateConversation)
+		conversations.POST("/import", chat, conversationHandler.ImportConversation)
+		conversations.GET("/search", readConversations, searchHandler.SearchMessages)
+		conversations.GET("/trash", readConversations, conversationHandler.GetTrash)
+		conversations.DELETE("/trash", chat, conversationHandler.EmptyTrash)
+		conversations.GET("/folders", readConversations, folderHandler.GetFolders)
+		conversations.POST("/folders", chat, folderHandler.CreateFolder)
+		conversations.PUT("/folders/:id", chat, folderHandler.RenameFolder)
+		conversations.DELETE("/folders/:id", chat, folderHandler.DeleteFolder)
+		conversations.GET("/labels", readConversations, folderHandler.GetLabels)
+		conversations.POST("/labels", chat, folderHandler.CreateLabel)
+		conversations.PUT("/labels/:id", chat, folderHandler.RenameLabel)
+		conversations.DELETE("/labels/:id", chat, folderHandler.DeleteLabel)
+		conversations.POST("/batch/archive", chat, conversationHandler.BatchArchiveConversations)
+		conversations.POST("/batch/move", chat, conversationHandler.BatchMoveConversations)
+		conversations.POST("/batch/label", chat, conversationHandler.BatchLabelConversations)
+		conversations.GET("/:id", readConversations, conversationHandler.GetConversation)
+		conversations.GET("/:id/export", readConversations, conversationHandler.ExportConversation)
+		conversations.GET("/:id/messages", readConversations, conversationHandler.GetMessages)
+		conversations.POST("/:id/messages", chat, middleware.AIChatRateLimit(), conversationHandler.SendMessage)
+		conversations.POST("/:id/messages/stream", chat, middleware.AIChatRateLimit(), conversationHandler.SendMessageStream)
+		conversations.POST("/:id/messages/audio", chat, middleware.AIChatRateLimit(), conversationHandler.SendAudioMessage)
+		conversations.PUT("/:id/messages/:messageId/feedback", chat, feedbackHandler.SetFeedback)
+		conversations.DELETE("/:id/messages/:messageId/feedback", chat, feedbackHandler.DeleteFeedback)
+		conversations.POST("/:id/messages/:messageId/speech", chat, middleware.AIChatRateLimit(), speechHandler.SynthesizeMessage)
+		conversations.PUT("/:id/title", chat, conversationHandler.RenameConversation)
+		conversations.PATCH("/:id", chat, conversationHandler.UpdateConversation)
+		conversations.PUT("/:id/labels", chat, conversationHandler.SetConversationLabels)
+		conversations.DELETE("/:id", chat, conversationHandler.DeleteConversation)
+		conversations.POST("/:id/restore", chat, conversationHandler.RestoreConversation)
+		conversations.POST("/:id/clone", chat, conversationHandler.CloneConversation)
+		conversations.POST("/:id/share", chat, shareHandler.CreateShare)
+		conversations.GET("/:id/shares", readConversations, shareHandler.ListShares)
+		conversations.DELETE("/:id/shares/:shareId", chat, shareHandler.RevokeShare)
+		conversations.DELETE("/:id/permanent", chat, conversationHandler.PermanentlyDeleteConversation)
+		conversations.DELETE("/batch", chat, conversationHandler.BatchDeleteConversations)
+	}
+
+	// 分享链接：查看无需登录，复制到自己的账户需要认证
+	shared := api.Group("/shared")
+	{
+		shared.GET("/:token", middleware.DefaultRateLimit(), shareHandler.GetSharedConversation)
+		shared.POST("/:token/fork", middleware.APIKeyOrRedisAuthMiddleware(cfg.JWTSecret, apiKeyService),
+			middleware.APIRateLimit(), chat, shareHandler.ForkSharedConversation)
+	}
+
+	// 媒体文件：下载使用签名地址，audio标签无法携带认证头，因此下载不需要登录认证
+	media := api.Group("/media")
+	{
+		media.GET("/*key", middleware.DefaultRateLimit(), mediaHandler.GetMedia)
+		mediaAuth := middleware.APIKeyOrRedisAuthMiddleware(cfg.JWTSecret, apiKeyService)
+		media.POST("/audio", mediaAuth, middleware.APIRateLimit(), chat, mediaHandler.UploadAudio)
+		media.POST("/sign", mediaAuth, middleware.APIRateLimit(), chat, mediaHandler.SignURL)
+	}
+
+	// 管理路由（需要认证，按角色授权）
+	adminOnly := middleware.RequireRole(userService, models.RoleAdmin)
+	staff := middleware.RequireRole(userService, models.RoleModerator, models.RoleAdmin)
+	admin := api.Group("/admin")
+	admin.Use(middleware.APIKeyOrRedisAuthMiddleware(cfg.JWTSecret, apiKeyService))
+	admin.Use(middleware.APIRateLimit())
+	{
+		// 用户管理和限流覆盖只允许管理员通过登录会话操作
+		users := admin.Group("/users", middleware.RequireSessionAuth(), adminOnly)
+		users.GET("/", adminHandler.ListUsers)
+		users.GET("/:id", adminHandler.GetUser)
+		users.PUT("/:id/role", adminHandler.UpdateUserRole)
+		users.DELETE("/:id/sessions", adminHandler.RevokeUserSessions)
+		users.GET("/:id/rate-limits", adminHandler.GetRateLimitOverrides)
+		users.PUT("/:id/rate-limits", adminHandler.SetRateLimitOverride)
+		users.DELETE("/:id/rate-limits/:limiter", adminHandler.DeleteRateLimitOverride)
+		users.DELETE("/:id/lockout", adminHandler.UnlockUser)
+		admin.GET("/login-failures", middleware.RequireSessionAuth(), adminOnly, adminHandler.GetLoginFailures)
+		admin.GET("/audit-events", middleware.RequireSessionAuth(), adminOnly, auditHandler.ListAuditEvents)
+		admin.DELETE("/ip-lockouts/:ip", middleware.RequireSessionAuth(), adminOnly, adminHandler.UnlockIP)
+
+		// 角色管理允许版主操作，也可以使用带 characters:manage 权限的API密钥
+		adminCharacters := admin.Group("/characters", staff, middleware.RequireScope(models.ScopeCharactersManage))
+		adminCharacters.GET("/", adminHandler.ListCharacters)
+		adminCharacters.POST("/", adminHandler.CreateCharacter)
+		adminCharacters.POST("/avatar", mediaHandler.UploadAvatar)
+		adminCharacters.PUT("/:id", adminHandler.UpdateCharacter)
+		adminCharacters.PUT("/:id/publish", adminHandler.PublishCharacter)
+		adminCharacters.DELETE("/:id", adminOnly, adminHandler.DeleteCharacter)
+
+		// 反馈统计允许版主查看，偏好数据包含用户对话内容，只允许管理员导出
+		admin.GET("/feedback/report", middleware.RequireSessionAuth(), staff, feedbackHandler.GetFeedbackReport)
+		admin.GET("/feedback/preference-pairs", middleware.RequireSessionAuth(), adminOnly, feedbackHandler.ExportPreferencePairs)
+
+		admin.GET("/cache/stats", middleware.RequireSessionAuth(), staff, adminHandler.GetCacheStats)
+		admin.DELETE("/cache/ai", middleware.RequireSessionAuth(), adminOnly, adminHandler.FlushAICache)
 	}
 
 	// 启动服务器
@@ -138,3 +333,39 @@ func main() {
 		log.Fatal("Failed to start server:", err)
 	}
 }
+
+// runPeriodically 启动时执行一次，之后按固定间隔执行后台任务
+func runPeriodically(interval time.Duration, task func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		task()
+		<-ticker.C
+	}
+}
+
+// purgeDeletedAccounts 删除宽限期已结束的账户，并清理其在Redis中的会话、限流计数、登录失败记录和搜索索引
+func purgeDeletedAccounts(userService *services.UserService, loginGuard *services.LoginGuard, searchIndex services.SearchIndex) {
+	users, err := userService.PurgeDeletedAccounts()
+	if err != nil {
+		log.Printf("Failed to purge deleted accounts: %v", err)
+	}
+
+	for _, user := range users {
+		if err := middleware.RevokeAllUserSessions(user.ID); err != nil {
+			log.Printf("Failed to revoke sessions of purged user %d: %v", user.ID, err)
+		}
+		if err := middleware.PurgeUserRateLimits(user.ID); err != nil {
+			log.Printf("Failed to purge rate limits of purged user %d: %v", user.ID, err)
+		}
+		loginGuard.Unlock(user.Email)
+		if err := searchIndex.DeleteUser(user.ID); err != nil {
+			log.Printf("Failed to remove purged user %d from search index: %v", user.ID, err)
+		}
+	}
+
+	if len(users) > 0 {
+		log.Printf("Purged %d deleted accounts", len(users))
+	}
+}
@@ -38,9 +38,7 @@ CREATE INDEX idx_conversations_user_id_fk ON conversations(user_id);
 CREATE INDEX idx_conversations_character_id_fk ON conversations(character_id);
 CREATE INDEX idx_messages_conversation_id_fk ON messages(conversation_id);
 
--- 全文搜索索引（可选，用于角色搜索功能）
--- 如果角色搜索功能使用频繁，可以考虑添加全文索引
--- ALTER TABLE characters ADD FULLTEXT(name, description, category);
+-- 角色发现使用的全文搜索索引 ft_characters_search 由 schema.sql 创建，已有数据库通过 migrate.sql 补齐
 
 -- 显示索引创建结果
 SHOW INDEX FROM users;
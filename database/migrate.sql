@@ -0,0 +1,142 @@
+-- 已有数据库的结构升级脚本
+-- schema.sql 只在表不存在时建表，已有的表不会增加新列。升级已有数据库时先执行 schema.sql 创建新增的表，
+-- 再执行本脚本为已有的表补齐新增的列和索引，并替换调整过的唯一约束。脚本可以重复执行，已经存在的列和索引会跳过
+SET NAMES utf8mb4;
+
+DROP PROCEDURE IF EXISTS add_column_if_missing;
+DROP PROCEDURE IF EXISTS add_index_if_missing;
+DROP PROCEDURE IF EXISTS add_foreign_key_if_missing;
+DROP PROCEDURE IF EXISTS drop_index_if_exists;
+
+DELIMITER //
+
+-- 列不存在时执行 ALTER TABLE tbl ADD COLUMN col definition
+CREATE PROCEDURE add_column_if_missing(IN tbl VARCHAR(64), IN col VARCHAR(64), IN definition TEXT)
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.COLUMNS
+        WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = tbl AND COLUMN_NAME = col
+    ) THEN
+        SET @ddl = CONCAT('ALTER TABLE ', tbl, ' ADD COLUMN ', col, ' ', definition);
+        PREPARE stmt FROM @ddl;
+        EXECUTE stmt;
+        DEALLOCATE PREPARE stmt;
+    END IF;
+END //
+
+-- 索引不存在时执行 ALTER TABLE tbl ADD definition，definition 中的索引名必须与 idx 一致
+CREATE PROCEDURE add_index_if_missing(IN tbl VARCHAR(64), IN idx VARCHAR(64), IN definition TEXT)
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.STATISTICS
+        WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = tbl AND INDEX_NAME = idx
+    ) THEN
+        SET @ddl = CONCAT('ALTER TABLE ', tbl, ' ADD ', definition);
+        PREPARE stmt FROM @ddl;
+        EXECUTE stmt;
+        DEALLOCATE PREPARE stmt;
+    END IF;
+END //
+
+-- 列上没有外键时执行 ALTER TABLE tbl ADD definition。schema.sql 中的外键没有命名，因此按列判断
+CREATE PROCEDURE add_foreign_key_if_missing(IN tbl VARCHAR(64), IN col VARCHAR(64), IN definition TEXT)
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM information_schema.KEY_COLUMN_USAGE
+        WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = tbl AND COLUMN_NAME = col
+            AND REFERENCED_TABLE_NAME IS NOT NULL
+    ) THEN
+        SET @ddl = CONCAT('ALTER TABLE ', tbl, ' ADD ', definition);
+        PREPARE stmt FROM @ddl;
+        EXECUTE stmt;
+        DEALLOCATE PREPARE stmt;
+    END IF;
+END //
+
+-- 索引存在时执行 ALTER TABLE tbl DROP INDEX idx
+CREATE PROCEDURE drop_index_if_exists(IN tbl VARCHAR(64), IN idx VARCHAR(64))
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM information_schema.STATISTICS
+        WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = tbl AND INDEX_NAME = idx
+    ) THEN
+        SET @ddl = CONCAT('ALTER TABLE ', tbl, ' DROP INDEX ', idx);
+        PREPARE stmt FROM @ddl;
+        EXECUTE stmt;
+        DEALLOCATE PREPARE stmt;
+    END IF;
+END //
+
+DELIMITER ;
+
+-- 邮箱验证
+CALL add_column_if_missing('users', 'email_verified_at', 'TIMESTAMP NULL DEFAULT NULL AFTER password_hash');
+
+-- 两步验证
+CALL add_column_if_missing('users', 'totp_secret', 'VARCHAR(64) NULL DEFAULT NULL AFTER email_verified_at');
+CALL add_column_if_missing('users', 'totp_enabled', 'BOOLEAN NOT NULL DEFAULT FALSE AFTER totp_secret');
+
+-- 用户角色和角色发布状态，已有角色默认保持公开
+CALL add_column_if_missing('users', 'role', "ENUM('user', 'moderator', 'admin') NOT NULL DEFAULT 'user' AFTER totp_enabled");
+CALL add_column_if_missing('characters', 'is_published', 'BOOLEAN NOT NULL DEFAULT TRUE AFTER category');
+
+-- 账户删除宽限期
+CALL add_column_if_missing('users', 'deletion_scheduled_at', 'TIMESTAMP NULL DEFAULT NULL AFTER role');
+
+-- 导入对话创建的私有角色，角色名称的唯一约束改为按所有者区分
+CALL add_column_if_missing('characters', 'owner_id', 'INT NULL DEFAULT NULL AFTER category');
+CALL add_column_if_missing('characters', 'owner_scope', 'INT AS (IFNULL(owner_id, 0)) VIRTUAL AFTER owner_id');
+CALL add_index_if_missing('characters', 'uk_characters_owner_name', 'UNIQUE INDEX uk_characters_owner_name (owner_scope, name)');
+CALL drop_index_if_exists('characters', 'name');
+CALL add_foreign_key_if_missing('characters', 'owner_id', 'FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE');
+
+-- 对话归档和列表分页
+CALL add_column_if_missing('conversations', 'is_archived', 'BOOLEAN NOT NULL DEFAULT FALSE AFTER title');
+CALL add_index_if_missing('conversations', 'idx_conversations_user_archived_updated', 'INDEX idx_conversations_user_archived_updated (user_id, is_archived, updated_at)');
+
+-- 消息全文搜索，数据量大时建索引需要较长时间
+CALL add_index_if_missing('messages', 'ft_messages_content', 'FULLTEXT INDEX ft_messages_content (content) WITH PARSER ngram');
+
+-- 角色发现的相关度排序
+CALL add_index_if_missing('characters', 'ft_characters_search', 'FULLTEXT INDEX ft_characters_search (name, description, category) WITH PARSER ngram');
+
+-- 角色统计按增量更新，character_stats 表由 schema.sql 创建
+CALL add_column_if_missing('character_stats', 'rating_sum', 'INT NOT NULL DEFAULT 0 AFTER rating_count');
+UPDATE character_stats cs
+SET rating_sum = (SELECT COALESCE(SUM(r.rating), 0) FROM character_ratings r WHERE r.character_id = cs.character_id);
+
+-- 记录生成AI回复的模型
+CALL add_column_if_missing('messages', 'model', 'VARCHAR(100) NULL DEFAULT NULL AFTER audio_url');
+
+-- 自动生成标题
+CALL add_column_if_missing('conversations', 'title_auto', 'BOOLEAN NOT NULL DEFAULT TRUE AFTER title');
+
+-- 对话置顶和文件夹，conversation_folders 表由 schema.sql 创建
+CALL add_column_if_missing('conversations', 'pinned_at', 'TIMESTAMP NULL AFTER is_archived');
+CALL add_column_if_missing('conversations', 'folder_id', 'INT NULL AFTER pinned_at');
+CALL add_index_if_missing('conversations', 'idx_conversations_folder', 'INDEX idx_conversations_folder (folder_id)');
+CALL add_foreign_key_if_missing('conversations', 'folder_id', 'FOREIGN KEY (folder_id) REFERENCES conversation_folders(id) ON DELETE SET NULL');
+
+-- 对话回收站
+CALL add_column_if_missing('conversations', 'deleted_at', 'TIMESTAMP NULL AFTER folder_id');
+CALL add_index_if_missing('conversations', 'idx_conversations_deleted', 'INDEX idx_conversations_deleted (deleted_at)');
+
+-- 分享链接保存消息快照，conversation_share_messages 表由 schema.sql 创建。为已有的链接按当前消息补齐快照
+INSERT INTO conversation_share_messages (share_id, role, content, created_at)
+SELECT sh.id, m.role, m.content, m.created_at
+FROM conversation_shares sh
+JOIN messages m ON m.conversation_id = sh.conversation_id AND m.id <= sh.last_message_id
+WHERE NOT EXISTS (SELECT 1 FROM conversation_share_messages sm WHERE sm.share_id = sh.id)
+ORDER BY sh.id, m.id;
+
+-- 角色音色
+CALL add_column_if_missing('characters', 'voice', 'VARCHAR(100) AFTER is_published');
+CALL add_column_if_missing('characters', 'voice_speed', 'FLOAT NOT NULL DEFAULT 1.0 AFTER voice');
+
+-- 媒体垃圾回收按音频引用查找，media_blobs 表由 schema.sql 创建
+CALL add_index_if_missing('messages', 'idx_messages_audio', 'INDEX idx_messages_audio (audio_url)');
+
+DROP PROCEDURE add_column_if_missing;
+DROP PROCEDURE add_index_if_missing;
+DROP PROCEDURE add_foreign_key_if_missing;
+DROP PROCEDURE drop_index_if_exists;
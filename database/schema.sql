@@ -3,11 +3,17 @@ SET NAMES utf8mb4;
 SET CHARACTER SET utf8mb4;
 
 -- 用户表
+-- 首个管理员需手动指定：UPDATE users SET role = 'admin' WHERE email = '...';
 CREATE TABLE IF NOT EXISTS users (
     id INT PRIMARY KEY AUTO_INCREMENT,
     username VARCHAR(50) UNIQUE NOT NULL,
     email VARCHAR(100) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
+    email_verified_at TIMESTAMP NULL DEFAULT NULL,
+    totp_secret VARCHAR(64) NULL DEFAULT NULL,
+    totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    role ENUM('user', 'moderator', 'admin') NOT NULL DEFAULT 'user',
+    deletion_scheduled_at TIMESTAMP NULL DEFAULT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -15,13 +21,91 @@ CREATE TABLE IF NOT EXISTS users (
 -- 角色表
 CREATE TABLE IF NOT EXISTS characters (
     id INT PRIMARY KEY AUTO_INCREMENT,
-    name VARCHAR(100) UNIQUE NOT NULL,
+    name VARCHAR(100) NOT NULL,
     description TEXT,
     avatar_url VARCHAR(255),
     system_prompt TEXT NOT NULL,
     category VARCHAR(50),
+    is_published BOOLEAN NOT NULL DEFAULT TRUE,
+    -- 语音合成使用的音色，为空时使用默认音色；语速1.0为正常速度
+    voice VARCHAR(100),
+    voice_speed FLOAT NOT NULL DEFAULT 1.0,
+    -- 导入对话时为用户创建的私有角色只有所有者可以使用，公共角色为NULL
+    owner_id INT NULL DEFAULT NULL,
+    -- 公共角色名称全局唯一，私有角色名称在同一用户内唯一
+    owner_scope INT AS (IFNULL(owner_id, 0)) VIRTUAL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    UNIQUE INDEX uk_characters_owner_name (owner_scope, name),
+    -- 角色发现的相关度排序，ngram分词以支持中文
+    FULLTEXT INDEX ft_characters_search (name, description, category) WITH PARSER ngram,
+    FOREIGN KEY (owner_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 角色标签表
+CREATE TABLE IF NOT EXISTS tags (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(50) UNIQUE NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 角色和标签的多对多关联
+CREATE TABLE IF NOT EXISTS character_tags (
+    character_id INT NOT NULL,
+    tag_id INT NOT NULL,
+    PRIMARY KEY (character_id, tag_id),
+    INDEX idx_character_tags_tag (tag_id),
+    FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 用户收藏的角色
+CREATE TABLE IF NOT EXISTS character_favorites (
+    user_id INT NOT NULL,
+    character_id INT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, character_id),
+    INDEX idx_character_favorites_character (character_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 角色评分和评价，每个用户对每个角色只有一条
+CREATE TABLE IF NOT EXISTS character_ratings (
+    user_id INT NOT NULL,
+    character_id INT NOT NULL,
+    rating TINYINT NOT NULL CHECK (rating BETWEEN 1 AND 5),
+    review TEXT,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, character_id),
+    INDEX idx_character_ratings_character_updated (character_id, updated_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 角色统计（冗余计数，由后台任务根据源数据异步重算）
+CREATE TABLE IF NOT EXISTS character_stats (
+    character_id INT PRIMARY KEY,
+    conversation_count INT NOT NULL DEFAULT 0,
+    message_count INT NOT NULL DEFAULT 0,
+    favorite_count INT NOT NULL DEFAULT 0,
+    rating_count INT NOT NULL DEFAULT 0,
+    -- 评分总和，增量更新时用于计算平均分
+    rating_sum INT NOT NULL DEFAULT 0,
+    average_rating DECIMAL(3,2) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 对话文件夹表，每个对话最多属于一个文件夹
+CREATE TABLE IF NOT EXISTS conversation_folders (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_conversation_folders_user_name (user_id, name),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
 -- 对话会话表
@@ -30,10 +114,67 @@ CREATE TABLE IF NOT EXISTS conversations (
     user_id INT NOT NULL,
     character_id INT NOT NULL,
     title VARCHAR(200),
+    -- 是否在第一轮对话后由模型自动生成标题，用户手动改名后关闭
+    title_auto BOOLEAN NOT NULL DEFAULT TRUE,
+    is_archived BOOLEAN NOT NULL DEFAULT FALSE,
+    -- 置顶的对话在列表中排在最前，按置顶时间倒序
+    pinned_at TIMESTAMP NULL,
+    folder_id INT NULL,
+    -- 移入回收站的时间，超过保留期后由后台任务永久删除
+    deleted_at TIMESTAMP NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_conversations_user_archived_updated (user_id, is_archived, updated_at),
+    INDEX idx_conversations_folder (folder_id),
+    INDEX idx_conversations_deleted (deleted_at),
     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
-    FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE
+    FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE,
+    FOREIGN KEY (folder_id) REFERENCES conversation_folders(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 对话标签表，一个对话可以有多个标签
+CREATE TABLE IF NOT EXISTS conversation_labels (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_conversation_labels_user_name (user_id, name),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS conversation_label_links (
+    conversation_id INT NOT NULL,
+    label_id INT NOT NULL,
+    PRIMARY KEY (conversation_id, label_id),
+    INDEX idx_conversation_label_links_label (label_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE,
+    FOREIGN KEY (label_id) REFERENCES conversation_labels(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 对话分享链接表，只保存令牌摘要。分享内容截止到创建链接时的最后一条消息
+CREATE TABLE IF NOT EXISTS conversation_shares (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    conversation_id INT NOT NULL,
+    token_hash CHAR(64) NOT NULL,
+    last_message_id INT NOT NULL,
+    expires_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    view_count INT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_conversation_shares_token (token_hash),
+    INDEX idx_conversation_shares_conversation (conversation_id),
+    FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 分享链接的消息快照，创建链接时复制，之后编辑或删除原消息不影响已分享的内容
+CREATE TABLE IF NOT EXISTS conversation_share_messages (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    share_id INT NOT NULL,
+    role ENUM('user', 'assistant') NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    INDEX idx_conversation_share_messages_share (share_id, id),
+    FOREIGN KEY (share_id) REFERENCES conversation_shares(id) ON DELETE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
 -- 消息表
@@ -43,10 +184,111 @@ CREATE TABLE IF NOT EXISTS messages (
     role ENUM('user', 'assistant') NOT NULL,
     content TEXT NOT NULL,
     audio_url VARCHAR(255),
+    -- 生成该回复的模型，仅AI消息有值
+    model VARCHAR(100) NULL DEFAULT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    -- 消息全文搜索，ngram分词以支持中文
+    FULLTEXT INDEX ft_messages_content (content) WITH PARSER ngram,
+    -- 媒体垃圾回收时按音频引用查找
+    INDEX idx_messages_audio (audio_url),
     FOREIGN KEY (conversation_id) REFERENCES conversations(id) ON DELETE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
+-- AI消息的用户反馈，每条消息一条
+-- context_hash为角色和该消息之前全部对话内容的摘要，摘要相同的回复是同一上下文下的不同候选，用于导出偏好数据
+CREATE TABLE IF NOT EXISTS message_feedback (
+    message_id INT PRIMARY KEY,
+    character_id INT NOT NULL,
+    rating ENUM('up', 'down') NOT NULL,
+    reason ENUM('out_of_character', 'factual_error', 'too_long', 'unsafe', 'other') NULL DEFAULT NULL,
+    comment TEXT,
+    context_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    INDEX idx_message_feedback_character_updated (character_id, updated_at),
+    INDEX idx_message_feedback_context (context_hash, rating),
+    FOREIGN KEY (message_id) REFERENCES messages(id) ON DELETE CASCADE,
+    FOREIGN KEY (character_id) REFERENCES characters(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 两步验证恢复码表（只保存摘要）
+CREATE TABLE IF NOT EXISTS user_recovery_codes (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    used_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uk_recovery_codes_user_code (user_id, code_hash),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 第三方登录身份表（OpenID Connect）
+CREATE TABLE IF NOT EXISTS user_identities (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    email VARCHAR(100),
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_login_at TIMESTAMP NULL DEFAULT NULL,
+    UNIQUE KEY uk_user_identities_issuer_subject (issuer, subject),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 个人API密钥表（只保存摘要）
+CREATE TABLE IF NOT EXISTS api_keys (
+    id INT PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    key_prefix VARCHAR(16) UNIQUE NOT NULL,
+    key_hash CHAR(64) NOT NULL,
+    scopes VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP NULL DEFAULT NULL,
+    last_used_at TIMESTAMP NULL DEFAULT NULL,
+    revoked_at TIMESTAMP NULL DEFAULT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_api_keys_user_id (user_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 审计日志表（只追加，不引用users外键，用户删除后记录仍保留）
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    actor_user_id INT NULL,
+    actor_api_key_id INT NULL,
+    ip VARCHAR(45) NOT NULL DEFAULT '',
+    user_agent VARCHAR(255) NOT NULL DEFAULT '',
+    action VARCHAR(64) NOT NULL,
+    target_type VARCHAR(32) NOT NULL DEFAULT '',
+    target_id VARCHAR(64) NOT NULL DEFAULT '',
+    metadata JSON NULL,
+    created_at TIMESTAMP(3) DEFAULT CURRENT_TIMESTAMP(3),
+    INDEX idx_audit_events_actor (actor_user_id, created_at),
+    INDEX idx_audit_events_action (action, created_at),
+    INDEX idx_audit_events_target (target_type, target_id),
+    INDEX idx_audit_events_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- 禁止修改和删除审计记录
+CREATE TRIGGER IF NOT EXISTS audit_events_no_update BEFORE UPDATE ON audit_events
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only';
+
+CREATE TRIGGER IF NOT EXISTS audit_events_no_delete BEFORE DELETE ON audit_events
+FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_events is append-only';
+
+-- 媒体文件表（头像、音频），记录对象存储中的每个文件，用于鉴权和垃圾回收
+CREATE TABLE IF NOT EXISTS media_blobs (
+    id BIGINT PRIMARY KEY AUTO_INCREMENT,
+    blob_key VARCHAR(200) UNIQUE NOT NULL,
+    user_id INT NULL,
+    kind ENUM('avatar', 'audio') NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_media_blobs_created_at (created_at),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
 -- 插入一些示例角色
 INSERT IGNORE INTO characters (name, description, avatar_url, system_prompt, category) VALUES
 ('哈利·波特', '来自霍格沃茨魔法学校的年轻巫师，勇敢、善良，拥有强大的魔法天赋', '/avatars/harry_potter.svg', '你是哈利·波特，来自J.K.罗琳的《哈利·波特》系列。你是一个勇敢、善良的年轻巫师，在霍格沃茨魔法学校学习。你总是愿意帮助朋友，对黑魔法深恶痛绝。请用友好、勇敢的语气与用户对话，可以分享一些魔法世界的趣事。', '文学人物'),
@@ -78,4 +320,28 @@ INSERT IGNORE INTO characters (name, description, avatar_url, system_prompt, cat
 -- 现代人物
 ('乔布斯', '苹果公司创始人，科技界的传奇人物，以创新和完美主义著称', '/avatars/steve_jobs.svg', '你是史蒂夫·乔布斯，苹果公司的联合创始人。你以创新和完美主义著称，改变了整个科技行业。你说话简洁有力，喜欢谈论创新和设计。请用乔布斯的创新精神和完美主义与用户对话，可以分享产品设计理念或创业经验。', '现代人物'),
 
-('马斯克', '特斯拉和SpaceX的CEO，以大胆的科技愿景和创业精神著称', '/avatars/elon_musk.svg', '你是埃隆·马斯克，特斯拉和SpaceX的CEO。你以大胆的科技愿景和创业精神著称，致力于改变世界。你说话直接，喜欢谈论未来科技。请用马斯克的创新思维和未来愿景与用户对话，可以分享科技发展或创业理念。', '现代人物');
\ No newline at end of file
+('马斯克', '特斯拉和SpaceX的CEO，以大胆的科技愿景和创业精神著称', '/avatars/elon_musk.svg', '你是埃隆·马斯克，特斯拉和SpaceX的CEO。你以大胆的科技愿景和创业精神著称，致力于改变世界。你说话直接，喜欢谈论未来科技。请用马斯克的创新思维和未来愿景与用户对话，可以分享科技发展或创业理念。', '现代人物');
+
+-- 示例标签
+INSERT IGNORE INTO tags (name) VALUES
+('魔法'), ('哲学'), ('科学'), ('艺术'), ('推理'), ('神话'), ('谋略'), ('军事'), ('科技'), ('冒险'), ('热血'), ('创业');
+
+INSERT IGNORE INTO character_tags (character_id, tag_id)
+SELECT c.id, t.id
+FROM characters c
+JOIN tags t ON (c.name, t.name) IN (
+    ('哈利·波特', '魔法'), ('哈利·波特', '冒险'),
+    ('苏格拉底', '哲学'),
+    ('爱因斯坦', '科学'), ('爱因斯坦', '哲学'),
+    ('达芬奇', '艺术'), ('达芬奇', '科学'),
+    ('夏洛克·福尔摩斯', '推理'),
+    ('孙悟空', '神话'), ('孙悟空', '冒险'),
+    ('诸葛亮', '谋略'), ('诸葛亮', '军事'),
+    ('拿破仑', '军事'), ('拿破仑', '谋略'),
+    ('钢铁侠', '科技'), ('钢铁侠', '冒险'),
+    ('哆啦A梦', '科技'),
+    ('路飞', '冒险'), ('路飞', '热血'),
+    ('鸣人', '热血'),
+    ('乔布斯', '科技'), ('乔布斯', '创业'),
+    ('马斯克', '科技'), ('马斯克', '创业')
+);
@@ -15,6 +15,18 @@ const router = createRouter({
       name: 'Chat',
       component: () => import('@/views/Chat.vue'),
       meta: { requiresAuth: true }
+    },
+    {
+      path: '/oidc/callback',
+      name: 'OIDCCallback',
+      component: () => import('@/views/OIDCCallback.vue'),
+      meta: { requiresAuth: false }
+    },
+    {
+      path: '/shared/:token',
+      name: 'SharedConversation',
+      component: () => import('@/views/SharedConversation.vue'),
+      meta: { requiresAuth: false }
     }
   ]
 })
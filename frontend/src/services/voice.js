@@ -13,6 +13,7 @@ class VoiceService {
     this.audioContext = null
     this.analyser = null
     this.microphone = null
+    this.mediaRecorder = null
     this.initSpeechRecognition()
   }
 
@@ -288,6 +289,9 @@ class VoiceService {
     if (this.recognition && this.isRecording) {
       this.recognition.stop()
     }
+    if (this.mediaRecorder && this.mediaRecorder.state === 'recording') {
+      this.mediaRecorder.stop()
+    }
   }
 
   // 语音合成
@@ -401,35 +405,53 @@ class VoiceService {
     return engines
   }
 
-  // 降级语音识别方案
+  // 降级语音识别方案：录音后上传到服务端转写
+  // 返回录音的Blob，调用stopRecognition或达到最长时间时结束录音
   async startFallbackRecognition(options = {}) {
+    const maxDuration = options.maxDuration || 30000
+    let stream
     try {
-      const stream = await navigator.mediaDevices.getUserMedia({ audio: true })
+      stream = await navigator.mediaDevices.getUserMedia({ audio: true })
+    } catch (error) {
+      throw new Error('降级语音识别失败：' + error.message)
+    }
+
+    return new Promise((resolve, reject) => {
       const mediaRecorder = new MediaRecorder(stream)
       const chunks = []
-      
+      this.mediaRecorder = mediaRecorder
+      this.isRecording = true
+
+      const timer = setTimeout(() => {
+        if (mediaRecorder.state === 'recording') {
+          mediaRecorder.stop()
+        }
+      }, maxDuration)
+
       mediaRecorder.ondataavailable = (event) => {
-        chunks.push(event.data)
+        if (event.data.size > 0) {
+          chunks.push(event.data)
+        }
       }
-      
-      mediaRecorder.onstop = async () => {
-        const blob = new Blob(chunks, { type: 'audio/wav' })
-        // 这里可以调用后端API进行语音识别
-        // 或者使用其他第三方语音识别服务
-        console.log('降级方案：录音完成，需要后端处理')
+
+      mediaRecorder.onstop = () => {
+        clearTimeout(timer)
+        stream.getTracks().forEach(track => track.stop())
+        this.mediaRecorder = null
+        this.isRecording = false
+        resolve(new Blob(chunks, { type: mediaRecorder.mimeType || 'audio/webm' }))
       }
-      
-      mediaRecorder.start()
-      
-      // 5秒后自动停止
-      setTimeout(() => {
-        mediaRecorder.stop()
+
+      mediaRecorder.onerror = (event) => {
+        clearTimeout(timer)
         stream.getTracks().forEach(track => track.stop())
-      }, 5000)
-      
-    } catch (error) {
-      throw new Error('降级语音识别失败：' + error.message)
-    }
+        this.mediaRecorder = null
+        this.isRecording = false
+        reject(new Error('录音失败：' + (event.error?.message || '未知错误')))
+      }
+
+      mediaRecorder.start()
+    })
   }
 
   // 获取可用语音列表
@@ -9,15 +9,26 @@ export const useAuthStore = defineStore('auth', () => {
 
   const isAuthenticated = computed(() => !!token.value)
 
+  const setSession = (newToken, userData) => {
+    token.value = newToken
+    user.value = userData
+    localStorage.setItem('token', newToken)
+  }
+
   const login = async (email, password) => {
     try {
       const response = await api.post('/auth/login', { email, password })
-      const { token: newToken, user: userData } = response.data
-      
-      token.value = newToken
-      user.value = userData
-      localStorage.setItem('token', newToken)
-      
+
+      // 开启两步验证时不返回token，需要用挑战令牌和验证码完成登录
+      if (response.data.two_factor_required) {
+        return {
+          success: false,
+          twoFactorRequired: true,
+          challengeToken: response.data.challenge_token
+        }
+      }
+
+      setSession(response.data.token, response.data.user)
       return { success: true }
     } catch (error) {
       return { 
@@ -27,22 +38,45 @@ export const useAuthStore = defineStore('auth', () => {
     }
   }
 
-  const register = async (username, email, password) => {
+  // 两步验证登录，code可以是验证器中的6位验证码或恢复码
+  const loginTwoFactor = async (challengeToken, code) => {
     try {
-      const response = await api.post('/auth/register', { username, email, password })
-      const { token: newToken, user: userData } = response.data
-      
-      token.value = newToken
-      user.value = userData
-      localStorage.setItem('token', newToken)
-      
+      const response = await api.post('/auth/login/2fa', {
+        challenge_token: challengeToken,
+        code
+      })
+      setSession(response.data.token, response.data.user)
       return { success: true }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '验证失败'
+      }
+    }
+  }
+
+  // 单点登录回调页拿到token后保存会话并获取用户信息
+  const completeOIDCLogin = async (newToken) => {
+    setSession(newToken, null)
+    return await fetchProfile()
+  }
+
+  const register = async (username, email, password) => {
+    try {
+      // 注册接口不返回token（避免泄露邮箱是否已注册），注册后直接登录
+      await api.post('/auth/register', { username, email, password })
     } catch (error) {
       return { 
         success: false, 
         error: error.response?.data?.error || '注册失败' 
       }
     }
+
+    const result = await login(email, password)
+    if (!result.success) {
+      return { success: false, error: '注册未完成，请查收邮件了解详情' }
+    }
+    return result
   }
 
   const logout = () => {
@@ -92,6 +126,8 @@ export const useAuthStore = defineStore('auth', () => {
     isAuthenticated,
     isInitialized,
     login,
+    loginTwoFactor,
+    completeOIDCLogin,
     register,
     logout,
     fetchProfile,
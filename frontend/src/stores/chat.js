@@ -1,17 +1,21 @@
 import { defineStore } from 'pinia'
-import { ref } from 'vue'
+import { ref, computed } from 'vue'
 import api from '@/services/api'
 
 export const useChatStore = defineStore('chat', () => {
   const characters = ref([])
   const conversations = ref([])
+  const conversationsTotal = ref(0)
+  const conversationsPage = ref(1)
   const currentConversation = ref(null)
   const messages = ref([])
+  const hasMoreMessages = ref(false)
+  const messagesCursor = ref(null)
   const isLoading = ref(false)
 
   const fetchCharacters = async () => {
     try {
-      const response = await api.get('/characters')
+      const response = await api.get('/characters', { params: { limit: 100 } })
       characters.value = response.data.characters
       return { success: true }
     } catch (error) {
@@ -24,7 +28,7 @@ export const useChatStore = defineStore('chat', () => {
 
   const searchCharacters = async (query) => {
     try {
-      const response = await api.get(`/characters/search?q=${encodeURIComponent(query)}`)
+      const response = await api.get('/characters/search', { params: { q: query, limit: 100 } })
       return { 
         success: true, 
         characters: response.data.characters 
@@ -37,14 +41,68 @@ export const useChatStore = defineStore('chat', () => {
     }
   }
 
-  const fetchConversations = async () => {
+  const fetchFavorites = async () => {
     try {
-      const response = await api.get('/conversations')
-      conversations.value = response.data.conversations || []
+      const response = await api.get('/characters/favorites')
+      return { success: true, characters: response.data.characters }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '获取收藏失败'
+      }
+    }
+  }
+
+  const setFavorite = async (characterId, favorite) => {
+    try {
+      if (favorite) {
+        await api.post(`/characters/${characterId}/favorite`)
+      } else {
+        await api.delete(`/characters/${characterId}/favorite`)
+      }
+      return { success: true }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '操作失败'
+      }
+    }
+  }
+
+  const rateCharacter = async (characterId, rating, review = '') => {
+    try {
+      const response = await api.put(`/characters/${characterId}/rating`, { rating, review })
+      return { success: true, rating: response.data.rating }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '评分失败'
+      }
+    }
+  }
+
+  const hasMoreConversations = computed(() => conversations.value.length < conversationsTotal.value)
+
+  // 分页获取对话列表，page大于1时追加到已加载的列表后面
+  const fetchConversations = async (page = 1) => {
+    try {
+      const response = await api.get('/conversations', { params: { page, limit: 30 } })
+      const items = response.data.conversations || []
+      if (page === 1) {
+        conversations.value = items
+      } else {
+        // 翻页期间有新对话时，后一页可能和已加载的重复
+        const loaded = new Set(conversations.value.map(c => c.id))
+        conversations.value = [...conversations.value, ...items.filter(c => !loaded.has(c.id))]
+      }
+      conversationsTotal.value = response.data.total || 0
+      conversationsPage.value = page
       return { success: true }
     } catch (error) {
       console.error('获取对话列表失败:', error)
-      conversations.value = []
+      if (page === 1) {
+        conversations.value = []
+      }
       return { 
         success: false, 
         error: error.response?.data?.error || '获取对话列表失败' 
@@ -52,11 +110,31 @@ export const useChatStore = defineStore('chat', () => {
     }
   }
 
+  const fetchMoreConversations = () => fetchConversations(conversationsPage.value + 1)
+
+  // 全文搜索当前用户的消息
+  const searchMessages = async (query, page = 1) => {
+    try {
+      const response = await api.get('/conversations/search', { params: { q: query, page } })
+      return {
+        success: true,
+        results: response.data.results || [],
+        total: response.data.total || 0
+      }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '搜索消息失败'
+      }
+    }
+  }
+
   const createConversation = async (characterId) => {
     try {
       const response = await api.post('/conversations', { character_id: characterId })
       const conversation = response.data.conversation
       conversations.value.unshift(conversation)
+      conversationsTotal.value++
       return { success: true, conversation }
     } catch (error) {
       return { 
@@ -72,12 +150,15 @@ export const useChatStore = defineStore('chat', () => {
       const response = await api.get(`/conversations/${conversationId}`)
       currentConversation.value = response.data.conversation
       messages.value = response.data.messages || []
+      hasMoreMessages.value = response.data.has_more || false
+      messagesCursor.value = response.data.next_cursor ?? null
       console.log('Conversation loaded:', response.data.conversation?.title, 'Messages:', messages.value.length)
       return { success: true }
     } catch (error) {
       console.error('Failed to fetch conversation:', error)
       currentConversation.value = null
       messages.value = []
+      hasMoreMessages.value = false
       return { 
         success: false, 
         error: error.response?.data?.error || '获取对话失败' 
@@ -85,6 +166,33 @@ export const useChatStore = defineStore('chat', () => {
     }
   }
 
+  // 加载更早的消息，插入到当前消息列表前面
+  const fetchOlderMessages = async () => {
+    const conversation = currentConversation.value
+    if (!conversation || !hasMoreMessages.value || messagesCursor.value == null) {
+      return { success: true, count: 0 }
+    }
+    try {
+      const response = await api.get(`/conversations/${conversation.id}/messages`, {
+        params: { before: messagesCursor.value }
+      })
+      // 加载期间切换了对话则丢弃结果
+      if (currentConversation.value?.id !== conversation.id) {
+        return { success: true, count: 0 }
+      }
+      const older = response.data.messages || []
+      messages.value.unshift(...older)
+      hasMoreMessages.value = response.data.has_more || false
+      messagesCursor.value = response.data.next_cursor ?? null
+      return { success: true, count: older.length }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '加载更早的消息失败'
+      }
+    }
+  }
+
   const sendMessage = async (conversationId, content, audioUrl = null) => {
     isLoading.value = true
     try {
@@ -125,7 +233,32 @@ export const useChatStore = defineStore('chat', () => {
     }
   }
 
-  const sendMessageStream = async (conversationId, content, audioUrl = null, onStreamData = null) => {
+  // 上传录音，由服务端转写后发送，返回转写文本
+  const sendAudioMessage = async (conversationId, audioBlob) => {
+    isLoading.value = true
+    try {
+      const extension = audioBlob.type.includes('mp4') ? 'mp4' : audioBlob.type.includes('ogg') ? 'ogg' : 'webm'
+      const formData = new FormData()
+      formData.append('audio', audioBlob, `recording.${extension}`)
+      const response = await api.post(`/conversations/${conversationId}/messages/audio`, formData)
+
+      messages.value.push(response.data.user_message)
+      if (response.data.ai_message) {
+        messages.value.push(response.data.ai_message)
+      }
+      return { success: true, transcript: response.data.transcript }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '发送语音消息失败'
+      }
+    } finally {
+      isLoading.value = false
+    }
+  }
+
+  // tts为true时服务端逐句合成语音，每句合成完成后调用onAudio
+  const sendMessageStream = async (conversationId, content, audioUrl = null, onStreamData = null, { tts = false, onAudio = null } = {}) => {
     isLoading.value = true
     let hasReceivedFirstData = false
     
@@ -141,7 +274,8 @@ export const useChatStore = defineStore('chat', () => {
         },
         body: JSON.stringify({
           content,
-          audio_url: audioUrl
+          audio_url: audioUrl,
+          tts
         })
       })
 
@@ -170,6 +304,14 @@ export const useChatStore = defineStore('chat', () => {
 
             try {
               const parsedData = JSON.parse(data)
+
+              // 逐句合成的语音
+              if (parsedData.type === 'audio') {
+                if (onAudio) {
+                  onAudio(parsedData)
+                }
+                continue
+              }
               
               // 处理用户消息
               if (parsedData.role === 'user') {
@@ -229,13 +371,35 @@ export const useChatStore = defineStore('chat', () => {
     }
   }
 
+  // 服务端用角色音色合成AI消息的语音，已合成过的直接返回。
+  // 消息的audio_url是稳定引用，播放使用返回的带签名临时地址
+  const synthesizeMessage = async (conversationId, messageId) => {
+    try {
+      const response = await api.post(`/conversations/${conversationId}/messages/${messageId}/speech`)
+      const message = response.data.message
+      const index = messages.value.findIndex(m => m.id === message.id)
+      if (index !== -1) {
+        messages.value[index] = { ...messages.value[index], audio_url: message.audio_url }
+      }
+      return { success: true, audioUrl: response.data.audio_url }
+    } catch (error) {
+      return {
+        success: false,
+        status: error.response?.status,
+        error: error.response?.data?.error || '合成语音失败'
+      }
+    }
+  }
+
   const deleteConversation = async (conversationId) => {
     try {
       await api.delete(`/conversations/${conversationId}`)
       conversations.value = conversations.value.filter(c => c.id !== conversationId)
+      conversationsTotal.value = Math.max(0, conversationsTotal.value - 1)
       if (currentConversation.value?.id === conversationId) {
         currentConversation.value = null
         messages.value = []
+        hasMoreMessages.value = false
       }
       return { success: true }
     } catch (error) {
@@ -254,11 +418,13 @@ export const useChatStore = defineStore('chat', () => {
       
       // 从对话列表中移除已删除的对话
       conversations.value = conversations.value.filter(c => !conversationIds.includes(c.id))
+      conversationsTotal.value = Math.max(0, conversationsTotal.value - (response.data.deleted_count || 0))
       
       // 如果当前对话被删除，清空当前对话
       if (currentConversation.value && conversationIds.includes(currentConversation.value.id)) {
         currentConversation.value = null
         messages.value = []
+        hasMoreMessages.value = false
       }
       
       return { 
@@ -273,26 +439,295 @@ export const useChatStore = defineStore('chat', () => {
     }
   }
 
+  // 手动修改标题，修改后服务端不再自动生成标题
+  const renameConversation = async (conversationId, title) => {
+    try {
+      const response = await api.put(`/conversations/${conversationId}/title`, { title })
+      applyConversationTitle(response.data.conversation)
+      return { success: true }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '修改标题失败'
+      }
+    }
+  }
+
+  // 第一轮对话后标题在服务端后台生成，稍后重新获取
+  const refreshConversationTitle = async (conversationId) => {
+    try {
+      const response = await api.get(`/conversations/${conversationId}`)
+      applyConversationTitle(response.data.conversation)
+    } catch (error) {
+      console.warn('Failed to refresh conversation title:', error)
+    }
+  }
+
+  const applyConversationTitle = (conversation) => {
+    if (!conversation) return
+    const item = conversations.value.find(c => c.id === conversation.id)
+    if (item) {
+      item.title = conversation.title
+      item.title_auto = conversation.title_auto
+    }
+    if (currentConversation.value?.id === conversation.id) {
+      currentConversation.value.title = conversation.title
+      currentConversation.value.title_auto = conversation.title_auto
+    }
+  }
+
+  // 复制对话，可以只复制到指定消息为止，或换用其他角色
+  const cloneConversation = async (conversationId, { messageId = null, characterId = null, title = '' } = {}) => {
+    try {
+      const body = {}
+      if (messageId) body.message_id = messageId
+      if (characterId) body.character_id = characterId
+      if (title) body.title = title
+      const response = await api.post(`/conversations/${conversationId}/clone`, body)
+      const conversation = response.data.conversation
+      conversations.value.unshift(conversation)
+      conversationsTotal.value++
+      return { success: true, conversation }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '复制对话失败'
+      }
+    }
+  }
+
+  // 创建只读分享链接，链接地址只返回一次
+  const createShare = async (conversationId, expiresInDays = null) => {
+    try {
+      const body = expiresInDays ? { expires_in_days: expiresInDays } : {}
+      const response = await api.post(`/conversations/${conversationId}/share`, body)
+      return { success: true, url: response.data.url, share: response.data.share }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '创建分享链接失败'
+      }
+    }
+  }
+
+  // 将别人分享的对话复制到自己的账户
+  const forkSharedConversation = async (token) => {
+    try {
+      const response = await api.post(`/shared/${token}/fork`)
+      const conversation = response.data.conversation
+      conversations.value.unshift(conversation)
+      conversationsTotal.value++
+      return { success: true, conversation }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '复制对话失败'
+      }
+    }
+  }
+
+  // 回收站
+  const fetchTrash = async (page = 1) => {
+    try {
+      const response = await api.get('/conversations/trash', { params: { page, limit: 50 } })
+      return {
+        success: true,
+        conversations: response.data.conversations || [],
+        total: response.data.total || 0
+      }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '获取回收站失败'
+      }
+    }
+  }
+
+  const restoreConversation = async (conversationId) => {
+    try {
+      const response = await api.post(`/conversations/${conversationId}/restore`)
+      return { success: true, conversation: response.data.conversation }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '恢复对话失败'
+      }
+    }
+  }
+
+  const permanentlyDeleteConversation = async (conversationId) => {
+    try {
+      await api.delete(`/conversations/${conversationId}/permanent`)
+      return { success: true }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '永久删除失败'
+      }
+    }
+  }
+
+  const emptyTrash = async () => {
+    try {
+      const response = await api.delete('/conversations/trash')
+      return { success: true, deletedCount: response.data.deleted_count }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '清空回收站失败'
+      }
+    }
+  }
+
+  // 修改置顶、归档或文件夹状态，folder_id为0时移出文件夹
+  const updateConversation = async (conversationId, changes) => {
+    try {
+      const response = await api.patch(`/conversations/${conversationId}`, changes)
+      const conversation = response.data.conversation
+      const index = conversations.value.findIndex(c => c.id === conversationId)
+      if (index !== -1) {
+        conversations.value[index] = { ...conversations.value[index], ...conversation }
+      }
+      if (currentConversation.value?.id === conversationId) {
+        currentConversation.value = { ...currentConversation.value, ...conversation }
+      }
+      return { success: true, conversation }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '修改对话失败'
+      }
+    }
+  }
+
+  const batchArchiveConversations = async (conversationIds, archived = true) => {
+    try {
+      const response = await api.post('/conversations/batch/archive', { ids: conversationIds, archived })
+      return { success: true, updatedCount: response.data.updated_count }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '批量归档失败'
+      }
+    }
+  }
+
+  const batchMoveConversations = async (conversationIds, folderId) => {
+    try {
+      const response = await api.post('/conversations/batch/move', { ids: conversationIds, folder_id: folderId })
+      return { success: true, updatedCount: response.data.updated_count }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '批量移动失败'
+      }
+    }
+  }
+
+  const batchLabelConversations = async (conversationIds, labelId, remove = false) => {
+    try {
+      const response = await api.post('/conversations/batch/label', { ids: conversationIds, label_id: labelId, remove })
+      return { success: true, updatedCount: response.data.updated_count }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '批量修改标签失败'
+      }
+    }
+  }
+
+  const fetchFolders = async () => {
+    try {
+      const response = await api.get('/conversations/folders')
+      return { success: true, folders: response.data.folders }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '获取文件夹失败'
+      }
+    }
+  }
+
+  const fetchLabels = async () => {
+    try {
+      const response = await api.get('/conversations/labels')
+      return { success: true, labels: response.data.labels }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '获取标签失败'
+      }
+    }
+  }
+
+  // 提交或撤销对AI消息的反馈，rating为null时撤销
+  const setMessageFeedback = async (message, rating, reason = '', comment = '') => {
+    const url = `/conversations/${message.conversation_id}/messages/${message.id}/feedback`
+    try {
+      if (rating) {
+        const response = await api.put(url, { rating, reason, comment })
+        message.feedback = response.data.feedback
+      } else {
+        await api.delete(url)
+        message.feedback = null
+      }
+      return { success: true }
+    } catch (error) {
+      return {
+        success: false,
+        error: error.response?.data?.error || '提交反馈失败'
+      }
+    }
+  }
+
   const clearCurrentConversation = () => {
     currentConversation.value = null
     messages.value = []
+    hasMoreMessages.value = false
   }
 
   return {
     characters,
     conversations,
+    conversationsTotal,
+    hasMoreConversations,
     currentConversation,
     messages,
+    hasMoreMessages,
     isLoading,
     fetchCharacters,
     searchCharacters,
+    fetchFavorites,
+    setFavorite,
+    rateCharacter,
     fetchConversations,
+    fetchMoreConversations,
+    searchMessages,
     createConversation,
     fetchConversation,
+    fetchOlderMessages,
     sendMessage,
     sendMessageStream,
+    sendAudioMessage,
+    synthesizeMessage,
     deleteConversation,
     batchDeleteConversations,
+    renameConversation,
+    refreshConversationTitle,
+    updateConversation,
+    cloneConversation,
+    createShare,
+    forkSharedConversation,
+    fetchTrash,
+    restoreConversation,
+    permanentlyDeleteConversation,
+    emptyTrash,
+    batchArchiveConversations,
+    batchMoveConversations,
+    batchLabelConversations,
+    fetchFolders,
+    fetchLabels,
+    setMessageFeedback,
     clearCurrentConversation
   }
 })